@@ -0,0 +1,308 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/dsp"
+	"github.com/handegar/fv1emu/settings"
+)
+
+/**
+  A SpinASM compatible assembler for the FV-1. The output is the same
+  list of 32-bit instruction words as 'reader.ReadBin()' returns for
+  an assembled program, ie. padded with NOPs to a full program, and
+  a symbol table with what the EQU and MEM directives declared.
+
+  Refs:
+   - The FV-1 datasheet and the SpinASM user manual
+   - asfv1: https://github.com/ndf-zz/asfv1
+*/
+
+type sourceLine struct {
+	Num  int    // 1-based line number
+	Text string // Raw source text
+}
+
+type argument struct {
+	Tokens []token
+	Col    int // Column where the argument starts
+}
+
+type instruction struct {
+	Line     *sourceLine
+	Mnemonic token
+	Args     []argument
+}
+
+type assembler struct {
+	filename     string
+	instructions []instruction
+	labels       map[string]int // Label -> instruction index
+	symbols      map[string]value
+	symbolTable  *base.SymbolTable
+	nextMemory   int // First free delay memory address
+	diagnostics  Diagnostics
+}
+
+// An assembled program
+type Program struct {
+	Words    []uint32 // Padded with NOPs to a full program
+	Symbols  *base.SymbolTable
+	Warnings Diagnostics
+}
+
+// Assemble SpinASM source code into FV-1 instruction words. On
+// failure the returned error is a 'Diagnostics' with all errors and
+// warnings found.
+func Assemble(source string) (*Program, error) {
+	return assemble("<source>", source)
+}
+
+// Assemble a SpinASM (.spn) file into FV-1 instruction words
+func AssembleFile(filename string) (*Program, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return assemble(filename, decodeSource(data))
+}
+
+// SpinASM on Windows tends to save files as UTF-16. Convert these
+// (and UTF-8 files with a BOM) to plain UTF-8.
+func decodeSource(data []byte) string {
+	isLE := bytes.HasPrefix(data, []byte{0xFF, 0xFE})
+	isBE := bytes.HasPrefix(data, []byte{0xFE, 0xFF})
+	if !isLE && !isBE {
+		return string(bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF}))
+	}
+
+	data = data[2:]
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if isLE {
+			units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+		} else {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func assemble(filename string, source string) (*Program, error) {
+	a := &assembler{
+		filename:    filename,
+		labels:      make(map[string]int),
+		symbols:     predefinedSymbols(),
+		symbolTable: base.NewSymbolTable(),
+	}
+
+	a.parse(source)
+
+	if len(a.instructions) > settings.InstructionsPerSample {
+		ins := a.instructions[settings.InstructionsPerSample]
+		a.addError(a.errorAt(ins.Line, ins.Mnemonic.Col,
+			fmt.Errorf("Program has %d instructions, the FV-1 only has room for %d",
+				len(a.instructions), settings.InstructionsPerSample)))
+	}
+
+	var words []uint32
+	for i := range a.instructions {
+		ins := &a.instructions[i]
+		encoder, found := encoders[ins.Mnemonic.Text]
+		if !found {
+			a.addError(a.errorAt(ins.Line, ins.Mnemonic.Col,
+				fmt.Errorf("Unknown instruction '%s'", ins.Mnemonic.Text)))
+			continue
+		}
+
+		op, err := encoder(a, i)
+		if err != nil {
+			a.addError(err)
+			continue
+		}
+		words = append(words, encodeOp(op))
+	}
+
+	if a.diagnostics.HasErrors() {
+		sort.SliceStable(a.diagnostics, func(i, j int) bool {
+			return a.diagnostics[i].Line < a.diagnostics[j].Line
+		})
+		return nil, a.diagnostics
+	}
+
+	// Fill up the rest of the program with NOPs like SpinASM does
+	for len(words) < settings.InstructionsPerSample {
+		words = append(words, encodeOp(newOp("NOP")))
+	}
+
+	return &Program{Words: words, Symbols: a.symbolTable, Warnings: a.diagnostics}, nil
+}
+
+// Split the source into labels, directives and instructions. Errors
+// are recorded and the parsing continues on the next line.
+func (a *assembler) parse(source string) {
+	for n, text := range strings.Split(source, "\n") {
+		line := &sourceLine{Num: n + 1, Text: strings.TrimRight(text, "\r")}
+
+		tokens, col, err := tokenize(line.Text)
+		if err != nil {
+			a.addError(a.errorAt(line, col, err))
+			continue
+		}
+
+		for len(tokens) >= 2 && tokens[0].Kind == tokIdent && tokens[1].Kind == tokColon {
+			label := tokens[0]
+			if _, found := a.labels[label.Text]; found {
+				a.addError(a.errorAt(line, label.Col, fmt.Errorf("Label '%s' is already defined", label.Text)))
+			} else {
+				a.labels[label.Text] = len(a.instructions)
+			}
+			tokens = tokens[2:]
+		}
+
+		if len(tokens) == 0 {
+			continue
+		}
+
+		if isDirective, err := a.parseDirective(line, tokens); isDirective {
+			if err != nil {
+				a.addError(err)
+			}
+			continue
+		}
+
+		if tokens[0].Kind != tokIdent {
+			a.addError(a.errorAt(line, tokens[0].Col, fmt.Errorf("Expected an instruction, got '%s'", tokens[0].Text)))
+			continue
+		}
+
+		a.instructions = append(a.instructions, instruction{
+			Line:     line,
+			Mnemonic: tokens[0],
+			Args:     splitArguments(tokens[1:], tokens[0].Col+len(tokens[0].Text)),
+		})
+	}
+}
+
+// Handles "EQU NAME VALUE", "NAME EQU VALUE", "MEM NAME LENGTH" and
+// "NAME MEM LENGTH". A comma between the name and the value is
+// optional. Directives are evaluated in source order.
+func (a *assembler) parseDirective(line *sourceLine, tokens []token) (bool, error) {
+	if len(tokens) < 2 || tokens[0].Kind != tokIdent || tokens[1].Kind != tokIdent {
+		return false, nil
+	}
+
+	var directive, name token
+	switch {
+	case tokens[0].Text == "EQU" || tokens[0].Text == "MEM":
+		directive, name = tokens[0], tokens[1]
+	case tokens[1].Text == "EQU" || tokens[1].Text == "MEM":
+		directive, name = tokens[1], tokens[0]
+	default:
+		return false, nil
+	}
+
+	expr := tokens[2:]
+	if len(expr) > 0 && expr[0].Kind == tokComma {
+		expr = expr[1:]
+	}
+	if len(expr) == 0 {
+		return true, a.errorAt(line, directive.Col, fmt.Errorf("%s '%s' is missing a value", directive.Text, name.Text))
+	}
+
+	if strings.ContainsAny(name.Text, "#^") {
+		return true, a.errorAt(line, name.Col, fmt.Errorf("Invalid symbol name '%s'", name.Text))
+	}
+	if _, found := a.symbols[name.Text]; found {
+		return true, a.errorAt(line, name.Col, fmt.Errorf("Symbol '%s' is already defined", name.Text))
+	}
+
+	v, col, err := evalExpression(expr, a.lookupSymbol)
+	if err != nil {
+		if col == 0 {
+			col = expr[0].Col
+		}
+		return true, a.errorAt(line, col, err)
+	}
+
+	if directive.Text == "EQU" {
+		a.symbols[name.Text] = v
+		a.symbolTable.Equates[name.Text] = v.F
+		return true, nil
+	}
+
+	// Like SpinASM a block of length N occupies N+1 words so that
+	// "NAME#" is an address inside the block.
+	length := int(v.F)
+	if length < 0 {
+		return true, a.errorAt(line, expr[0].Col, fmt.Errorf("Invalid length %d for memory block '%s'",
+			length, name.Text))
+	}
+	start := a.nextMemory
+	if start+length >= base.MEMORY_SIZE {
+		return true, a.errorAt(line, expr[0].Col,
+			fmt.Errorf("Memory block '%s' needs %d words but only %d of the %d words are free",
+				name.Text, length+1, base.MEMORY_SIZE-start, base.MEMORY_SIZE))
+	}
+	a.nextMemory = start + length + 1
+
+	a.symbolTable.AddMemoryBlock(name.Text, start, length)
+	a.symbols[name.Text] = intValue(int64(start))
+	a.symbols[name.Text+"#"] = intValue(int64(start + length))
+	a.symbols[name.Text+"^"] = intValue(int64(start + length/2))
+	return true, nil
+}
+
+// Split tokens on commas. Empty arguments (like the flags in "CHO RDA,
+// RMP0,, addr") are kept as arguments without any tokens.
+func splitArguments(tokens []token, col int) []argument {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var args []argument
+	current := argument{Col: col + 1}
+	for _, t := range tokens {
+		if t.Kind == tokComma {
+			args = append(args, current)
+			current = argument{Col: t.Col + 1}
+			continue
+		}
+		if len(current.Tokens) == 0 {
+			current.Col = t.Col
+		}
+		current.Tokens = append(current.Tokens, t)
+	}
+	return append(args, current)
+}
+
+func (a *assembler) lookupSymbol(name string) (value, bool) {
+	v, found := a.symbols[name]
+	return v, found
+}
+
+// Create an op with a copy of the argument layout for the given
+// (possibly pseudo-op) instruction name
+func newOp(name string) base.Op {
+	op, found := dsp.NewOp(name)
+	if !found {
+		panic("Unknown instruction " + name)
+	}
+	return op
+}
+
+// Pack an op and its arguments into an instruction word. The encoders
+// only produce valid ops so an error here is a bug in the assembler.
+func encodeOp(op base.Op) uint32 {
+	word, err := dsp.EncodeOp(op)
+	if err != nil {
+		panic(err)
+	}
+	return word
+}
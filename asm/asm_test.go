@@ -0,0 +1,362 @@
+package asm
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/dsp"
+	"github.com/handegar/fv1emu/settings"
+)
+
+func assembleOne(t *testing.T, source string) uint32 {
+	prog, err := Assemble(source)
+	if err != nil {
+		t.Fatalf("Assembling '%s' failed: %s", source, err)
+	}
+	return prog.Words[0]
+}
+
+func Test_Encoding(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected uint32
+	}{
+		{"CLR", 0x0000000E},
+		{"NOP", 0x00000011},
+		{"NOT", 0xFFFFFF10},
+		{"ABSA", 0x00000009},
+		{"RDAX ADCL, 1.0", 0x40000284},
+		{"rdax adcl, 1", 0x40000284},
+		{"WRAX DACL, 0", 0x000002C6},
+		{"RDAX POT1, -2.0", 0x80000224},
+		{"RDAX REG0, 2.0", 0x7FFF0404},
+		{"LDAX POT0", 0x00000205},
+		{"MULX REG0", 0x0000040A},
+		{"SOF -1.0, 0.5", 0xC000400D},
+		{"SOF 1.0, -1", 0x4000800D},
+		{"AND $7FFFFF", 0x7FFFFF0E},
+		{"OR %1010", 0x00000A0F},
+		{"XOR 0x0F << 4", 0x0000F010},
+		{"RDA 1000, 0.5", 0x20007D00},
+		{"WRA 100+20*2, -0.5", 0xE0001182},
+		{"WRAP 0, 1.0", 0x40000003},
+		{"RMPA 1.0", 0x40000301},
+		{"SKP RUN, 2", 0x80400011},
+		{"SKP RUN|ZRO, 63", 0xA7E00011},
+		{"WLDS SIN0, 125, 0", 0x07D00012},
+		{"WLDS SIN1, 511, 32767", 0x3FFFFFF2},
+		{"WLDR RMP0, 100, 4096", 0x400C8012},
+		{"WLDR RMP1, -1, 512", 0x7FFFE072},
+		{"JAM RMP0", 0x00000093},
+		{"JAM RMP1", 0x000000D3},
+		{"CHO RDA, SIN0, SIN|REG|COMPC, 1000", 0x06007D14},
+		{"CHO RDA, RMP0, , 10", 0x00400154},
+		{"CHO SOF, RMP1, NA|COMPC, 0.5", 0xA4680014},
+		{"CHO RDAL, SIN1", 0xC2200014},
+		{"CHO RDAL, RMP0, COMPA", 0xC8400014},
+	}
+
+	for _, test := range tests {
+		got := assembleOne(t, test.source)
+		if got != test.expected {
+			t.Errorf("'%s': Expected 0x%08X, got 0x%08X", test.source, test.expected, got)
+		}
+	}
+}
+
+func Test_DecodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		source string
+		name   string
+	}{
+		{"CLR", "CLR"},
+		{"ABSA", "ABSA"},
+		{"NOT", "NOT"},
+		{"NOP", "NOP"},
+		{"LDAX REG3", "LDAX"},
+		{"WLDS SIN1, 10, 100", "WLDS"},
+		{"WLDR RMP1, 10, 1024", "WLDR"},
+		{"JAM RMP1", "JAM"},
+		{"CHO RDA, SIN0, COS, 100", "CHO RDA"},
+		{"CHO SOF, RMP0, NA, 0", "CHO SOF"},
+		{"CHO RDAL, RMP1", "CHO RDAL"},
+		{"SKP NEG, 5", "SKP"},
+		{"RDFX REG0, 0.5", "RDFX"},
+	}
+
+	for _, test := range tests {
+		op := dsp.DecodeOp(assembleOne(t, test.source))
+		if op.Name != test.name {
+			t.Errorf("'%s' decodes as '%s', expected '%s'", test.source, op.Name, test.name)
+		}
+	}
+
+	op := dsp.DecodeOp(assembleOne(t, "WLDS SIN1, 10, 100"))
+	if op.Args[0].RawValue != 100 || op.Args[1].RawValue != 10 || op.Args[2].RawValue != 1 {
+		t.Errorf("WLDS arguments did not survive a decode: %+v", op.Args)
+	}
+
+	op = dsp.DecodeOp(assembleOne(t, "JAM RMP1"))
+	if op.Args[1].RawValue != 1 {
+		t.Errorf("JAM RMP1 decodes to LFO %d", op.Args[1].RawValue)
+	}
+}
+
+func Test_Labels(t *testing.T) {
+	prog, err := Assemble(`
+	skp   run, start
+	wlds  sin0, 125, 0
+	wlds  sin1, 125, 0
+start:	ldax  pot0
+end:
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := prog.Words
+
+	if words[0] != 0x80400011 { // SKP RUN, 2
+		t.Errorf("Expected SKP RUN,2 (0x80400011), got 0x%08X", words[0])
+	}
+	if words[3] != 0x00000205 {
+		t.Errorf("Expected LDAX POT0 as instruction 3, got 0x%08X", words[3])
+	}
+	if len(words) != settings.InstructionsPerSample {
+		t.Errorf("Expected the program to be padded to %d words, got %d",
+			settings.InstructionsPerSample, len(words))
+	}
+	for _, w := range words[4:] {
+		if w != 0x11 {
+			t.Fatalf("Expected NOP padding, got 0x%08X", w)
+		}
+	}
+}
+
+func Test_Errors(t *testing.T) {
+	tests := []struct {
+		source string
+		errMsg string
+	}{
+		{"FOO 1", "1:1: error: Unknown instruction 'FOO'"},
+		{"RDAX BAR, 1.0", "1:6: error: Unknown register 'BAR'"},
+		{"RDAX REG0", "RDAX expects 2 argument(s), got 1"},
+		{"SOF 1.0, 1.5", "outside the range of a S.10"},
+		{"RDA 40000, 1.0", "Delay memory address 40000"},
+		{"SKP RUN, nowhere", "Undefined label 'NOWHERE'"},
+		{"WLDR RMP0, 0, 1000", "Ramp amplitude must be"},
+		{"CHO FOO, SIN0, 0, 0", "Expected RDA, SOF or RDAL"},
+		{"a:\na: CLR", "Label 'A' is already defined"},
+		{"RDAX ADCL, 1.0 @", "1:16: error: Unexpected character '@'"},
+		{"SKP 0, 64", "skip distance"},
+	}
+
+	for _, test := range tests {
+		_, err := Assemble(test.source)
+		if err == nil {
+			t.Errorf("Expected '%s' to fail", test.source)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.errMsg) {
+			t.Errorf("'%s': Expected error containing \"%s\", got \"%s\"",
+				test.source, test.errMsg, err)
+		}
+	}
+
+	// Too many instructions
+	src := strings.Repeat("CLR\n", settings.InstructionsPerSample+1)
+	_, err := Assemble(src)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("only has room for %d",
+		settings.InstructionsPerSample)) {
+		t.Errorf("Expected a program size error, got %v", err)
+	}
+
+	// Skipping too far
+	src = "SKP 0, end\n" + strings.Repeat("CLR\n", 64) + "end: CLR\n"
+	_, err = Assemble(src)
+	if err == nil || !strings.Contains(err.Error(), "64 instructions away") {
+		t.Errorf("Expected a skip distance error, got %v", err)
+	}
+}
+
+func Test_CalibrationPrograms(t *testing.T) {
+	files, err := filepath.Glob("../programs/calibrate/*.spn")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No calibration programs found")
+	}
+
+	for _, f := range files {
+		prog, err := AssembleFile(f)
+		if err != nil {
+			t.Errorf("Assembling '%s' failed: %s", f, err)
+			continue
+		}
+		if len(prog.Warnings) > 0 {
+			t.Errorf("Unexpected warnings from '%s': %s", f, prog.Warnings)
+		}
+		if len(dsp.DecodeOpCodes(prog.Words, dsp.ConfigFromSettings())) == 0 {
+			t.Errorf("No instructions decoded from '%s'", f)
+		}
+	}
+}
+
+func Test_UTF16Source(t *testing.T) {
+	src := "CLR\r\n"
+	data := []byte{0xFF, 0xFE}
+	for _, c := range src {
+		data = append(data, byte(c), 0)
+	}
+	if decodeSource(data) != src {
+		t.Errorf("UTF-16LE source was not decoded properly: %q", decodeSource(data))
+	}
+}
+
+func Test_Directives(t *testing.T) {
+	prog, err := Assemble(`
+	equ   krt  0.5
+	gain  equ  reg1
+	mem   delay1  1000
+	delay2 mem 99
+	equ   half, delay1^
+
+	rdax  adcl, krt
+	wrax  gain, 0
+	rda   delay1#, 0.5
+	wra   delay2, krt
+	rda   delay1^, 0.5
+	rda   delay2+10, 1.0
+	rda   half, 1.0
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words, symbols := prog.Words, prog.Symbols
+
+	expected := []string{
+		"RDAX ADCL, 0.5",
+		"WRAX REG1, 0",
+		"RDA 1000, 0.5",
+		"WRA 1001, 0.5",
+		"RDA 500, 0.5",
+		"RDA 1011, 1.0",
+		"RDA 500, 1.0",
+	}
+	for i, src := range expected {
+		if words[i] != assembleOne(t, src) {
+			t.Errorf("Instruction %d: Expected '%s' (0x%08X), got 0x%08X",
+				i, src, assembleOne(t, src), words[i])
+		}
+	}
+
+	if len(symbols.Memory) != 2 {
+		t.Fatalf("Expected 2 memory blocks, got %d", len(symbols.Memory))
+	}
+	if symbols.Memory[0] != (base.MemoryBlock{Name: "DELAY1", Start: 0, Length: 1000}) ||
+		symbols.Memory[1] != (base.MemoryBlock{Name: "DELAY2", Start: 1001, Length: 99}) {
+		t.Errorf("Unexpected memory blocks: %+v", symbols.Memory)
+	}
+	if symbols.RegisterName(base.REG0+1) != "GAIN" {
+		t.Errorf("Expected REG1 to be named 'GAIN', got '%s'", symbols.RegisterName(base.REG0+1))
+	}
+	if symbols.Equates["KRT"] != 0.5 {
+		t.Errorf("Expected KRT = 0.5, got %f", symbols.Equates["KRT"])
+	}
+
+	names := map[int]string{0: "DELAY1", 1000: "DELAY1#", 500: "DELAY1^", 1011: "DELAY2+10"}
+	for addr, name := range names {
+		got, _ := symbols.AddressName(addr)
+		if got != name {
+			t.Errorf("Expected address %d to be '%s', got '%s'", addr, name, got)
+		}
+	}
+
+	// "^" is still xor when followed by an operand
+	prog, err = Assemble("equ a 3\nequ b 5\nor a^b")
+	if err != nil || prog.Words[0] != assembleOne(t, "OR 6") {
+		t.Errorf("Expected 'a^b' to be xor: %v", err)
+	}
+}
+
+func Test_DirectiveErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		errMsg string
+	}{
+		{"equ x 1\nequ x 2", "2:5: error: Symbol 'X' is already defined"},
+		{"equ reg0 1", "Symbol 'REG0' is already defined"},
+		{"mem x", "is missing a value"},
+		{"mem a 20000\nmem b 20000", "Memory block 'B' needs 20001 words"},
+		{"mem a 32768", "Memory block 'A' needs 32769 words"},
+		{"rda x#, 1.0", "Undefined symbol 'X#'"},
+	}
+
+	for _, test := range tests {
+		_, err := Assemble(test.source)
+		if err == nil {
+			t.Errorf("Expected '%s' to fail", test.source)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.errMsg) {
+			t.Errorf("'%s': Expected error containing \"%s\", got \"%s\"",
+				test.source, test.errMsg, err)
+		}
+	}
+
+	// All of the delay memory is fine
+	if _, err := Assemble("mem a 32767"); err != nil {
+		t.Errorf("Expected a 32768 word block to be accepted: %s", err)
+	}
+}
+
+func Test_Diagnostics(t *testing.T) {
+	_, err := Assemble("\trdax\tadcl, 1.0\n\trda\t100, 2.5\n\tsof\t1.0, 1.5\n\tldax\tfoo")
+	if err == nil {
+		t.Fatal("Expected assembling to fail")
+	}
+	diags, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("Expected a Diagnostics error, got %T", err)
+	}
+
+	// All errors are reported, not just the first one
+	expected := []struct {
+		line int
+		col  int
+		msg  string
+	}{
+		{2, 11, "outside the range of a S1.9"},
+		{3, 11, "outside the range of a S.10"},
+		{4, 7, "Unknown register 'FOO'"},
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got %d:\n%s", len(expected), len(diags), diags)
+	}
+	for i, e := range expected {
+		d := diags[i]
+		if d.Line != e.line || d.Col != e.col || d.Severity != SeverityError ||
+			!strings.Contains(d.Message, e.msg) {
+			t.Errorf("Expected error '%s' at %d:%d, got %s", e.msg, e.line, e.col, d)
+		}
+	}
+
+	// Snippet with a caret lined up with the offending argument
+	snippet := "<source>:2:11: error: Value 2.5 is outside the range of a S1.9 [-2 .. 1.998046875]\n" +
+		"    2 | \trda\t100, 2.5\n" +
+		"      | \t   \t     ^"
+	if diags[0].Error() != snippet {
+		t.Errorf("Expected:\n%s\nGot:\n%s", snippet, diags[0].Error())
+	}
+
+	// Warnings does not stop the build
+	prog, err := Assemble("rdax adcl, 2.0\nrda 0, 2")
+	if err != nil {
+		t.Fatalf("Expected warnings only, got %s", err)
+	}
+	if len(prog.Warnings) != 2 || prog.Warnings[0].Severity != SeverityWarning ||
+		!strings.Contains(prog.Warnings[0].Message, "clamped to 1.99993896484375") ||
+		prog.Warnings[1].Line != 2 {
+		t.Errorf("Expected two clamping warnings, got:\n%s", prog.Warnings)
+	}
+}
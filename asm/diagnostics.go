@@ -0,0 +1,113 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// An error or warning tied to a position in the source
+type Diagnostic struct {
+	Filename string
+	Line     int // 1-based
+	Col      int // 1-based
+	Severity Severity
+	Message  string
+	Source   string // The source line the diagnostic refers to
+}
+
+// Formats the diagnostic with the offending source line and a caret
+// pointing at the column, ie.
+//
+//	algo.spn:3:12: error: Undefined symbol 'ADCX'
+//	    3 | 	rdax	adcx, 1.0
+//	      | 	    	^
+func (d *Diagnostic) Error() string {
+	ret := fmt.Sprintf("%s:%d:%d: %s: %s", d.Filename, d.Line, d.Col, d.Severity, d.Message)
+	if d.Source == "" {
+		return ret
+	}
+
+	// Keep the tabs so the caret lines up with the source
+	var caret strings.Builder
+	for i := 0; i < d.Col-1 && i < len(d.Source); i++ {
+		if d.Source[i] == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	caret.WriteByte('^')
+
+	return ret + fmt.Sprintf("\n%5d | %s\n      | %s", d.Line, d.Source, caret.String())
+}
+
+// All diagnostics from an assembly. Used as the error returned when
+// assembling fails.
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	var lines []string
+	for _, d := range ds {
+		lines = append(lines, d.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *assembler) diagnosticAt(line *sourceLine, col int, severity Severity, msg string) *Diagnostic {
+	if col < 1 {
+		col = 1
+	}
+	return &Diagnostic{
+		Filename: a.filename,
+		Line:     line.Num,
+		Col:      col,
+		Severity: severity,
+		Message:  msg,
+		Source:   line.Text,
+	}
+}
+
+func (a *assembler) errorAt(line *sourceLine, col int, err error) error {
+	return a.diagnosticAt(line, col, SeverityError, err.Error())
+}
+
+func (a *assembler) warningAt(line *sourceLine, col int, format string, args ...interface{}) {
+	a.diagnostics = append(a.diagnostics,
+		a.diagnosticAt(line, col, SeverityWarning, fmt.Sprintf(format, args...)))
+}
+
+// Record an error returned by the parser or an encoder
+func (a *assembler) addError(err error) {
+	if d, ok := err.(*Diagnostic); ok {
+		a.diagnostics = append(a.diagnostics, d)
+		return
+	}
+	a.diagnostics = append(a.diagnostics, &Diagnostic{
+		Filename: a.filename,
+		Severity: SeverityError,
+		Message:  err.Error(),
+	})
+}
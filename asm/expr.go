@@ -0,0 +1,208 @@
+package asm
+
+import (
+	"fmt"
+	"math"
+)
+
+//
+// Recursive descent evaluator for argument expressions. Operator
+// precedence follows C (lowest first): | ^ & << >> + - * / unary
+//
+
+type exprParser struct {
+	tokens  []token
+	pos     int
+	symbols func(name string) (value, bool)
+	errCol  int // Column of the token causing an error
+}
+
+func evalExpression(tokens []token, symbols func(name string) (value, bool)) (value, int, error) {
+	if len(tokens) == 0 {
+		return value{}, 0, fmt.Errorf("Missing expression")
+	}
+
+	p := exprParser{tokens: tokens, symbols: symbols}
+	v, err := p.parseOr()
+	if err != nil {
+		return value{}, p.errCol, err
+	}
+	if p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		return value{}, t.Col, fmt.Errorf("Unexpected '%s' in expression", t.Text)
+	}
+	return v, 0, nil
+}
+
+func (p *exprParser) peekOperator(ops ...string) (string, bool) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].Kind != tokOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if p.tokens[p.pos].Text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) fail(t token, format string, args ...interface{}) error {
+	p.errCol = t.Col
+	return fmt.Errorf(format, args...)
+}
+
+func (p *exprParser) requireInts(t token, a value, b value) error {
+	if !a.IsInt || !b.IsInt {
+		return p.fail(t, "Operator '%s' requires integer operands", t.Text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseBinary(next func() (value, error), ops []string,
+	apply func(t token, a value, b value) (value, error)) (value, error) {
+	a, err := next()
+	if err != nil {
+		return a, err
+	}
+	for {
+		if _, ok := p.peekOperator(ops...); !ok {
+			return a, nil
+		}
+		t := p.tokens[p.pos]
+		p.pos += 1
+		b, err := next()
+		if err != nil {
+			return b, err
+		}
+		a, err = apply(t, a, b)
+		if err != nil {
+			return a, err
+		}
+	}
+}
+
+func (p *exprParser) parseOr() (value, error) {
+	return p.parseBinary(p.parseXor, []string{"|"}, func(t token, a value, b value) (value, error) {
+		if err := p.requireInts(t, a, b); err != nil {
+			return a, err
+		}
+		return intValue(int64(a.F) | int64(b.F)), nil
+	})
+}
+
+func (p *exprParser) parseXor() (value, error) {
+	return p.parseBinary(p.parseAnd, []string{"^"}, func(t token, a value, b value) (value, error) {
+		if err := p.requireInts(t, a, b); err != nil {
+			return a, err
+		}
+		return intValue(int64(a.F) ^ int64(b.F)), nil
+	})
+}
+
+func (p *exprParser) parseAnd() (value, error) {
+	return p.parseBinary(p.parseShift, []string{"&"}, func(t token, a value, b value) (value, error) {
+		if err := p.requireInts(t, a, b); err != nil {
+			return a, err
+		}
+		return intValue(int64(a.F) & int64(b.F)), nil
+	})
+}
+
+func (p *exprParser) parseShift() (value, error) {
+	return p.parseBinary(p.parseAdd, []string{"<<", ">>"}, func(t token, a value, b value) (value, error) {
+		if err := p.requireInts(t, a, b); err != nil {
+			return a, err
+		}
+		if b.F < 0 || b.F > 63 {
+			return a, p.fail(t, "Invalid shift amount %d", int64(b.F))
+		}
+		if t.Text == "<<" {
+			return intValue(int64(a.F) << uint(b.F)), nil
+		}
+		return intValue(int64(a.F) >> uint(b.F)), nil
+	})
+}
+
+func (p *exprParser) parseAdd() (value, error) {
+	return p.parseBinary(p.parseMul, []string{"+", "-"}, func(t token, a value, b value) (value, error) {
+		isInt := a.IsInt && b.IsInt
+		if t.Text == "+" {
+			return value{F: a.F + b.F, IsInt: isInt}, nil
+		}
+		return value{F: a.F - b.F, IsInt: isInt}, nil
+	})
+}
+
+func (p *exprParser) parseMul() (value, error) {
+	return p.parseBinary(p.parseUnary, []string{"*", "/"}, func(t token, a value, b value) (value, error) {
+		if t.Text == "*" {
+			return value{F: a.F * b.F, IsInt: a.IsInt && b.IsInt}, nil
+		}
+		if b.F == 0 {
+			return a, p.fail(t, "Division by zero")
+		}
+		// Integer division only if it is exact, otherwise we'll
+		// end up with a real number (like Python 3 does in asfv1).
+		q := a.F / b.F
+		return value{F: q, IsInt: a.IsInt && b.IsInt && q == math.Trunc(q)}, nil
+	})
+}
+
+func (p *exprParser) parseUnary() (value, error) {
+	op, ok := p.peekOperator("-", "+", "~")
+	if !ok {
+		return p.parsePrimary()
+	}
+	t := p.tokens[p.pos]
+	p.pos += 1
+	v, err := p.parseUnary()
+	if err != nil {
+		return v, err
+	}
+	switch op {
+	case "-":
+		v.F = -v.F
+	case "~":
+		if !v.IsInt {
+			return v, p.fail(t, "Operator '~' requires an integer operand")
+		}
+		v.F = float64(^int64(v.F))
+	}
+	return v, nil
+}
+
+func (p *exprParser) parsePrimary() (value, error) {
+	if p.pos >= len(p.tokens) {
+		last := p.tokens[len(p.tokens)-1]
+		p.errCol = last.Col + len(last.Text)
+		return value{}, fmt.Errorf("Unexpected end of expression")
+	}
+
+	t := p.tokens[p.pos]
+	p.pos += 1
+
+	switch t.Kind {
+	case tokNumber:
+		return t.Num, nil
+	case tokIdent:
+		v, ok := p.symbols(t.Text)
+		if !ok {
+			return v, p.fail(t, "Undefined symbol '%s'", t.Text)
+		}
+		return v, nil
+	case tokOperator:
+		if t.Text == "(" {
+			v, err := p.parseOr()
+			if err != nil {
+				return v, err
+			}
+			if _, ok := p.peekOperator(")"); !ok {
+				return v, p.fail(t, "Missing ')'")
+			}
+			p.pos += 1
+			return v, nil
+		}
+	}
+
+	return value{}, p.fail(t, "Unexpected '%s' in expression", t.Text)
+}
@@ -0,0 +1,492 @@
+package asm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/handegar/fv1emu/base"
+)
+
+//
+// One encoder per mnemonic. Each encoder fills in the arguments of a
+// base.Op which is then packed by encodeOp().
+//
+
+type encoderFn func(a *assembler, idx int) (base.Op, error)
+
+var encoders map[string]encoderFn
+
+func init() {
+	encoders = map[string]encoderFn{
+		"SOF":  encodeScaleOffset("SOF"),
+		"LOG":  encodeScaleOffset("LOG"),
+		"EXP":  encodeScaleOffset("EXP"),
+		"AND":  encodeMask("AND"),
+		"OR":   encodeMask("OR"),
+		"XOR":  encodeMask("XOR"),
+		"RDA":  encodeMemory("RDA"),
+		"WRA":  encodeMemory("WRA"),
+		"WRAP": encodeMemory("WRAP"),
+		"RDAX": encodeRegisterCoeff("RDAX"),
+		"WRAX": encodeRegisterCoeff("WRAX"),
+		"MAXX": encodeRegisterCoeff("MAXX"),
+		"RDFX": encodeRegisterCoeff("RDFX"),
+		"WRLX": encodeRegisterCoeff("WRLX"),
+		"WRHX": encodeRegisterCoeff("WRHX"),
+		"MULX": encodeRegister("MULX"),
+		"LDAX": encodeRegister("LDAX"),
+		"CLR":  encodeNoArgs("CLR"),
+		"NOT":  encodeNoArgs("NOT"),
+		"ABSA": encodeNoArgs("ABSA"),
+		"NOP":  encodeNoArgs("NOP"),
+		"RMPA": encodeRMPA,
+		"SKP":  encodeSKP,
+		"WLDS": encodeWLDS,
+		"WLDR": encodeWLDR,
+		"JAM":  encodeJAM,
+		"CHO":  encodeCHO,
+	}
+}
+
+func (a *assembler) expectArgs(idx int, num int) error {
+	ins := &a.instructions[idx]
+	if len(ins.Args) != num {
+		return a.errorAt(ins.Line, ins.Mnemonic.Col,
+			fmt.Errorf("%s expects %d argument(s), got %d", ins.Mnemonic.Text, num, len(ins.Args)))
+	}
+	return nil
+}
+
+func (a *assembler) argError(idx int, arg int, err error) error {
+	ins := &a.instructions[idx]
+	return a.errorAt(ins.Line, ins.Args[arg].Col, err)
+}
+
+// Evaluate the expression of an argument
+func (a *assembler) evalArg(idx int, arg int) (value, error) {
+	ins := &a.instructions[idx]
+	v, col, err := evalExpression(ins.Args[arg].Tokens, a.lookupSymbol)
+	if err != nil {
+		if col == 0 {
+			col = ins.Args[arg].Col
+		}
+		return v, a.errorAt(ins.Line, col, err)
+	}
+	return v, nil
+}
+
+// An integer argument in the range [min .. max]
+func (a *assembler) intArg(idx int, arg int, min int64, max int64, what string) (int32, error) {
+	v, err := a.evalArg(idx, arg)
+	if err != nil {
+		return 0, err
+	}
+	if !v.IsInt {
+		return 0, a.argError(idx, arg, fmt.Errorf("The %s must be an integer, got %g", what, v.F))
+	}
+	i := int64(v.F)
+	if i < min || i > max {
+		return 0, a.argError(idx, arg, fmt.Errorf("The %s must be in the range [%d .. %d], got %d",
+			what, min, max, i))
+	}
+	return int32(i), nil
+}
+
+// A set of flags. An empty argument means no flags.
+func (a *assembler) flagsArg(idx int, arg int, numBits int, what string) (int32, error) {
+	if len(a.instructions[idx].Args[arg].Tokens) == 0 {
+		return 0, nil
+	}
+	return a.intArg(idx, arg, 0, (1<<numBits)-1, what)
+}
+
+// A register number. If the register is given by an EQU name, the
+// name is recorded in the symbol table.
+func (a *assembler) registerArg(idx int, arg int) (int32, error) {
+	tokens := a.instructions[idx].Args[arg].Tokens
+	if len(tokens) == 1 && tokens[0].Kind == tokIdent {
+		if _, found := a.symbols[tokens[0].Text]; !found {
+			return 0, a.argError(idx, arg, fmt.Errorf("Unknown register '%s'", tokens[0].Text))
+		}
+	}
+
+	v, err := a.evalArg(idx, arg)
+	if err != nil {
+		return 0, err
+	}
+	if !v.IsInt || v.F < 0 || v.F > 0x3F {
+		return 0, a.argError(idx, arg, fmt.Errorf("Unknown register %g, registers are [0 .. 63]", v.F))
+	}
+	reg := int32(v.F)
+
+	if len(tokens) == 1 && tokens[0].Kind == tokIdent {
+		_, isEquate := a.symbolTable.Equates[tokens[0].Text]
+		_, hasName := a.symbolTable.Registers[int(reg)]
+		if isEquate && !hasName {
+			a.symbolTable.Registers[int(reg)] = tokens[0].Text
+		}
+	}
+	return reg, nil
+}
+
+// Delay memory addresses. Real values (like "delay*0.5") are truncated.
+func (a *assembler) addressArg(idx int, arg int) (int32, error) {
+	v, err := a.evalArg(idx, arg)
+	if err != nil {
+		return 0, err
+	}
+	addr := int64(v.F)
+	if addr < 0 || addr >= base.MEMORY_SIZE {
+		return 0, a.argError(idx, arg, fmt.Errorf("Delay memory address %d is outside [0 .. %d]",
+			addr, base.MEMORY_SIZE-1))
+	}
+	return int32(addr), nil
+}
+
+// A signed fixed point value with 'intBits' integer bits and
+// 'fracBits' fraction bits (ie. S1.14 -> 1, 14).
+//
+// Like SpinASM, integers in the range of the format are treated as
+// reals ("RDAX ADCL, 1" is the same as "RDAX ADCL, 1.0"). Larger
+// positive integers are treated as the raw bit pattern of the field.
+func (a *assembler) fixedPointArg(idx int, arg int, intBits int, fracBits int) (int32, error) {
+	v, err := a.evalArg(idx, arg)
+	if err != nil {
+		return 0, err
+	}
+
+	numBits := intBits + fracBits + 1
+	upper := math.Ldexp(1, intBits)
+	mask := int64(1)<<numBits - 1
+
+	if v.IsInt && v.F > upper {
+		if v.F > float64(mask) {
+			return 0, a.argError(idx, arg, fmt.Errorf("Value %d does not fit into %d bits",
+				int64(v.F), numBits))
+		}
+		return int32(v.F), nil
+	}
+
+	if v.F < -upper || v.F > upper {
+		return 0, a.argError(idx, arg, fmt.Errorf("Value %g is outside the range of a %s [%g .. %g]",
+			v.F, qFormatName(intBits, fracBits), -upper, upper-math.Ldexp(1, -fracBits)))
+	}
+
+	raw := int64(math.Round(math.Ldexp(v.F, fracBits)))
+	maxRaw := int64(1)<<(numBits-1) - 1
+	if raw > maxRaw {
+		raw = maxRaw // Ie. 2.0 -> 1.99993896484375 for a S1.14
+		a.warningAt(a.instructions[idx].Line, a.instructions[idx].Args[arg].Col,
+			"Value %g is clamped to %g, the largest %s value",
+			v.F, math.Ldexp(float64(maxRaw), -fracBits), qFormatName(intBits, fracBits))
+	}
+	return int32(raw & mask), nil
+}
+
+func qFormatName(intBits int, fracBits int) string {
+	if intBits == 0 {
+		return fmt.Sprintf("S.%d", fracBits)
+	}
+	return fmt.Sprintf("S%d.%d", intBits, fracBits)
+}
+
+// "SOF C, D", "LOG C, D" and "EXP C, D": C is S1.14 and D is S.10
+func encodeScaleOffset(name string) encoderFn {
+	return func(a *assembler, idx int) (base.Op, error) {
+		op := newOp(name)
+		if err := a.expectArgs(idx, 2); err != nil {
+			return op, err
+		}
+		c, err := a.fixedPointArg(idx, 0, 1, 14)
+		if err != nil {
+			return op, err
+		}
+		d, err := a.fixedPointArg(idx, 1, 0, 10)
+		if err != nil {
+			return op, err
+		}
+		op.Args[0].RawValue = d
+		op.Args[1].RawValue = c
+		return op, nil
+	}
+}
+
+// "AND MASK", "OR MASK" and "XOR MASK"
+func encodeMask(name string) encoderFn {
+	return func(a *assembler, idx int) (base.Op, error) {
+		op := newOp(name)
+		if err := a.expectArgs(idx, 1); err != nil {
+			return op, err
+		}
+		mask, err := a.intArg(idx, 0, -(1 << 23), (1<<24)-1, "mask")
+		if err != nil {
+			return op, err
+		}
+		op.Args[1].RawValue = mask & 0xFFFFFF
+		return op, nil
+	}
+}
+
+// "RDA ADDR, C", "WRA ADDR, C" and "WRAP ADDR, C": C is S1.9
+func encodeMemory(name string) encoderFn {
+	return func(a *assembler, idx int) (base.Op, error) {
+		op := newOp(name)
+		if err := a.expectArgs(idx, 2); err != nil {
+			return op, err
+		}
+		addr, err := a.addressArg(idx, 0)
+		if err != nil {
+			return op, err
+		}
+		c, err := a.fixedPointArg(idx, 1, 1, 9)
+		if err != nil {
+			return op, err
+		}
+		op.Args[0].RawValue = addr
+		op.Args[1].RawValue = c
+		return op, nil
+	}
+}
+
+// "RDAX REG, C" and friends: C is S1.14
+func encodeRegisterCoeff(name string) encoderFn {
+	return func(a *assembler, idx int) (base.Op, error) {
+		op := newOp(name)
+		if err := a.expectArgs(idx, 2); err != nil {
+			return op, err
+		}
+		reg, err := a.registerArg(idx, 0)
+		if err != nil {
+			return op, err
+		}
+		c, err := a.fixedPointArg(idx, 1, 1, 14)
+		if err != nil {
+			return op, err
+		}
+		op.Args[0].RawValue = reg
+		op.Args[2].RawValue = c
+		return op, nil
+	}
+}
+
+// "MULX REG" and "LDAX REG"
+func encodeRegister(name string) encoderFn {
+	return func(a *assembler, idx int) (base.Op, error) {
+		op := newOp(name)
+		if err := a.expectArgs(idx, 1); err != nil {
+			return op, err
+		}
+		reg, err := a.registerArg(idx, 0)
+		if err != nil {
+			return op, err
+		}
+		op.Args[0].RawValue = reg
+		if name == "LDAX" {
+			op.Args[2].Type = base.Blank // Same as dsp.DecodeOp()
+		}
+		return op, nil
+	}
+}
+
+// CLR, NOT, ABSA and NOP
+func encodeNoArgs(name string) encoderFn {
+	return func(a *assembler, idx int) (base.Op, error) {
+		op := newOp(name)
+		if err := a.expectArgs(idx, 0); err != nil {
+			return op, err
+		}
+		if name == "NOT" {
+			op.Args[1].RawValue = 0xFFFFFF
+		}
+		return op, nil
+	}
+}
+
+// "RMPA C": C is S1.9
+func encodeRMPA(a *assembler, idx int) (base.Op, error) {
+	op := newOp("RMPA")
+	if err := a.expectArgs(idx, 1); err != nil {
+		return op, err
+	}
+	c, err := a.fixedPointArg(idx, 0, 1, 9)
+	if err != nil {
+		return op, err
+	}
+	op.Args[1].RawValue = c
+	return op, nil
+}
+
+// "SKP CMASK, N" where N is a label or the number of instructions to skip
+func encodeSKP(a *assembler, idx int) (base.Op, error) {
+	op := newOp("SKP")
+	if err := a.expectArgs(idx, 2); err != nil {
+		return op, err
+	}
+	flags, err := a.flagsArg(idx, 0, 5, "condition mask")
+	if err != nil {
+		return op, err
+	}
+
+	var n int32
+	target := a.instructions[idx].Args[1]
+	if len(target.Tokens) == 1 && target.Tokens[0].Kind == tokIdent {
+		labelIdx, found := a.labels[target.Tokens[0].Text]
+		if !found {
+			return op, a.argError(idx, 1, fmt.Errorf("Undefined label '%s'", target.Tokens[0].Text))
+		}
+		n = int32(labelIdx - (idx + 1))
+		if n < 0 || n > 63 {
+			return op, a.argError(idx, 1, fmt.Errorf("Label '%s' is %d instructions away, "+
+				"SKP can only jump [0 .. 63] instructions forward", target.Tokens[0].Text, n))
+		}
+	} else {
+		n, err = a.intArg(idx, 1, 0, 63, "skip distance")
+		if err != nil {
+			return op, err
+		}
+	}
+
+	op.Args[1].RawValue = n
+	op.Args[2].RawValue = flags
+	return op, nil
+}
+
+// "WLDS SIN0|SIN1, FREQ, AMP"
+func encodeWLDS(a *assembler, idx int) (base.Op, error) {
+	op := newOp("WLDS")
+	if err := a.expectArgs(idx, 3); err != nil {
+		return op, err
+	}
+	lfo, err := a.intArg(idx, 0, base.LFO_SIN0, base.LFO_SIN1, "sine LFO")
+	if err != nil {
+		return op, err
+	}
+	freq, err := a.intArg(idx, 1, 0, (1<<9)-1, "frequency")
+	if err != nil {
+		return op, err
+	}
+	amp, err := a.intArg(idx, 2, 0, (1<<15)-1, "amplitude")
+	if err != nil {
+		return op, err
+	}
+	op.Args[0].RawValue = amp
+	op.Args[1].RawValue = freq
+	op.Args[2].RawValue = lfo
+	return op, nil
+}
+
+// "WLDR RMP0|RMP1, FREQ, AMP"
+func encodeWLDR(a *assembler, idx int) (base.Op, error) {
+	op := newOp("WLDR")
+	if err := a.expectArgs(idx, 3); err != nil {
+		return op, err
+	}
+	lfo, err := a.intArg(idx, 0, 0, base.LFO_RMP1, "ramp LFO")
+	if err != nil {
+		return op, err
+	}
+	freq, err := a.intArg(idx, 1, -(1 << 15), (1<<15)-1, "frequency")
+	if err != nil {
+		return op, err
+	}
+	amp, err := a.intArg(idx, 2, 512, 4096, "amplitude")
+	if err != nil {
+		return op, err
+	}
+	ampIdx, found := base.RampAmpValuesMap[int16(amp)]
+	if !found {
+		return op, a.argError(idx, 2, fmt.Errorf("Ramp amplitude must be 512, 1024, 2048 or 4096, got %d", amp))
+	}
+	op.Args[0].RawValue = int32(ampIdx)
+	op.Args[2].RawValue = freq & 0xFFFF
+	op.Args[3].RawValue = lfo & 1 // Both 0/1 and RMP0/RMP1 are accepted
+	return op, nil
+}
+
+// "JAM RMP0|RMP1"
+func encodeJAM(a *assembler, idx int) (base.Op, error) {
+	op := newOp("JAM")
+	if err := a.expectArgs(idx, 1); err != nil {
+		return op, err
+	}
+	lfo, err := a.intArg(idx, 0, 0, base.LFO_RMP1, "ramp LFO")
+	if err != nil {
+		return op, err
+	}
+	op.Args[1].RawValue = lfo & 1
+	return op, nil
+}
+
+// "CHO RDA, LFO, FLAGS, ADDR", "CHO SOF, LFO, FLAGS, D" and
+// "CHO RDAL, LFO[, FLAGS]"
+func encodeCHO(a *assembler, idx int) (base.Op, error) {
+	ins := &a.instructions[idx]
+	if len(ins.Args) < 2 {
+		return newOp("CHO RDA"), a.errorAt(ins.Line, ins.Mnemonic.Col,
+			fmt.Errorf("CHO expects at least 2 arguments, got %d", len(ins.Args)))
+	}
+
+	subCmd := ins.Args[0]
+	var name string
+	if len(subCmd.Tokens) == 1 {
+		switch subCmd.Tokens[0].Text {
+		case "RDA":
+			name = "CHO RDA"
+		case "SOF":
+			name = "CHO SOF"
+		case "RDAL":
+			name = "CHO RDAL"
+		}
+	}
+	if name == "" {
+		return newOp("CHO RDA"), a.argError(idx, 0, fmt.Errorf("Expected RDA, SOF or RDAL after CHO"))
+	}
+
+	op := newOp(name)
+	if name == "CHO RDAL" {
+		if len(ins.Args) != 2 && len(ins.Args) != 3 {
+			return op, a.errorAt(ins.Line, ins.Mnemonic.Col,
+				fmt.Errorf("CHO RDAL expects 2 or 3 arguments, got %d", len(ins.Args)))
+		}
+	} else if err := a.expectArgs(idx, 4); err != nil {
+		return op, err
+	}
+
+	lfo, err := a.intArg(idx, 1, 0, base.LFO_RMP1, "LFO")
+	if err != nil {
+		return op, err
+	}
+
+	flags := int32(base.CHO_REG) // Default for "CHO RDAL"
+	if len(ins.Args) > 2 {
+		flags, err = a.flagsArg(idx, 2, 6, "CHO flags")
+		if err != nil {
+			return op, err
+		}
+	}
+
+	var subCmdBits int32
+	switch name {
+	case "CHO RDA":
+		subCmdBits = 0b00
+		addr, err := a.addressArg(idx, 3)
+		if err != nil {
+			return op, err
+		}
+		op.Args[0].RawValue = addr
+	case "CHO SOF":
+		subCmdBits = 0b10
+		d, err := a.fixedPointArg(idx, 3, 0, 15)
+		if err != nil {
+			return op, err
+		}
+		op.Args[0].RawValue = d
+	case "CHO RDAL":
+		subCmdBits = 0b11
+	}
+
+	op.Args[1].RawValue = lfo
+	op.Args[3].RawValue = flags
+	op.Args[4].RawValue = subCmdBits
+	return op, nil
+}
@@ -0,0 +1,197 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	tokIdent int = iota
+	tokNumber
+	tokOperator
+	tokComma
+	tokColon
+)
+
+type token struct {
+	Kind int
+	Text string // Upper-cased for identifiers
+	Col  int    // 1-based column in the source line
+	Num  value  // Only valid for tokNumber
+}
+
+// A numeric value in an expression. Integers and reals are kept
+// apart as SpinASM treats them differently in some contexts.
+type value struct {
+	F     float64
+	IsInt bool
+}
+
+func intValue(i int64) value {
+	return value{F: float64(i), IsInt: true}
+}
+
+func realValue(f float64) value {
+	return value{F: f, IsInt: false}
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// Can an operand start at text[i]? Used to tell "delay^" (middle of
+// a MEM block) apart from "a^b" (xor).
+func isOperandStart(text string, i int) bool {
+	if i >= len(text) {
+		return false
+	}
+	c := text[i]
+	return isIdentChar(c) || strings.IndexByte("($%.~", c) >= 0
+}
+
+// Splits a single source line into tokens. Everything after a ';' is
+// a comment. Returns the column of the offending character on errors.
+func tokenize(text string) ([]token, int, error) {
+	var tokens []token
+
+	i := 0
+	for i < len(text) {
+		c := text[i]
+		col := i + 1
+
+		switch {
+		case c == ';':
+			return tokens, 0, nil
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i += 1
+
+		case isIdentStart(c):
+			start := i
+			for i < len(text) && isIdentChar(text[i]) {
+				i += 1
+			}
+			// "NAME#" (end) and "NAME^" (middle) of a MEM block
+			if i < len(text) && (text[i] == '#' || (text[i] == '^' && !isOperandStart(text, i+1))) {
+				i += 1
+			}
+			tokens = append(tokens, token{Kind: tokIdent,
+				Text: strings.ToUpper(text[start:i]), Col: col})
+
+		case isDigit(c) || c == '$' || c == '%' ||
+			(c == '.' && i+1 < len(text) && isDigit(text[i+1])):
+			n, v, err := scanNumber(text[i:])
+			if err != nil {
+				return nil, col, err
+			}
+			tokens = append(tokens, token{Kind: tokNumber, Text: text[i : i+n], Col: col, Num: v})
+			i += n
+
+		case c == ',':
+			tokens = append(tokens, token{Kind: tokComma, Text: ",", Col: col})
+			i += 1
+
+		case c == ':':
+			tokens = append(tokens, token{Kind: tokColon, Text: ":", Col: col})
+			i += 1
+
+		case c == '<' || c == '>':
+			if i+1 >= len(text) || text[i+1] != c {
+				return nil, col, fmt.Errorf("Unexpected character '%c'", c)
+			}
+			tokens = append(tokens, token{Kind: tokOperator, Text: text[i : i+2], Col: col})
+			i += 2
+
+		case strings.IndexByte("+-*/|&^~()", c) >= 0:
+			tokens = append(tokens, token{Kind: tokOperator, Text: string(c), Col: col})
+			i += 1
+
+		default:
+			return nil, col, fmt.Errorf("Unexpected character '%c'", c)
+		}
+	}
+
+	return tokens, 0, nil
+}
+
+// Scans a numeric literal at the start of 'text'. Supported formats:
+// 123, 1.5, .5, 1e-3, $7FF, 0x7FF, %0101_0101 and 0b0101.
+// Returns the number of characters consumed.
+func scanNumber(text string) (int, value, error) {
+	prefixBase := func(prefixLen int, base int, valid func(byte) bool) (int, value, error) {
+		n := prefixLen
+		for n < len(text) && (valid(text[n]) || text[n] == '_') {
+			n += 1
+		}
+		digits := strings.ReplaceAll(text[prefixLen:n], "_", "")
+		i, err := strconv.ParseUint(digits, base, 32)
+		if err != nil {
+			return n, value{}, fmt.Errorf("Invalid number '%s'", text[:n])
+		}
+		return n, intValue(int64(i)), nil
+	}
+	isBinDigit := func(c byte) bool { return c == '0' || c == '1' }
+
+	switch {
+	case text[0] == '$':
+		return prefixBase(1, 16, isHexDigit)
+	case text[0] == '%':
+		return prefixBase(1, 2, isBinDigit)
+	case len(text) > 2 && text[0] == '0' && (text[1] == 'x' || text[1] == 'X'):
+		return prefixBase(2, 16, isHexDigit)
+	case len(text) > 2 && text[0] == '0' && (text[1] == 'b' || text[1] == 'B') && isBinDigit(text[2]):
+		return prefixBase(2, 2, isBinDigit)
+	}
+
+	n := 0
+	isReal := false
+	for n < len(text) && (isDigit(text[n]) || text[n] == '.') {
+		if text[n] == '.' {
+			isReal = true
+		}
+		n += 1
+	}
+	if n < len(text) && (text[n] == 'e' || text[n] == 'E') {
+		m := n + 1
+		if m < len(text) && (text[m] == '+' || text[m] == '-') {
+			m += 1
+		}
+		if m < len(text) && isDigit(text[m]) {
+			for m < len(text) && isDigit(text[m]) {
+				m += 1
+			}
+			n = m
+			isReal = true
+		}
+	}
+	if n < len(text) && isIdentChar(text[n]) {
+		return n, value{}, fmt.Errorf("Invalid number '%s'", text[:n+1])
+	}
+
+	if isReal {
+		f, err := strconv.ParseFloat(text[:n], 64)
+		if err != nil {
+			return n, value{}, fmt.Errorf("Invalid number '%s'", text[:n])
+		}
+		return n, realValue(f), nil
+	}
+
+	i, err := strconv.ParseInt(text[:n], 10, 64)
+	if err != nil {
+		return n, value{}, fmt.Errorf("Invalid number '%s'", text[:n])
+	}
+	return n, intValue(i), nil
+}
@@ -0,0 +1,41 @@
+package asm
+
+import (
+	"strings"
+
+	"github.com/handegar/fv1emu/base"
+)
+
+// Symbols known by SpinASM without any EQU declarations. They all
+// share the same namespace, just like in asfv1.
+func predefinedSymbols() map[string]value {
+	symbols := make(map[string]value)
+
+	// Register names (POT0, ADCL, REG0, SIN0_RATE, ...)
+	for num, name := range base.Symbols {
+		if num > 0x3F || strings.HasPrefix(name, "<") {
+			continue // Unofficial registers and SKP flags
+		}
+		symbols[name] = intValue(int64(num))
+	}
+
+	// LFO selectors used by WLDS/WLDR/JAM/CHO
+	for num, name := range base.LFOTypeNames[:base.LFO_COS0] {
+		symbols[name] = intValue(int64(num))
+	}
+
+	// CHO sub-commands
+	symbols["RDA"] = intValue(0b00)
+	symbols["SOF"] = intValue(0b10)
+	symbols["RDAL"] = intValue(0b11)
+
+	for flag, name := range base.ChoFlagSymbols {
+		symbols[name] = intValue(int64(flag))
+	}
+
+	for flag, name := range base.SkpFlagSymbols {
+		symbols[name] = intValue(int64(flag))
+	}
+
+	return symbols
+}
@@ -0,0 +1,171 @@
+package bank
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/dsp"
+)
+
+/**
+  An EEPROM image for the FV-1: 8 programs of 128 instructions each,
+  4 KB in total. This is what a pedal boots from and what the '-prog'
+  parameter selects a program from.
+*/
+
+const NUM_PROGRAMS = 8
+const PROGRAM_SIZE = 128 // Instructions per program
+const NOP_WORD = 0x00000011
+
+type Image struct {
+	Words [NUM_PROGRAMS * PROGRAM_SIZE]uint32
+}
+
+// An image where all programs are empty (only NOPs)
+func NewImage() *Image {
+	img := new(Image)
+	for i := range img.Words {
+		img.Words[i] = NOP_WORD
+	}
+	return img
+}
+
+// Create an image from a BIN/HEX file's words. Images with less than 8
+// programs are filled up with empty programs.
+func NewImageFromWords(words []uint32) (*Image, error) {
+	if len(words) > NUM_PROGRAMS*PROGRAM_SIZE {
+		return nil, fmt.Errorf("Image has %d instructions, an EEPROM only has room for %d",
+			len(words), NUM_PROGRAMS*PROGRAM_SIZE)
+	}
+	img := NewImage()
+	copy(img.Words[:], words)
+	return img, nil
+}
+
+func checkSlot(slot int) error {
+	if slot < 0 || slot >= NUM_PROGRAMS {
+		return fmt.Errorf("Program slot must be between 0 and %d, got %d", NUM_PROGRAMS-1, slot)
+	}
+	return nil
+}
+
+// Returns a copy of the 128 instructions in a slot
+func (img *Image) Program(slot int) ([]uint32, error) {
+	if err := checkSlot(slot); err != nil {
+		return nil, err
+	}
+	return append([]uint32{}, img.Words[slot*PROGRAM_SIZE:(slot+1)*PROGRAM_SIZE]...), nil
+}
+
+// Put a program into a slot. Programs shorter than 128 instructions
+// are padded with NOPs.
+func (img *Image) SetProgram(slot int, words []uint32) error {
+	if err := checkSlot(slot); err != nil {
+		return err
+	}
+	if len(words) > PROGRAM_SIZE {
+		return fmt.Errorf("Program has %d instructions, a slot only has room for %d",
+			len(words), PROGRAM_SIZE)
+	}
+
+	program := img.Words[slot*PROGRAM_SIZE : (slot+1)*PROGRAM_SIZE]
+	for i := range program {
+		if i < len(words) {
+			program[i] = words[i]
+		} else {
+			program[i] = NOP_WORD
+		}
+	}
+	return nil
+}
+
+// Resources used by a program
+type Summary struct {
+	NumInstructions int // Not counting the NOPs at the end
+	Pots            []string
+	Inputs          []string
+	Outputs         []string
+	LFOs            []string
+	MaxDelayAddress int // -1 if the delay memory isn't used
+}
+
+func Summarize(words []uint32) Summary {
+	var s Summary
+	s.MaxDelayAddress = -1
+
+	for i, w := range words {
+		if w != NOP_WORD {
+			s.NumInstructions = i + 1
+		}
+	}
+
+	var ops []base.Op
+	for _, w := range words[:s.NumInstructions] {
+		ops = append(ops, dsp.DecodeOp(w))
+	}
+
+	var names = func(used map[string]bool) []string {
+		var ret []string
+		for name := range used {
+			ret = append(ret, name)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	pots, inputs, outputs, lfos := map[string]bool{}, map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for _, op := range ops {
+		switch op.Name {
+		case "RDAX", "RDFX", "LDAX", "MULX", "MAXX":
+			reg := int(op.Args[0].RawValue)
+			switch reg {
+			case base.POT0, base.POT1, base.POT2:
+				pots[base.Symbols[reg]] = true
+			case base.ADCL, base.ADCR:
+				inputs[base.Symbols[reg]] = true
+			}
+		case "WRAX", "WRHX", "WRLX":
+			reg := int(op.Args[0].RawValue)
+			if reg == base.DACL || reg == base.DACR {
+				outputs[base.Symbols[reg]] = true
+			}
+		case "RDA", "WRA", "WRAP", "CHO RDA":
+			s.MaxDelayAddress = max(s.MaxDelayAddress, int(op.Args[0].RawValue))
+		case "RMPA":
+			s.MaxDelayAddress = max(s.MaxDelayAddress, 0)
+		}
+
+		if strings.HasPrefix(op.Name, "CHO") {
+			lfos[base.LFOTypeNames[op.Args[1].RawValue]] = true
+		}
+	}
+
+	s.Pots = names(pots)
+	s.Inputs = names(inputs)
+	s.Outputs = names(outputs)
+	s.LFOs = names(lfos)
+	return s
+}
+
+func (s Summary) String() string {
+	if s.NumInstructions == 0 {
+		return "<empty>"
+	}
+
+	var list = func(names []string) string {
+		if len(names) == 0 {
+			return "-"
+		}
+		return strings.Join(names, ",")
+	}
+
+	mem := "-"
+	if s.MaxDelayAddress >= 0 {
+		mem = fmt.Sprintf("%d", s.MaxDelayAddress)
+	}
+
+	return fmt.Sprintf("%3d instructions | in: %-9s | out: %-9s | pots: %-14s | lfos: %-19s | mem: %s",
+		s.NumInstructions, list(s.Inputs), list(s.Outputs), list(s.Pots), list(s.LFOs), mem)
+}
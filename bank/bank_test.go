@@ -0,0 +1,93 @@
+package bank
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Image(t *testing.T) {
+	t.Run("NewImageFromWords", func(t *testing.T) {
+		img, err := NewImageFromWords([]uint32{1, 2, 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Words[0] != 1 || img.Words[2] != 3 || img.Words[3] != NOP_WORD ||
+			img.Words[len(img.Words)-1] != NOP_WORD {
+			t.Errorf("Image was not filled up with NOPs")
+		}
+
+		_, err = NewImageFromWords(make([]uint32, NUM_PROGRAMS*PROGRAM_SIZE+1))
+		if err == nil {
+			t.Errorf("Expected a too large image to fail")
+		}
+	})
+
+	t.Run("SetProgram", func(t *testing.T) {
+		img := NewImage()
+		if err := img.SetProgram(3, []uint32{0x40000284, 0x000002C6}); err != nil {
+			t.Fatal(err)
+		}
+
+		program, err := img.Program(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(program) != PROGRAM_SIZE || program[0] != 0x40000284 ||
+			program[1] != 0x000002C6 || program[2] != NOP_WORD {
+			t.Errorf("Unexpected program in slot 3: %v", program[:3])
+		}
+
+		// Replacing with a shorter program clears the rest of the slot
+		img.SetProgram(3, []uint32{0x0E})
+		program, _ = img.Program(3)
+		if program[0] != 0x0E || program[1] != NOP_WORD {
+			t.Errorf("Slot was not cleared: %v", program[:3])
+		}
+
+		// The other slots are untouched
+		empty, _ := img.Program(2)
+		if !reflect.DeepEqual(empty, NewImage().Words[:PROGRAM_SIZE]) {
+			t.Errorf("Slot 2 was modified")
+		}
+
+		if img.SetProgram(8, nil) == nil {
+			t.Errorf("Expected slot 8 to fail")
+		}
+		if img.SetProgram(0, make([]uint32, PROGRAM_SIZE+1)) == nil {
+			t.Errorf("Expected a too large program to fail")
+		}
+		if _, err := img.Program(-1); err == nil {
+			t.Errorf("Expected slot -1 to fail")
+		}
+	})
+}
+
+func Test_Summarize(t *testing.T) {
+	words := []uint32{
+		0x40000284, // RDAX ADCL, 1.0
+		0x00000205, // LDAX POT0
+		0x20007D00, // RDA 1000, 0.5
+		0x06007D14, // CHO RDA, SIN0, SIN|REG|COMPC, 1000
+		0x00000011, // NOP
+		0x000002C6, // WRAX DACL, 0
+	}
+	for len(words) < PROGRAM_SIZE {
+		words = append(words, NOP_WORD)
+	}
+
+	s := Summarize(words)
+	if s.NumInstructions != 6 {
+		t.Errorf("Expected 6 instructions, got %d", s.NumInstructions)
+	}
+	if !reflect.DeepEqual(s.Inputs, []string{"ADCL"}) || !reflect.DeepEqual(s.Outputs, []string{"DACL"}) ||
+		!reflect.DeepEqual(s.Pots, []string{"POT0"}) || !reflect.DeepEqual(s.LFOs, []string{"SIN0"}) {
+		t.Errorf("Unexpected resources: %+v", s)
+	}
+	if s.MaxDelayAddress != 1000 {
+		t.Errorf("Expected delay memory up to 1000, got %d", s.MaxDelayAddress)
+	}
+
+	if Summarize(NewImage().Words[:PROGRAM_SIZE]).String() != "<empty>" {
+		t.Errorf("Expected an empty program")
+	}
+}
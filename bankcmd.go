@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/handegar/fv1emu/bank"
+	"github.com/handegar/fv1emu/writer"
+)
+
+const bankUsage = `Usage:
+  fv1emu bank list IMAGE
+      List the programs in an EEPROM image
+  fv1emu bank extract IMAGE SLOT PROGRAM
+      Write the program in SLOT (0-7) to a BIN or HEX file
+  fv1emu bank replace [-o OUTIMAGE] IMAGE SLOT PROGRAM
+      Put a program (BIN, HEX or SPN) into SLOT. Overwrites IMAGE unless -o is given.
+  fv1emu bank build OUTIMAGE PROGRAM0 [PROGRAM1 ... PROGRAM7]
+      Build an image from up to 8 programs. Use '-' for an empty slot.`
+
+// The "bank" sub-command for working with 8-program EEPROM images.
+// Returns FALSE on errors.
+func runBankCommand(args []string) bool {
+	if len(args) == 0 {
+		fmt.Println(bankUsage)
+		return false
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = bankList(args[1:])
+	case "extract":
+		err = bankExtract(args[1:])
+	case "replace":
+		err = bankReplace(args[1:])
+	case "build":
+		err = bankBuild(args[1:])
+	default:
+		err = fmt.Errorf("Unknown bank command '%s'\n%s", args[0], bankUsage)
+	}
+
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return false
+	}
+	return true
+}
+
+func loadBankImage(filename string) (*bank.Image, error) {
+	words, _, err := loadProgramFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return bank.NewImageFromWords(words)
+}
+
+// Load a file with a single program
+func loadBankProgram(filename string) ([]uint32, error) {
+	words, _, err := loadProgramFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := bank.NewImageFromWords(words)
+	if err != nil {
+		return nil, err
+	}
+	for slot := 1; slot < bank.NUM_PROGRAMS; slot++ {
+		program, _ := img.Program(slot)
+		if bank.Summarize(program).NumInstructions > 0 {
+			return nil, fmt.Errorf("'%s' has more than one program. Use 'bank extract' first.", filename)
+		}
+	}
+	return img.Program(0)
+}
+
+func parseSlot(str string) (int, error) {
+	slot, err := strconv.Atoi(str)
+	if err != nil || slot < 0 || slot >= bank.NUM_PROGRAMS {
+		return 0, fmt.Errorf("Program slot must be between 0 and %d, got '%s'", bank.NUM_PROGRAMS-1, str)
+	}
+	return slot, nil
+}
+
+func bankList(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%s", bankUsage)
+	}
+	img, err := loadBankImage(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("* Programs in '%s':\n", args[0])
+	for slot := 0; slot < bank.NUM_PROGRAMS; slot++ {
+		program, _ := img.Program(slot)
+		fmt.Printf("  %d: %s\n", slot, bank.Summarize(program))
+	}
+	return nil
+}
+
+func bankExtract(args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("%s", bankUsage)
+	}
+	img, err := loadBankImage(args[0])
+	if err != nil {
+		return err
+	}
+	slot, err := parseSlot(args[1])
+	if err != nil {
+		return err
+	}
+
+	program, _ := img.Program(slot)
+	return writer.SaveProgram(args[2], program)
+}
+
+func bankReplace(args []string) error {
+	flags := flag.NewFlagSet("bank replace", flag.ContinueOnError)
+	output := flags.String("o", "", "Output image (default is to overwrite the input image)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) != 3 {
+		return fmt.Errorf("%s", bankUsage)
+	}
+
+	img, err := loadBankImage(args[0])
+	if err != nil {
+		return err
+	}
+	slot, err := parseSlot(args[1])
+	if err != nil {
+		return err
+	}
+	program, err := loadBankProgram(args[2])
+	if err != nil {
+		return err
+	}
+	if err = img.SetProgram(slot, program); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		*output = args[0]
+	}
+	return writer.SaveProgram(*output, img.Words[:])
+}
+
+func bankBuild(args []string) error {
+	if len(args) < 2 || len(args) > bank.NUM_PROGRAMS+1 {
+		return fmt.Errorf("%s", bankUsage)
+	}
+
+	img := bank.NewImage()
+	for slot, filename := range args[1:] {
+		if filename == "-" {
+			continue
+		}
+		program, err := loadBankProgram(filename)
+		if err != nil {
+			return err
+		}
+		if err = img.SetProgram(slot, program); err != nil {
+			return err
+		}
+	}
+
+	return writer.SaveProgram(args[0], img.Words[:])
+}
@@ -28,7 +28,7 @@ var Ops = map[uint32]Op{
 	0x0F: {"OR",
 		[]OpArg{{4, Blank, 0}, {24, Bin, 0}},
 		0},
-	0x10: {"XOR", // Also NOT if arg=0xFFFFF8
+	0x10: {"XOR", // Also NOT if arg=0xFFFFFF
 		[]OpArg{{4, Blank, 0}, {24, Bin, 0}},
 		0},
 	0x11: {"SKP", // Also NOP if all args are 0
@@ -73,7 +73,7 @@ var Ops = map[uint32]Op{
 		//  []OpArg{{15, UInt, 0}, {9, UInt, 0}, {1, Flag, 0}, {2, Const, 0}},
 		0},
 	0x13: {"JAM",
-		[]OpArg{{1, Flag, 0}, {25, Const, 1}},
+		[]OpArg{{1, Blank, 0}, {1, Flag, 0}, {24, Const, 1}},
 		0},
 	0x14: {"CHO", //  SubCmd: 0b00=RDA, 0b10=SOF, 0b11=RDAL,
 		//       ADDR           N             0              FLAGS         SubCmd
@@ -0,0 +1,94 @@
+package base
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A delay memory block declared with the MEM directive. Like SpinASM
+// the block occupies Length+1 words so that "NAME#" (the end) is a
+// valid address.
+type MemoryBlock struct {
+	Name   string
+	Start  int
+	Length int
+}
+
+func (m MemoryBlock) End() int {
+	return m.Start + m.Length
+}
+
+func (m MemoryBlock) Middle() int {
+	return m.Start + m.Length/2
+}
+
+func (m MemoryBlock) Contains(addr int) bool {
+	return addr >= m.Start && addr <= m.End()
+}
+
+// Symbolic information about a program, ie. what the EQU and MEM
+// directives in the source declared. Kept alongside the decoded ops
+// so addresses and registers can be shown by name.
+type SymbolTable struct {
+	Equates   map[string]float64 // All EQU values by name
+	Registers map[int]string     // Register number -> EQU name used for it
+	Memory    []MemoryBlock      // Sorted by start address
+}
+
+func NewSymbolTable() *SymbolTable {
+	st := new(SymbolTable)
+	st.Equates = make(map[string]float64)
+	st.Registers = make(map[int]string)
+	return st
+}
+
+func (st *SymbolTable) AddMemoryBlock(name string, start int, length int) {
+	st.Memory = append(st.Memory, MemoryBlock{Name: name, Start: start, Length: length})
+	sort.Slice(st.Memory, func(i, j int) bool {
+		return st.Memory[i].Start < st.Memory[j].Start
+	})
+}
+
+// Returns the memory block containing 'addr', if any
+func (st *SymbolTable) MemoryBlockAt(addr int) (MemoryBlock, bool) {
+	if st == nil {
+		return MemoryBlock{}, false
+	}
+	for _, m := range st.Memory {
+		if m.Contains(addr) {
+			return m, true
+		}
+	}
+	return MemoryBlock{}, false
+}
+
+// Returns the name of a register. EQU names are preferred over the
+// built-in names.
+func (st *SymbolTable) RegisterName(regNo int) string {
+	if st != nil {
+		if name, found := st.Registers[regNo]; found {
+			return name
+		}
+	}
+	return Symbols[regNo]
+}
+
+// Returns a delay memory address in SpinASM syntax, ie. "delay",
+// "delay#", "delay^" or "delay+123". Returns FALSE if the address is
+// not part of any memory block.
+func (st *SymbolTable) AddressName(addr int) (string, bool) {
+	m, found := st.MemoryBlockAt(addr)
+	if !found {
+		return "", false
+	}
+
+	switch addr {
+	case m.Start:
+		return m.Name, true
+	case m.End():
+		return m.Name + "#", true
+	case m.Middle():
+		return m.Name + "^", true
+	}
+	return fmt.Sprintf("%s+%d", m.Name, addr-m.Start), true
+}
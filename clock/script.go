@@ -0,0 +1,267 @@
+package clock
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+  A clock frequency which changes over time, for emulating pedals
+  where the FV-1 clock is modulated (like the EQD Afterneath). The
+  curve is read from a script with one command per line. '#' starts a
+  comment.
+
+  	# Seconds  Hz      Ramp from the previous point
+  	0.0        32768
+  	2.0        16000   exp
+  	4.5        32768   lin
+  	6.0        24000   step
+  	lfo 0.5 4000 sine
+
+  Breakpoints must be given in increasing time. Before the first and
+  after the last breakpoint the clock keeps its frequency. 'lin' (the
+  default) ramps linearly from the previous breakpoint, 'exp' ramps
+  exponentially (ie. linearly in pitch) and 'step' jumps at the
+  breakpoint.
+
+  'lfo RATE DEPTH [sine|triangle]' adds an LFO of RATE Hz swinging
+  DEPTH Hz up and down around the breakpoints, or around the base
+  clock when there are no breakpoints.
+
+  The time is the time of the audio, ie. seconds into the input file.
+*/
+
+type Ramp int
+
+const (
+	Linear Ramp = iota
+	Exponential
+	Step
+)
+
+var rampNames = []string{"lin", "exp", "step"}
+
+func (r Ramp) String() string {
+	return rampNames[r]
+}
+
+type Shape int
+
+const (
+	Sine Shape = iota
+	Triangle
+)
+
+var shapeNames = []string{"sine", "triangle"}
+
+func (s Shape) String() string {
+	return shapeNames[s]
+}
+
+type Breakpoint struct {
+	Time      float64 // Seconds
+	Frequency float64 // Hz
+	Ramp      Ramp    // From the previous breakpoint
+}
+
+type Curve struct {
+	Base   float64 // Hz. Used when there are no breakpoints.
+	Points []Breakpoint
+
+	LFORate  float64 // Hz
+	LFODepth float64 // Hz
+	LFOShape Shape
+}
+
+// A constant clock of 'base' Hz
+func NewCurve(base float64) *Curve {
+	return &Curve{Base: base}
+}
+
+func ReadScript(filename string, base float64) (*Curve, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseScript(f, base)
+}
+
+// Parse a script. 'base' is the clock without breakpoints.
+func ParseScript(r io.Reader, base float64) (*Curve, error) {
+	c := NewCurve(base)
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		if strings.EqualFold(fields[0], "lfo") {
+			err = c.parseLFO(fields[1:])
+		} else {
+			err = c.parseBreakpoint(fields)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if min, _ := c.Range(); min <= 0 {
+		return nil, fmt.Errorf("The clock goes down to %.2f Hz, it must stay above 0 Hz", min)
+	}
+	return c, nil
+}
+
+// 'TIME HZ [RAMP]'
+func (c *Curve) parseBreakpoint(fields []string) error {
+	if len(fields) < 2 || len(fields) > 3 {
+		return fmt.Errorf("Expected 'TIME HZ [lin|exp|step]' or 'lfo RATE DEPTH [sine|triangle]'")
+	}
+	values, err := parseNumbers(fields[:2])
+	if err != nil {
+		return err
+	}
+	p := Breakpoint{Time: values[0], Frequency: values[1]}
+	if len(fields) == 3 {
+		ramp, ok := lookup(rampNames, fields[2])
+		if !ok {
+			return fmt.Errorf("Unknown ramp '%s', expected %s", fields[2], strings.Join(rampNames, ", "))
+		}
+		p.Ramp = Ramp(ramp)
+	}
+
+	if p.Time < 0 {
+		return fmt.Errorf("Negative time %g", p.Time)
+	}
+	if p.Frequency <= 0 {
+		return fmt.Errorf("The clock frequency must be positive, got %g", p.Frequency)
+	}
+	if n := len(c.Points); n > 0 && p.Time <= c.Points[n-1].Time {
+		return fmt.Errorf("The time %g is not after the previous breakpoint (%g)", p.Time, c.Points[n-1].Time)
+	}
+	c.Points = append(c.Points, p)
+	return nil
+}
+
+// 'RATE DEPTH [SHAPE]'
+func (c *Curve) parseLFO(fields []string) error {
+	if len(fields) < 2 || len(fields) > 3 {
+		return fmt.Errorf("Expected 'lfo RATE DEPTH [sine|triangle]'")
+	}
+	values, err := parseNumbers(fields[:2])
+	if err != nil {
+		return err
+	}
+	if values[0] < 0 || values[1] < 0 {
+		return fmt.Errorf("The LFO rate and depth can't be negative")
+	}
+	c.LFORate, c.LFODepth = values[0], values[1]
+	if len(fields) == 3 {
+		shape, ok := lookup(shapeNames, fields[2])
+		if !ok {
+			return fmt.Errorf("Unknown LFO shape '%s', expected %s", fields[2], strings.Join(shapeNames, ", "))
+		}
+		c.LFOShape = Shape(shape)
+	}
+	return nil
+}
+
+func parseNumbers(fields []string) ([]float64, error) {
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number '%s'", f)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func lookup(names []string, name string) (int, bool) {
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// The clock frequency at 't' seconds
+func (c *Curve) At(t float64) float64 {
+	return c.breakpointsAt(t) + c.lfoAt(t)
+}
+
+func (c *Curve) breakpointsAt(t float64) float64 {
+	if len(c.Points) == 0 {
+		return c.Base
+	}
+	// The first breakpoint after 't'
+	i := sort.Search(len(c.Points), func(i int) bool { return c.Points[i].Time > t })
+	if i == 0 {
+		return c.Points[0].Frequency
+	}
+	if i == len(c.Points) {
+		return c.Points[i-1].Frequency
+	}
+
+	from, to := c.Points[i-1], c.Points[i]
+	x := (t - from.Time) / (to.Time - from.Time)
+	switch to.Ramp {
+	case Exponential:
+		return from.Frequency * math.Pow(to.Frequency/from.Frequency, x)
+	case Step:
+		return from.Frequency
+	}
+	return from.Frequency + (to.Frequency-from.Frequency)*x
+}
+
+func (c *Curve) lfoAt(t float64) float64 {
+	if c.LFODepth == 0 {
+		return 0
+	}
+	phase := t * c.LFORate
+	if c.LFOShape == Triangle {
+		_, frac := math.Modf(phase + 0.25) // Starts at 0 going up, like the sine
+		return c.LFODepth * (1 - 4*math.Abs(frac-0.5))
+	}
+	return c.LFODepth * math.Sin(2*math.Pi*phase)
+}
+
+// The lowest and highest frequency of the curve
+func (c *Curve) Range() (float64, float64) {
+	lo, hi := c.Base, c.Base
+	if len(c.Points) > 0 {
+		lo, hi = math.Inf(1), math.Inf(-1)
+		for _, p := range c.Points {
+			lo, hi = math.Min(lo, p.Frequency), math.Max(hi, p.Frequency)
+		}
+	}
+	return lo - c.LFODepth, hi + c.LFODepth
+}
+
+// The number of clock cycles (ie. samples processed by the FV-1)
+// between 'from' and 'to' seconds
+func (c *Curve) Cycles(from float64, to float64) float64 {
+	const step = 0.001 // Seconds
+	cycles := 0.0
+	for t := from; t < to; t += step {
+		dt := math.Min(step, to-t)
+		cycles += (c.At(t) + c.At(t+dt)) / 2 * dt
+	}
+	return cycles
+}
@@ -0,0 +1,90 @@
+package clock
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, script string) *Curve {
+	c, err := ParseScript(strings.NewReader(script), 32768)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return c
+}
+
+func Test_Breakpoints(t *testing.T) {
+	c := parse(t, `
+# A dip and back
+1.0  32000
+2.0  16000  exp  # Down one octave
+3.0  32000  lin
+4.0  8000   step
+`)
+	type testCase struct {
+		time, expected float64
+	}
+	for _, tc := range []testCase{
+		{0.0, 32000}, // Before the first breakpoint
+		{1.0, 32000},
+		{1.5, 22627.417}, // Exponential, half an octave down
+		{2.0, 16000},
+		{2.5, 24000}, // Linear
+		{3.5, 32000}, // Step, kept until the breakpoint
+		{4.0, 8000},
+		{100, 8000}, // After the last breakpoint
+	} {
+		if f := c.At(tc.time); math.Abs(f-tc.expected) > 0.001 {
+			t.Errorf("At %gs: Expected %f Hz, got %f Hz", tc.time, tc.expected, f)
+		}
+	}
+
+	if lo, hi := c.Range(); lo != 8000 || hi != 32000 {
+		t.Errorf("Expected the range <8000, 32000>, got <%f, %f>", lo, hi)
+	}
+}
+
+func Test_LFO(t *testing.T) {
+	c := parse(t, "lfo 2 1000")
+	if f := c.At(0.125); math.Abs(f-33768) > 0.001 {
+		t.Errorf("Expected the sine top of 33768 Hz, got %f Hz", f)
+	}
+
+	c = parse(t, "0 20000\nlfo 1 500 triangle")
+	for time, expected := range map[float64]float64{0: 20000, 0.125: 20250, 0.25: 20500, 0.75: 19500, 1.0: 20000} {
+		if f := c.At(time); math.Abs(f-expected) > 0.001 {
+			t.Errorf("At %gs: Expected %f Hz, got %f Hz", time, expected, f)
+		}
+	}
+	if lo, hi := c.Range(); lo != 19500 || hi != 20500 {
+		t.Errorf("Expected the range <19500, 20500>, got <%f, %f>", lo, hi)
+	}
+}
+
+func Test_Cycles(t *testing.T) {
+	c := parse(t, "0 10000\n1 20000")
+	if n := c.Cycles(0, 2); math.Abs(n-35000) > 1 {
+		t.Errorf("Expected 35000 cycles, got %f", n)
+	}
+	if n := NewCurve(32768).Cycles(0.5, 1.5); math.Abs(n-32768) > 1e-6 {
+		t.Errorf("Expected 32768 cycles, got %f", n)
+	}
+}
+
+func Test_ScriptErrors(t *testing.T) {
+	for _, script := range []string{
+		"1.0",
+		"1.0 abc",
+		"1.0 32768 cubic",
+		"1.0 0",
+		"1.0 32768\n0.5 32768",
+		"lfo 1",
+		"lfo 1 100 square",
+		"lfo 1 40000", // Goes below 0 Hz
+	} {
+		if _, err := ParseScript(strings.NewReader(script), 32768); err == nil {
+			t.Errorf("Expected an error for '%s'", script)
+		}
+	}
+}
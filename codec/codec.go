@@ -0,0 +1,213 @@
+package codec
+
+import (
+	"fmt"
+	"math"
+)
+
+/**
+  A model of the codec around the FV-1 DSP core, so renders get the
+  band-limiting, resolution and DC-blocking of the hardware instead of
+  an ideal datapath. The stages, in signal order:
+
+    - Band-limit: input low-pass before the ADC
+    - ADC: quantization to the codec resolution, clipping at full scale
+    - Reconstruction: DAC low-pass
+    - DC-blocking: DAC high-pass, see "high-pass filtering in the DAC"
+      in AN-0001
+
+  The low-passes are 4th order Butterworth filters and the high-pass
+  is a 1st order filter. A stage is switched off by setting its value
+  in the Config to 0. The filters run at the DSP sample rate, so the
+  cutoffs are kept below 0.45 of the sample rate.
+
+  The input is already sampled at the DSP rate, so the band-limit
+  filter can't remove aliasing, it only gives the input the bandwidth
+  of the codec. When the audio is resampled to the DSP rate, the
+  resampler removes what is above half the DSP rate.
+
+  	c, err := codec.New(codec.DefaultConfig(), 32768)
+  	...
+  	adc := c.ADC(in)
+  	...
+  	out := c.DAC(dac)
+*/
+
+type Config struct {
+	BandLimit      float64 // Hz
+	ADCBits        int     // Bits, including the sign
+	Reconstruction float64 // Hz
+	DCBlock        float64 // Hz
+}
+
+// A 24-bit codec with filters at about 0.45 of the 32768 Hz sample
+// rate of a FV-1 with the standard crystal
+func DefaultConfig() Config {
+	return Config{
+		BandLimit:      15000.0,
+		ADCBits:        24,
+		Reconstruction: 15000.0,
+		DCBlock:        10.0,
+	}
+}
+
+// The highest cutoff relative to the sample rate
+const maxCutoff = 0.45
+
+type Codec struct {
+	config     Config
+	sampleRate float64
+	adcStep    float64 // Zero when the ADC stage is off
+
+	bandLimit      [2]lowPass
+	reconstruction [2]lowPass
+	dcBlock        [2]highPass
+}
+
+func New(config Config, sampleRate float64) (*Codec, error) {
+	if config.BandLimit < 0 || config.Reconstruction < 0 || config.DCBlock < 0 {
+		return nil, fmt.Errorf("The codec filter frequencies can't be negative")
+	}
+	if config.ADCBits < 0 || config.ADCBits > 32 {
+		return nil, fmt.Errorf("Invalid ADC resolution of %d bits, expected 1 to 32 (or 0 for off)",
+			config.ADCBits)
+	}
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("Invalid sample rate %f", sampleRate)
+	}
+
+	c := &Codec{config: config}
+	if config.ADCBits > 0 {
+		c.adcStep = math.Ldexp(1, 1-config.ADCBits)
+	}
+	c.SetSampleRate(sampleRate)
+	return c, nil
+}
+
+func (c *Codec) Config() Config {
+	return c.config
+}
+
+// Update the filters for a new DSP sample rate, like when the clock
+// changes. The filter states are kept.
+func (c *Codec) SetSampleRate(sampleRate float64) {
+	if sampleRate == c.sampleRate {
+		return
+	}
+	c.sampleRate = sampleRate
+	for ch := 0; ch < 2; ch++ {
+		c.bandLimit[ch].set(c.cutoff(c.config.BandLimit), sampleRate)
+		c.reconstruction[ch].set(c.cutoff(c.config.Reconstruction), sampleRate)
+		c.dcBlock[ch].set(c.cutoff(c.config.DCBlock), sampleRate)
+	}
+}
+
+func (c *Codec) cutoff(freq float64) float64 {
+	return math.Min(freq, maxCutoff*c.sampleRate)
+}
+
+// Clear the filter states
+func (c *Codec) Reset() {
+	for ch := 0; ch < 2; ch++ {
+		c.bandLimit[ch].reset()
+		c.reconstruction[ch].reset()
+		c.dcBlock[ch].reset()
+	}
+}
+
+// The input stages. Returns what the DSP reads from ADCL and ADCR.
+func (c *Codec) ADC(sample [2]float64) [2]float64 {
+	for ch, v := range sample {
+		if c.config.BandLimit > 0 {
+			v = c.bandLimit[ch].process(v)
+		}
+		if c.adcStep > 0 {
+			v = math.Round(v/c.adcStep) * c.adcStep
+			v = math.Max(-1.0, math.Min(1.0-c.adcStep, v))
+		}
+		sample[ch] = v
+	}
+	return sample
+}
+
+// The output stages for what the DSP wrote to DACL and DACR
+func (c *Codec) DAC(sample [2]float64) [2]float64 {
+	for ch, v := range sample {
+		if c.config.Reconstruction > 0 {
+			v = c.reconstruction[ch].process(v)
+		}
+		if c.config.DCBlock > 0 {
+			v = c.dcBlock[ch].process(v)
+		}
+		sample[ch] = v
+	}
+	return sample
+}
+
+//
+// Filters
+//
+
+// A biquad in transposed direct form II
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (b *biquad) process(x float64) float64 {
+	y := b.b0*x + b.z1
+	b.z1 = b.b1*x - b.a1*y + b.z2
+	b.z2 = b.b2*x - b.a2*y
+	return y
+}
+
+// A 4th order Butterworth low-pass made from two biquads with the Q
+// values of the Butterworth poles
+type lowPass [2]biquad
+
+var butterworthQ = [2]float64{0.54119610, 1.30656296}
+
+func (l *lowPass) set(cutoff float64, sampleRate float64) {
+	w := 2 * math.Pi * cutoff / sampleRate
+	cos := math.Cos(w)
+	for i := range l {
+		// From the "Audio EQ Cookbook"
+		alpha := math.Sin(w) / (2 * butterworthQ[i])
+		a0 := 1 + alpha
+		l[i].b0 = (1 - cos) / 2 / a0
+		l[i].b1 = (1 - cos) / a0
+		l[i].b2 = (1 - cos) / 2 / a0
+		l[i].a1 = -2 * cos / a0
+		l[i].a2 = (1 - alpha) / a0
+	}
+}
+
+func (l *lowPass) process(x float64) float64 {
+	return l[1].process(l[0].process(x))
+}
+
+func (l *lowPass) reset() {
+	for i := range l {
+		l[i].z1, l[i].z2 = 0, 0
+	}
+}
+
+// A 1st order high-pass (DC blocker)
+type highPass struct {
+	r      float64
+	x1, y1 float64
+}
+
+func (h *highPass) set(cutoff float64, sampleRate float64) {
+	h.r = math.Exp(-2 * math.Pi * cutoff / sampleRate)
+}
+
+func (h *highPass) process(x float64) float64 {
+	y := x - h.x1 + h.r*h.y1
+	h.x1, h.y1 = x, y
+	return y
+}
+
+func (h *highPass) reset() {
+	h.x1, h.y1 = 0, 0
+}
@@ -0,0 +1,86 @@
+package codec
+
+import (
+	"math"
+	"testing"
+)
+
+// The peak of a sine of 'freq' Hz through 'stage', after the filters
+// have settled
+func peak(t *testing.T, freq float64, offset float64, stage func(*Codec, [2]float64) [2]float64) float64 {
+	c, err := New(DefaultConfig(), 32768)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	p := 0.0
+	for i := 0; i < 32768; i++ {
+		v := offset + 0.5*math.Sin(2*math.Pi*freq*float64(i)/32768)
+		out := stage(c, [2]float64{v, -v})
+		if i > 16384 {
+			p = math.Max(p, math.Abs(out[0]))
+		}
+	}
+	return p
+}
+
+func Test_CodecFilters(t *testing.T) {
+	adc := (*Codec).ADC
+	dac := (*Codec).DAC
+	type testCase struct {
+		name   string
+		stage  func(*Codec, [2]float64) [2]float64
+		freq   float64
+		offset float64
+		gain   float64 // dB
+	}
+	for _, c := range []testCase{
+		{"ADC passband", adc, 1000, 0, 0.0},
+		{"ADC cutoff", adc, 14745.6, 0, -3.0}, // Limited to 0.45 of the sample rate
+		{"DAC passband", dac, 1000, 0, 0.0},
+		{"DAC DC-blocking", dac, 10, 0.4, -3.0},
+	} {
+		gain := 20 * math.Log10(peak(t, c.freq, c.offset, c.stage)/0.5)
+		if math.Abs(gain-c.gain) > 0.1 {
+			t.Errorf("%s: Expected %.1f dB at %.0f Hz, got %.2f dB", c.name, c.gain, c.freq, gain)
+		}
+	}
+}
+
+func Test_CodecADCBits(t *testing.T) {
+	c, _ := New(Config{ADCBits: 8}, 32768)
+	type testCase struct {
+		in, expected float64
+	}
+	for _, tc := range []testCase{
+		{0.1, 13.0 / 128},
+		{-0.5, -0.5},
+		{1.5, 127.0 / 128}, // Clipped
+		{-1.5, -1.0},
+	} {
+		if out := c.ADC([2]float64{tc.in, tc.in}); out[0] != tc.expected || out[1] != tc.expected {
+			t.Errorf("Expected %f -> %f, got %f", tc.in, tc.expected, out[0])
+		}
+	}
+}
+
+// A zero Config switches all stages off
+func Test_CodecOff(t *testing.T) {
+	c, _ := New(Config{}, 32768)
+	for _, v := range []float64{0.123456789, -1.5, 2.0} {
+		in := [2]float64{v, -v}
+		if out := c.DAC(c.ADC(in)); out != in {
+			t.Errorf("Expected %v unchanged, got %v", in, out)
+		}
+	}
+}
+
+func Test_CodecErrors(t *testing.T) {
+	for _, config := range []Config{{BandLimit: -1}, {ADCBits: 33}, {DCBlock: -10}} {
+		if _, err := New(config, 32768); err == nil {
+			t.Errorf("Expected an error for %+v", config)
+		}
+	}
+	if _, err := New(DefaultConfig(), 0); err == nil {
+		t.Errorf("Expected an error for a zero sample rate")
+	}
+}
@@ -12,6 +12,7 @@ import (
 var lastState *dsp.State
 var lastOpCodes []base.Op
 var lastSampleNum int
+var symbolTable *base.SymbolTable // From the assembler. Nil for BIN/HEX files.
 
 var previousStates map[uint]*dsp.State = make(map[uint]*dsp.State)
 
@@ -19,6 +20,10 @@ func Reset() {
 	previousStates = make(map[uint]*dsp.State)
 }
 
+func SetSymbolTable(st *base.SymbolTable) {
+	symbolTable = st
+}
+
 func RegisterState(state *dsp.State) {
 	if previousStates[state.IP] == nil {
 		previousStates[state.IP] = state.Duplicate()
@@ -80,7 +85,7 @@ func generateCodeListing(opCodes []base.Op, state *dsp.State) string {
 		
 		str := fmt.Sprintf("%s[  %s  ](%s)",
 			label,
-			disasm.OpCodeToString(op, i, false), codeColor)
+			disasm.OpCodeToString(op, i, false, symbolTable), codeColor)
 		lines = append(lines, str)
 	}
 
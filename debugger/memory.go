@@ -93,6 +93,9 @@ func renderMemoryMap(state *dsp.State, sampleNum int, cursorPosition int) {
 	infoP.PaddingLeft = 0
 	infoP.PaddingRight = 0
 	txt := fmt.Sprintf("'P': ADDR_PTR | One character is %d values", VALUES_PER_BLOCK)
+	if block, found := symbolTable.MemoryBlockAt(cursorPosition); found {
+		txt = fmt.Sprintf("%s: [%d .. %d] | %s", block.Name, block.Start, block.End(), txt)
+	}
 	infoP.Text = fmt.Sprintf("[%s](fg:blue)", txt)
 	infoP.SetRect(width-len(txt)-4, ypos-1, width-2, ypos)
 	infoP.TextStyle = termui.NewStyle(termui.ColorBlue)
@@ -104,6 +107,9 @@ func renderMemoryMap(state *dsp.State, sampleNum int, cursorPosition int) {
 	cursorP.PaddingLeft = 0
 	cursorP.PaddingRight = 0
 	txt = fmt.Sprintf("%d", cursorPosition)
+	if name, found := symbolTable.AddressName(cursorPosition); found {
+		txt += " " + name
+	}
 	cursorP.Text = "[" + txt + "](fg:cyan)"
 	cursorP.Title = "\\"
 	curX, curY := calculateCursorPosition(cursorPosition, width)
@@ -134,7 +140,11 @@ func buildZoomTable(state *dsp.State, cursorPosition int, valuesPerBlock int) *w
 	// Make header row
 	var header []string
 	for i := 0; i < valuesPerBlock; i++ {
-		header = append(header, fmt.Sprintf("%d", cursorPosition+i))
+		label := fmt.Sprintf("%d", cursorPosition+i)
+		if name, found := symbolTable.AddressName(cursorPosition + i); found {
+			label += " " + name
+		}
+		header = append(header, label)
 	}
 	table.Rows = append(table.Rows, header)
 	table.RowStyles[0] = ui.NewStyle(ui.ColorYellow)
@@ -158,6 +168,37 @@ func buildZoomTable(state *dsp.State, cursorPosition int, valuesPerBlock int) *w
 	return table
 }
 
+// Move the cursor to the start of the next (dir > 0) or previous
+// (dir < 0) MEM block
+func jumpToMemoryBlock(dir int) {
+	if symbolTable == nil || len(symbolTable.Memory) == 0 {
+		return
+	}
+
+	blocks := symbolTable.Memory
+	if dir > 0 {
+		target := blocks[0].Start
+		for _, m := range blocks {
+			if m.Start > uiState.memoryCursor {
+				target = m.Start
+				break
+			}
+		}
+		uiState.memoryCursor = target
+	} else {
+		target := blocks[len(blocks)-1].Start
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if blocks[i].Start < uiState.memoryCursor {
+				target = blocks[i].Start
+				break
+			}
+		}
+		uiState.memoryCursor = target
+	}
+
+	UpdateScreen(lastOpCodes, lastState, lastSampleNum)
+}
+
 func buildMemMapText(width int, state *dsp.State) string {
 	width = BLOCKS_PER_LINE
 	numLines := (dsp.DELAY_RAM_SIZE / VALUES_PER_BLOCK) / width
@@ -74,6 +74,10 @@ func WaitForInput(state *dsp.State) string {
 			increaseMemoryCursor(128)
 		case "8":
 			decreaseMemoryCursor(128)
+		case "b":
+			jumpToMemoryBlock(1)
+		case "B":
+			jumpToMemoryBlock(-1)
 		case "s", "<PageDown>":
 			return "next sample"
 		case "S":
@@ -205,6 +209,7 @@ func renderHelpScreen() {
 	keys.Rows = append(keys.Rows, " 4 (Keypad left):   [Memory map: Prev position](fg:white)")
 	keys.Rows = append(keys.Rows, " 8 (Keypad up):     [Memory map: Back 128 positions](fg:white)")
 	keys.Rows = append(keys.Rows, " 2 (Keypad down):   [Memory map: Skip 128 positions](fg:white)")
+	keys.Rows = append(keys.Rows, " b, SHIFT-b:        [Memory map: Next/previous MEM block](fg:white)")
 	keys.Rows = append(keys.Rows, " s, PgDn:           [Next sample](fg:white)")
 	keys.Rows = append(keys.Rows, " SHIFT-s:           [Skip 100 samples](fg:white)")
 	keys.Rows = append(keys.Rows, " CTRL-s:            [Skip 1000 samples](fg:white)")
@@ -265,10 +270,10 @@ func makeSineString(typ int, state *dsp.State) string {
 		sinRange = state.Registers[base.SIN0_RANGE].Value
 	} else {
 		sinRate = state.Registers[base.SIN1_RATE].Value
-		sinRange = state.Registers[base.SIN0_RANGE].Value
+		sinRange = state.Registers[base.SIN1_RANGE].Value
 	}
 
-	sinhz := (float64(sinRate) / 256.0) * 20.0
+	sinhz := dsp.SineLFOFrequency(sinRate, settings.ChipClockFrequency)
 	sinrange := float64(sinRange) / 32767.0
 
 	sin := 0.0
@@ -317,7 +322,7 @@ func makeRampString(typ int, state *dsp.State) string {
 		lfo = state.Ramp1Osc.GetValue()
 		reg = ramp1Reg
 	}
-	rmphz := (float64(rateRegValue) / 32767) * 20.0
+	rmphz := dsp.RampLFOFrequency(rateRegValue, ampRegValue, settings.ChipClockFrequency)
 
 	lfoStr := fmt.Sprintf(" [RAMP%d](fg:yellow) [Rate:](fg:cyan) %d ",
 		typ-2, rateRegValue)
@@ -329,8 +334,8 @@ func makeRampString(typ int, state *dsp.State) string {
 		dsp.GetXFadeFromLFO(lfo, typ, state),
 		1.0-lfo,
 		dsp.GetRampRange(typ, state)-lfo)
-	lfoStr += fmt.Sprintf("       [Half:](fg:cyan) %.2f  [Reg:](fg:cyan) %.2f\n",
-		dsp.GetLFOValuePlusHalfCycle(typ, state),
+	lfoStr += fmt.Sprintf("       [Half:](fg:cyan) %.3f  [Reg:](fg:cyan) %.3f\n",
+		dsp.GetLFOValuePlusHalfCycle(lfo, typ, state),
 		reg)
 	return lfoStr
 }
@@ -9,11 +9,12 @@ import (
 	"github.com/handegar/fv1emu/utils"
 )
 
-func PrintCodeListing(opCodes []base.Op) {
+func PrintCodeListing(opCodes []base.Op, symbols *base.SymbolTable) {
 	fmt.Printf("\n;;\n;; Disassembly (%d opcodes)\n;;\n", len(opCodes))
+	printMemoryBlocks(symbols)
 	var skpTargets []int
 	for pos, opCode := range opCodes {
-		op := OpCodeToString(opCode, pos, settings.PrintDebug)
+		op := OpCodeToString(opCode, pos, settings.PrintDebug, symbols)
 		if opCode.Name == "SKP" {
 			skpTargets = append(skpTargets, pos+int(opCode.Args[1].RawValue))
 		}
@@ -37,7 +38,10 @@ func PrintCodeListing(opCodes []base.Op) {
 	fmt.Println()
 }
 
-func OpCodeToString(opcode base.Op, ip int, showParamData bool) string {
+// Returns the op as SpinASM-like source. Registers and delay memory
+// addresses are shown by name if 'symbols' (which can be nil) knows
+// them.
+func OpCodeToString(opcode base.Op, ip int, showParamData bool, symbols *base.SymbolTable) string {
 	ret := "  "
 
 	switch opcode.Name {
@@ -58,21 +62,21 @@ func OpCodeToString(opcode base.Op, ip int, showParamData bool) string {
 	case "NOT":
 		ret += NOT_ToString(opcode)
 	case "LDAX":
-		ret += LDAX_ToString(opcode)
+		ret += LDAX_ToString(opcode, symbols)
 	case "WRAX":
-		ret += WRAX_ToString(opcode)
+		ret += WRAX_ToString(opcode, symbols)
 	case "MULX":
-		ret += MULX_ToString(opcode)
+		ret += MULX_ToString(opcode, symbols)
 	case "WRA":
-		ret += WRA_ToString(opcode)
+		ret += WRA_ToString(opcode, symbols)
 	case "WRAP":
-		ret += WRAP_ToString(opcode)
+		ret += WRAP_ToString(opcode, symbols)
 	case "RDA":
-		ret += RDA_ToString(opcode)
+		ret += RDA_ToString(opcode, symbols)
 	case "RDAX":
-		ret += RDAX_ToString(opcode)
+		ret += RDAX_ToString(opcode, symbols)
 	case "RDFX":
-		ret += RDFX_ToString(opcode)
+		ret += RDFX_ToString(opcode, symbols)
 	case "LOG":
 		ret += LOG_ToString(opcode)
 	case "WLDS":
@@ -80,19 +84,19 @@ func OpCodeToString(opcode base.Op, ip int, showParamData bool) string {
 	case "WLDR":
 		ret += WLDR_ToString(opcode)
 	case "CHO RDA":
-		ret += CHO_ToString(opcode)
+		ret += CHO_ToString(opcode, symbols)
 	case "CHO SOF":
-		ret += CHO_ToString(opcode)
+		ret += CHO_ToString(opcode, symbols)
 	case "CHO RDAL":
-		ret += CHO_ToString(opcode)
+		ret += CHO_ToString(opcode, symbols)
 	case "JAM":
 		ret += JAM_ToString(opcode)
 	case "MAXX":
-		ret += MAXX_ToString(opcode)
+		ret += MAXX_ToString(opcode, symbols)
 	case "WRLX":
-		ret += WRLX_ToString(opcode)
+		ret += WRLX_ToString(opcode, symbols)
 	case "WRHX":
-		ret += WRHX_ToString(opcode)
+		ret += WRHX_ToString(opcode, symbols)
 	case "RMPA":
 		ret += RMPA_ToString(opcode)
 	case "CLR":
@@ -141,21 +145,21 @@ func RMPA_ToString(op base.Op) string {
 		utils.QFormatToFloat64(op.Args[1].RawValue, 1, 9))
 }
 
-func WRLX_ToString(op base.Op) string {
+func WRLX_ToString(op base.Op, st *base.SymbolTable) string {
 	return fmt.Sprintf("WRLX  %s, %f",
-		base.Symbols[int(op.Args[0].RawValue)],
+		st.RegisterName(int(op.Args[0].RawValue)),
 		utils.QFormatToFloat64(op.Args[2].RawValue, 1, 14))
 }
 
-func WRHX_ToString(op base.Op) string {
+func WRHX_ToString(op base.Op, st *base.SymbolTable) string {
 	return fmt.Sprintf("WRHX  %s, %f",
-		base.Symbols[int(op.Args[0].RawValue)],
+		st.RegisterName(int(op.Args[0].RawValue)),
 		utils.QFormatToFloat64(op.Args[2].RawValue, 1, 14))
 }
 
-func MAXX_ToString(op base.Op) string {
+func MAXX_ToString(op base.Op, st *base.SymbolTable) string {
 	return fmt.Sprintf("MAXX  %s, %f",
-		base.Symbols[int(op.Args[0].RawValue)],
+		st.RegisterName(int(op.Args[0].RawValue)),
 		utils.QFormatToFloat64(op.Args[2].RawValue, 1, 14))
 }
 
@@ -210,50 +214,50 @@ func NOP_ToString(op base.Op) string {
 	return fmt.Sprintf("NOP   ")
 }
 
-func LDAX_ToString(op base.Op) string {
+func LDAX_ToString(op base.Op, st *base.SymbolTable) string {
 	return fmt.Sprintf("LDAX  %s",
-		base.Symbols[int(op.Args[0].RawValue)])
+		st.RegisterName(int(op.Args[0].RawValue)))
 }
 
-func WRAX_ToString(op base.Op) string {
+func WRAX_ToString(op base.Op, st *base.SymbolTable) string {
 	regNo := int(op.Args[0].RawValue)
 	return fmt.Sprintf("WRAX  %s, %f",
-		base.Symbols[regNo],
+		st.RegisterName(regNo),
 		utils.QFormatToFloat64(op.Args[2].RawValue, 1, 14))
 }
 
-func RDAX_ToString(op base.Op) string {
+func RDAX_ToString(op base.Op, st *base.SymbolTable) string {
 	return fmt.Sprintf("RDAX  %s, %f",
-		base.Symbols[int(op.Args[0].RawValue)],
+		st.RegisterName(int(op.Args[0].RawValue)),
 		utils.QFormatToFloat64(op.Args[2].RawValue, 1, 14))
 }
 
-func RDFX_ToString(op base.Op) string {
+func RDFX_ToString(op base.Op, st *base.SymbolTable) string {
 	return fmt.Sprintf("RDFX  %s, %f",
-		base.Symbols[int(op.Args[0].RawValue)],
+		st.RegisterName(int(op.Args[0].RawValue)),
 		utils.QFormatToFloat64(op.Args[2].RawValue, 1, 14))
 }
 
-func MULX_ToString(op base.Op) string {
+func MULX_ToString(op base.Op, st *base.SymbolTable) string {
 	return fmt.Sprintf("MULX  %s",
-		base.Symbols[int(op.Args[0].RawValue)])
+		st.RegisterName(int(op.Args[0].RawValue)))
 }
 
-func WRA_ToString(op base.Op) string {
-	return fmt.Sprintf("WRA   mem_%d, %f",
-		op.Args[0].RawValue,
+func WRA_ToString(op base.Op, st *base.SymbolTable) string {
+	return fmt.Sprintf("WRA   %s, %f",
+		addressToString(st, int(op.Args[0].RawValue)),
 		utils.QFormatToFloat64(op.Args[1].RawValue, 1, 9))
 }
 
-func WRAP_ToString(op base.Op) string {
-	return fmt.Sprintf("WRAP  mem_%d, %f",
-		op.Args[0].RawValue,
+func WRAP_ToString(op base.Op, st *base.SymbolTable) string {
+	return fmt.Sprintf("WRAP  %s, %f",
+		addressToString(st, int(op.Args[0].RawValue)),
 		utils.QFormatToFloat64(op.Args[1].RawValue, 1, 9))
 }
 
-func RDA_ToString(op base.Op) string {
-	return fmt.Sprintf("RDA   mem_%d, %f",
-		op.Args[0].RawValue,
+func RDA_ToString(op base.Op, st *base.SymbolTable) string {
+	return fmt.Sprintf("RDA   %s, %f",
+		addressToString(st, int(op.Args[0].RawValue)),
 		utils.QFormatToFloat64(op.Args[1].RawValue, 1, 9))
 }
 
@@ -295,7 +299,7 @@ func WLDR_ToString(op base.Op) string {
 	return fmt.Sprintf("WLDR  %s, %d, %d", typ, freq, amp)
 }
 
-func CHO_ToString(op base.Op) string {
+func CHO_ToString(op base.Op, st *base.SymbolTable) string {
 	addr := (int(op.Args[0].RawValue) << 1) >> 1
 	typ := "<?>"
 	switch op.Args[1].RawValue {
@@ -332,8 +336,8 @@ func CHO_ToString(op base.Op) string {
 			typ, strings.Join(flags, "|"), addr)
 
 	case 0b0:
-		return fmt.Sprintf("CHO   RDA, %s, %s, mem_%d",
-			typ, strings.Join(flags, "|"), addr)
+		return fmt.Sprintf("CHO   RDA, %s, %s, %s",
+			typ, strings.Join(flags, "|"), addressToString(st, addr))
 
 	case 0b11:
 		cmd = "RDAL"
@@ -345,3 +349,21 @@ func CHO_ToString(op base.Op) string {
 
 	return fmt.Sprintf("CHO   %s\t", cmd)
 }
+
+func addressToString(st *base.SymbolTable, addr int) string {
+	if name, found := st.AddressName(addr); found {
+		return name
+	}
+	return fmt.Sprintf("mem_%d", addr)
+}
+
+// Print the MEM blocks (if any) as SpinASM directives
+func printMemoryBlocks(st *base.SymbolTable) {
+	if st == nil || len(st.Memory) == 0 {
+		return
+	}
+	for _, m := range st.Memory {
+		fmt.Printf("MEM   %s, %d\t;; [%d .. %d]\n", m.Name, m.Length, m.Start, m.End())
+	}
+	fmt.Println()
+}
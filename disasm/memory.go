@@ -0,0 +1,136 @@
+package disasm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/settings"
+)
+
+/**
+  Recovers the delay memory layout of a program without a source by
+  looking at all addresses used by RDA/WRA/WRAP/CHO RDA.
+
+  SpinASM (and asfv1) allocates MEM blocks back to back, each block
+  taking its length plus one word. Delay lines are written at their
+  start and read further out, so each written address is taken as the
+  start of a block which then ends just before the next block. The
+  last block ends at the highest address used.
+*/
+
+type DelayTap struct {
+	IP      int    // Instruction number
+	Op      string // RDA, WRA, WRAP or CHO RDA
+	Address int
+}
+
+type DelayLine struct {
+	base.MemoryBlock
+	Taps []DelayTap
+}
+
+// Milliseconds between the start of a delay line and 'offset' at the
+// current clock frequency
+func DelayTimeMs(offset int) float64 {
+	return float64(offset) * 1000.0 / settings.ClockFrequency
+}
+
+// Returns the inferred delay lines and a symbol table with them as
+// memory blocks named "mem0", "mem1", ... Returns an empty layout if
+// the delay memory isn't used. The last return value is the number
+// of RMPA instructions which address memory through ADDR_PTR and
+// therefore can't be part of the analysis.
+func InferMemoryLayout(ops []base.Op) ([]DelayLine, *base.SymbolTable, int) {
+	var taps []DelayTap
+	starts := make(map[int]bool)
+	numRMPA := 0
+	maxAddr := -1
+
+	for ip, op := range ops {
+		switch op.Name {
+		case "RDA", "WRA", "WRAP", "CHO RDA":
+			addr := int(op.Args[0].RawValue)
+			if addr >= base.MEMORY_SIZE {
+				continue // Not a valid address, leave it as is
+			}
+			taps = append(taps, DelayTap{IP: ip, Op: op.Name, Address: addr})
+			if op.Name == "WRA" || op.Name == "WRAP" {
+				starts[addr] = true
+			}
+			maxAddr = max(maxAddr, addr)
+		case "RMPA":
+			numRMPA += 1
+		}
+	}
+
+	symbols := base.NewSymbolTable()
+	if len(taps) == 0 {
+		return nil, symbols, numRMPA
+	}
+
+	// Blocks are allocated from address 0. Anything before the first
+	// written address is a block of its own (unused or only read).
+	starts[0] = true
+
+	var sortedStarts []int
+	for s := range starts {
+		sortedStarts = append(sortedStarts, s)
+	}
+	sort.Ints(sortedStarts)
+
+	var lines []DelayLine
+	for i, start := range sortedStarts {
+		end := maxAddr
+		if i+1 < len(sortedStarts) {
+			end = sortedStarts[i+1] - 1
+		}
+		if end < start { // Last block with nothing but a write
+			end = start
+		}
+
+		line := DelayLine{}
+		line.Name = fmt.Sprintf("mem%d", i)
+		line.Start = start
+		line.Length = end - start
+		for _, t := range taps {
+			if line.Contains(t.Address) {
+				line.Taps = append(line.Taps, t)
+			}
+		}
+		lines = append(lines, line)
+		symbols.AddMemoryBlock(line.Name, line.Start, line.Length)
+	}
+
+	return lines, symbols, numRMPA
+}
+
+// Print the inferred delay lines with their taps and delay times
+func PrintMemoryLayout(lines []DelayLine, numRMPA int) {
+	if len(lines) == 0 && numRMPA == 0 {
+		return
+	}
+
+	fmt.Printf(";;\n;; Delay memory layout (%.0f Hz clock)\n;;\n", settings.ClockFrequency)
+	for _, line := range lines {
+		fmt.Printf(";; %-6s [%5d .. %5d] %5d words, %8.2f ms\n",
+			line.Name, line.Start, line.End(), line.Length+1, DelayTimeMs(line.Length))
+
+		st := &base.SymbolTable{Memory: []base.MemoryBlock{line.MemoryBlock}}
+		var taps []string
+		for _, t := range line.Taps {
+			name, _ := st.AddressName(t.Address)
+			taps = append(taps, fmt.Sprintf("%s@%d %s (%.2f ms)",
+				t.Op, t.IP, name, DelayTimeMs(t.Address-line.Start)))
+		}
+		if len(taps) > 0 {
+			fmt.Printf(";;        %s\n", strings.Join(taps, "\n;;        "))
+		}
+	}
+	if numRMPA > 0 {
+		fmt.Printf(";; NOTE: %d RMPA instruction(s) read through ADDR_PTR and are not part of the layout\n",
+			numRMPA)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,101 @@
+package disasm
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/handegar/fv1emu/asm"
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/dsp"
+)
+
+func decodeAll(words []uint32) []base.Op {
+	var ops []base.Op
+	for _, w := range words {
+		ops = append(ops, dsp.DecodeOp(w))
+	}
+	return ops
+}
+
+func Test_InferMemoryLayout(t *testing.T) {
+	prog, err := asm.Assemble(`
+	mem	unused	100
+	mem	ap1	156
+	mem	del	4000
+	mem	mod	600
+	rda	ap1#, 0.5
+	wrap	ap1, -0.5
+	wra	del, 0
+	rda	del+1000, 0.3
+	rda	del#, 0.3
+	rmpa	1.0
+	wra	mod, 0
+	cho	rda, sin0, sin|reg|compc, mod^
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines, symbols, numRMPA := InferMemoryLayout(decodeAll(prog.Words))
+	if numRMPA != 1 {
+		t.Errorf("Expected one RMPA, got %d", numRMPA)
+	}
+
+	// The length of the last block is unknown, it ends at the last tap
+	expected := []base.MemoryBlock{
+		{Name: "mem0", Start: 0, Length: 100},
+		{Name: "mem1", Start: 101, Length: 156},
+		{Name: "mem2", Start: 258, Length: 4000},
+		{Name: "mem3", Start: 4259, Length: 300},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d delay lines, got %+v", len(expected), lines)
+	}
+	for i, e := range expected {
+		if lines[i].MemoryBlock != e {
+			t.Errorf("Expected %+v, got %+v", e, lines[i].MemoryBlock)
+		}
+	}
+	if len(lines[0].Taps) != 0 || len(lines[1].Taps) != 2 || len(lines[2].Taps) != 3 {
+		t.Errorf("Unexpected taps: %+v", lines)
+	}
+
+	source, err := ToSpinASM(prog.Words, symbols)
+	if err != nil {
+		t.Fatalf("%s\n%s", err, source)
+	}
+	for _, expected := range []string{
+		"MEM\tmem1\t156\n", "\tRDA\tmem1#, 0.5\n", "\tWRAP\tmem1, -0.5\n",
+		"\tRDA\tmem2+1000, ", "\tRDA\tmem2#, ", "\tCHO\tRDA, SIN0, REG|COMPC, mem3#\n",
+	} {
+		if !strings.Contains(source, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, source)
+		}
+	}
+
+	t.Run("No delay memory", func(t *testing.T) {
+		prog, _ := asm.Assemble("rdax adcl, 1.0\nwrax dacl, 0\n")
+		lines, symbols, _ := InferMemoryLayout(decodeAll(prog.Words))
+		if len(lines) != 0 || len(symbols.Memory) != 0 {
+			t.Errorf("Expected no delay lines, got %+v", lines)
+		}
+	})
+}
+
+// The inferred layout must always disassemble into source which
+// assembles to the same program
+func Test_InferMemoryLayoutCalibrationPrograms(t *testing.T) {
+	files, _ := filepath.Glob("../programs/calibrate/*.spn")
+	for _, f := range files {
+		prog, err := asm.AssembleFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, symbols, _ := InferMemoryLayout(decodeAll(prog.Words))
+		if source, err := ToSpinASM(prog.Words, symbols); err != nil {
+			t.Errorf("%s: %s\n%s", f, err, source)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package disasm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/handegar/fv1emu/asm"
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/dsp"
+	"github.com/handegar/fv1emu/settings"
+	"github.com/handegar/fv1emu/utils"
+)
+
+/**
+  Disassembly into SpinASM source which can be fed back into SpinASM,
+  asfv1 or the built-in assembler. Unlike the listing from
+  'PrintCodeListing()' all values are written so that they assemble
+  to the very same instruction words.
+*/
+
+// Returns a program (one program, ie. 128 instruction words) as
+// SpinASM source. 'symbols' can be nil. An error is returned together
+// with the source if it does not assemble back to the same words.
+func ToSpinASM(words []uint32, symbols *base.SymbolTable) (string, error) {
+	if len(words) > settings.InstructionsPerSample {
+		words = words[:settings.InstructionsPerSample]
+	}
+
+	var ops []base.Op
+	for _, w := range words {
+		ops = append(ops, dsp.DecodeOp(w))
+	}
+
+	// Trailing NOPs are added by the assembler, but all SKP targets
+	// must be within the listing to get a label.
+	count := 0
+	labels := make(map[int]bool)
+	for i, op := range ops {
+		if op.Name != "NOP" {
+			count = i + 1
+		}
+		if op.Name == "SKP" {
+			labels[i+1+int(op.Args[1].RawValue)] = true
+		}
+	}
+	for target := range labels {
+		if target <= len(ops) {
+			count = max(count, target)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("; Disassembled by fv1emu v%s\n\n", settings.Version))
+
+	if symbols != nil {
+		writeSymbolDirectives(&sb, symbols)
+	}
+
+	for i := 0; i < count; i++ {
+		if labels[i] {
+			sb.WriteString(fmt.Sprintf("L%d:\n", i))
+		}
+		sb.WriteString("\t" + SpinASMInstruction(ops[i], i, len(ops), symbols) + "\n")
+	}
+	if labels[count] {
+		sb.WriteString(fmt.Sprintf("L%d:\n", count))
+	}
+
+	source := sb.String()
+	return source, verifySpinASM(source, words)
+}
+
+// Assemble the source again and compare with the original words
+func verifySpinASM(source string, words []uint32) error {
+	prog, err := asm.Assemble(source)
+	if err != nil {
+		return fmt.Errorf("The disassembly does not assemble:\n%s", err)
+	}
+
+	for i, w := range words {
+		if prog.Words[i] != w {
+			return fmt.Errorf("Instruction %d (0x%08X) can not be expressed in SpinASM, it assembles as 0x%08X",
+				i, w, prog.Words[i])
+		}
+	}
+	return nil
+}
+
+func writeSymbolDirectives(sb *strings.Builder, symbols *base.SymbolTable) {
+	for _, m := range symbols.Memory {
+		sb.WriteString(fmt.Sprintf("MEM\t%s\t%d\n", m.Name, m.Length))
+	}
+
+	for regNo := 0; regNo <= 0x3F; regNo++ {
+		if name, found := symbols.Registers[regNo]; found {
+			sb.WriteString(fmt.Sprintf("EQU\t%s\t%s\n", name, registerToSpinASM(regNo, nil)))
+		}
+	}
+
+	if len(symbols.Memory) > 0 || len(symbols.Registers) > 0 {
+		sb.WriteString("\n")
+	}
+}
+
+func registerToSpinASM(regNo int, symbols *base.SymbolTable) string {
+	name := symbols.RegisterName(regNo)
+	if name == "" || strings.HasPrefix(name, "<") {
+		return fmt.Sprintf("%d", regNo) // Unofficial registers
+	}
+	return name
+}
+
+func addressToSpinASM(addr int, symbols *base.SymbolTable) string {
+	if name, found := symbols.AddressName(addr); found {
+		return name
+	}
+	return fmt.Sprintf("%d", addr)
+}
+
+// A fixed point value as a decimal number which gives the very same
+// bits when assembled
+func fixedToSpinASM(raw int32, intBits int, fracBits int) string {
+	str := strconv.FormatFloat(utils.QFormatToFloat64(raw, intBits, fracBits), 'f', -1, 64)
+	if !strings.Contains(str, ".") {
+		str += ".0"
+	}
+	return str
+}
+
+func flagsToSpinASM(flags int, symbols map[int]string) string {
+	var names []string
+	for bit := 0; bit < 8; bit++ {
+		if flags&(1<<bit) != 0 {
+			names = append(names, symbols[1<<bit])
+		}
+	}
+	if len(names) == 0 {
+		return "0"
+	}
+	return strings.Join(names, "|")
+}
+
+// One instruction in SpinASM syntax. SKP targets within the program
+// are written as "L<instruction number>".
+func SpinASMInstruction(op base.Op, ip int, numOps int, symbols *base.SymbolTable) string {
+	reg := func() string {
+		return registerToSpinASM(int(op.Args[0].RawValue), symbols)
+	}
+
+	switch op.Name {
+	case "CLR", "NOT", "ABSA", "NOP":
+		return op.Name
+	case "SOF", "LOG", "EXP":
+		// LOG's D is S4.6 in the datasheet but S.10 in SpinASM and asfv1
+		return fmt.Sprintf("%s\t%s, %s", op.Name,
+			fixedToSpinASM(op.Args[1].RawValue, 1, 14),
+			fixedToSpinASM(op.Args[0].RawValue, 0, 10))
+	case "AND", "OR", "XOR":
+		return fmt.Sprintf("%s\t$%06X", op.Name, op.Args[1].RawValue&0xFFFFFF)
+	case "RDA", "WRA", "WRAP":
+		return fmt.Sprintf("%s\t%s, %s", op.Name,
+			addressToSpinASM(int(op.Args[0].RawValue), symbols),
+			fixedToSpinASM(op.Args[1].RawValue, 1, 9))
+	case "RMPA":
+		return fmt.Sprintf("RMPA\t%s", fixedToSpinASM(op.Args[1].RawValue, 1, 9))
+	case "RDAX", "WRAX", "MAXX", "RDFX", "WRLX", "WRHX":
+		return fmt.Sprintf("%s\t%s, %s", op.Name, reg(), fixedToSpinASM(op.Args[2].RawValue, 1, 14))
+	case "MULX", "LDAX":
+		return fmt.Sprintf("%s\t%s", op.Name, reg())
+	case "SKP":
+		flags := flagsToSpinASM(int(op.Args[2].RawValue), base.SkpFlagSymbols)
+		n := int(op.Args[1].RawValue)
+		if target := ip + 1 + n; target <= numOps {
+			return fmt.Sprintf("SKP\t%s, L%d", flags, target)
+		}
+		return fmt.Sprintf("SKP\t%s, %d", flags, n)
+	case "WLDS":
+		return fmt.Sprintf("WLDS\t%s, %d, %d", base.LFOTypeNames[op.Args[2].RawValue],
+			op.Args[1].RawValue, op.Args[0].RawValue)
+	case "WLDR":
+		return fmt.Sprintf("WLDR\t%s, %d, %d", base.LFOTypeNames[base.LFO_RMP0+op.Args[3].RawValue],
+			int16(op.Args[2].RawValue), base.RampAmpValues[op.Args[0].RawValue])
+	case "JAM":
+		return fmt.Sprintf("JAM\t%s", base.LFOTypeNames[base.LFO_RMP0+op.Args[1].RawValue])
+	case "CHO RDA", "CHO SOF", "CHO RDAL":
+		lfo := base.LFOTypeNames[op.Args[1].RawValue]
+		flagBits := int(op.Args[3].RawValue)
+		flags := flagsToSpinASM(flagBits, base.ChoFlagSymbols)
+		if flagBits == 0 && op.Args[1].RawValue <= base.LFO_SIN1 {
+			flags = base.ChoFlagSymbols[base.CHO_SIN]
+		}
+
+		switch op.Name {
+		case "CHO RDA":
+			return fmt.Sprintf("CHO\tRDA, %s, %s, %s", lfo, flags,
+				addressToSpinASM(int(op.Args[0].RawValue), symbols))
+		case "CHO SOF":
+			return fmt.Sprintf("CHO\tSOF, %s, %s, %s", lfo, flags,
+				fixedToSpinASM(op.Args[0].RawValue, 0, 15))
+		default:
+			return fmt.Sprintf("CHO\tRDAL, %s, %s", lfo, flags)
+		}
+	}
+
+	// Can't be expressed in SpinASM. The verification will complain.
+	return fmt.Sprintf("; Unknown instruction 0x%08X", uint32(op.RawValue))
+}
@@ -0,0 +1,158 @@
+package disasm
+
+import (
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/handegar/fv1emu/asm"
+	"github.com/handegar/fv1emu/dsp"
+	"github.com/handegar/fv1emu/settings"
+)
+
+func Test_SpinASMCalibrationPrograms(t *testing.T) {
+	files, err := filepath.Glob("../programs/calibrate/*.spn")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No calibration programs found")
+	}
+
+	for _, f := range files {
+		prog, err := asm.AssembleFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Both with and without the symbols from the source
+		for _, symbols := range []bool{false, true} {
+			st := prog.Symbols
+			if !symbols {
+				st = nil
+			}
+			source, err := ToSpinASM(prog.Words, st)
+			if err != nil {
+				t.Errorf("%s: %s\n%s", f, err, source)
+			}
+		}
+	}
+}
+
+// Random instruction for each op with the fields set to any value
+// SpinASM can express
+func randomOp(rnd *rand.Rand) uint32 {
+	names := []string{"SOF", "LOG", "EXP", "AND", "OR", "XOR", "CLR", "NOT", "ABSA",
+		"RDA", "WRA", "WRAP", "RMPA", "RDAX", "WRAX", "MAXX", "RDFX", "WRLX", "WRHX",
+		"MULX", "LDAX", "SKP", "NOP", "WLDS", "WLDR", "JAM", "CHO RDA", "CHO SOF", "CHO RDAL"}
+
+	name := names[rnd.Intn(len(names))]
+	op, _ := dsp.NewOp(name)
+	random := func(bits int) int32 {
+		return int32(rnd.Intn(1 << bits))
+	}
+
+	switch name {
+	case "SOF", "LOG", "EXP":
+		op.Args[0].RawValue = random(11)
+		op.Args[1].RawValue = random(16)
+	case "AND", "OR", "XOR":
+		op.Args[1].RawValue = random(24)
+	case "RDA", "WRA", "WRAP":
+		op.Args[0].RawValue = random(15)
+		op.Args[1].RawValue = random(11)
+	case "RMPA":
+		op.Args[1].RawValue = random(11)
+	case "RDAX", "WRAX", "MAXX", "RDFX", "WRLX", "WRHX":
+		op.Args[0].RawValue = random(6)
+		op.Args[2].RawValue = random(16)
+	case "MULX", "LDAX":
+		op.Args[0].RawValue = random(6)
+	case "SKP":
+		op.Args[1].RawValue = random(6)
+		op.Args[2].RawValue = random(5) | 1 // Not a NOP
+	case "WLDS":
+		op.Args[0].RawValue = random(15)
+		op.Args[1].RawValue = random(9)
+		op.Args[2].RawValue = random(1)
+	case "WLDR":
+		op.Args[0].RawValue = random(2)
+		op.Args[2].RawValue = random(16)
+		op.Args[3].RawValue = random(1)
+	case "JAM":
+		op.Args[1].RawValue = random(1)
+	case "CHO RDA", "CHO SOF", "CHO RDAL":
+		op.Args[1].RawValue = random(2)
+		op.Args[3].RawValue = random(6)
+		if name == "CHO RDA" {
+			op.Args[0].RawValue = random(15)
+		} else if name == "CHO SOF" {
+			op.Args[0].RawValue = random(16)
+		}
+	}
+
+	word, err := dsp.EncodeOp(op)
+	if err != nil {
+		panic(err)
+	}
+	return word
+}
+
+func Test_SpinASMRandomPrograms(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 200; n++ {
+		var words []uint32
+		length := 1 + rnd.Intn(settings.InstructionsPerSample)
+		for i := 0; i < length; i++ {
+			words = append(words, randomOp(rnd))
+		}
+
+		source, err := ToSpinASM(words, nil)
+		if err != nil {
+			t.Fatalf("%s\n%s", err, source)
+		}
+	}
+}
+
+func Test_SpinASMSyntax(t *testing.T) {
+	prog, err := asm.Assemble(`
+	mem	delay	1000
+	equ	vol	reg3
+	skp	run, start
+	wldr	rmp0, -100, 1024
+start:	rdax	vol, -2.0
+	and	$7FFF00
+	cho	rda, rmp0, reg|compc|rptr2|na, delay^
+	cho	sof, sin1, cos, -0.25
+	rda	delay#, 1.0
+	wra	delay+10, 0.5
+	skp	zro|neg, end
+	clr
+end:
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := ToSpinASM(prog.Words, prog.Symbols)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"MEM\tDELAY\t1000",
+		"EQU\tVOL\tREG3",
+		"\tSKP\tRUN, L2\n",
+		"\tWLDR\tRMP0, -100, 1024\n",
+		"L2:\n\tRDAX\tVOL, -2.0\n",
+		"\tAND\t$7FFF00\n",
+		"\tCHO\tRDA, RMP0, REG|COMPC|RPTR2|NA, DELAY^\n",
+		"\tCHO\tSOF, SIN1, COS, -0.25\n",
+		"\tRDA\tDELAY#, 1.0\n",
+		"\tWRA\tDELAY+10, 0.5\n",
+		"\tSKP\tNEG|ZRO, L10\n",
+		"\tCLR\nL10:\n",
+	} {
+		if !strings.Contains(source, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, source)
+		}
+	}
+}
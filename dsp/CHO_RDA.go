@@ -1,10 +1,9 @@
 package dsp
 
 import (
-	"errors"
+	"math"
 
 	"github.com/handegar/fv1emu/base"
-	"github.com/handegar/fv1emu/utils"
 )
 
 /**
@@ -19,6 +18,19 @@ selected LFO at run time, for an in depth explanation please consult the FV-1
 datasheet alongside with application note AN-0001. CHO RDA is a very flexible
 and powerful instruction, especially useful for delay line modulation effects
 such as chorus or pitch shifting.
+
+  The scaled LFO is an address offset with a fractional part. The
+  integer part (rounded down) moves the read and the fraction is the
+  coefficient, or 1-fraction with COMPC. A pair of instructions, one
+  word apart, interpolates between two neighbouring delay words:
+
+  	cho rda, sin0, sin|reg|compc, delay    ; (1-frac) * delay[n]
+  	cho rda, sin0, sin, delay+1            ; frac * delay[n+1]
+
+  The NA flag reads the word at the address itself, without any
+  offset, and uses the crossfade (or 1-crossfade with COMPC) as the
+  coefficient. Together with RPTR2 this crossfades the two read
+  pointers of a pitch shifter.
 */
 
 /*
@@ -36,7 +48,7 @@ Flags:
 		  REG   - Save LFO value to a register for reuse
 */
 
-func CHO_RDA(op base.Op, state *State) error {
+func CHO_RDA(op base.Op) opFunc {
 	/*
 	   LFO related post:
 	   http://www.spinsemi.com/forum/viewtopic.php?f=3&t=505
@@ -46,50 +58,31 @@ func CHO_RDA(op base.Op, state *State) error {
 	typ := int(op.Args[1].RawValue)
 	flags := int(op.Args[3].RawValue)
 
+	if err := checkCHOFlags(typ, flags); err != nil {
+		return failOp(err)
+	}
 	if (flags & base.CHO_COS) != 0 {
-		if !isSinLFO(typ) {
-			return errors.New("Cannot use the COS flag with RAMP LFOs")
-		}
 		typ += 4 // Make SIN -> COS
 	}
 
-	lfo := GetLFOValue(typ, state, (flags&base.CHO_REG) != 0)
-
-	if (flags & base.CHO_RPTR2) != 0 {
-		if isSinLFO(typ) {
-			return errors.New("Cannot use RPTR2 with SIN LFOs")
-		}
-		lfo = GetLFOValuePlusHalfCycle(typ, state)
-	}
-
-	if flags&base.CHO_COMPA != 0 {
-		if isSinLFO(typ) {
-			lfo = -lfo
-		} else {
-			lfo = GetRampRange(typ, state) - lfo
-		}
-	}
-
-	if (flags & base.CHO_NA) != 0 { // === Shall we do the X-FADE? =====
-		if isSinLFO(typ) {
-			return errors.New("Cannot use the NA flag with SIN LFOs")
+	return func(state *State) error {
+		lfo := GetLFOValue(typ, state, (flags&base.CHO_REG) != 0)
+		lfo = applyLFOFlags(lfo, typ, flags, state)
+
+		var whole, coeff float64
+		if (flags & base.CHO_NA) != 0 { // === Shall we do the X-FADE? =====
+			coeff = GetXFadeFromLFO(lfo, typ, state)
+		} else { // == Interpolated read ===================================
+			offset := ScaleLFOValue(lfo, typ, state)
+			whole = math.Floor(offset)
+			coeff = offset - whole
 		}
-
-		xfade := GetXFadeFromLFO(lfo, typ, state)
 		if (flags & base.CHO_COMPC) != 0 {
-			xfade = 1.0 - xfade
+			coeff = 1.0 - coeff
 		}
-		state.scaleReg.SetFloat64(xfade)
-		state.offsetReg.SetInt32(int32(addr))
 
-		state.ACC.Add(state.offsetReg.Mult(state.scaleReg))
-	} else { // == Regular LFO envelope ================================
-		scaledLFO := ScaleLFOValue(lfo, typ, state)
-		delayIndex := addr + int(scaledLFO)
-
-		idx, err := capDelayRAMIndex(state.DelayRAMPtr+delayIndex, state)
+		idx, err := capDelayRAMIndex(state.DelayRAMPtr+addr+int(whole), state)
 		if err != nil {
-			utils.Assert(false, "Mem access out of bounds")
 			return state.DebugFlags.IncreaseOutOfBoundsMemoryRead()
 		}
 
@@ -97,23 +90,10 @@ func CHO_RDA(op base.Op, state *State) error {
 		state.LR.SetWithIntsAndFracs(delayValue, 0, 23)
 		state.workRegA.SetWithIntsAndFracs(delayValue, 0, 23)
 
-		if (flags & base.CHO_COMPC) != 0 {
-			// FIXME: Is this shift needed? (20220923 handegar)
-			if isSinLFO(typ) {
-				lfo = (lfo + 1.0) / 2.0 // Shift to [0 .. 1.0]
-			}
-			state.scaleReg.SetFloat64(1.0 - lfo)
-			lfo = (2*lfo - 1.0)
-		} else {
-			state.scaleReg.SetFloat64(lfo)
-		}
-
-		utils.Assert(lfo >= -1.0 && lfo <= 1.0,
-			"LFO is < 0 || > 1.0 (was %f, type=%s)", lfo, base.LFOTypeNames[typ])
-
+		state.scaleReg.SetFloat64(coeff)
 		state.workRegA.Mult(state.scaleReg)
 		state.ACC.Add(state.workRegA)
-	}
 
-	return nil
+		return nil
+	}
 }
@@ -0,0 +1,28 @@
+package dsp
+
+import (
+	"fmt"
+
+	"github.com/handegar/fv1emu/base"
+)
+
+// A program ready to be run by 'ProcessSample()'. Each instruction is
+// turned into a closure once so nothing has to be looked up or
+// converted per sample. The decoded instructions are kept for the
+// debugger and anyone else who wants to inspect the program.
+type Program struct {
+	Ops  []base.Op
+	code []opFunc
+}
+
+func Compile(ops []base.Op) (*Program, error) {
+	prog := &Program{Ops: ops, code: make([]opFunc, len(ops))}
+	for ip, op := range ops {
+		code, err := compileOp(op)
+		if err != nil {
+			return nil, fmt.Errorf("Instruction %d: %s", ip, err)
+		}
+		prog.code[ip] = code
+	}
+	return prog, nil
+}
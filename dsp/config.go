@@ -0,0 +1,35 @@
+package dsp
+
+import (
+	"github.com/handegar/fv1emu/settings"
+)
+
+// The settings which change how a program is run. Each State has its
+// own copy so that several emulators with different settings can run
+// in the same process. See the settings package for a description of
+// each value.
+type Config struct {
+	ClockFrequency        float64 // Hz. The LFOs step once per sample and do not use it.
+	Pot0Value             float64
+	Pot1Value             float64
+	Pot2Value             float64
+	InstructionsPerSample int
+	Disable24BitsClamping bool
+	AllowAllChoRdalFlags  bool
+	FloatDelayRAM         bool
+}
+
+// The configuration given by the command line, ie. the global
+// settings when this is called
+func ConfigFromSettings() Config {
+	return Config{
+		ClockFrequency:        settings.ClockFrequency,
+		Pot0Value:             settings.Pot0Value,
+		Pot1Value:             settings.Pot1Value,
+		Pot2Value:             settings.Pot2Value,
+		InstructionsPerSample: settings.InstructionsPerSample,
+		Disable24BitsClamping: settings.Disable24BitsClamping,
+		AllowAllChoRdalFlags:  settings.AllowAllChoRdalFlags,
+		FloatDelayRAM:         settings.FloatDelayRAM,
+	}
+}
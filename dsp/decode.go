@@ -3,7 +3,6 @@ package dsp
 import (
 	//"fmt"
 	"github.com/handegar/fv1emu/base"
-	"github.com/handegar/fv1emu/settings"
 )
 
 func DecodeOp(opcode uint32) base.Op {
@@ -23,11 +22,7 @@ func DecodeOp(opcode uint32) base.Op {
 	// Special case for WLDR -> WLDS
 	if opcodeNum == 0x12 && subOpcodeNum == 0 {
 		op.Name = "WLDS"
-		op.Args = []base.OpArg{
-			{Len: 15, Type: base.UInt, RawValue: 0},
-			{Len: 9, Type: base.UInt, RawValue: 0},
-			{Len: 1, Type: base.Flag, RawValue: 0},
-			{Len: 2, Type: base.Const, RawValue: 0}}
+		op.Args = wldsArgs()
 	}
 
 	if op.Name == "OR" || op.Name == "AND" || op.Name == "XOR" {
@@ -73,7 +68,7 @@ func DecodeOp(opcode uint32) base.Op {
 		if op.Args[1].RawValue == 0 {
 			op.Name = "CLR"
 		}
-	} else if op.Name == "XOR" && op.Args[1].RawValue == 0xFFFFF8 {
+	} else if op.Name == "XOR" && op.Args[1].RawValue&0xFFFFFF == 0xFFFFFF {
 		op.Name = "NOT"
 	} else if op.Name == "MAXX" {
 		if op.Args[0].RawValue == 0 && op.Args[2].RawValue == 0 {
@@ -86,7 +81,16 @@ func DecodeOp(opcode uint32) base.Op {
 	return op
 }
 
-func DecodeOpCodes(buffer []uint32) []base.Op {
+// Decode a program with the number of instructions per sample given
+// by 'config'
+func DecodeOpCodes(buffer []uint32, config Config) []base.Op {
+	return DecodeProgram(buffer, config.InstructionsPerSample)
+}
+
+// Decode until the first SKP/NOP without arguments.
+// 'instructionsPerSample' works as a fuse for buffers with more than
+// one program.
+func DecodeProgram(buffer []uint32, instructionsPerSample int) []base.Op {
 	var ret []base.Op
 	for n, b := range buffer {
 		op := DecodeOp(b)
@@ -96,7 +100,7 @@ func DecodeOpCodes(buffer []uint32) []base.Op {
 		}
 
 		ret = append(ret, op)
-		if n > settings.InstructionsPerSample { // Fuse
+		if n > instructionsPerSample { // Fuse
 			break
 		}
 	}
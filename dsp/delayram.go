@@ -0,0 +1,79 @@
+package dsp
+
+import (
+	"math/bits"
+)
+
+//
+// The delay memory of the FV-1 does not store the full S.23 values
+// but 14-bit floating point words (S+10+3): a sign, a 3 bit exponent
+// and a 10 bit mantissa. Each octave from full scale and down has 11
+// significant bits (with the implied leading one), and below the 7th
+// octave the values are denormal with the resolution of a 17 bit
+// integer. This gives the noise floor and grit of long reverbs on
+// the real chip.
+//
+//   Bit 13:      Sign
+//   Bits 12..10: Exponent (0 is denormal)
+//   Bits 9..0:   Mantissa
+//
+
+const (
+	delayWordMantissaBits = 10
+	delayWordMaxExponent  = 7
+
+	// The step of the denormal and lowest normal octave in S.23 LSBs
+	delayWordMinShift = 23 - delayWordMantissaBits - delayWordMaxExponent
+)
+
+// Compress a S.23 value to a delay memory word. Values are rounded to
+// the nearest word and clipped at full scale.
+func EncodeDelayWord(v int32) uint16 {
+	var sign uint16
+	m := uint32(v)
+	if v < 0 {
+		sign = 1 << 13
+		m = uint32(-int64(v))
+	}
+
+	// The number of bits above the mantissa decides the exponent
+	exp := max(0, bits.Len32(m)-delayWordMantissaBits-delayWordMinShift)
+	shift := delayWordMinShift + max(0, exp-1)
+	mantissa := (m + (1 << shift >> 1)) >> shift // Rounded
+	if exp > 0 {
+		mantissa -= 1 << delayWordMantissaBits // The implied one
+	}
+	if mantissa == 1<<delayWordMantissaBits { // Rounded up to the next octave
+		exp++
+		mantissa = 0
+	}
+	if exp > delayWordMaxExponent {
+		exp = delayWordMaxExponent
+		mantissa = 1<<delayWordMantissaBits - 1
+	}
+	return sign | uint16(exp)<<delayWordMantissaBits | uint16(mantissa)
+}
+
+// Expand a delay memory word to a S.23 value
+func DecodeDelayWord(w uint16) int32 {
+	exp := int(w>>delayWordMantissaBits) & delayWordMaxExponent
+	m := int32(w & (1<<delayWordMantissaBits - 1))
+	if exp > 0 {
+		m = (m | 1<<delayWordMantissaBits) << (exp - 1)
+	}
+	m <<= delayWordMinShift
+	if w&(1<<13) != 0 {
+		return -m
+	}
+	return m
+}
+
+// Write the 24 bits of the accumulator to the delay memory, through
+// the 14-bit word format when Config.FloatDelayRAM is set
+func (s *State) writeDelayRAM(idx int, acc *Register) {
+	v := acc.ToQFormat(0, 23)
+	if s.Config.FloatDelayRAM {
+		v = DecodeDelayWord(EncodeDelayWord((v<<8)>>8)) & 0xFFFFFF
+	}
+	s.DelayRAM[idx] = v
+}
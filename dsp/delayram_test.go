@@ -0,0 +1,67 @@
+package dsp
+
+import (
+	"testing"
+
+	"github.com/handegar/fv1emu/base"
+)
+
+func Test_DelayWords(t *testing.T) {
+	type testCase struct {
+		in       int32
+		expected int32
+	}
+	for _, c := range []testCase{
+		{0, 0},
+		{63, 64}, // Denormal, steps of 64
+		{-100, -128},
+		{0xFFFF, 0x10000},            // Rounded up to the lowest normal octave
+		{0x10000 + 63, 0x10040},      // Normal, 11 significant bits
+		{0x400000 + 0x800, 0x401000}, // Top octave, steps of 0x1000
+		{0x7FFFFF, 0x7FF000},         // Clipped at full scale
+		{-0x800000, -0x7FF000},
+	} {
+		w := EncodeDelayWord(c.in)
+		if w >= 1<<14 {
+			t.Errorf("%d: Expected a 14-bit word, got 0x%x", c.in, w)
+		}
+		if out := DecodeDelayWord(w); out != c.expected {
+			t.Errorf("Expected 0x%x -> 0x%x, got 0x%x (word 0x%x)", c.in, c.expected, out, w)
+		}
+	}
+
+	// Every word decodes to a value which encodes to the same word
+	for w := uint16(0); w < 1<<14; w++ {
+		if w == 1<<13 {
+			continue // Negative zero
+		}
+		if back := EncodeDelayWord(DecodeDelayWord(w)); back != w {
+			t.Errorf("Expected word 0x%x back, got 0x%x", w, back)
+		}
+	}
+}
+
+func Test_FloatDelayRAM(t *testing.T) {
+	config := ConfigFromSettings()
+	config.FloatDelayRAM = true
+	state := NewStateWithConfig(config)
+	state.ACC.SetWithIntsAndFracs(0x123456, 0, 23)
+
+	op := base.Ops[0x02] // WRA
+	op.Args[0].RawValue = 0x3e8
+	op.Args[1].RawValue = 0
+	applyOp(op, state)
+	if state.DelayRAM[0x3e8] != 0x123400 {
+		t.Errorf("Expected RAM[0x3e8]=0x123400, got 0x%x", state.DelayRAM[0x3e8])
+	}
+
+	// Negative values are stored as 24 bits like without the 14-bit
+	// format
+	state.ACC.SetWithIntsAndFracs(-0x123456, 0, 23)
+	op = base.Ops[0x03] // WRAP
+	op.Args[0].RawValue = 0x3e9
+	applyOp(op, state)
+	if state.DelayRAM[0x3e9] != -0x123400&0xFFFFFF {
+		t.Errorf("Expected RAM[0x3e9]=0x%x, got 0x%x", -0x123400&0xFFFFFF, state.DelayRAM[0x3e9])
+	}
+}
@@ -0,0 +1,154 @@
+package dsp
+
+import (
+	"fmt"
+
+	"github.com/handegar/fv1emu/base"
+)
+
+// Pseudo instructions and the instruction they are encoded as
+var pseudoOps = map[string]uint32{
+	"CLR":      0x0E, // AND 0
+	"NOT":      0x10, // XOR 0xFFFFFF
+	"ABSA":     0x09, // MAXX 0, 0
+	"LDAX":     0x05, // RDFX REG, 0
+	"NOP":      0x11, // SKP 0, 0
+	"WLDS":     0x12, // WLDR with the upper two bits cleared
+	"CHO RDA":  0x14,
+	"CHO SOF":  0x14,
+	"CHO RDAL": 0x14,
+	"CHO <?>":  0x14,
+}
+
+// The argument layout of WLDS. Also used by DecodeOp().
+func wldsArgs() []base.OpArg {
+	return []base.OpArg{
+		{Len: 15, Type: base.UInt, RawValue: 0},
+		{Len: 9, Type: base.UInt, RawValue: 0},
+		{Len: 1, Type: base.Flag, RawValue: 0},
+		{Len: 2, Type: base.Const, RawValue: 0}}
+}
+
+// Returns the opcode number (lower 5 bits) of an instruction, pseudo
+// instructions included
+func OpcodeNumber(name string) (uint32, bool) {
+	if num, found := pseudoOps[name]; found {
+		return num, true
+	}
+	for num, op := range base.Ops {
+		if op.Name == name {
+			return num, true
+		}
+	}
+	return 0, false
+}
+
+// Creates an op with the argument layout DecodeOp() would give it and
+// all the fields defining the instruction set. Returns FALSE for
+// unknown instructions.
+func NewOp(name string) (base.Op, bool) {
+	opcodeNum, found := OpcodeNumber(name)
+	if !found {
+		return base.Op{}, false
+	}
+
+	op := base.Op{Name: name}
+	if name == "WLDS" {
+		op.Args = wldsArgs()
+	} else {
+		op.Args = append(op.Args, base.Ops[opcodeNum].Args...)
+	}
+
+	switch name {
+	case "NOT":
+		op.Args[1].RawValue = -1 // Same as DecodeOp() gives
+	case "LDAX":
+		op.Args[2].Type = base.Blank
+	case "CHO SOF":
+		op.Args[4].RawValue = 0b10
+	case "CHO RDAL":
+		op.Args[4].RawValue = 0b11
+	}
+	return op, true
+}
+
+/*
+*
+
+	The inverse of DecodeOp(). The arguments must follow the same
+	layout as DecodeOp() produces. Signed fields may be given either as
+	the raw bit pattern or as a negative number.
+
+	The fields defining a pseudo instruction are implied by the name,
+	ie. "CLR" is always encoded with a zero mask and "NOP" with zero
+	flags and no skip. Blank fields are always encoded as zeros.
+*/
+func EncodeOp(op base.Op) (uint32, error) {
+	opcodeNum, found := OpcodeNumber(op.Name)
+	if !found {
+		return 0, fmt.Errorf("Unknown instruction '%s'", op.Name)
+	}
+
+	layout := base.Ops[opcodeNum].Args
+	if op.Name == "WLDS" {
+		layout = wldsArgs()
+	}
+	if len(op.Args) != len(layout) {
+		return 0, fmt.Errorf("%s expects %d arguments, got %d", op.Name, len(layout), len(op.Args))
+	}
+
+	args := make([]int32, len(op.Args))
+	for i, arg := range op.Args {
+		args[i] = arg.RawValue
+	}
+
+	switch op.Name {
+	case "CLR":
+		args[1] = 0
+	case "NOT":
+		args[1] = 0xFFFFFF
+	case "ABSA":
+		args[0], args[2] = 0, 0
+	case "LDAX":
+		args[2] = 0
+	case "NOP":
+		args[1], args[2] = 0, 0
+	case "WLDS":
+		args[3] = 0
+	case "WLDR":
+		if args[4] == 0 {
+			return 0, fmt.Errorf("WLDR must have a non-zero value in the upper two bits, zero means WLDS")
+		}
+	case "CHO RDA", "CHO SOF", "CHO RDAL":
+		subCmd := map[string]int32{"CHO RDA": 0b00, "CHO SOF": 0b10, "CHO RDAL": 0b11}[op.Name]
+		if args[4] != subCmd {
+			return 0, fmt.Errorf("%s must have 0b%02b as sub-command, got 0b%02b", op.Name, subCmd, args[4])
+		}
+	}
+
+	word := opcodeNum
+	switch opcodeNum {
+	case 0x0E, 0x0F, 0x10: // AND, OR and XOR (and CLR/NOT)
+		// The 24-bit mask starts at bit 8. DecodeOp() sign-extends it.
+		mask := args[1]
+		if mask < -(1<<23) || mask > (1<<24)-1 {
+			return 0, fmt.Errorf("The mask of %s (0x%x) does not fit into 24 bits", op.Name, mask)
+		}
+		return word | (uint32(mask)&0xFFFFFF)<<8, nil
+	}
+
+	bitPos := 5
+	for i, arg := range layout {
+		if arg.Type != base.Blank {
+			v := args[i]
+			if v < -(1<<(arg.Len-1)) || int64(v) > (1<<arg.Len)-1 {
+				return 0, fmt.Errorf("Argument %d of %s (%d) does not fit into %d bits",
+					i, op.Name, v, arg.Len)
+			}
+			word |= (uint32(v) & (1<<arg.Len - 1)) << bitPos
+		}
+		bitPos += arg.Len
+	}
+
+	return word, nil
+}
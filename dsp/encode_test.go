@@ -0,0 +1,201 @@
+package dsp
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/handegar/fv1emu/base"
+)
+
+// Values to test for a field of 'len' bits
+func boundaryValues(len int) []uint32 {
+	max := uint32(1)<<len - 1
+	values := []uint32{0, 1, max, max - 1, max >> 1, (max >> 1) + 1, 0x55555555 & max, 0xAAAAAAAA & max}
+	return values
+}
+
+// All instruction words with each (non-blank) field at its boundary
+// values while the other fields are all zeros or all ones
+func boundaryWords(opcodeNum uint32, layout []base.OpArg) []uint32 {
+	var words []uint32
+	for i := range layout {
+		if layout[i].Type == base.Blank {
+			continue
+		}
+		for _, others := range []bool{false, true} {
+			for _, v := range boundaryValues(layout[i].Len) {
+				word := opcodeNum
+				bitPos := 5
+				for j, arg := range layout {
+					fieldMask := uint32(1)<<arg.Len - 1
+					field := uint32(0)
+					if j == i {
+						field = v
+					} else if others {
+						field = fieldMask
+					}
+					if arg.Type != base.Blank {
+						word |= (field & fieldMask) << bitPos
+					}
+					bitPos += arg.Len
+				}
+				words = append(words, word)
+			}
+		}
+	}
+	return words
+}
+
+func Test_EncodeRoundTrip(t *testing.T) {
+	var words []uint32
+	for opcodeNum, opDef := range base.Ops {
+		switch opcodeNum {
+		case 0x0E, 0x0F, 0x10: // AND, OR and XOR: mask at bit 8
+			for _, v := range boundaryValues(24) {
+				words = append(words, opcodeNum|v<<8)
+			}
+		case 0x12: // WLDS has its own layout (with the upper bits cleared)
+			layout := wldsArgs()
+			for _, w := range boundaryWords(opcodeNum, layout[:3]) {
+				words = append(words, w)
+			}
+			fallthrough
+		default:
+			words = append(words, boundaryWords(opcodeNum, opDef.Args)...)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, word := range words {
+		op := DecodeOp(word)
+		names[op.Name] = true
+
+		encoded, err := EncodeOp(op)
+		if err != nil {
+			t.Errorf("0x%08X (%s): %s", word, op.Name, err)
+			continue
+		}
+		if encoded != word {
+			t.Errorf("0x%08X (%s) was encoded as 0x%08X", word, op.Name, encoded)
+		}
+	}
+
+	// Make sure all instructions and special cases has been covered
+	for _, name := range []string{"RDA", "RMPA", "WRA", "WRAP", "RDAX", "RDFX", "LDAX",
+		"WRAX", "WRHX", "WRLX", "MAXX", "ABSA", "MULX", "LOG", "EXP", "SOF", "AND",
+		"CLR", "OR", "XOR", "NOT", "SKP", "NOP", "WLDS", "WLDR", "JAM",
+		"CHO RDA", "CHO SOF", "CHO RDAL"} {
+		if !names[name] {
+			t.Errorf("%s was never tested", name)
+		}
+	}
+}
+
+// Random words: Encoding a decoded op must give a word which decodes
+// to the same op again (blank bits will be lost on the way).
+func Test_EncodeRandomWords(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		word := rnd.Uint32()
+		op := DecodeOp(word)
+		if _, found := OpcodeNumber(op.Name); !found {
+			continue // Unused opcodes
+		}
+
+		encoded, err := EncodeOp(op)
+		if err != nil {
+			t.Fatalf("0x%08X (%s): %s", word, op.Name, err)
+		}
+
+		again := DecodeOp(encoded)
+		again.RawValue = op.RawValue
+		if !reflect.DeepEqual(op, again) {
+			t.Fatalf("0x%08X: Decoded as %+v, but 0x%08X decodes as %+v", word, op, encoded, again)
+		}
+	}
+}
+
+func Test_EncodeNewOp(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected uint32
+	}{
+		{"CLR", 0x0000000E},
+		{"NOT", 0xFFFFFF10},
+		{"ABSA", 0x00000009},
+		{"NOP", 0x00000011},
+		{"LDAX", 0x00000005},
+		{"RMPA", 0x00000301},
+		{"JAM", 0x00000093},
+		{"WLDS", 0x00000012},
+		{"WLDR", 0x40000012},
+		{"CHO RDA", 0x00000014},
+		{"CHO SOF", 0x80000014},
+		{"CHO RDAL", 0xC0000014},
+	}
+
+	for _, test := range tests {
+		op, found := NewOp(test.name)
+		if !found {
+			t.Errorf("NewOp() does not know '%s'", test.name)
+			continue
+		}
+		word, err := EncodeOp(op)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if word != test.expected {
+			t.Errorf("%s: Expected 0x%08X, got 0x%08X", test.name, test.expected, word)
+		}
+		if DecodeOp(word).Name != test.name {
+			t.Errorf("%s: Decodes as %s", test.name, DecodeOp(word).Name)
+		}
+	}
+
+	// Negative values for signed fields
+	op, _ := NewOp("RDAX")
+	op.Args[0].RawValue = base.ADCL
+	op.Args[2].RawValue = -0x4000 // -1.0
+	word, err := EncodeOp(op)
+	if err != nil || word != 0xC0000284 {
+		t.Errorf("RDAX ADCL,-1.0: Expected 0xC0000284, got 0x%08X (%v)", word, err)
+	}
+}
+
+func Test_EncodeErrors(t *testing.T) {
+	if _, err := EncodeOp(base.Op{Name: "FOO"}); err == nil {
+		t.Errorf("Expected an unknown instruction to fail")
+	}
+
+	op, _ := NewOp("RDAX")
+	op.Args = op.Args[:2]
+	if _, err := EncodeOp(op); err == nil {
+		t.Errorf("Expected a missing argument to fail")
+	}
+
+	op, _ = NewOp("RDAX")
+	op.Args[0].RawValue = 64
+	if _, err := EncodeOp(op); err == nil {
+		t.Errorf("Expected a 7-bit register number to fail")
+	}
+
+	op, _ = NewOp("OR")
+	op.Args[1].RawValue = 1 << 24
+	if _, err := EncodeOp(op); err == nil {
+		t.Errorf("Expected a 25-bit mask to fail")
+	}
+
+	op, _ = NewOp("CHO SOF")
+	op.Args[4].RawValue = 0b11
+	if _, err := EncodeOp(op); err == nil {
+		t.Errorf("Expected a CHO SOF with the RDAL sub-command to fail")
+	}
+
+	op, _ = NewOp("WLDR")
+	op.Args[4].RawValue = 0
+	if _, err := EncodeOp(op); err == nil {
+		t.Errorf("Expected a WLDR which would decode as WLDS to fail")
+	}
+}
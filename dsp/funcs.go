@@ -6,12 +6,9 @@ import (
 	"math"
 
 	"github.com/handegar/fv1emu/base"
-	"github.com/handegar/fv1emu/settings"
 	"github.com/handegar/fv1emu/utils"
 )
 
-var skipNumSamples int = 0
-
 // Return ENUMS for the debug callbacks
 const (
 	Ok int = iota
@@ -22,49 +19,46 @@ const (
 
 type DebugCallback func(opCodes []base.Op, state *State, sampleNum int) int
 
-func SkipNumSamples(num int) {
-	skipNumSamples = num
+// Runs the program once, ie. processes one sample. Errors are
+// printed. Returns FALSE if processing should stop.
+func ProcessSample(prog *Program, state *State, sampleNum int,
+	debugPre DebugCallback, debugPost DebugCallback) bool {
+	cont, err := processSample(prog, state, sampleNum, debugPre, debugPost)
+	if err != nil {
+		fmt.Printf("An error occured (IP=%d, Sample=%d):\n",
+			state.IP, sampleNum)
+		fmt.Println(err)
+		state.DebugFlags.Print()
+	}
+	return cont
 }
 
-func GetSkipNumSamples() int {
-	return skipNumSamples
+// Same as 'ProcessSample()' but without any debugging and nothing is
+// printed
+func RunProgram(prog *Program, state *State) error {
+	_, err := processSample(prog, state, 0, nil, nil)
+	return err
 }
 
-func ProcessSample(opCodes []base.Op, state *State, sampleNum int,
-	debugPre DebugCallback, debugPost DebugCallback) bool {
+func processSample(prog *Program, state *State, sampleNum int,
+	debugPre DebugCallback, debugPost DebugCallback) (bool, error) {
+	debug := debugPre != nil && debugPost != nil
 	state.IP = 0
 
-	cycles := 0
-
-	for state.IP < uint(len(opCodes)) {
-		cycles += 1
-		// FIXME: The LFO should probably be updated in sync
-		// with an external clock, not per
-		// instruction. (20220227 handegar)
-		// FIXME: It seems like LFOs might be update once per sample period but at
-		// different times (ref: // http://www.spinsemi.com/forum/viewtopic.php?p=5086#p5086)
-		state.UpdateRampLFOs()
-		state.UpdateSineLFOs()
-
-		op := opCodes[state.IP]
-
-		if skipNumSamples < 1 {
-			debugPre(opCodes, state, sampleNum)
+	for state.IP < uint(len(prog.code)) {
+		if debug && state.skipNumSamples < 1 {
+			debugPre(prog.Ops, state, sampleNum)
 		}
 
-		err := applyOp(op, state)
+		err := prog.code[state.IP](state)
 		if err != nil {
-			fmt.Printf("An error occured (IP=%d, Sample=%d):\n",
-				state.IP, sampleNum)
-			fmt.Println(err)
-			state.DebugFlags.Print()
-			return false
+			return false, err
 		}
 
-		if skipNumSamples <= 0 {
-			status := debugPost(opCodes, state, sampleNum)
+		if debug && state.skipNumSamples <= 0 {
+			status := debugPost(prog.Ops, state, sampleNum)
 			if status == Fatal || status == Quit {
-				return false
+				return false, nil
 			} else if status == NextInstruction {
 				continue
 			}
@@ -84,67 +78,104 @@ func ProcessSample(opCodes []base.Op, state *State, sampleNum int,
 	}
 
 	// Decrease debug-skipping count
-	if skipNumSamples > 0 {
-		skipNumSamples -= 1
+	if state.skipNumSamples > 0 {
+		state.skipNumSamples -= 1
 	}
 
-	//
-	// To ensure the all samples uses 128 cycles we'll have to
-	// update the LFOs for remaining cycles to ensure that we stay
-	// close to how the FV-1 operates (and sounds).
-	//
-	for cycles < settings.InstructionsPerSample {
-		state.UpdateSineLFOs()
-		state.UpdateRampLFOs()
-		cycles += 1
-	}
+	// The LFOs take one step per sample, so their speed follows the
+	// rate the program runs at
+	state.UpdateSineLFOs()
+	state.UpdateRampLFOs()
 
-	return true // Lets continue!
+	return true, nil // Lets continue!
 }
 
 /*
-Returns the LFO value, but 1/2 further into the cycle.
+Returns the ramp LFO value 'lfo', but 1/2 further into the cycle
+(RPTR2). Wraps at the end of the ramp.
 NB: This is only valid for RAMP LFOs.
 */
-func GetLFOValuePlusHalfCycle(lfoType int, state *State) float64 {
+func GetLFOValuePlusHalfCycle(lfo float64, lfoType int, state *State) float64 {
 	utils.Assert(!isSinLFO(lfoType), "Cannot call GetLFOValuePlusHalfCycle() for SIN LFOs")
 
-	lfo := GetLFOValue(lfoType, state, false) + 0.5
-	if lfo > 1.0 {
-		lfo -= 1.0
+	end := GetRampRange(lfoType, state)
+	lfo += end / 2.0
+	if lfo >= end {
+		lfo -= end
+	}
+	return lfo
+}
+
+// Returns an error if the flags of a CHO instruction cannot be used
+// with the LFO 'typ'
+func checkCHOFlags(typ int, flags int) error {
+	if (flags&base.CHO_COS) != 0 && !isSinLFO(typ) {
+		return errors.New("Cannot use the COS flag with RAMP LFOs")
+	}
+	if (flags&base.CHO_RPTR2) != 0 && isSinLFO(typ) {
+		return errors.New("Cannot use RPTR2 with SIN LFOs")
+	}
+	if (flags&base.CHO_NA) != 0 && isSinLFO(typ) {
+		return errors.New("Cannot use the NA flag with SIN LFOs")
 	}
+	return nil
+}
 
-	utils.Assert(lfo <= 1.0 && lfo >= 0.0, "LFO range outside [0 .. 1] (was %f)", lfo)
+// An instruction which only returns 'err'. Invalid instructions are
+// reported when they are run, like any other runtime error.
+func failOp(err error) opFunc {
+	return func(state *State) error {
+		return err
+	}
+}
+
+// The LFO value after the RPTR2 and COMPA flags of the CHO
+// instructions. COMPA gives -lfo for the sine LFOs and the distance
+// to the end of the ramp for the ramp LFOs.
+func applyLFOFlags(lfo float64, typ int, flags int, state *State) float64 {
+	if (flags&base.CHO_RPTR2) != 0 && !isSinLFO(typ) {
+		lfo = GetLFOValuePlusHalfCycle(lfo, typ, state)
+	}
+	if (flags & base.CHO_COMPA) != 0 {
+		if isSinLFO(typ) {
+			lfo = -lfo
+		} else {
+			lfo = GetRampRange(typ, state) - lfo
+		}
+	}
 	return lfo
 }
 
 /*
-Return a LFO value scaled with the amplitude value specified in the state
-
-	NOTE: The scaled value is an integer, ie *NOT* <0 .. 1.0>
+Return a LFO value scaled with the SINx_RANGE register, ie. the
+output of the LFO as seen by the CHO instructions. A range of 32767
+is the whole wave. The ramp values are already scaled by the ramp
+width and are returned as they are.
 */
-func ScaleLFOValue(value float64, lfoType int, state *State) float64 {
-	amp := 1.0
+func GetLFORangeValue(value float64, lfoType int, state *State) float64 {
 	switch lfoType {
-	// FIXME: Why do we have to divide with 16? The amp scaling is way to
-	// high... (20260121 handegar)
 	case base.LFO_SIN0, base.LFO_COS0:
-		amp = float64(state.GetRegister(base.SIN0_RANGE).Value) / 16.0
+		return value * float64(state.GetRegister(base.SIN0_RANGE).Value) / 32768.0
 	case base.LFO_SIN1, base.LFO_COS1:
-		amp = float64(state.GetRegister(base.SIN1_RANGE).Value) / 16.0
-
-	case base.LFO_RMP0:
-		amp = float64(state.GetRegister(base.RAMP0_RANGE).ToInt32())
-	case base.LFO_RMP1:
-		amp = float64(state.GetRegister(base.RAMP1_RANGE).ToInt32())
+		return value * float64(state.GetRegister(base.SIN1_RANGE).Value) / 32768.0
 	}
+	return value
+}
 
-	return value * amp
+/*
+Return a LFO value as a delay address offset in words. An LFO output
+of 1.0 is 8192 words, so a sine with a range of 32767 swings +/-8192
+words and 0.5 on a ramp is 4096 words.
+
+	NOTE: The scaled value is an integer, ie *NOT* <0 .. 1.0>
+*/
+func ScaleLFOValue(value float64, lfoType int, state *State) float64 {
+	return GetLFORangeValue(value, lfoType, state) * 8192.0
 }
 
 /*
 Return the normalized LFO value
-ie. a value from  <-1.0 .. 1.0> for SIN/COS and <0 .. 1.0> for RAMP
+ie. a value from  <-1.0 .. 1.0> for SIN/COS and <0 .. range/8192> for RAMP
 
 Set the 'storeValue' parameter to TRUE when the "REG" keyword is
 used for the "CHO RDA" instruction.
@@ -194,12 +225,16 @@ func GetLFOValue(lfoType int, state *State, storeValue bool) float64 {
 
 	case base.LFO_RMP0: // Ramps
 		lfo = float64(state.Ramp0Osc.GetValue())
-		utils.Assert(lfo <= 1.0 && lfo >= 0.0, "LFO Ramp0 range outside [0 .. 1.0] (was %f)", lfo)
+		if !(lfo < GetRampRange(lfoType, state) && lfo >= 0.0) {
+			utils.Assert(false, "LFO Ramp0 outside the ramp range (was %f)", lfo)
+		}
 		state.ramp0LFOReg.SetFloat64(lfo)
 
 	case base.LFO_RMP1:
 		lfo = float64(state.Ramp1Osc.GetValue())
-		utils.Assert(lfo <= 1.0 && lfo >= 0.0, "LFO Ramp1 range outside [0 .. 1.0] (was %f)", lfo)
+		if !(lfo < GetRampRange(lfoType, state) && lfo >= 0.0) {
+			utils.Assert(false, "LFO Ramp1 outside the ramp range (was %f)", lfo)
+		}
 		state.ramp1LFOReg.SetFloat64(lfo)
 
 	default:
@@ -227,49 +262,41 @@ func GetLFOValue(lfoType int, state *State, storeValue bool) float64 {
 	return lfo
 }
 
-// Outputs a [0 .. 0.5] range.
+/*
+The crossfade coefficient of the NA flag, <0 .. 1.0>. Like the
+flat-top pyramid in the datasheet it is 1.0 around the middle of the
+ramp, where a RPTR2 read wraps, and 0 around the start and end, where
+a read without RPTR2 wraps. In between it is linear for a quarter of
+the cycle:
+
+	1.0 |       ______
+	    |      /      \
+	0.0 |_____/        \_____
+	    0    1/4  1/2  3/4   1  (ramp position)
+
+So two reads half a cycle apart, crossfaded with NA and NA|COMPC,
+never let a wrapping read through and always sum to the full level.
+*/
 func GetXFadeFromLFO(lfo float64, typ int, state *State) float64 {
 	utils.Assert(!isSinLFO(typ), "Cannot crossfade a SIN LFO")
 
-	// FIXME: I am unsure which shape the XFade LFO has in an actual FV-1. A
-	// pointy pyramid seems logical but the datasheet shows a flat-top pyramid. (20260202
-	// handegar)
-
-	val := 0.0
-	if true {
-		// Symetric saw-tooth with flat top. Each part is 1/3 of a cycle
-		if lfo < 1.0/3.0 {
-			val = lfo * 3.0
-		} else if lfo < 2.0/3.0 {
-			val = 1.0
-		} else {
-			val = (1.0 - lfo) * 3.0
-		}
-
-		val = val
-
-	} else {
-		// Simple symetric sawtooth
-		if lfo < 0.5 {
-			val = lfo * 2.0
-		} else {
-			val = (1 - lfo) * 2.0
-		}
-	}
+	pos := lfo / GetRampRange(typ, state) // <0 .. 1.0>
+	triangle := 1.0 - math.Abs(2.0*pos-1.0)
+	val := math.Max(0.0, math.Min(1.0, 2.0*triangle-0.5))
 
 	state.DebugFlags.XFadeMax = math.Max(state.DebugFlags.XFadeMax, val)
 	state.DebugFlags.XFadeMin = math.Min(state.DebugFlags.XFadeMin, val)
 
-	return val / 2.0 // XFade is always <0 .. 0.5>
+	return val
 }
 
-// Output normalized, <0 .. 1.0>
+// The end of the ramp, ie. RAMPx_RANGE as a LFO value <0.0625 .. 0.5>
 func GetRampRange(typ int, state *State) float64 {
 	utils.Assert(typ == base.LFO_RMP0 || typ == base.LFO_RMP1, "Only RAMP oscillator allowed")
 	if typ == base.LFO_RMP0 {
-		return float64(state.Registers[base.RAMP0_RANGE].ToInt32()) / 4096.0
+		return float64(state.Registers[base.RAMP0_RANGE].ToInt32()) / 8192.0
 	} else if typ == base.LFO_RMP1 {
-		return float64(state.Registers[base.RAMP1_RANGE].ToInt32()) / 4096.0
+		return float64(state.Registers[base.RAMP1_RANGE].ToInt32()) / 8192.0
 	}
 
 	return 0.0
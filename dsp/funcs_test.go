@@ -1 +1,272 @@
 package dsp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/handegar/fv1emu/base"
+)
+
+// An instruction with the given raw argument values. Blank and
+// constant arguments are skipped.
+func newTestOp(name string, args ...int32) base.Op {
+	op, _ := NewOp(name)
+	for i, a := range args {
+		if op.Args[i].Type != base.Blank && op.Args[i].Type != base.Const {
+			op.Args[i].RawValue = a
+		}
+	}
+	return op
+}
+
+// A reverb-like program: an input filter, 8 allpasses, a modulated
+// delay and a filtered output. 60 instructions.
+func benchmarkProgram() []base.Op {
+	op := newTestOp
+	const half14 = 1 << 13 // 0.5 as S1.14
+	const half9 = 1 << 8   // 0.5 as S1.9
+	const minusHalf9 = 0x7FF - half9 + 1
+
+	ops := []base.Op{
+		op("SKP", 0, 2, base.SKP_RUN),
+		op("WLDS", 30, 50, 0),
+		op("WLDR", 1, 0, 200, 1),
+		op("RDAX", base.ADCL, 0, half14),
+		op("RDAX", base.ADCR, 0, half14),
+		op("RDFX", base.REG0, 0, 1000),
+		op("WRHX", base.REG0, 0, 0x10000-half14),
+		op("LDAX", base.REG0),
+	}
+	addr := int32(0)
+	for i := int32(0); i < 8; i++ {
+		length := 150 + i*97
+		ops = append(ops,
+			op("RDA", addr+length, half9),
+			op("WRAP", addr, minusHalf9))
+		addr += length + 1
+	}
+	ops = append(ops,
+		op("WRA", addr, 0),
+		op("CHO RDA", addr+100, base.LFO_SIN0, 0, base.CHO_REG|base.CHO_COMPC),
+		op("CHO RDA", addr+101, base.LFO_SIN0, 0, 0),
+		op("CHO RDA", addr+500, base.LFO_RMP1, 0, base.CHO_REG|base.CHO_COMPC),
+		op("CHO RDA", addr+501, base.LFO_RMP1, 0, base.CHO_RPTR2),
+		op("WRLX", base.REG0+1, 0, half14),
+		op("MULX", base.POT0),
+		op("SOF", 0, half14),
+		op("WRAX", base.REG0+2, 0, 0),
+		op("RDAX", base.REG0+2, 0, half14),
+		op("RDAX", base.ADCL, 0, half14),
+		op("MAXX", base.REG0+3, 0, half14),
+		op("WRAX", base.DACL, 0, 0),
+	)
+	for len(ops) < 58 {
+		ops = append(ops, op("RDAX", base.REG0+2, 0, half14/4), op("WRAX", base.REG0+4, 0, 0))
+	}
+	return append(ops, op("RDAX", base.REG0+4, 0, half14), op("WRAX", base.DACR, 0, 0))
+}
+
+func noDebug(opCodes []base.Op, state *State, sampleNum int) int {
+	return Ok
+}
+
+func Benchmark_ProcessSample(b *testing.B) {
+	prog, err := Compile(benchmarkProgram())
+	if err != nil {
+		b.Fatal(err)
+	}
+	state := NewState()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		state.GetRegister(base.ADCL).SetFloat64(0.5 * math.Sin(float64(i)*0.01))
+		ProcessSample(prog, state, i, noDebug, noDebug)
+	}
+}
+
+func Benchmark_Compile(b *testing.B) {
+	ops := benchmarkProgram()
+	for i := 0; i < b.N; i++ {
+		Compile(ops)
+	}
+}
+
+func Test_Compile(t *testing.T) {
+	ops := benchmarkProgram()
+	prog, err := Compile(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prog.Ops) != len(ops) || len(prog.code) != len(ops) {
+		t.Errorf("Expected %d compiled instructions, got %d", len(ops), len(prog.code))
+	}
+
+	bad, _ := NewOp("RDAX")
+	bad.Name = "CHO <?>"
+	if _, err = Compile(append(ops, bad)); err == nil {
+		t.Errorf("Expected an unknown instruction to fail")
+	}
+
+	wide := newTestOp("SOF", 0, 1<<16) // C is a S1.14 of 16 bits
+	if _, err = Compile(append(ops, wide)); err == nil {
+		t.Errorf("Expected a too wide coefficient to fail")
+	}
+}
+
+// Only the instructions, without the LFO updates and the debug checks
+// in ProcessSample()
+func Benchmark_Instructions(b *testing.B) {
+	prog, err := Compile(benchmarkProgram())
+	if err != nil {
+		b.Fatal(err)
+	}
+	state := NewState()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, code := range prog.code {
+			code(state)
+		}
+	}
+}
+
+func Test_ProcessSampleAllocations(t *testing.T) {
+	// The instructions not in the benchmark program
+	ops := append(benchmarkProgram(),
+		newTestOp("LOG", 0x7ff, 1<<13),
+		newTestOp("EXP", 0, 1<<13),
+		newTestOp("AND", 0, 0x7FFF00),
+		newTestOp("OR", 0, 0x0F00),
+		newTestOp("XOR", 0, 0x0F0F0F),
+		newTestOp("NOT"),
+		newTestOp("ABSA"),
+		newTestOp("CLR"),
+		newTestOp("RMPA", 0, 1<<8),
+		newTestOp("CHO SOF", 0x100, base.LFO_RMP0, 0, base.CHO_COMPC),
+		newTestOp("CHO RDAL", 0, base.LFO_SIN1, 0, base.CHO_REG),
+		newTestOp("JAM", 0, 1),
+		newTestOp("RDAX", base.POT0, 0, 1<<14),
+		newTestOp("WRAX", base.SIN0_RATE, 0, 1<<14),
+		newTestOp("WRAX", base.RAMP1_RATE, 0, 1<<14),
+		newTestOp("NOP"))
+	prog, err := Compile(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := NewState()
+
+	sampleNum := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		state.GetRegister(base.ADCL).SetFloat64(0.5 * math.Sin(float64(sampleNum)*0.01))
+		ProcessSample(prog, state, sampleNum, noDebug, noDebug)
+		sampleNum++
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations per sample, got %.1f", allocs)
+	}
+
+	// The debugger copies the state for every instruction
+	previous := NewState()
+	allocs = testing.AllocsPerRun(100, func() {
+		previous.Copy(state)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected State.Copy() to not allocate, got %.1f", allocs)
+	}
+}
+
+// The crossfade is 0 around the ends of the ramp and 1 around the
+// middle, so a read and its RPTR2 twin always add up to the full level
+func Test_RampCrossfade(t *testing.T) {
+	state := NewState()
+	state.GetRegister(base.RAMP0_RANGE).SetInt32(4096) // Ends at 0.5
+
+	type testCase struct {
+		lfo      float64
+		expected float64
+	}
+	for _, c := range []testCase{
+		{0.0, 0.0},
+		{0.0625, 0.0}, // 1/8 cycle
+		{0.125, 0.5},  // 1/4 cycle
+		{0.1875, 1.0}, // 3/8 cycle
+		{0.25, 1.0},
+		{0.375, 0.5},
+		{0.49, 0.0},
+	} {
+		if x := GetXFadeFromLFO(c.lfo, base.LFO_RMP0, state); x != c.expected {
+			t.Errorf("LFO %f: Expected a crossfade of %f, got %f", c.lfo, c.expected, x)
+		}
+	}
+
+	for lfo := 0.0; lfo < 0.5; lfo += 1.0 / 8192 {
+		half := GetLFOValuePlusHalfCycle(lfo, base.LFO_RMP0, state)
+		if half < 0 || half >= 0.5 || math.Abs(math.Abs(half-lfo)-0.25) > 1e-12 {
+			t.Fatalf("LFO %f: RPTR2 gave %f", lfo, half)
+		}
+		sum := GetXFadeFromLFO(lfo, base.LFO_RMP0, state) + GetXFadeFromLFO(half, base.LFO_RMP0, state)
+		if math.Abs(sum-1.0) > 1e-12 {
+			t.Fatalf("LFO %f: The crossfades add up to %f", lfo, sum)
+		}
+	}
+
+	// RPTR2 before COMPA
+	lfo := applyLFOFlags(0.125, base.LFO_RMP0, base.CHO_RPTR2|base.CHO_COMPA, state)
+	if lfo != 0.125 {
+		t.Errorf("Expected RPTR2|COMPA to give 0.125, got %f", lfo)
+	}
+	lfo = applyLFOFlags(0.1, base.LFO_RMP0, base.CHO_COMPA, state)
+	if math.Abs(lfo-0.4) > 1e-12 {
+		t.Errorf("Expected COMPA to give 0.4, got %f", lfo)
+	}
+}
+
+// An unused register is recorded in the debug flags
+func Test_InvalidRegister(t *testing.T) {
+	state := NewState()
+	state.GetRegister(base.POT0)
+	if state.DebugFlags.InvalidRegister != 0 {
+		t.Errorf("Expected POT0 to be valid")
+	}
+	state.GetRegister(0x13)
+	if state.DebugFlags.InvalidRegister != 0x13 {
+		t.Errorf("Expected register 0x13 to be recorded, got %d", state.DebugFlags.InvalidRegister)
+	}
+}
+
+// The LFOs take one step per sample whatever the length of the
+// program
+func Test_LFOStep(t *testing.T) {
+	short := []base.Op{newTestOp("WLDS", 0x7FFF, 100, 0), newTestOp("WLDR", 0, 0, 300, 0)}
+	long := append([]base.Op{}, short...)
+	for len(long) < 128 {
+		long = append(long, newTestOp("SOF", 0, 1<<13))
+	}
+	programs := [][]base.Op{short, long}
+
+	sin := NewSineOscillator()
+	sin.SetFreq(100)
+	ramp := NewRampOscillator()
+	ramp.SetFreq(300)
+	ramp.SetWidth(int32(base.RampAmpValues[0]))
+	for i := 0; i < 1000; i++ {
+		sin.Step()
+		ramp.Step()
+	}
+
+	for _, ops := range programs {
+		prog, err := Compile(ops)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		state := NewState()
+		for i := 0; i < 1000; i++ {
+			if err := RunProgram(prog, state); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		}
+		if state.Sin0Osc != sin || state.Ramp0Osc != ramp {
+			t.Errorf("%d instructions: Expected 1000 LFO steps", len(ops))
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package dsp
 
 import (
-	//"fmt"
+	"fmt"
 	"math"
 
 	"github.com/handegar/fv1emu/base"
@@ -13,9 +13,17 @@ func isSinLFO(typ int) bool {
 		typ == base.LFO_COS0 || typ == base.LFO_COS1
 }
 
-var opTable = map[string]interface{}{
-	"LOG": func(op base.Op, state *State) error {
-		state.workReg1_14.SetWithIntsAndFracs(op.Args[1].RawValue, 1, 14) // C
+// An instruction compiled for one specific set of arguments. The
+// arguments are decoded and the coefficients converted to registers
+// once when the program is compiled, not for every sample.
+type opFunc func(state *State) error
+
+// Each entry takes a decoded instruction and returns the compiled
+// instruction. Nothing in the returned closure may be modified
+// when it runs as the same program can be shared by several states.
+var opTable = map[string]func(op base.Op) opFunc{
+	"LOG": func(op base.Op) opFunc {
+		C := NewRegisterWithIntsAndFracs(op.Args[1].RawValue, 1, 14).ToFloat64()
 		// NOTE: According to the SPIN datasheet the second
 		// parameter for LOG is supposed to be a S4.6 number,
 		// but the ASFY1 assembler, the DISFY1 disassembler,
@@ -25,202 +33,421 @@ var opTable = map[string]interface{}{
 		// UPDATE: According to this post the manual might
 		// contain an error:
 		// http://www.spinsemi.com/forum/viewtopic.php?f=3&t=511
-		state.workReg0_10.SetWithIntsAndFracs(op.Args[0].RawValue, 0, 10) // D
-
-		// C*LOG(|ACC|) + D
-		state.PACC.Copy(state.ACC)
-		acc := state.ACC.Abs().ToFloat64()
-
-		val := (math.Log10(acc) / math.Log10(2.0)) / 16.0
-		val = val * state.workReg1_14.ToFloat64()
-		val = val + state.workReg0_10.ToFloat64()
-		state.ACC.SetClampedFloat64(val)
+		D := NewRegisterWithIntsAndFracs(op.Args[0].RawValue, 0, 10).ToFloat64()
+
+		return func(state *State) error {
+			// C*LOG(|ACC|) + D
+			state.PACC.Copy(state.ACC)
+			acc := state.ACC.Abs().ToFloat64()
+			if acc == 0.0 {
+				acc = 1.0 / (1 << 23) // LOG(0) is the LOG of the smallest value
+			}
 
-		return nil
+			val := (math.Log10(acc) / math.Log10(2.0)) / 16.0
+			val = val * C
+			val = val + D
+			state.ACC.SetClampedFloat64(val)
+			return nil
+		}
 	},
-	"EXP": func(op base.Op, state *State) error {
-		state.workReg1_14.SetWithIntsAndFracs(op.Args[1].RawValue, 1, 14) // C
-		state.workReg0_10.SetWithIntsAndFracs(op.Args[0].RawValue, 0, 10) // D
+	"EXP": func(op base.Op) opFunc {
+		C := NewRegisterWithIntsAndFracs(op.Args[1].RawValue, 1, 14)
+		D := NewRegisterWithIntsAndFracs(op.Args[0].RawValue, 0, 10)
 
-		// C*exp(ACC) + D
-		state.PACC.Copy(state.ACC)
-		acc := state.ACC.ToFloat64()
+		return func(state *State) error {
+			// C*exp(ACC) + D
+			state.PACC.Copy(state.ACC)
+			acc := state.ACC.ToFloat64()
 
-		if acc >= 0 {
-			state.ACC.SetToMax24Bit().Mult(state.workReg1_14).Add(state.workReg0_10)
-		} else {
-			acc = acc * 16.0
-			state.ACC.SetFloat64(math.Exp2(acc)).Mult(state.workReg1_14).Add(state.workReg0_10)
+			if acc >= 0 {
+				state.ACC.SetToMax24Bit().Mult(C).Add(D)
+			} else {
+				acc = acc * 16.0
+				state.ACC.SetFloat64(math.Exp2(acc)).Mult(C).Add(D)
+			}
+			return nil
 		}
-
-		/*
-			acc = math.Exp2(acc)*state.workReg1_14.ToFloat64() + state.workReg0_10.ToFloat64()
-			state.ACC.SetFloat64(min(acc, 1.0))
-		*/
-		return nil
 	},
-	"SOF": func(op base.Op, state *State) error {
-		state.workReg1_14.SetWithIntsAndFracs(op.Args[1].RawValue, 1, 14) // C
-		state.workReg0_10.SetWithIntsAndFracs(op.Args[0].RawValue, 0, 10) // D
-
-		// C * ACC + D
-		state.PACC.Copy(state.ACC)
-		state.ACC.Mult(state.workReg1_14).Add(state.workReg0_10)
-		return nil
+	"SOF": func(op base.Op) opFunc {
+		C := NewRegisterWithIntsAndFracs(op.Args[1].RawValue, 1, 14)
+		D := NewRegisterWithIntsAndFracs(op.Args[0].RawValue, 0, 10)
+
+		return func(state *State) error {
+			// C * ACC + D
+			state.PACC.Copy(state.ACC)
+			state.ACC.Mult(C).Add(D)
+			return nil
+		}
 	},
-	"AND": func(op base.Op, state *State) error {
-		state.PACC.Copy(state.ACC)
+	"AND": func(op base.Op) opFunc {
 		v := Extend24to32(op.Args[1].RawValue)
-		state.ACC.And(v)
-		return nil
+		return func(state *State) error {
+			state.PACC.Copy(state.ACC)
+			state.ACC.And(v)
+			return nil
+		}
 	},
-	"CLR": func(op base.Op, state *State) error {
-		state.PACC.Copy(state.ACC)
-		state.ACC.Clear()
-		return nil
+	"CLR": func(op base.Op) opFunc {
+		return func(state *State) error {
+			state.PACC.Copy(state.ACC)
+			state.ACC.Clear()
+			return nil
+		}
 	},
-	"OR": func(op base.Op, state *State) error {
-		state.PACC.Copy(state.ACC)
+	"OR": func(op base.Op) opFunc {
 		v := Extend24to32(op.Args[1].RawValue)
-		state.ACC.Or(v)
-		return nil
+		return func(state *State) error {
+			state.PACC.Copy(state.ACC)
+			state.ACC.Or(v)
+			return nil
+		}
 	},
-	"XOR": func(op base.Op, state *State) error {
-		state.PACC.Copy(state.ACC)
+	"XOR": func(op base.Op) opFunc {
 		v := Extend24to32(op.Args[1].RawValue)
-		state.ACC.Xor(v)
-		return nil
+		return func(state *State) error {
+			state.PACC.Copy(state.ACC)
+			state.ACC.Xor(v)
+			return nil
+		}
 	},
-	"NOT": func(op base.Op, state *State) error {
-		state.PACC.Copy(state.ACC)
-		state.ACC.Not(0x7FFFFFFF)
-		return nil
+	"NOT": func(op base.Op) opFunc {
+		return func(state *State) error {
+			state.PACC.Copy(state.ACC)
+			state.ACC.Not(0x7FFFFFFF)
+			return nil
+		}
 	},
-	"SKP": func(op base.Op, state *State) error {
+	"SKP": func(op base.Op) opFunc {
 		flags := int(op.Args[2].RawValue)
-		N := op.Args[1].RawValue
+		N := uint(op.Args[1].RawValue)
 
-		jmp := false
-		if (flags&base.SKP_RUN > 0) && state.RUN_FLAG == true { // RUN
-			jmp = true
-		}
-		if (flags&base.SKP_GEZ) > 0 && !state.ACC.IsSigned() && state.ACC.ToInt32() >= 0 { // GEZ
-			jmp = true
+		if flags == 0 { // No flags => Always jump
+			return func(state *State) error {
+				state.IP += N
+				return nil
+			}
 		}
-		if (flags&base.SKP_ZRO) > 0 && state.ACC.Value == 0 { // ZRO
-			jmp = true
+
+		return func(state *State) error {
+			jmp := false
+			if (flags&base.SKP_RUN > 0) && state.RUN_FLAG == true { // RUN
+				jmp = true
+			}
+			if (flags&base.SKP_GEZ) > 0 && !state.ACC.IsSigned() && state.ACC.ToInt32() >= 0 { // GEZ
+				jmp = true
+			}
+			if (flags&base.SKP_ZRO) > 0 && state.ACC.Value == 0 { // ZRO
+				jmp = true
+			}
+			if (flags&base.SKP_ZRC) > 0 &&
+				(state.ACC.IsSigned() != state.PACC.IsSigned()) { // ZRC
+				jmp = true
+			}
+			if (flags&base.SKP_NEG) > 0 && state.ACC.IsSigned() { // NEG
+				jmp = true
+			}
+
+			if jmp {
+				state.IP += N
+			}
+			return nil
 		}
-		if (flags&base.SKP_ZRC) > 0 &&
-			(state.ACC.IsSigned() != state.PACC.IsSigned()) { // ZRC
-			jmp = true
+	},
+	"NOP": func(op base.Op) opFunc {
+		return func(state *State) error {
+			return nil
 		}
-		if (flags&base.SKP_NEG) > 0 && state.ACC.IsSigned() { // NEG
-			jmp = true
+	},
+	"RDA": func(op base.Op) opFunc {
+		addr := int(op.Args[0].RawValue)
+		C := NewRegisterWithIntsAndFracs(op.Args[1].RawValue, 1, 9)
+
+		return func(state *State) error {
+			idx, err := capDelayRAMIndex(addr+state.DelayRAMPtr, state)
+			if err != nil {
+				return state.DebugFlags.IncreaseOutOfBoundsMemoryRead()
+			}
+
+			delayValue := state.DelayRAM[idx]
+			state.LR.SetWithIntsAndFracs(delayValue, 0, 23)
+
+			// SRAM[ADDR] * C + ACC
+			state.PACC.Copy(state.ACC)
+			state.workRegA.Copy(state.LR).Mult(C)
+			state.ACC.Add(state.workRegA)
+			return nil
 		}
-		if flags == 0 { // No flags => Always jump
-			jmp = true
+	},
+	"RMPA": func(op base.Op) opFunc {
+		C := NewRegisterWithIntsAndFracs(op.Args[1].RawValue, 1, 9)
+
+		return func(state *State) error {
+			addr := state.GetRegister(base.ADDR_PTR).ToInt32() >> 8 // ADDR_PTR
+			idx, err := capDelayRAMIndex(int(addr)+state.DelayRAMPtr, state)
+			if err != nil {
+				return state.DebugFlags.IncreaseOutOfBoundsMemoryRead()
+			}
+
+			delayValue := state.DelayRAM[idx]
+			state.LR.SetWithIntsAndFracs(delayValue, 0, 23)
+
+			// SRAM[PNTR[N]] * C + ACC
+			state.PACC.Copy(state.ACC)
+			state.workRegA.Copy(state.LR).Mult(C)
+			state.ACC.Add(state.workRegA)
+			return nil
 		}
+	},
+	"WRA": func(op base.Op) opFunc {
+		addr := int(op.Args[0].RawValue)
+		C := NewRegisterWithIntsAndFracs(op.Args[1].RawValue, 1, 9)
+
+		return func(state *State) error {
+			// ACC->SRAM[ADDR], ACC * C
+			state.PACC.Copy(state.ACC)
+			idx, err := capDelayRAMIndex(addr+state.DelayRAMPtr, state)
+			if err != nil {
+				return state.DebugFlags.IncreaseOutOfBoundsMemoryWrite()
+			}
 
-		if jmp {
-			state.IP += uint(N)
+			state.writeDelayRAM(idx, state.ACC)
+			state.ACC.Mult(C)
+			return nil
 		}
-		return nil
 	},
-	"NOP": func(op base.Op, state *State) error {
-		return nil
+	"WRAP": func(op base.Op) opFunc {
+		addr := int(op.Args[0].RawValue)
+		C := NewRegisterWithIntsAndFracs(op.Args[1].RawValue, 1, 9)
+
+		return func(state *State) error {
+			// ACC->SRAM[ADDR], (ACC*C) + LR
+			state.PACC.Copy(state.ACC)
+			idx, err := capDelayRAMIndex(addr+state.DelayRAMPtr, state)
+			if err != nil {
+				return state.DebugFlags.IncreaseOutOfBoundsMemoryWrite()
+			}
+
+			state.writeDelayRAM(idx, state.ACC)
+			state.ACC.Mult(C).Add(state.LR)
+			return nil
+		}
 	},
-	"RDA": func(op base.Op, state *State) error {
-		addr := op.Args[0].RawValue
-		state.workReg1_9.SetWithIntsAndFracs(op.Args[1].RawValue, 1, 9) // C
-		idx, err := capDelayRAMIndex(int(addr)+state.DelayRAMPtr, state)
-		if err != nil {
-			return state.DebugFlags.IncreaseOutOfBoundsMemoryRead()
+	"RDAX": func(op base.Op) opFunc {
+		regNo := int(op.Args[0].RawValue)
+		C := NewRegisterWithIntsAndFracs(op.Args[2].RawValue, 1, 14)
+
+		return func(state *State) error {
+			reg := state.GetRegister(regNo)
+
+			// (C * REG) + ACC
+			state.PACC.Copy(state.ACC)
+			state.workRegA.Copy(reg).Mult(C)
+			state.ACC.Add(state.workRegA)
+			return nil
 		}
+	},
+	"RDFX": func(op base.Op) opFunc {
+		regNo := int(op.Args[0].RawValue)
+		C := NewRegisterWithIntsAndFracs(op.Args[2].RawValue, 1, 14)
 
-		delayValue := state.DelayRAM[idx]
-		state.LR.SetWithIntsAndFracs(delayValue, 0, 23)
+		return func(state *State) error {
+			reg := state.GetRegister(regNo)
 
-		// SRAM[ADDR] * C + ACC
-		state.PACC.Copy(state.ACC)
-		state.workRegA.Copy(state.LR).Mult(state.workReg1_9)
-		state.ACC.Add(state.workRegA)
-		return nil
+			// (ACC - REG)*C + REG
+			state.PACC.Copy(state.ACC)
+			state.workRegA.Copy(state.ACC)
+			state.workRegA.Sub(reg).Mult(C).Add(reg)
+			state.ACC.Copy(state.workRegA)
+			return nil
+		}
+	},
+	"WRAX": compileWRAX,
+	"MAXX": func(op base.Op) opFunc {
+		regNo := int(op.Args[0].RawValue)
+		C := NewRegisterWithIntsAndFracs(op.Args[2].RawValue, 1, 14)
+
+		return func(state *State) error {
+			// MAX(|REG[ADDR] * C|, |ACC| )
+			state.PACC.Copy(state.ACC)
+			reg := state.GetRegister(regNo)
+			state.workRegA.Copy(reg).Mult(C).Abs()
+			state.workRegB.Copy(state.ACC).Abs()
+			if state.workRegA.GreaterThan(state.workRegB) {
+				state.ACC.Copy(state.workRegA)
+			} else {
+				state.ACC.Copy(state.workRegB)
+			}
+			return nil
+		}
 	},
-	"RMPA": func(op base.Op, state *State) error {
-		state.workReg1_9.SetWithIntsAndFracs(op.Args[1].RawValue, 1, 9) // C
-		addr := state.GetRegister(base.ADDR_PTR).ToInt32() >> 8         // ADDR_PTR
-		idx, err := capDelayRAMIndex(int(addr)+state.DelayRAMPtr, state)
-		if err != nil {
-			return state.DebugFlags.IncreaseOutOfBoundsMemoryRead()
+	"ABSA": func(op base.Op) opFunc {
+		return func(state *State) error {
+			state.PACC.Copy(state.ACC)
+			state.ACC.Abs()
+			return nil
 		}
+	},
+	"MULX": func(op base.Op) opFunc {
+		regNo := int(op.Args[0].RawValue)
 
-		delayValue := state.DelayRAM[idx]
-		state.LR.SetWithIntsAndFracs(delayValue, 0, 23)
+		return func(state *State) error {
+			reg := state.GetRegister(regNo)
 
-		// SRAM[PNTR[N]] * C + ACC
-		state.PACC.Copy(state.ACC)
-		state.workRegA.Copy(state.LR).Mult(state.workReg1_9)
-		state.ACC.Add(state.workRegA)
-		return nil
+			// ACC * REG[ADDR]
+			state.PACC.Copy(state.ACC)
+			state.ACC.Mult(reg)
+			return nil
+		}
 	},
-	"WRA": func(op base.Op, state *State) error {
-		addr := op.Args[0].RawValue
-		state.workReg1_9.SetWithIntsAndFracs(op.Args[1].RawValue, 1, 9) // C
+	"LDAX": func(op base.Op) opFunc {
+		regNo := int(op.Args[0].RawValue)
 
-		// ACC->SRAM[ADDR], ACC * C
-		state.PACC.Copy(state.ACC)
-		idx, err := capDelayRAMIndex(int(addr)+state.DelayRAMPtr, state)
-		if err != nil {
-			return state.DebugFlags.IncreaseOutOfBoundsMemoryWrite()
+		return func(state *State) error {
+			reg := state.GetRegister(regNo)
+			state.ACC.Copy(reg)
+			return nil
 		}
+	},
+	"WRLX": func(op base.Op) opFunc {
+		regNo := int(op.Args[0].RawValue)
+		C := NewRegisterWithIntsAndFracs(op.Args[2].RawValue, 1, 14)
 
-		// NOTE: The delay memory on the FV1 is actually 14 bits (S+10+3), not 24. (I think)
-		state.DelayRAM[idx] = state.ACC.ToQFormat(0, 23)
-		state.ACC.Mult(state.workReg1_9)
-		return nil
+		return func(state *State) error {
+			// ACC->REG[ADDR], (PACC-ACC)*C + PACC
+			state.workRegB.Copy(state.ACC)
+			state.GetRegister(regNo).Copy(state.ACC)
+			state.workRegA.Copy(state.PACC).Sub(state.ACC).Mult(C).Add(state.PACC)
+			state.ACC.Copy(state.workRegA)
+			state.PACC.Copy(state.workRegB)
+			return nil
+		}
 	},
-	"WRAP": func(op base.Op, state *State) error {
-		addr := op.Args[0].RawValue
-		state.workReg1_9.SetWithIntsAndFracs(op.Args[1].RawValue, 1, 9) // C
+	"WRHX": func(op base.Op) opFunc {
+		regNo := int(op.Args[0].RawValue)
+		C := NewRegisterWithIntsAndFracs(op.Args[2].RawValue, 1, 14)
 
-		// ACC->SRAM[ADDR], (ACC*C) + LR
-		state.PACC.Copy(state.ACC)
-		idx, err := capDelayRAMIndex(int(addr)+state.DelayRAMPtr, state)
-		if err != nil {
-			return state.DebugFlags.IncreaseOutOfBoundsMemoryWrite()
+		return func(state *State) error {
+			// ACC->REG[ADDR], (ACC*C)+PACC
+			state.workRegB.Copy(state.ACC)
+			state.GetRegister(regNo).Copy(state.ACC)
+			state.workRegA.Copy(state.ACC).Mult(C).Add(state.PACC)
+			state.ACC.Copy(state.workRegA)
+			state.PACC.Copy(state.workRegB)
+			return nil
 		}
+	},
+	"WLDS": func(op base.Op) opFunc {
+		freq := op.Args[1].RawValue
+		amp := op.Args[0].RawValue
+		typ := op.Args[2].RawValue
 
-		state.DelayRAM[idx] = state.ACC.ToQFormat(0, 23)
-		state.ACC.Mult(state.workReg1_9).Add(state.LR)
-		return nil
+		// Cap values
+		invalid := false
+		if freq < 0 {
+			freq = 0
+			invalid = true
+		} else if freq > ((1 << 9) - 1) { // > 511
+			freq = (1 << 9) - 1
+			invalid = true
+		}
+
+		if amp < 0 {
+			amp = 0
+			invalid = true
+		} else if amp > ((1 << 15) - 1) { // > 32768
+			amp = (1 << 15) - 1
+			invalid = true
+		}
+
+		return func(state *State) error {
+			if invalid {
+				state.DebugFlags.SetInvalidSinLFOFlag(typ)
+			}
+
+			if typ == 0 { // SIN0
+				state.Registers[base.SIN0_RATE].SetInt32(freq)
+				state.Registers[base.SIN0_RANGE].SetInt32(int32(amp))
+				state.Sin0Osc.SetFreq(freq)
+			} else { // SIN1
+				state.Registers[base.SIN1_RATE].SetInt32(freq)
+				state.Registers[base.SIN1_RANGE].SetInt32(int32(amp))
+				state.Sin1Osc.SetFreq(freq)
+			}
+			return nil
+		}
 	},
-	"RDAX": func(op base.Op, state *State) error {
-		regNo := int(op.Args[0].RawValue)
-		state.workReg1_14.SetWithIntsAndFracs(op.Args[2].RawValue, 1, 14) // C
-		reg := state.GetRegister(regNo)
+	"WLDR": func(op base.Op) opFunc {
+		ampIdx := int8(op.Args[0].RawValue)
+		freq := int32(int16(op.Args[2].RawValue)) // Aka. 'rate'
+		typ := op.Args[3].RawValue
 
-		// (C * REG) + ACC
-		state.PACC.Copy(state.ACC)
-		state.workRegA.Copy(reg).Mult(state.workReg1_14)
-		state.ACC.Add(state.workRegA)
-		return nil
+		// Cap frequency value
+		invalid := false
+		if freq < -(1 << 14) { // -16384
+			freq = -(1 << 14) + 1
+			invalid = true
+		} else if freq > ((1 << 15) - 1) { // +32768
+			freq = (1 << 15) - 1
+			invalid = true
+		}
+		amp := int32(base.RampAmpValues[int32(ampIdx)])
+
+		return func(state *State) error {
+			if invalid {
+				state.DebugFlags.SetInvalidRampLFOFlag(typ)
+			}
+
+			if typ == 0 { // RAMP0
+				state.Registers[base.RAMP0_RATE].SetInt32(freq)
+				state.Registers[base.RAMP0_RANGE].SetInt32(amp)
+				state.Ramp0Osc.SetFreq(freq)
+				state.Ramp0Osc.SetWidth(amp)
+			} else { // RAMP1
+				state.Registers[base.RAMP1_RATE].SetInt32(freq)
+				state.Registers[base.RAMP1_RANGE].SetInt32(amp)
+				state.Ramp1Osc.SetFreq(freq)
+				state.Ramp1Osc.SetWidth(amp)
+			}
+			return nil
+		}
 	},
-	"RDFX": func(op base.Op, state *State) error {
-		regNo := int(op.Args[0].RawValue)
-		reg := state.GetRegister(regNo)
-		state.workReg1_14.SetWithIntsAndFracs(op.Args[2].RawValue, 1, 14) // C
+	"JAM": func(op base.Op) opFunc {
+		typ := op.Args[1].RawValue
 
-		// (ACC - REG)*C + REG
-		state.PACC.Copy(state.ACC)
-		state.workRegA.Copy(state.ACC)
-		state.workRegA.Sub(reg).Mult(state.workReg1_14).Add(reg)
-		state.ACC.Copy(state.workRegA)
-		return nil
+		return func(state *State) error {
+			if typ == 0 {
+				state.Ramp0Osc.Reset()
+			} else {
+				state.Ramp1Osc.Reset()
+			}
+			return nil
+		}
 	},
-	"WRAX": func(op base.Op, state *State) error {
-		regNo := int(op.Args[0].RawValue)
-		state.workReg1_14.SetWithIntsAndFracs(op.Args[2].RawValue, 1, 14) // C
+	"CHO RDA":  CHO_RDA,
+	"CHO SOF":  CHO_SOF,
+	"CHO RDAL": CHO_RDAL,
+}
 
+func compileWRAX(op base.Op) opFunc {
+	regNo := int(op.Args[0].RawValue)
+	C := NewRegisterWithIntsAndFracs(op.Args[2].RawValue, 1, 14)
+
+	switch regNo {
+	case base.SIN0_RATE, base.SIN1_RATE, base.SIN0_RANGE, base.SIN1_RANGE,
+		base.RAMP0_RATE, base.RAMP1_RATE, base.RAMP0_RANGE, base.RAMP1_RANGE,
+		base.ADDR_PTR:
+		// Handled below
+	default:
+		return func(state *State) error {
+			// Just a regular WRAX with ACC as a floating point value
+			state.PACC.Copy(state.ACC)
+			state.GetRegister(regNo).Copy(state.ACC)
+			state.ACC.Mult(C)
+			return nil
+		}
+	}
+
+	return func(state *State) error {
 		// ACC->REG[ADDR], C * ACC
 		state.PACC.Copy(state.ACC)
 		reg := state.GetRegister(regNo)
@@ -234,7 +461,8 @@ var opTable = map[string]interface{}{
 			if accAsInt < 0 { // Don't allow a negative rate/freq
 				accAsInt = -accAsInt
 			}
-			rate := accAsInt >> (24 - 9 - 1) // Reduce to 0..511
+			// Reduce to 0..511. -1.0 gives 512, which is clamped.
+			rate := min(accAsInt>>(24-9-1), 511)
 			reg.SetInt32(rate)
 			if regNo == base.SIN0_RATE {
 				state.Sin0Osc.SetFreq(rate)
@@ -245,14 +473,11 @@ var opTable = map[string]interface{}{
 		case base.SIN0_RANGE, base.SIN1_RANGE:
 			amp := accAsInt >> (24 - 15 - 1) // Reduce to 0..32767
 			reg.SetInt32(amp)
-			if regNo == base.SIN0_RATE {
-				state.Sin0Osc.SetAmp(amp)
-			} else {
-				state.Sin1Osc.SetAmp(amp)
-			}
 
 		case base.RAMP0_RATE, base.RAMP1_RATE:
-			freq := int32(int16(accAsInt >> (24 - 16))) // Reduce to -16384..32767
+			// The 16 bits of the register, -32768..32767. See
+			// RampOscillator.SetFreq().
+			freq := int32(int16(accAsInt >> (24 - 16)))
 			reg.SetInt32(freq)
 
 			if regNo == base.RAMP0_RATE {
@@ -262,167 +487,53 @@ var opTable = map[string]interface{}{
 			}
 
 		case base.RAMP0_RANGE, base.RAMP1_RANGE:
-			ampidx := state.ACC.ToInt32() >> 21 // Reduce to 0, 1, 2 or 3
-			reg.SetInt32(int32(base.RampAmpValues[3-ampidx]))
+			ampidx := (state.ACC.ToInt32() >> 21) & 3 // Reduce to 0, 1, 2 or 3
+			width := int32(base.RampAmpValues[3-ampidx])
+			reg.SetInt32(width)
 
 			if regNo == base.RAMP0_RANGE {
-				state.Ramp0Osc.SetAmpIdx(int8(ampidx))
+				state.Ramp0Osc.SetWidth(width)
 			} else {
-				state.Ramp1Osc.SetAmpIdx(int8(ampidx))
+				state.Ramp1Osc.SetWidth(width)
 			}
 
 		case base.ADDR_PTR:
 			// This value will be down-scaled when used as
 			// we can only address 32768 memory bytes
 			reg.SetInt32(accAsInt)
-
-		default:
-			// Just a regular WRAX with ACC as a floating point value
-			reg.Copy(state.ACC)
 		}
 
-		state.ACC.Mult(state.workReg1_14)
+		state.ACC.Mult(C)
 		return nil
-	},
-	"MAXX": func(op base.Op, state *State) error {
-		regNo := int(op.Args[0].RawValue)
-		state.workReg1_14.SetWithIntsAndFracs(op.Args[2].RawValue, 1, 14) // C
-
-		// MAX(|REG[ADDR] * C|, |ACC| )
-		state.PACC.Copy(state.ACC)
-		reg := state.GetRegister(regNo)
-		state.workRegA.Copy(reg).Mult(state.workReg1_14).Abs()
-		state.workRegB.Copy(state.ACC).Abs()
-		if state.workRegA.GreaterThan(state.workRegB) {
-			state.ACC.Copy(state.workRegA)
-		} else {
-			state.ACC.Copy(state.workRegB)
-		}
-		return nil
-	},
-	"ABSA": func(op base.Op, state *State) error {
-		state.PACC.Copy(state.ACC)
-		state.ACC.Abs()
-		return nil
-	},
-	"MULX": func(op base.Op, state *State) error {
-		regNo := int(op.Args[0].RawValue)
-		reg := state.GetRegister(regNo)
-
-		// ACC * REG[ADDR]
-		state.PACC.Copy(state.ACC)
-		state.ACC.Mult(reg)
-		return nil
-	},
-	"LDAX": func(op base.Op, state *State) error {
-		regNo := int(op.Args[0].RawValue)
-		reg := state.GetRegister(regNo)
-		state.ACC.Copy(reg)
-		return nil
-	},
-	"WRLX": func(op base.Op, state *State) error {
-		regNo := int(op.Args[0].RawValue)
-		state.workReg1_14.SetWithIntsAndFracs(op.Args[2].RawValue, 1, 14) // C
-
-		// ACC->REG[ADDR], (PACC-ACC)*C + PACC
-		state.workRegB.Copy(state.ACC)
-		state.GetRegister(regNo).Copy(state.ACC)
-		state.workRegA.Copy(state.PACC).Sub(state.ACC).Mult(state.workReg1_14).Add(state.PACC)
-		state.ACC.Copy(state.workRegA)
-		state.PACC.Copy(state.workRegB)
-		return nil
-	},
-	"WRHX": func(op base.Op, state *State) error {
-		regNo := int(op.Args[0].RawValue)
-		state.workReg1_14.SetWithIntsAndFracs(op.Args[2].RawValue, 1, 14) // C
-
-		// ACC->REG[ADDR], (ACC*C)+PACC
-		state.workRegB.Copy(state.ACC)
-		state.GetRegister(regNo).Copy(state.ACC)
-		state.workRegA.Copy(state.ACC).Mult(state.workReg1_14).Add(state.PACC)
-		state.ACC.Copy(state.workRegA)
-		state.PACC.Copy(state.workRegB)
-		return nil
-	},
-	"WLDS": func(op base.Op, state *State) error {
-		freq := op.Args[1].RawValue
-		amp := op.Args[0].RawValue
-		typ := op.Args[2].RawValue
-
-		// Cap values
-		if freq < 0 {
-			freq = 0
-			state.DebugFlags.SetInvalidSinLFOFlag(typ)
-		} else if freq > ((1 << 9) - 1) { // > 511
-			freq = (1 << 9) - 1
-			state.DebugFlags.SetInvalidSinLFOFlag(typ)
-		}
-
-		if amp < 0 {
-			amp = 0
-			state.DebugFlags.SetInvalidSinLFOFlag(typ)
-		} else if amp > ((1 << 15) - 1) { // > 32768
-			amp = (1 << 15) - 1
-			state.DebugFlags.SetInvalidSinLFOFlag(typ)
-		}
-
-		if typ == 0 { // SIN0
-			state.Registers[base.SIN0_RATE].SetInt32(freq)
-			state.Registers[base.SIN0_RANGE].SetInt32(int32(amp))
-			state.Sin0Osc.SetFreq(freq)
-			state.Sin0Osc.SetAmp(amp)
-		} else { // SIN1
-			state.Registers[base.SIN1_RATE].SetInt32(freq)
-			state.Registers[base.SIN1_RANGE].SetInt32(int32(amp))
-			state.Sin1Osc.SetFreq(freq)
-			state.Sin1Osc.SetAmp(amp)
-		}
-
-		return nil
-	},
-	"WLDR": func(op base.Op, state *State) error {
-		ampIdx := int8(op.Args[0].RawValue)
-		freq := int32(int16(op.Args[2].RawValue)) // Aka. 'rate'
-		typ := op.Args[3].RawValue
-
-		// Cap frequency value
-		if freq < -(1 << 14) { // -16384
-			freq = -(1 << 14) + 1
-			state.DebugFlags.SetInvalidRampLFOFlag(typ)
-		} else if freq > ((1 << 15) - 1) { // +32768
-			freq = (1 << 15) - 1
-			state.DebugFlags.SetInvalidRampLFOFlag(typ)
-		}
-
-		if typ == 0 { // RAMP0
-			state.Registers[base.RAMP0_RATE].SetInt32(freq)
-			state.Registers[base.RAMP0_RANGE].SetInt32(int32(base.RampAmpValues[int32(ampIdx)]))
-			state.Ramp0Osc.SetFreq(freq)
-			state.Ramp0Osc.SetAmpIdx(ampIdx)
-		} else { // RAMP1
-			state.Registers[base.RAMP1_RATE].SetInt32(freq)
-			state.Registers[base.RAMP1_RANGE].SetInt32(int32(base.RampAmpValues[int32(ampIdx)]))
-			state.Ramp1Osc.SetFreq(freq)
-			state.Ramp1Osc.SetAmpIdx(ampIdx)
-		}
+	}
+}
 
-		return nil
-	},
-	"JAM": func(op base.Op, state *State) error {
-		typ := op.Args[0].RawValue
-		if typ == 0 {
-			state.Ramp0Osc.Reset()
-		} else {
-			state.Ramp1Osc.Reset()
+// Compiles a single instruction
+func compileOp(op base.Op) (opFunc, error) {
+	compile, found := opTable[op.Name]
+	if !found {
+		return nil, fmt.Errorf("Unknown instruction '%s' (0x%08X)", op.Name, uint32(op.RawValue))
+	}
+	// The coefficients are read as fixed point numbers of the
+	// argument's width
+	for i, arg := range op.Args {
+		switch arg.Type {
+		case base.Real_1_14, base.Real_1_9, base.Real_10, base.Real_4_6:
+			if arg.RawValue < 0 || arg.RawValue >= 1<<arg.Len {
+				return nil, fmt.Errorf("Argument %d of %s (0x%x) does not fit into %d bits",
+					i, op.Name, arg.RawValue, arg.Len)
+			}
 		}
-		return nil
-	},
-	"CHO RDA":  CHO_RDA,
-	"CHO SOF":  CHO_SOF,
-	"CHO RDAL": CHO_RDAL,
+	}
+	return compile(op), nil
 }
 
+// Runs a single instruction. Mostly used by the tests; programs are
+// compiled once with 'Compile()'.
 func applyOp(opCode base.Op, state *State) error {
-	err := opTable[opCode.Name].(func(op base.Op, state *State) error)(opCode, state)
-	return err
+	code, err := compileOp(opCode)
+	if err != nil {
+		return err
+	}
+	return code(state)
 }
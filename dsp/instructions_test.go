@@ -70,10 +70,11 @@ func Test_AccumulatorOps(t *testing.T) {
 			t.Errorf("ACC | C != 0b%b. Got 0b%b", expected, state.ACC.Value)
 		}
 
-		// Set MSB
+		// Set MSB. The 24 bit mask is sign extended like the
+		// registers, so this is -1.0.
 		state.ACC.Clear()
 		op.Args[1].RawValue = 0b1 << 23
-		expected = state.ACC.Value | op.Args[1].RawValue
+		expected = state.ACC.Value | Extend24to32(op.Args[1].RawValue)
 
 		applyOp(op, state)
 		if state.ACC.Value != expected {
@@ -361,7 +362,7 @@ func Test_RegisterOps(t *testing.T) {
 		applyOp(op, state)
 
 		if !state.ACC.Equal(expected) {
-			t.Errorf("Expected ACC=0x%x, got 0x%x\n", state.ACC.Value, expected)
+			t.Errorf("Expected ACC=0x%x, got 0x%x\n", expected.Value, state.ACC.Value)
 		}
 
 		if !state.GetRegister(0x20).Equal(a) {
@@ -585,71 +586,59 @@ func Test_LFOOps(t *testing.T) {
 
 		applyOp(op, state)
 		if float2Compare(float32(state.Ramp0Osc.value), float32(0.0)) {
-			t.Errorf("Expected Ramo0State to be 0.0, got %f\n", state.Ramp0Osc.value)
+			t.Errorf("Expected Ramo0State to be 0.0, got %d\n", state.Ramp0Osc.value)
 		}
 
 		op.Args[1].RawValue = 0x1
 		applyOp(op, state)
 		if float2Compare(float32(state.Ramp1Osc.value), float32(0.0)) {
-			t.Errorf("Expected Ramp1State to be 0.0, got %f\n", state.Ramp1Osc.value)
+			t.Errorf("Expected Ramp1State to be 0.0, got %d\n", state.Ramp1Osc.value)
 		}
 	})
 
 	t.Run("CHO RDA", func(t *testing.T) {
-		state := NewState()
-		state.ACC.Clear()
-		state.GetRegister(base.SIN0_RANGE).Value = 125
-		state.GetRegister(base.SIN1_RANGE).Value = 125
-		for i := 0; i < 125*2; i++ {
-			state.DelayRAM[500+i] = int32(1 + i)
-		}
-		op := base.Ops[0x14]
-		op.Name = "CHO RDA"
-		op.Args[0].RawValue = 0x3e8 // 1000
-		op.Args[1].RawValue = 0x00  // Type (SIN0)
-		op.Args[3].RawValue = 0x0   // Flags
-
-		applyOp(op, state)
-		lfo := GetLFOValue(0, state, false)
-		lfoScaled := ScaleLFOValue(lfo, 0, state)
-		if state.ACC.Value != state.DelayRAM[1000+int(lfoScaled)] {
-			t.Errorf("Expected ACC to be 0x%x, got 0x%x\n",
-				state.DelayRAM[500+int(lfoScaled)],
-				state.ACC.Value)
+		// A pair of CHO RDA interpolates between two neighbouring
+		// delay words with the fraction of the scaled LFO
+		type testCase struct {
+			name     string
+			typ      int
+			lfo      float64
+			expected float64
+		}
+		for _, c := range []testCase{
+			{"SIN0 +2.5", base.LFO_SIN0, 0.3125, 0.5*0.125 + 0.5*0.25}, // RAM[1002] and RAM[1003]
+			{"SIN0 -2.75", base.LFO_SIN0, -0.34375, 0.75*0.5 + 0.25*0.375},
+			{"SIN1 +3.0", base.LFO_SIN1, 0.375, 0.25}, // Only RAM[1003]
+			// The ramp is already in words, 0.5 is 4096 words
+			{"RMP0 +2.25", base.LFO_RMP0, 2.25 / 8192, 0.75*0.125 + 0.25*0.25},
+		} {
+			state := NewState()
+			state.GetRegister(base.SIN0_RANGE).Value = 32 // +/-8 words
+			state.GetRegister(base.SIN1_RANGE).Value = 32
+			state.GetRegister(base.RAMP0_RANGE).SetInt32(4096)
+			state.sin0LFOReg.SetFloat64(c.lfo)
+			state.sin1LFOReg.SetFloat64(c.lfo)
+			state.ramp0LFOReg.SetFloat64(c.lfo)
+			state.DelayRAM[997] = 0x400000  // 0.5
+			state.DelayRAM[998] = 0x300000  // 0.375
+			state.DelayRAM[1002] = 0x100000 // 0.125
+			state.DelayRAM[1003] = 0x200000 // 0.25
+
+			op := base.Ops[0x14]
+			op.Name = "CHO RDA"
+			op.Args[0].RawValue = 1000
+			op.Args[1].RawValue = int32(c.typ)
+			op.Args[3].RawValue = base.CHO_COMPC
+			applyOp(op, state)
+
+			op.Args[0].RawValue = 1001
+			op.Args[3].RawValue = 0x0
+			applyOp(op, state)
+
+			if state.ACC.ToFloat64() != c.expected {
+				t.Errorf("%s: Expected ACC=%f, got %f", c.name, c.expected, state.ACC.ToFloat64())
+			}
 		}
-
-		state.GetRegister(base.SIN0_RANGE).Value = 2
-		state.ACC.Clear()
-		state.Sin0Osc.value = 3.1415 / 2.0
-		applyOp(op, state)
-		if state.ACC.Value != state.DelayRAM[1000+int(GetLFOValue(0, state, false))] {
-			t.Errorf("Expected ACC to be 0x%x, got 0x%x\n",
-				state.DelayRAM[1000+int(GetLFOValue(0, state, false))],
-				state.ACC.Value)
-		}
-
-		state.ACC.Clear()
-		op.Args[1].RawValue = 0x01 // Type (SIN1)
-		state.Sin1Osc.value = 0.0
-		applyOp(op, state)
-		if state.ACC.Value != state.DelayRAM[1000+int(GetLFOValue(1, state, false))] {
-			t.Errorf("Expected ACC to be 0x%x, got 0x%x\n",
-				state.DelayRAM[1000+int(GetLFOValue(1, state, false))],
-				state.ACC.Value)
-		}
-
-		state.GetRegister(base.SIN0_RANGE).SetFloat64(1.0)
-		state.ACC.Clear()
-		state.Sin1Osc.value = 3.1415 / 2.0
-		applyOp(op, state)
-		if state.ACC.Value != state.DelayRAM[1000+int(GetLFOValue(1, state, false))] {
-			t.Errorf("Expected ACC to be 0x%x, got 0x%x\n",
-				state.DelayRAM[1000+int(GetLFOValue(1, state, false))],
-				state.ACC.Value)
-		}
-
-		// FXIME: Ramp 0/1
-		fmt.Println("CHO RDA  RMPx, ... not verified")
 	})
 
 	t.Run("CHO SOF", func(t *testing.T) {
@@ -679,62 +668,98 @@ func Test_LFOOps(t *testing.T) {
 		op.Args[3].RawValue = 0x2
 		op.Args[4].RawValue = 0x3
 
-		state.Sin0Osc.value = 3.14 / 2.0
-		state.GetRegister(base.SIN0_RANGE).Value = 1
+		state.Sin0Osc.sin, state.Sin0Osc.cos = sineLFOAmplitude, 0 // The top
+		state.GetRegister(base.SIN0_RANGE).Value = 16384 // Half the wave
 		applyOp(op, state)
 
-		lfo := GetLFOValue(0, state, false)
-		lfoScaled := ScaleLFOValue(lfo, 0, state)
-		state.workRegA.SetFloat64(lfoScaled)
-
-		if !state.ACC.Equal(state.workRegA) {
+		lfoScaled := float64(sineLFOAmplitude) / (1 << 23) / 2
+		if state.ACC.ToFloat64() != lfoScaled {
 			t.Errorf("Expected ACC=%f, got %f\n", lfoScaled, state.ACC.ToFloat64())
 		}
 
 		// SIN1
 		op.Args[1].RawValue = 0x1
-		state.Sin1Osc.value = 3.14 / 2.0
-		state.GetRegister(base.SIN1_RANGE).Value = 1
+		state.Sin1Osc.sin, state.Sin1Osc.cos = sineLFOAmplitude, 0
+		state.GetRegister(base.SIN1_RANGE).Value = 16384
 		applyOp(op, state)
 
-		lfo = GetLFOValue(1, state, false)
-		lfoScaled = ScaleLFOValue(lfo, 1, state)
-		state.workRegA.SetFloat64(lfoScaled)
-
-		if !state.ACC.Equal(state.workRegA) {
+		if state.ACC.ToFloat64() != lfoScaled {
 			t.Errorf("Expected ACC=%f, got %f\n", lfoScaled, state.ACC.ToFloat64())
 		}
 
 		// RMP0
 		op.Args[1].RawValue = 0x2
-		state.Ramp0Osc.value = 0.5
-		state.GetRegister(base.RAMP0_RANGE).Value = 2
+		state.Ramp0Osc.SetWidth(4096)
+		state.Ramp0Osc.value = 2048 << rampFractionBits // 0.25
+		state.GetRegister(base.RAMP0_RANGE).SetInt32(4096)
 		applyOp(op, state)
 
-		lfo = GetLFOValue(2, state, false)
-		lfoScaled = ScaleLFOValue(lfo, 2, state)
-		state.workRegA.SetFloat64(lfoScaled)
-
-		if !state.ACC.Equal(state.workRegA) {
-			t.Errorf("Expected ACC=%f, got %f\n", lfoScaled, state.ACC.ToFloat64())
+		// The ramp is loaded as it is, 0.5 is the end of a 4096 word ramp
+		if state.ACC.ToFloat64() != 0.25 {
+			t.Errorf("Expected ACC=0.25, got %f\n", state.ACC.ToFloat64())
 		}
 
 		// RMP1
 		op.Args[1].RawValue = 0x3
-		state.Ramp1Osc.value = 0.5
-		state.GetRegister(base.RAMP1_RANGE).Value = 2
+		state.Ramp1Osc.SetWidth(4096)
+		state.Ramp1Osc.value = 2048 << rampFractionBits // 0.25
+		state.GetRegister(base.RAMP1_RANGE).SetInt32(4096)
 		applyOp(op, state)
 
-		lfo = GetLFOValue(3, state, false)
-		lfoScaled = ScaleLFOValue(lfo, 3, state)
-		state.workRegA.SetFloat64(lfoScaled)
-
-		if !state.ACC.Equal(state.workRegA) {
-			t.Errorf("Expected ACC=%f, got %f\n", lfoScaled, state.ACC.ToFloat64())
+		// The ramp is loaded as it is, 0.5 is the end of a 4096 word ramp
+		if state.ACC.ToFloat64() != 0.25 {
+			t.Errorf("Expected ACC=0.25, got %f\n", state.ACC.ToFloat64())
 		}
 
 	})
 
+	t.Run("WRAX SIN0_RATE", func(t *testing.T) {
+		op := base.Ops[0x06]
+		op.Args[0].RawValue = base.SIN0_RATE
+		for _, acc := range []float64{-1.0, -0.5, 0.5} {
+			state := NewState()
+			state.ACC.SetFloat64(acc)
+			applyOp(op, state)
+
+			// -1.0 gives 512 which does not fit in 9 bits
+			expected := min(int32(math.Abs(acc)*512), 511)
+			if state.GetRegister(base.SIN0_RATE).Value != expected || state.Sin0Osc.rate != expected {
+				t.Errorf("ACC=%f: Expected a rate of %d, got %d (oscillator %d)", acc, expected,
+					state.GetRegister(base.SIN0_RATE).Value, state.Sin0Osc.rate)
+			}
+		}
+	})
+
+	t.Run("WRAX RAMP0_RATE", func(t *testing.T) {
+		op := base.Ops[0x06]
+		op.Args[0].RawValue = base.RAMP0_RATE
+		type testCase struct {
+			acc      float64
+			expected int32
+			position int32 // After one step, in 1/16384 words
+		}
+		for _, c := range []testCase{
+			{-1.0, -32768, 2 << rampFractionBits}, // Two words up per sample
+			{-0.625, -20480, 20480},
+			{-0.5, -16384, 1 << rampFractionBits}, // The datasheet minimum
+			{0.5, 16384, (4096 - 1) << rampFractionBits},
+		} {
+			state := NewState()
+			state.Ramp0Osc.SetWidth(4096)
+			state.ACC.SetFloat64(c.acc)
+			applyOp(op, state)
+
+			if state.GetRegister(base.RAMP0_RATE).Value != c.expected || state.Ramp0Osc.rate != c.expected {
+				t.Errorf("ACC=%f: Expected a rate of %d, got %d (oscillator %d)", c.acc, c.expected,
+					state.GetRegister(base.RAMP0_RATE).Value, state.Ramp0Osc.rate)
+			}
+			state.Ramp0Osc.Step()
+			if state.Ramp0Osc.value != c.position {
+				t.Errorf("ACC=%f: Expected the position %d after one step, got %d",
+					c.acc, c.position, state.Ramp0Osc.value)
+			}
+		}
+	})
 }
 
 func Test_PseudoOps(t *testing.T) {
@@ -5,108 +5,145 @@ import (
 
 	"math"
 
-	"github.com/handegar/fv1emu/settings"
 	"github.com/handegar/fv1emu/utils"
 )
 
 //
 // Sine/Cosine oscillator (LFO)
 //
+// Modeled on the generator of the chip as the datasheet describes it:
+// the sine and cosine are two integrators feeding each other, stepped
+// once per sample:
+//
+//   sin += cos * rate / 2^17
+//   cos -= sin * rate / 2^17
+//
+// where 'rate' is the 9 bit SINx_RATE register. This gives the
+// frequency in the datasheet, f = rate * Fs / (2^17 * 2 * pi), or
+// 0 .. 20.3 Hz with a 32768 Hz clock. The values are S.23 integers
+// and the products are truncated, so the wave is not a perfect sine:
+// the cosine lags a little and the peaks differ by a few LSBs. The
+// model is not compared with captures from a real FV-1.
+//
+
+const (
+	sineLFORateShift = 17
+
+	// The peak value. The sine and cosine swing a little outside the
+	// start value, so some headroom is left below 1.0.
+	sineLFOAmplitude = (1 << 23) - (1 << 8)
+)
 
 type SineOscillator struct {
-	value float64
-	freq  float64 // normalized 0..1
-	amp   float64 // normalized 0..1
+	sin  int32 // S.23
+	cos  int32 // S.23
+	rate int32 // 0..511
 }
 
-func (s *SineOscillator) Update() {
-	factor := ((2.0 * math.Pi) / settings.ClockFrequency)
-
-	// Calibrated so that max freq -> sine of 20 hz
-	f0 := 4.0 * s.freq / 512.0
-	utils.Assert(f0 >= 0.0 && f0 <= 20.0, "Sin0 rate out of range [0..20hz]: %f", f0)
-	s.value += f0 * factor
+// Starts at sin=0 and cos=1
+func NewSineOscillator() SineOscillator {
+	return SineOscillator{cos: sineLFOAmplitude}
 }
 
-func (s *SineOscillator) SetFreq(freq int32) {
-	s.freq = (float64(freq) / 512.0) * 20.0
+// One sample of the chip. Called once per processed sample, so the
+// frequency follows the rate the program runs at.
+func (s *SineOscillator) Step() {
+	s.sin = saturateS23(int64(s.sin) + (int64(s.cos)*int64(s.rate))>>sineLFORateShift)
+	s.cos = saturateS23(int64(s.cos) - (int64(s.sin)*int64(s.rate))>>sineLFORateShift)
 }
-func (s *SineOscillator) SetAmp(amp int32) {
-	s.amp = float64(amp) / 32767.0
+
+// The integrators saturate like the rest of the datapath, which also
+// keeps the amplitude from drifting upwards
+func saturateS23(v int64) int32 {
+	return int32(max(-(1 << 23), min((1<<23)-1, v)))
 }
 
-//
-// A possible optimization could be to roll our own SIN/COS
-// function like this:
-//   https://news.ycombinator.com/item?id=30844872
-//
-// The hardware sine-function in the FV-1 is not 64-bit as
-// GOLang's "math.sin()" so there is no need for this kind of
-// super-precision. A simple 24-bit will be more than enough
-// and probably too precise for anyone to notice.
-//
+// The rate is clamped to the 9 bits of SINx_RATE, 0..511
+func (s *SineOscillator) SetFreq(rate int32) {
+	s.rate = max(0, min(511, rate))
+}
 
-//
-// NOTE: One thing I have seen while calibrating against a real FV-1
-// is that the chip's sine function is quite uneven and more
-// flattend on the bottom than the top. Maybe I should experiment with
-// approximations to match the asymetric look on the real deal.
-//
+// The frequency of the sine LFO for a SINx_RATE value with a
+// 'clockFrequency' Hz clock
+func SineLFOFrequency(rate int32, clockFrequency float64) float64 {
+	return float64(rate) * clockFrequency / ((1 << sineLFORateShift) * 2.0 * math.Pi)
+}
 
 func (s *SineOscillator) GetSine() float64 {
-	return math.Sin(s.value)
+	return float64(s.sin) / (1 << 23)
 }
 
 func (s *SineOscillator) GetCosine() float64 {
-	return math.Cos(s.value)
+	return float64(s.cos) / (1 << 23)
 }
 
 //
 // Ramp oscillator (LFO)
 //
+// The ramp is the position of a delay read within a window of 512,
+// 1024, 2048 or 4096 words (the RAMPx_RANGE register). Each sample it
+// moves 'rate' / 16384 words towards 0 and wraps at the end of the
+// window, so the frequency is f = rate * Fs / (16384 * range). A
+// rate of 16384 reads one word closer per sample, which shifts the
+// pitch up an octave, and -8192 shifts it down an octave.
+//
+// The value is read as a S.23 number where 4096 words is 0.5, so the
+// ramp goes from 0 to range / 8192. Like the sine, the model follows
+// the datasheet and is not compared with captures from a real FV-1.
+//
+
+const (
+	// The position has this many bits below a delay word
+	rampFractionBits = 14
+
+	// Bits of the position below the S.23 LFO value
+	rampValueShift = rampFractionBits - 10
+)
 
 type RampOscillator struct {
-	value int32
-	freq  int32
-	amp   int32 // 512, 1024, 2048 or 4096
+	value int32 // The position in 1/16384 words
+	rate  int32 // -32768 .. 32767
+	width int32 // 512, 1024, 2048 or 4096 words
+}
+
+func NewRampOscillator() RampOscillator {
+	return RampOscillator{width: 512}
 }
 
-func (r *RampOscillator) Update() {
-	utils.Assert(r.freq >= -16384 && r.freq <= 32767,
-		"Ramp0 rate out of range [-16384 .. 38767]: %d", r.freq)
-	r.value -= r.freq >> 2
-	if r.value < 0 {
-		r.value = 0x7FFFFFFF
-	}
+// One sample of the chip, see SineOscillator.Step()
+func (r *RampOscillator) Step() {
+	r.value = (r.value - r.rate) & (r.width<<rampFractionBits - 1)
 }
 
-func (r *RampOscillator) SetFreq(freq int32) {
-	utils.Assert(freq < 32769 && freq >= -16384, "Ramp freq out of range: %d", freq)
-	r.freq = freq
+// RAMPx_RATE is a 16 bit register. The datasheet only gives WLDR
+// rates down to -16384, but WRAX can write down to -32768. The
+// position is the same integrator for the whole range, so -32768
+// moves it two words away from 0 per sample. Other values are clamped.
+func (r *RampOscillator) SetFreq(rate int32) {
+	r.rate = max(-32768, min(32767, rate))
 }
 
-// Input: 0, 1, 2 or 3
-func (r *RampOscillator) SetAmpIdx(ampIdx int8) {
-	utils.Assert(ampIdx >= 0 && ampIdx <= 3, "Invalid AmpIdx value: %d", ampIdx)
-	r.amp = 512 << ampIdx
+// Input: 512, 1024, 2048 or 4096. The position is kept within the
+// new window.
+func (r *RampOscillator) SetWidth(width int32) {
+	utils.Assert(width == 512 || width == 1024 || width == 2048 || width == 4096,
+		"Invalid Ramp width: %d", width)
+	r.width = width
+	r.value &= width<<rampFractionBits - 1
 }
 
-// Will choose a matching ampidx.
-// Input: 512, 1024, 2048 or 4096
-func (r *RampOscillator) SetAmp(amp int32) {
-	utils.Assert(amp == 512 || amp == 1024 || amp == 2048 || amp == 2096,
-		"Invalid Ramp amp value")
-	r.amp = amp
+// The frequency of the ramp LFO for a RAMPx_RATE value and a width of
+// 'width' words with a 'clockFrequency' Hz clock
+func RampLFOFrequency(rate int32, width int32, clockFrequency float64) float64 {
+	return float64(rate) * clockFrequency / float64(width<<rampFractionBits)
 }
 
-// Always returns a value between 0 and 0.5
-// FIXME: Return <0 .. 1.0> or <0 .. 0.5>? (20260202 handegar)
+// Returns a value between 0 and range / 8192 (0.5 for 4096 words)
 func (r *RampOscillator) GetValue() float64 {
-	// FIXME: Here we could do a quick bitshift instead I think (20260202 handegar)
-	//fmt.Printf("r.val=%d\n", r.value)
-	return float64(r.value) / float64(0x7FFFFFFF)
+	return float64(r.value>>rampValueShift) / (1 << 23)
 }
 
+// Back to the start of the ramp, like JAM
 func (r *RampOscillator) Reset() {
 	r.value = 0
 }
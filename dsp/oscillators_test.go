@@ -0,0 +1,111 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+// The period (in samples) and amplitude of the sine LFO must follow
+// the datasheet, f = rate * Fs / (2^17 * 2 * pi)
+func Test_SineOscillator(t *testing.T) {
+	for _, rate := range []int32{10, 51, 125, 300, 511} {
+		osc := NewSineOscillator()
+		osc.SetFreq(rate)
+
+		expected := 2 * math.Pi * (1 << 17) / float64(rate)
+		first, last, crossings := 0, 0, 0
+		peak := int32(0)
+		for i := 0; i < int(expected*20); i++ {
+			prev := osc.sin
+			osc.Step()
+			if prev < 0 && osc.sin >= 0 {
+				if crossings == 0 {
+					first = i
+				}
+				last = i
+				crossings++
+			}
+			peak = max(peak, osc.sin, -osc.sin, osc.cos, -osc.cos)
+		}
+
+		period := float64(last-first) / float64(crossings-1)
+		if math.Abs(period-expected) > expected*1e-4 {
+			t.Errorf("Rate %d: Expected a period of %.1f samples, got %.1f", rate, expected, period)
+		}
+		if peak > (1<<23)-1 || peak < sineLFOAmplitude-(1<<12) {
+			t.Errorf("Rate %d: Unexpected peak value 0x%x", rate, peak)
+		}
+	}
+
+	// The first step from sin=0, cos=1
+	osc := NewSineOscillator()
+	osc.SetFreq(511)
+	osc.Step()
+	if osc.sin != 32703 || osc.cos != 8388225 {
+		t.Errorf("Expected sin=32703, cos=8388225, got sin=%d, cos=%d", osc.sin, osc.cos)
+	}
+
+	if f := SineLFOFrequency(511, 32768); math.Abs(f-20.33) > 0.01 {
+		t.Errorf("Expected 20.33 Hz, got %.2f", f)
+	}
+}
+
+// f = rate * Fs / (16384 * width), and the ramp counts down from the
+// end of the window
+func Test_RampOscillator(t *testing.T) {
+	type testCase struct {
+		rate   int32
+		width  int32
+		period int // Samples
+	}
+	for _, c := range []testCase{
+		{16384, 4096, 4096}, // An octave up
+		{-8192, 4096, 8192}, // An octave down
+		{16384, 512, 512},
+		{4096, 1024, 4096},
+		{32767, 2048, 1024},
+	} {
+		osc := NewRampOscillator()
+		osc.SetWidth(c.width)
+		osc.SetFreq(c.rate)
+
+		wraps, first, last := 0, 0, 0
+		for i := 1; i <= c.period*10; i++ {
+			prev := osc.GetValue()
+			osc.Step()
+			if (c.rate > 0 && osc.GetValue() > prev) || (c.rate < 0 && osc.GetValue() < prev) {
+				if wraps == 0 {
+					first = i
+				}
+				last = i
+				wraps++
+			}
+			if osc.GetValue() < 0 || osc.GetValue() >= float64(c.width)/8192 {
+				t.Fatalf("Rate %d, width %d: Value %f outside the ramp", c.rate, c.width, osc.GetValue())
+			}
+		}
+		if period := float64(last-first) / float64(wraps-1); math.Abs(period-float64(c.period)) > 1 {
+			t.Errorf("Rate %d, width %d: Expected a period of %d samples, got %.1f",
+				c.rate, c.width, c.period, period)
+		}
+	}
+
+	// The first step from 0 wraps to the end of the window
+	osc := NewRampOscillator()
+	osc.SetWidth(4096)
+	osc.SetFreq(16384)
+	osc.Step()
+	if osc.GetValue() != 0.5-1.0/8192 {
+		t.Errorf("Expected %f, got %f", 0.5-1.0/8192, osc.GetValue())
+	}
+
+	// A narrower window keeps the position within it
+	osc.SetWidth(512)
+	if osc.GetValue() != 0.0625-1.0/8192 {
+		t.Errorf("Expected %f, got %f", 0.0625-1.0/8192, osc.GetValue())
+	}
+
+	if f := RampLFOFrequency(8015, 4096, 32768); math.Abs(f-3.91) > 0.01 {
+		t.Errorf("Expected 3.91 Hz, got %.2f", f)
+	}
+}
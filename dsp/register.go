@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/handegar/fv1emu/settings"
 	"github.com/handegar/fv1emu/utils"
 )
 
@@ -21,6 +20,10 @@ type Register struct {
 	// This is the "public" Q-format, internally it's always S8.23
 	IntBits      int
 	FractionBits int
+
+	// Set for the registers of a State with 24 bit clamping
+	// disabled. Not changed by Copy().
+	noClamping bool
 }
 
 func NewRegister(value int32) *Register {
@@ -69,7 +72,7 @@ func (r *Register) SetToMin24Bit() *Register {
 	Returns TRUE on second value if value were clamped
 */
 func (r *Register) Clamp24Bit() (*Register, bool) {
-	if settings.Disable24BitsClamping { // Shall we not perform clamping?
+	if r.noClamping { // Shall we not perform clamping?
 		return r, false
 	}
 
@@ -99,11 +102,8 @@ func (r *Register) extendSign() {
 	Set a different Q-Format value than S8.23
 */
 func (r *Register) SetWithIntsAndFracs(value int32, intbits int, fractionbits int) *Register {
-	// Will the actual number fit?
-	if value > ((1 << (intbits + fractionbits + 1)) - 1) {
-		fmt.Errorf("The value 0x%x won't fit int a S%d.%d", value, intbits, fractionbits)
-	}
-
+	// The bits above the sign bit are dropped. Compile() checks that
+	// the instruction arguments fit.
 	r.IntBits = intbits
 	r.FractionBits = fractionbits
 	r.Value = value
@@ -125,8 +125,9 @@ func (r *Register) SetClampedFloat64(value float64) *Register {
 }
 
 func (r *Register) SetFloat64(value float64) *Register {
-	utils.Assert(value <= 1.0 && value >= -1.0,
-		"Register.SetFloat64(%f): Value out of range [-1.0 .. 0.9999]", value)
+	if !(value <= 1.0 && value >= -1.0) { // Checked first to avoid allocating the assert arguments
+		utils.Assert(false, "Register.SetFloat64(%f): Value out of range [-1.0 .. 0.9999]", value)
+	}
 	r.Value = int32(value * math.Pow(2, 23))
 	r.IntBits = 8
 	r.FractionBits = 23
@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"github.com/handegar/fv1emu/base"
-	"github.com/handegar/fv1emu/settings"
 	//"github.com/handegar/fv1emu/utils"
 )
 
@@ -26,6 +25,10 @@ type State struct {
 
 	DebugFlags *DebugFlags // Contains misc debug/error flags which will be set @ runtime
 
+	Config Config // Clock, pots and the other settings for this state
+
+	skipNumSamples int // Number of samples to run before calling the debugger again
+
 	Registers RegisterBank // All 64 registers
 
 	//
@@ -55,14 +58,16 @@ type State struct {
 	workReg4_6  *Register // S4.6
 }
 
+// Called once per sample
 func (s *State) UpdateSineLFOs() {
-	s.Sin0Osc.Update()
-	s.Sin1Osc.Update()
+	s.Sin0Osc.Step()
+	s.Sin1Osc.Step()
 }
 
+// Called once per sample
 func (s *State) UpdateRampLFOs() {
-	s.Ramp0Osc.Update()
-	s.Ramp1Osc.Update()
+	s.Ramp0Osc.Step()
+	s.Ramp1Osc.Step()
 }
 
 // Returns the LFO values stored by the CHO+'REG' flag
@@ -71,47 +76,63 @@ func (s *State) GetLFORegisterValues() (float64, float64, float64, float64) {
 		s.ramp0LFOReg.ToFloat64(), s.ramp1LFOReg.ToFloat64()
 }
 
-type RegisterBank map[int]*Register
+// All 64 registers, stored by value so that processing a sample never
+// allocates
+type RegisterBank [64]Register
 
+// A new state configured by the global settings
 func NewState() *State {
+	return NewStateWithConfig(ConfigFromSettings())
+}
+
+func NewStateWithConfig(config Config) *State {
 	s := new(State)
 	s.DebugFlags = new(DebugFlags)
+	s.Config = config
+
+	// Allocated once. Reset() and Copy() only change the values.
+	for _, r := range []**Register{&s.ACC, &s.PACC, &s.LR,
+		&s.sin0LFOReg, &s.sin1LFOReg, &s.cos0LFOReg, &s.cos1LFOReg,
+		&s.ramp0LFOReg, &s.ramp1LFOReg,
+		&s.workRegA, &s.workRegB, &s.scaleReg, &s.offsetReg,
+		&s.workReg0_23, &s.workReg0_10, &s.workReg1_14, &s.workReg1_9, &s.workReg4_6} {
+		*r = NewRegister(0)
+	}
+
 	s.Reset()
 	return s
 }
 
+// Same as checking for values outside [-1.0 .. 1.0], without the
+// float conversions
+func isOverflow(r *Register) bool {
+	return r.Value > (1<<23) || r.Value < -(1<<23)
+}
+
 func (s *State) CheckForOverflows() {
-	acc := s.ACC.ToFloat64()
-	if acc > 1.0 || acc < -1.0 {
+	if isOverflow(s.ACC) {
 		s.DebugFlags.ACCOverflowCount += 1
 	}
-	pacc := s.PACC.ToFloat64()
-	if pacc > 1.0 || pacc < -1.0 {
+	if isOverflow(s.PACC) {
 		s.DebugFlags.PACCOverflowCount += 1
 	}
-	lr := s.LR.ToFloat64()
-	if lr > 1.0 || lr < -1.0 {
+	if isOverflow(s.LR) {
 		s.DebugFlags.LROverflowCount += 1
 	}
-	dacl := s.GetRegister(base.DACL).ToFloat64()
-	if dacl > 1.0 || dacl < -1.0 {
+	if isOverflow(&s.Registers[base.DACL]) {
 		s.DebugFlags.DACLOverflowCount += 1
 	}
-	dacr := s.GetRegister(base.DACR).ToFloat64()
-	if dacr > 1.0 || dacr < -1.0 {
+	if isOverflow(&s.Registers[base.DACR]) {
 		s.DebugFlags.DACROverflowCount += 1
 	}
 }
 
 func (s *State) GetRegister(regNo int) *Register {
-	err := validateRegisterNo(regNo)
-	if err != nil && !settings.Debugger {
-		fmt.Printf("ERROR: %s\n", err)
+	if validateRegisterNo(regNo) != nil {
 		s.DebugFlags.InvalidRegister = regNo
-		s.DebugFlags.Print()
 	}
 
-	return s.Registers[regNo]
+	return &s.Registers[regNo]
 }
 
 func (s *State) Copy(in *State) {
@@ -120,25 +141,20 @@ func (s *State) Copy(in *State) {
 	s.ACC.Copy(in.ACC)
 	s.PACC.Copy(in.PACC)
 	s.LR.Copy(in.LR)
-	s.Sin0Osc.value = in.Sin0Osc.value
-	s.Sin1Osc.value = in.Sin1Osc.value
+	s.Sin0Osc = in.Sin0Osc
+	s.Sin1Osc = in.Sin1Osc
 
-	s.Ramp0Osc.value = in.Ramp0Osc.value
-	s.Ramp1Osc.value = in.Ramp1Osc.value
+	s.Ramp0Osc = in.Ramp0Osc
+	s.Ramp1Osc = in.Ramp1Osc
 	s.DelayRAMPtr = in.DelayRAMPtr
 
-	for i := 0; i < 64; i++ {
-		if (i >= 8 && i <= 15) || i == 19 || (i >= 25 && i <= 31) {
-			s.Registers[i] = nil
-			continue // Unused registers
-		}
-		s.Registers[i] = NewRegister(0) // All registers are S.23 as default
-		s.Registers[i].Copy(in.Registers[i])
+	for i := range s.Registers {
+		s.Registers[i].Copy(&in.Registers[i])
 	}
 }
 
 func (s *State) Duplicate() *State {
-	new := NewState()
+	new := NewStateWithConfig(s.Config)
 	new.Copy(s)
 	return new
 }
@@ -148,42 +164,45 @@ func (s *State) Reset() {
 	s.RUN_FLAG = false
 	s.DelayRAMPtr = 0
 
-	s.ACC = NewRegister(0)
-	s.PACC = NewRegister(0)
-	s.LR = NewRegister(0)
-
-	s.Sin0Osc.value = 0
-	s.Sin1Osc.value = 0
-	s.Ramp0Osc.Reset()
-	s.Ramp1Osc.Reset()
-
-	s.sin0LFOReg = NewRegister(0)
-	s.sin1LFOReg = NewRegister(0)
-	s.cos0LFOReg = NewRegister(0)
-	s.cos1LFOReg = NewRegister(0)
-	s.ramp0LFOReg = NewRegister(0)
-	s.ramp1LFOReg = NewRegister(0)
-
-	s.workRegA = NewRegister(0)
-	s.workRegB = NewRegister(0)
-	s.scaleReg = NewRegister(0)
-	s.offsetReg = NewRegister(0)
-
-	s.workReg0_23 = NewRegisterWithIntsAndFracs(0, 0, 23)
-	s.workReg0_10 = NewRegisterWithIntsAndFracs(0, 0, 10)
-	s.workReg1_14 = NewRegisterWithIntsAndFracs(0, 1, 14)
-	s.workReg1_9 = NewRegisterWithIntsAndFracs(0, 1, 9)
-	s.workReg4_6 = NewRegisterWithIntsAndFracs(0, 4, 6)
-
-	s.Registers = make(map[int]*Register)
-	for i := 0; i < 64; i++ {
-		s.Registers[i] = NewRegister(0) // All registers are S.23 as default
+	*s.ACC = *NewRegister(0)
+	*s.PACC = *NewRegister(0)
+	*s.LR = *NewRegister(0)
+
+	s.DelayRAM = [DELAY_RAM_SIZE]int32{}
+
+	s.Sin0Osc = NewSineOscillator()
+	s.Sin1Osc = NewSineOscillator()
+	s.Ramp0Osc = NewRampOscillator()
+	s.Ramp1Osc = NewRampOscillator()
+
+	*s.sin0LFOReg = *NewRegister(0)
+	*s.sin1LFOReg = *NewRegister(0)
+	*s.cos0LFOReg = *NewRegister(0)
+	*s.cos1LFOReg = *NewRegister(0)
+	*s.ramp0LFOReg = *NewRegister(0)
+	*s.ramp1LFOReg = *NewRegister(0)
+
+	*s.workRegA = *NewRegister(0)
+	*s.workRegB = *NewRegister(0)
+	*s.scaleReg = *NewRegister(0)
+	*s.offsetReg = *NewRegister(0)
+
+	*s.workReg0_23 = *NewRegisterWithIntsAndFracs(0, 0, 23)
+	*s.workReg0_10 = *NewRegisterWithIntsAndFracs(0, 0, 10)
+	*s.workReg1_14 = *NewRegisterWithIntsAndFracs(0, 1, 14)
+	*s.workReg1_9 = *NewRegisterWithIntsAndFracs(0, 1, 9)
+	*s.workReg4_6 = *NewRegisterWithIntsAndFracs(0, 4, 6)
+
+	for i := range s.Registers {
+		s.Registers[i] = *NewRegister(0) // All registers are S.23 as default
 	}
 
+	s.setClamping(!s.Config.Disable24BitsClamping)
+
 	// Set default register values
-	s.GetRegister(base.POT0).SetClampedFloat64(settings.Pot0Value) // POT0, (16) Pot 0 input register
-	s.GetRegister(base.POT1).SetClampedFloat64(settings.Pot1Value) // POT1, (17) Pot 1 input register
-	s.GetRegister(base.POT2).SetClampedFloat64(settings.Pot2Value) // POT2, (18) Pot 2 input register
+	s.GetRegister(base.POT0).SetClampedFloat64(s.Config.Pot0Value) // POT0, (16) Pot 0 input register
+	s.GetRegister(base.POT1).SetClampedFloat64(s.Config.Pot1Value) // POT1, (17) Pot 1 input register
+	s.GetRegister(base.POT2).SetClampedFloat64(s.Config.Pot2Value) // POT2, (18) Pot 2 input register
 
 	s.GetRegister(base.RAMP0_RANGE).Value = 512
 	s.GetRegister(base.RAMP1_RANGE).Value = 512
@@ -191,6 +210,30 @@ func (s *State) Reset() {
 	s.DebugFlags.Reset()
 }
 
+// Turn 24 bit clamping on or off for all registers used when running
+// a program
+func (s *State) setClamping(clamp bool) {
+	for _, r := range []*Register{s.ACC, s.PACC, s.LR,
+		s.sin0LFOReg, s.sin1LFOReg, s.cos0LFOReg, s.cos1LFOReg,
+		s.ramp0LFOReg, s.ramp1LFOReg,
+		s.workRegA, s.workRegB, s.scaleReg, s.offsetReg,
+		s.workReg0_23, s.workReg0_10, s.workReg1_14, s.workReg1_9, s.workReg4_6} {
+		r.noClamping = !clamp
+	}
+	for i := range s.Registers {
+		s.Registers[i].noClamping = !clamp
+	}
+}
+
+// Run the next 'num' samples without calling the debugger
+func (s *State) SkipNumSamples(num int) {
+	s.skipNumSamples = num
+}
+
+func (s *State) GetSkipNumSamples() int {
+	return s.skipNumSamples
+}
+
 func validateRegisterNo(regNo int) error {
 	if !(regNo >= 0 && regNo <= base.RAMP1_RANGE) &&
 		!(regNo >= base.POT0 && regNo <= base.POT2) &&
@@ -0,0 +1,228 @@
+package fv1
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/handegar/fv1emu/bank"
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/codec"
+	"github.com/handegar/fv1emu/dsp"
+)
+
+/**
+  An emulated FV-1 for use as a library. Each Chip has its own
+  program, state and options and does not read the global settings,
+  so any number of chips can run side by side, each in its own
+  goroutine. A single Chip must not be used from several goroutines
+  at the same time.
+
+  	chip, err := fv1.New(fv1.DefaultOptions())
+  	...
+  	err = chip.Load(words, 0)
+  	...
+  	err = chip.Process(in, out)
+*/
+
+type Options struct {
+	// Hz. The rate Process() is fed at, like the clock of a real
+	// FV-1. Only the codec filters use it: the LFOs step once per
+	// sample, so their speed follows the rate of the samples whatever
+	// this is set to.
+	ClockFrequency float64
+
+	// Potentiometer values [0 .. 1.0]. Can be changed later with
+	// SetPot().
+	Pot0 float64
+	Pot1 float64
+	Pot2 float64
+
+	// The number of instructions decoded from a program by Load(),
+	// 128 on the chip. The LFOs step once per sample however many
+	// instructions are run.
+	InstructionsPerSample int
+
+	// Let registers use all 32 bits instead of clamping to 24 bits
+	Disable24BitsClamping bool
+
+	// Use all CHO RDAL flags, not just REG and COMPC
+	AllowAllChoRdalFlags bool
+
+	// Store the delay memory in the 14-bit floating point format of
+	// the FV-1 instead of 24 bits
+	FloatDelayRAM bool
+
+	// Model the ADC and DAC, nil for an ideal datapath. The filters
+	// assume the samples are at the clock frequency.
+	Codec *codec.Config
+}
+
+// The same defaults as the command line tool
+func DefaultOptions() Options {
+	return Options{
+		ClockFrequency:        44100.0,
+		Pot0:                  0.5,
+		Pot1:                  0.5,
+		Pot2:                  0.5,
+		InstructionsPerSample: 128,
+		Disable24BitsClamping: false,
+		AllowAllChoRdalFlags:  true,
+		FloatDelayRAM:         false,
+	}
+}
+
+type Chip struct {
+	config dsp.Config
+	state  *dsp.State
+	prog   *dsp.Program
+	codec  *codec.Codec // nil without a codec model
+}
+
+func New(opts Options) (*Chip, error) {
+	if opts.ClockFrequency <= 0 {
+		return nil, fmt.Errorf("Invalid clock frequency %f", opts.ClockFrequency)
+	}
+	if opts.InstructionsPerSample <= 0 {
+		return nil, fmt.Errorf("Invalid number of instructions per sample %d",
+			opts.InstructionsPerSample)
+	}
+	for pot, value := range []float64{opts.Pot0, opts.Pot1, opts.Pot2} {
+		if err := checkPotValue(pot, value); err != nil {
+			return nil, err
+		}
+	}
+
+	c := new(Chip)
+	c.config = dsp.Config{
+		ClockFrequency:        opts.ClockFrequency,
+		Pot0Value:             opts.Pot0,
+		Pot1Value:             opts.Pot1,
+		Pot2Value:             opts.Pot2,
+		InstructionsPerSample: opts.InstructionsPerSample,
+		Disable24BitsClamping: opts.Disable24BitsClamping,
+		AllowAllChoRdalFlags:  opts.AllowAllChoRdalFlags,
+		FloatDelayRAM:         opts.FloatDelayRAM,
+	}
+	c.state = dsp.NewStateWithConfig(c.config)
+	if opts.Codec != nil {
+		var err error
+		if c.codec, err = codec.New(*opts.Codec, opts.ClockFrequency); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func checkPotValue(pot int, value float64) error {
+	if value < 0.0 || value > 1.0 {
+		return fmt.Errorf("POT%d value %f is outside [0 .. 1.0]", pot, value)
+	}
+	return nil
+}
+
+// Load program number 'prog' from a BIN/HEX image (see the reader
+// package) or the words from the assembler. The chip is reset.
+func (c *Chip) Load(words []uint32, prog int) error {
+	size := bank.PROGRAM_SIZE
+	start := prog * size
+	if prog < 0 || start >= len(words) {
+		return fmt.Errorf("Program %d not found, there are only %d program(s)",
+			prog, (len(words)+size-1)/size)
+	}
+
+	ops := dsp.DecodeOpCodes(words[start:min(len(words), start+size)], c.config)
+	if len(ops) == 0 {
+		return fmt.Errorf("Program %d has no instructions", prog)
+	}
+	return c.LoadOps(ops)
+}
+
+// Load an already decoded program. The chip is reset.
+func (c *Chip) LoadOps(ops []base.Op) error {
+	prog, err := dsp.Compile(ops)
+	if err != nil {
+		return err
+	}
+	c.prog = prog
+	c.Reset()
+	return nil
+}
+
+// The decoded instructions of the loaded program
+func (c *Chip) Program() []base.Op {
+	if c.prog == nil {
+		return nil
+	}
+	return c.prog.Ops
+}
+
+// Clears the delay memory, the registers, the LFOs and the codec
+// filters like a power cycle. The pots keep their values.
+func (c *Chip) Reset() {
+	c.state.Reset()
+	if c.codec != nil {
+		c.codec.Reset()
+	}
+}
+
+// Set POT0, POT1 or POT2 to a value between 0 and 1.0. Takes effect
+// from the next sample.
+func (c *Chip) SetPot(pot int, value float64) error {
+	if pot < 0 || pot > 2 {
+		return fmt.Errorf("Invalid pot number %d", pot)
+	}
+	if err := checkPotValue(pot, value); err != nil {
+		return err
+	}
+
+	switch pot {
+	case 0:
+		c.config.Pot0Value = value
+	case 1:
+		c.config.Pot1Value = value
+	case 2:
+		c.config.Pot2Value = value
+	}
+	c.state.Config = c.config
+	c.state.GetRegister(base.POT0 + pot).SetClampedFloat64(value)
+	return nil
+}
+
+// The internal state, for inspecting registers, memory and debug
+// flags
+func (c *Chip) State() *dsp.State {
+	return c.state
+}
+
+// Run the program once for each stereo sample in 'in' and write the
+// result to 'out', which must be at least as long. 'in' and 'out'
+// can be the same slice. Input values are clamped to [-1.0 .. 1.0>.
+func (c *Chip) Process(in [][2]float64, out [][2]float64) error {
+	if c.prog == nil {
+		return errors.New("No program loaded")
+	}
+	if len(out) < len(in) {
+		return fmt.Errorf("The output buffer is too short (%d < %d samples)", len(out), len(in))
+	}
+
+	adcl := c.state.GetRegister(base.ADCL)
+	adcr := c.state.GetRegister(base.ADCR)
+	dacl := c.state.GetRegister(base.DACL)
+	dacr := c.state.GetRegister(base.DACR)
+
+	for i, sample := range in {
+		if c.codec != nil {
+			sample = c.codec.ADC(sample)
+		}
+		adcl.SetClampedFloat64(sample[0])
+		adcr.SetClampedFloat64(sample[1])
+		if err := dsp.RunProgram(c.prog, c.state); err != nil {
+			return fmt.Errorf("Sample %d, instruction %d: %s", i, c.state.IP, err)
+		}
+		out[i] = [2]float64{dacl.ToFloat64(), dacr.ToFloat64()}
+		if c.codec != nil {
+			out[i] = c.codec.DAC(out[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package fv1
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/handegar/fv1emu/asm"
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/codec"
+	"github.com/handegar/fv1emu/dsp"
+	"github.com/handegar/fv1emu/settings"
+)
+
+// Uses the pots, the sine LFO and overflows the accumulator
+const testProgram = `
+	skp	run, start
+	wlds	sin0, 100, 1000
+start:	rdax	adcl, 1.0
+	mulx	pot0
+	wra	0, 0
+	cho	rda, sin0, sin|reg|compc, 500
+	cho	rda, sin0, sin, 501
+	wrax	dacl, 1.0
+	sof	1.99, 0
+	sof	1.99, 0
+	mulx	pot1
+	wrax	dacr, 0
+`
+
+func testWords(t *testing.T) []uint32 {
+	prog, err := asm.Assemble(testProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog.Words
+}
+
+func testInput() [][2]float64 {
+	in := make([][2]float64, 2000)
+	for i := range in {
+		v := 0.8 * math.Sin(float64(i)*0.05)
+		in[i] = [2]float64{v, v}
+	}
+	return in
+}
+
+func newChip(t *testing.T, opts Options) *Chip {
+	chip, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = chip.Load(testWords(t), 0); err != nil {
+		t.Fatal(err)
+	}
+	return chip
+}
+
+func process(t *testing.T, chip *Chip, in [][2]float64) [][2]float64 {
+	out := make([][2]float64, len(in))
+	if err := chip.Process(in, out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func equal(a [][2]float64, b [][2]float64) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+// Must give exactly the same result as the command line emulator
+func Test_ChipMatchesProcessSample(t *testing.T) {
+	in := testInput()
+	out := process(t, newChip(t, DefaultOptions()), in)
+
+	prog, err := dsp.Compile(dsp.DecodeOpCodes(testWords(t), dsp.ConfigFromSettings()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noDebug := func(opCodes []base.Op, state *dsp.State, sampleNum int) int { return dsp.Ok }
+	state := dsp.NewState()
+	for i, sample := range in {
+		state.GetRegister(base.ADCL).SetFloat64(sample[0])
+		state.GetRegister(base.ADCR).SetFloat64(sample[1])
+		dsp.ProcessSample(prog, state, i, noDebug, noDebug)
+		expected := [2]float64{state.GetRegister(base.DACL).ToFloat64(),
+			state.GetRegister(base.DACR).ToFloat64()}
+		if out[i] != expected {
+			t.Fatalf("Sample %d: Expected %v, got %v", i, expected, out[i])
+		}
+	}
+}
+
+func Test_ChipOptions(t *testing.T) {
+	in := testInput()
+	reference := process(t, newChip(t, DefaultOptions()), in)
+
+	// The global settings must not matter
+	defer func(pot float64, clock float64, clamp bool) {
+		settings.Pot0Value, settings.ClockFrequency, settings.Disable24BitsClamping = pot, clock, clamp
+	}(settings.Pot0Value, settings.ClockFrequency, settings.Disable24BitsClamping)
+	settings.Pot0Value = 0.1
+	settings.ClockFrequency = 32768
+	settings.Disable24BitsClamping = true
+	if !equal(reference, process(t, newChip(t, DefaultOptions()), in)) {
+		t.Errorf("The global settings changed the result")
+	}
+
+	variants := map[string]func(opts *Options){
+		"Pot0":     func(opts *Options) { opts.Pot0 = 0.9 },
+		"Clamping": func(opts *Options) { opts.Disable24BitsClamping = true },
+		"Cycles":   func(opts *Options) { opts.InstructionsPerSample = 4 },
+	}
+	for name, change := range variants {
+		t.Run(name, func(t *testing.T) {
+			opts := DefaultOptions()
+			change(&opts)
+			if equal(reference, process(t, newChip(t, opts), in)) {
+				t.Errorf("Changing %s did not change the result", name)
+			}
+		})
+	}
+}
+
+func Test_ChipSetPotAndReset(t *testing.T) {
+	in := testInput()
+	opts := DefaultOptions()
+	opts.Pot1 = 0.25
+	expected := process(t, newChip(t, opts), in)
+
+	chip := newChip(t, DefaultOptions())
+	process(t, chip, in)
+	if err := chip.SetPot(1, 0.25); err != nil {
+		t.Fatal(err)
+	}
+	chip.Reset()
+	if !equal(expected, process(t, chip, in)) {
+		t.Errorf("Expected the same result after SetPot() and Reset() as a new chip")
+	}
+
+	if chip.SetPot(3, 0.5) == nil || chip.SetPot(0, 1.5) == nil {
+		t.Errorf("Expected invalid pots to fail")
+	}
+}
+
+// Different chips running at the same time must not affect each
+// other. Run with -race to catch shared state.
+func Test_ChipsInParallel(t *testing.T) {
+	in := testInput()
+	pots := []float64{0.0, 0.2, 0.4, 0.6, 0.8, 1.0}
+
+	expected := make([][][2]float64, len(pots))
+	for i, pot := range pots {
+		opts := DefaultOptions()
+		opts.Pot0 = pot
+		expected[i] = process(t, newChip(t, opts), in)
+	}
+
+	chips := make([]*Chip, len(pots))
+	for i, pot := range pots {
+		opts := DefaultOptions()
+		opts.Pot0 = pot
+		chips[i] = newChip(t, opts)
+	}
+
+	results := make([][][2]float64, len(pots))
+	var wg sync.WaitGroup
+	for i := range chips {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = make([][2]float64, len(in))
+			chips[i].Process(in, results[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range pots {
+		if !equal(expected[i], results[i]) {
+			t.Errorf("Chip %d gave a different result when run in parallel", i)
+		}
+	}
+}
+
+// The codec model quantizes the input and removes DC from the output
+func Test_ChipCodec(t *testing.T) {
+	prog, err := asm.Assemble("rdax adcl, 1.0\nwrax dacl, 0\nsof 0, 0.5\nwrax dacr, 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.Codec = &codec.Config{ADCBits: 8, DCBlock: 100}
+	chip, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = chip.Load(prog.Words, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	in := make([][2]float64, 44100)
+	for i := range in {
+		in[i] = [2]float64{0.1, 0}
+	}
+	out := process(t, chip, in)
+	if out[0][0] != 13.0/128 {
+		t.Errorf("Expected the input quantized to 8 bits (%f), got %f", 13.0/128, out[0][0])
+	}
+	if last := out[len(out)-1]; math.Abs(last[0]) > 1e-6 || math.Abs(last[1]) > 1e-6 {
+		t.Errorf("Expected the DC to be removed, got %v", last)
+	}
+
+	opts.Codec = &codec.Config{ADCBits: 40}
+	if _, err := New(opts); err == nil {
+		t.Errorf("Expected an invalid codec to fail")
+	}
+}
+
+// The sine LFO steps once per sample, so only the codec filters
+// follow the clock
+func Test_ChipClock(t *testing.T) {
+	in := testInput()
+	opts := DefaultOptions()
+	reference := process(t, newChip(t, opts), in)
+	opts.ClockFrequency = 32768
+	if !equal(reference, process(t, newChip(t, opts), in)) {
+		t.Errorf("Expected the clock not to change the result without a codec")
+	}
+
+	opts.Codec = &codec.Config{BandLimit: 15000}
+	withCodec := process(t, newChip(t, opts), in)
+	opts.ClockFrequency = 44100
+	if equal(withCodec, process(t, newChip(t, opts), in)) {
+		t.Errorf("Expected the clock to change the codec filters")
+	}
+}
+
+// Any ACC written to the LFO registers is a valid program
+func Test_ChipLFORegisters(t *testing.T) {
+	for _, source := range []string{
+		"sof 0, -1.0\nwrax sin0_rate, 0\ncho rdal, sin0\nwrax dacl, 0",
+		"sof 0, -1.0\nwrax sin1_rate, 0\ncho rdal, sin1\nwrax dacl, 0",
+		"sof 0, -1.0\nwrax rmp0_rate, 0\ncho rdal, rmp0\nwrax dacl, 0",
+		"sof 0, -0.6\nwrax rmp1_rate, 0\ncho rdal, rmp1\nwrax dacl, 0",
+	} {
+		prog, err := asm.Assemble(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chip := newChip(t, DefaultOptions())
+		if err = chip.Load(prog.Words, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err = chip.Process(testInput(), make([][2]float64, 2000)); err != nil {
+			t.Errorf("%q: Unexpected error: %s", source, err)
+		}
+	}
+}
+
+func Test_ChipErrors(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ClockFrequency = 0
+	if _, err := New(opts); err == nil {
+		t.Errorf("Expected a zero clock frequency to fail")
+	}
+	opts = DefaultOptions()
+	opts.InstructionsPerSample = 0
+	if _, err := New(opts); err == nil {
+		t.Errorf("Expected zero instructions per sample to fail")
+	}
+
+	chip, _ := New(DefaultOptions())
+	if chip.Process(testInput(), make([][2]float64, 2000)) == nil {
+		t.Errorf("Expected processing without a program to fail")
+	}
+	if chip.Load(testWords(t), 1) == nil {
+		t.Errorf("Expected loading a missing program to fail")
+	}
+	nops := make([]uint32, 128)
+	for i := range nops {
+		nops[i] = 0x11
+	}
+	if chip.Load(nops, 0) == nil {
+		t.Errorf("Expected loading an empty program to fail")
+	}
+
+	chip.Load(testWords(t), 0)
+	if chip.Process(testInput(), make([][2]float64, 10)) == nil {
+		t.Errorf("Expected a short output buffer to fail")
+	}
+
+	// Decodable words with flags the LFO cannot use load, but fail
+	// when they are run
+	for _, word := range []uint32{
+		0x81400014, // CHO SOF, RMP0, COS
+		0xD0000014, // CHO RDAL, SIN0, RPTR2
+	} {
+		if err := chip.Load([]uint32{word}, 0); err != nil {
+			t.Errorf("0x%08X: Expected loading to work, got %s", word, err)
+		}
+		if chip.Process(testInput(), make([][2]float64, 2000)) == nil {
+			t.Errorf("0x%08X: Expected processing to fail", word)
+		}
+	}
+}
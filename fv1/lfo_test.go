@@ -0,0 +1,366 @@
+package fv1
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/faiface/beep"
+	"github.com/handegar/fv1emu/asm"
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/pcm"
+	"github.com/handegar/fv1emu/reader"
+	"github.com/handegar/fv1emu/writer"
+)
+
+// Rewrite the fixtures with 'go test ./fv1 -run Test_LFOFixtures -update'
+var updateFixtures = flag.Bool("update", false, "Write the LFO fixtures in testdata/")
+
+/**
+  The LFO fixtures are regression fixtures: the DACL/DACR output of
+  the LFO calibration programs at the 32768 Hz clock of the chip,
+  written by the emulator itself with '-update' and stored as 24-bit
+  WAV files, so any change to the LFO output shows up here. There are
+  no captures from a real FV-1 to compare with, so they do not show
+  that the emulator matches the hardware.
+
+  The sin-lfo-*.wav fixtures were written after checking the sine
+  rates and amplitudes against the datasheet (see Test_SineOscillator
+  in the dsp package), and the ramp-*.wav fixtures after checking the
+  ramp periods against f = rate * Fs / (16384 * range) (see
+  Test_RampOscillator). The crossfade and RPTR2 behaviour in
+  ramp-modulate.wav is the model of the emulator, not a measurement.
+  A digital capture from a real FV-1 can replace a fixture as long as
+  it has the same length, pot settings and input.
+*/
+
+const fixtureSamples = 16384
+
+type lfoFixture struct {
+	file    string // In testdata/
+	program string // In programs/calibrate/
+	pot0    float64
+	pot1    float64
+	input   float64 // A constant level on both inputs
+}
+
+var lfoFixtures = []lfoFixture{
+	{"sin-lfo-fast.wav", "sin-lfo.spn", 1.0, 1.0, 0.0},        // Rate 500, 19.9 Hz
+	{"sin-lfo-slow.wav", "sin-lfo.spn", 1.0, 0.0, 0.0},        // Rate 125, 4.97 Hz
+	{"ramp-lfo-wide.wav", "ramp-lfo.spn", 1.0, 1.0, 0.0},      // 4096 words, 15.6 Hz
+	{"ramp-lfo-narrow.wav", "ramp-lfo.spn", 0.0, 0.0, 0.0},    // 512 words, 31.3 Hz
+	{"ramp-modulate.wav", "ramp-modulate.spn", 0.5, 0.5, 0.5}, // 2048 words, 19.6 Hz
+}
+
+func renderFixture(t *testing.T, f lfoFixture) [][2]float64 {
+	in := make([][2]float64, fixtureSamples)
+	for i := range in {
+		in[i] = [2]float64{f.input, f.input}
+	}
+	return renderProgram(t, f.program, f.pot0, f.pot1, in)
+}
+
+// Run a program from programs/calibrate at 32768 Hz
+func renderProgram(t *testing.T, program string, pot0 float64, pot1 float64, in [][2]float64) [][2]float64 {
+	prog, err := asm.AssembleFile(filepath.Join("..", "programs", "calibrate", program))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.ClockFrequency = 32768
+	opts.Pot0 = pot0
+	opts.Pot1 = pot1
+	chip, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = chip.Load(prog.Words, 0); err != nil {
+		t.Fatal(err)
+	}
+	return process(t, chip, in)
+}
+
+func Test_LFOFixtures(t *testing.T) {
+	for _, f := range lfoFixtures {
+		out := renderFixture(t, f)
+		filename := filepath.Join("testdata", f.file)
+
+		if *updateFixtures {
+			format := beep.Format{SampleRate: 32768, NumChannels: 2, Precision: 3}
+			w, err := writer.CreateWAVAs(filename, format, pcm.S24LE)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Write(out); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		stream, err := reader.ReadAudio(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := make([][2]float64, fixtureSamples+1)
+		n, _ := stream.Stream(expected)
+		stream.Close()
+		if n != fixtureSamples {
+			t.Fatalf("%s: Expected %d samples, got %d", f.file, fixtureSamples, n)
+		}
+
+		for i := range out {
+			for ch := 0; ch < 2; ch++ {
+				want := int32(math.Round(expected[i][ch] * (1 << 23)))
+				got := int32(math.Round(out[i][ch] * (1 << 23)))
+				if want != got {
+					t.Fatalf("%s: Sample %d, channel %d: Expected 0x%x, got 0x%x",
+						f.file, i, ch, want, got)
+				}
+			}
+		}
+	}
+}
+
+// The period of the sine in the sin-lfo fixtures follows the
+// datasheet, f = rate * Fs / (2^17 * 2 * pi), ie. 2^18 * pi / rate
+// samples
+func Test_SineLFOPeriod(t *testing.T) {
+	type testCase struct {
+		pot1     float64
+		expected float64 // Samples
+	}
+	for _, c := range []testCase{
+		{1.0, 1647.1}, // Rate 500
+		{0.0, 6588.4}, // Rate 125
+	} {
+		out := renderFixture(t, lfoFixture{program: "sin-lfo.spn", pot0: 1.0, pot1: c.pot1})
+		first, last, crossings := 0, 0, 0
+		for i := 1; i < len(out); i++ {
+			if out[i-1][0] < 0 && out[i][0] >= 0 {
+				if crossings == 0 {
+					first = i
+				}
+				last = i
+				crossings++
+			}
+		}
+		if crossings < 2 {
+			t.Fatalf("POT1 %.1f: Expected at least two periods, got %d crossings", c.pot1, crossings)
+		}
+		period := float64(last-first) / float64(crossings-1)
+		if math.Abs(period-c.expected) > 2 {
+			t.Errorf("POT1 %.1f: Expected a period of %.1f samples, got %.1f", c.pot1, c.expected, period)
+		}
+	}
+}
+
+// SINx_RANGE scales the sine read by CHO RDAL. POT0 sets the range in
+// sin-lfo.spn, so the peak follows it.
+func Test_SineLFORange(t *testing.T) {
+	for _, pot0 := range []float64{0.1, 0.5, 1.0} {
+		out := renderFixture(t, lfoFixture{program: "sin-lfo.spn", pot0: pot0, pot1: 1.0})
+		peak := 0.0
+		for _, s := range out {
+			peak = math.Max(peak, math.Abs(s[0]))
+		}
+		if math.Abs(peak-pot0) > 0.01 {
+			t.Errorf("POT0 %.1f: Expected a peak of %.2f, got %.4f", pot0, pot0, peak)
+		}
+	}
+}
+
+// The positions where a ramp in channel 'ch' wraps back to its end
+func rampWraps(out [][2]float64, ch int) []int {
+	var wraps []int
+	for i := 1; i < len(out); i++ {
+		if out[i][ch] > out[i-1][ch]+0.01 {
+			wraps = append(wraps, i)
+		}
+	}
+	return wraps
+}
+
+// The ramp-lfo.spn and ramp-modulate.spn output against the numbers of
+// the datasheet and AN-0001: RMPx_RANGE sets a window of 512 to 4096
+// words which is read as 0 .. range / 8192, and the period is
+// 16384 * range / rate samples. The rate is POT1 * 0.7338 + 0.2446
+// with the coefficients rounded to S1.14 and S.10.
+func Test_RampLFONumbers(t *testing.T) {
+	type testCase struct {
+		program string
+		pot0    float64
+		pot1    float64
+		input   float64
+		peak    float64 // Just below the end of the window
+		period  float64 // Samples
+	}
+	for _, c := range []testCase{
+		{"ramp-lfo.spn", 0.0, 0.0, 0.0, 512.0 / 8192, 16384 * 512 / 8000.0},
+		{"ramp-lfo.spn", 0.3, 1.0, 0.0, 1024.0 / 8192, 16384 * 1024 / 32044.0},
+		{"ramp-lfo.spn", 0.6, 1.0, 0.0, 2048.0 / 8192, 16384 * 2048 / 32044.0},
+		{"ramp-lfo.spn", 1.0, 1.0, 0.0, 4096.0 / 8192, 16384 * 4096 / 32044.0},
+		{"ramp-modulate.spn", 0.5, 0.5, 0.5, 0.5 * 2048 / 8192, 16384 * 2048 / 20022.0},
+	} {
+		name := fmt.Sprintf("%s, POT0 %.1f, POT1 %.1f", c.program, c.pot0, c.pot1)
+		out := renderFixture(t, lfoFixture{program: c.program, pot0: c.pot0, pot1: c.pot1, input: c.input})
+
+		peak := 0.0
+		for _, s := range out {
+			peak = math.Max(peak, s[0])
+		}
+		if peak >= c.peak || peak < c.peak-3.0/8192 {
+			t.Errorf("%s: Expected a peak just below %f, got %f", name, c.peak, peak)
+		}
+
+		wraps := rampWraps(out, 0)
+		if len(wraps) < 3 {
+			t.Fatalf("%s: Expected the ramp to wrap at least 3 times, got %d", name, len(wraps))
+		}
+		period := float64(wraps[len(wraps)-1]-wraps[0]) / float64(len(wraps)-1)
+		if math.Abs(period-c.period) > 1.0 {
+			t.Errorf("%s: Expected a period of %.1f samples, got %.1f", name, c.period, period)
+		}
+	}
+}
+
+// RPTR2 reads half a window ahead, and the NA crossfade is 0 for the
+// first and last 1/8 of the ramp, 1 for the middle 1/4 and linear in
+// between. With a rate of 16384 the 4096 word ramp is at 4096 - n
+// words after 'n' samples.
+func Test_RampLFOPointers(t *testing.T) {
+	prog, err := asm.Assemble(`
+		skp	run, start
+		wldr	rmp0, 16384, 4096
+start:	cho	rdal, rmp0, reg|na
+		wrax	dacl, 0
+		cho	rdal, rmp0, rptr2|na
+		wrax	dacr, 0
+		cho	rdal, rmp0, reg
+		wrax	reg0, 0
+		cho	rdal, rmp0, rptr2
+		wrax	reg1, 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chip := newChip(t, DefaultOptions())
+	if err = chip.Load(prog.Words, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	out := make([][2]float64, 8192)
+	pointers := make([][2]float64, len(out))
+	for i := range out {
+		if err = chip.Process(out[i:i+1], out[i:i+1]); err != nil {
+			t.Fatal(err)
+		}
+		pointers[i] = [2]float64{chip.state.GetRegister(base.REG0).ToFloat64(),
+			chip.state.GetRegister(base.REG0 + 1).ToFloat64()}
+	}
+
+	for i, p := range pointers {
+		if d := math.Mod(p[1]-p[0]+0.5, 0.5); d != 0.25 {
+			t.Fatalf("Sample %d: Expected RPTR2 0.25 ahead of %f, got %f", i, p[0], p[1])
+		}
+		if sum := out[i][0] + out[i][1]; math.Abs(sum-1.0) > 1e-6 {
+			t.Fatalf("Sample %d: Expected the crossfades to add up to 1.0, got %f", i, sum)
+		}
+	}
+
+	type testCase struct {
+		sample   int
+		expected float64
+	}
+	for _, c := range []testCase{
+		{0, 0.0},    // 0 words
+		{3584, 0.0}, // 512 words, 1/8
+		{3072, 0.5}, // 1024 words, 1/4
+		{2560, 1.0}, // 1536 words, 3/8
+		{2048, 1.0}, // 2048 words, 1/2
+		{1536, 1.0}, // 2560 words, 5/8
+		{1024, 0.5}, // 3072 words, 3/4
+		{512, 0.0},  // 3584 words, 7/8
+	} {
+		if math.Abs(out[c.sample][0]-c.expected) > 1e-6 {
+			t.Errorf("Sample %d: Expected a crossfade of %f, got %f", c.sample, c.expected, out[c.sample][0])
+		}
+	}
+}
+
+/**
+  CHO RDA interpolation. sin-modulate.spn and ramp-modulate.spn read
+  the LFOs with CHO RDAL, which gives the LFO offset of each sample.
+  sin-interpolate.spn and ramp-interpolate.spn have the same LFO setup
+  but read a delay line with CHO RDA pairs. With a linear ramp as the
+  input, the output tells the delay of the read, which must follow
+  the LFO offset to a fraction of a word. A stepped read is off by up
+  to a whole word.
+*/
+
+const interpolationSlope = 1.0 / 32768 // Input change per sample
+
+// The delay in words read by 'out' for the linear input
+func interpolatedDelays(t *testing.T, program string, pot0 float64, pot1 float64) []float64 {
+	in := make([][2]float64, fixtureSamples)
+	for i := range in {
+		in[i] = [2]float64{float64(i) * interpolationSlope, float64(i) * interpolationSlope}
+	}
+	out := renderProgram(t, program, pot0, pot1, in)
+	delays := make([]float64, len(out))
+	for n := range out {
+		delays[n] = float64(n) - out[n][0]/interpolationSlope
+	}
+	return delays
+}
+
+// The LFO offset in words of each sample, as read by CHO RDAL
+func lfoOffsets(t *testing.T, program string, pot0 float64, pot1 float64) []float64 {
+	in := make([][2]float64, fixtureSamples)
+	for i := range in {
+		in[i] = [2]float64{0.5, 0.5}
+	}
+	out := renderProgram(t, program, pot0, pot1, in)
+	offsets := make([]float64, len(out))
+	for n := range out {
+		offsets[n] = out[n][0] / 0.5 * 8192
+	}
+	return offsets
+}
+
+// The largest difference between 'delays' and 'expected' plus 'bias',
+// after the delay line has been filled
+func maxDelayError(delays []float64, expected []float64, bias float64) float64 {
+	maxErr := 0.0
+	for n := 4096; n < len(delays); n++ {
+		maxErr = math.Max(maxErr, math.Abs(delays[n]-expected[n]-bias))
+	}
+	return maxErr
+}
+
+func Test_CHORDAInterpolation(t *testing.T) {
+	// The chorus reads around the middle of its 1024 words
+	offsets := lfoOffsets(t, "sin-modulate.spn", 0.03, 0.0)
+	delays := interpolatedDelays(t, "sin-interpolate.spn", 0.03, 0.0)
+	if maxErr := maxDelayError(delays, offsets, 512); maxErr > 0.05 {
+		t.Errorf("sin-interpolate.spn: The delay is up to %f words from the LFO", maxErr)
+	}
+
+	// The two taps of the pitch shifter are crossfaded, so the delay is
+	// between the taps
+	offsets = lfoOffsets(t, "ramp-modulate.spn", 0.6, 0.0)
+	delays = interpolatedDelays(t, "ramp-interpolate.spn", 0.6, 0.0)
+	const window = 2048.0
+	expected := make([]float64, len(offsets))
+	for n, tap := range offsets {
+		pos := tap / window
+		xfade := math.Max(0, math.Min(1, 2*(1-math.Abs(2*pos-1))-0.5))
+		expected[n] = xfade*tap + (1-xfade)*math.Mod(tap+window/2, window)
+	}
+	if maxErr := maxDelayError(delays, expected, 0); maxErr > 0.05 {
+		t.Errorf("ramp-interpolate.spn: The delay is up to %f words from the LFO", maxErr)
+	}
+}
@@ -0,0 +1,125 @@
+package fv1
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/faiface/beep"
+)
+
+/**
+  A beep.Streamer which pulls samples from another streamer through
+  a Chip, one sample per program run. Nothing is processed before
+  Stream() is called, so it can be used in beep mixers, sequences,
+  resamplers and effect chains, or played by the speaker package:
+
+  	chip, err := fv1.New(fv1.DefaultOptions())
+  	...
+  	err = chip.Load(words, 0)
+  	...
+  	fx := fv1.NewFV1Streamer(chip, input)
+  	fx.SetTrail(format.SampleRate.N(2 * time.Second))
+  	speaker.Play(beep.Seq(fx, beep.Callback(done)))
+
+  The chip runs at the sample rate of the input. Use beep.Resample()
+  on the input to run it at another rate.
+*/
+
+type FV1Streamer struct {
+	chip  *Chip
+	input beep.Streamer
+	trail int // Samples of silence left to process after the input ends
+	err   error
+
+	// Pot values set by SetPot() since the last Stream(). Stream()
+	// usually runs in the speaker's goroutine, so these are guarded.
+	potMutex   sync.Mutex
+	pots       [3]float64
+	potChanged [3]bool
+}
+
+// The chip must have a program loaded. The streamer owns the chip
+// until it is drained, so the chip must not be used elsewhere.
+func NewFV1Streamer(chip *Chip, input beep.Streamer) *FV1Streamer {
+	return &FV1Streamer{chip: chip, input: input}
+}
+
+// Keep running the program on silence for 'numSamples' samples after
+// the input has ended, so echos and reverb tails are not cut off
+func (s *FV1Streamer) SetTrail(numSamples int) {
+	s.trail = max(numSamples, 0)
+}
+
+// Set POT0, POT1 or POT2 to a value between 0 and 1.0. Takes effect
+// from the first sample of the next Stream() call. Can be called from
+// any goroutine.
+func (s *FV1Streamer) SetPot(pot int, value float64) error {
+	if pot < 0 || pot > 2 {
+		return fmt.Errorf("Invalid pot number %d", pot)
+	}
+	if err := checkPotValue(pot, value); err != nil {
+		return err
+	}
+
+	s.potMutex.Lock()
+	defer s.potMutex.Unlock()
+	s.pots[pot] = value
+	s.potChanged[pot] = true
+	return nil
+}
+
+func (s *FV1Streamer) applyPots() {
+	s.potMutex.Lock()
+	defer s.potMutex.Unlock()
+	for pot, changed := range s.potChanged {
+		if changed {
+			s.chip.SetPot(pot, s.pots[pot])
+			s.potChanged[pot] = false
+		}
+	}
+}
+
+// Fill 'samples' with processed audio. Implements beep.Streamer.
+func (s *FV1Streamer) Stream(samples [][2]float64) (int, bool) {
+	if s.err != nil {
+		return 0, false
+	}
+	s.applyPots()
+
+	// Fill the whole buffer unless the input is drained, since beep
+	// takes a short read as the end of the stream
+	n := 0
+	for s.input != nil && n < len(samples) {
+		read, more := s.input.Stream(samples[n:])
+		n += read
+		if !more {
+			if s.err = s.input.Err(); s.err != nil {
+				return 0, false
+			}
+			s.input = nil
+		} else if read == 0 {
+			break
+		}
+	}
+
+	if s.input == nil && n < len(samples) && s.trail > 0 {
+		silence := min(s.trail, len(samples)-n)
+		clear(samples[n : n+silence])
+		s.trail -= silence
+		n += silence
+	}
+
+	if n == 0 {
+		return 0, s.input != nil
+	}
+	if s.err = s.chip.Process(samples[:n], samples[:n]); s.err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// The error from the input streamer or from running the program, if
+// any. Implements beep.Streamer.
+func (s *FV1Streamer) Err() error {
+	return s.err
+}
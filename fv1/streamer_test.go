@@ -0,0 +1,159 @@
+package fv1
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/faiface/beep"
+)
+
+// A streamer over a slice which hands out at most 'chunk' samples
+// per call. Short reads before the end are not allowed by beep, but
+// some decoders do it anyway.
+type sliceStreamer struct {
+	samples [][2]float64
+	chunk   int
+	err     error
+}
+
+func (s *sliceStreamer) Stream(samples [][2]float64) (int, bool) {
+	if len(s.samples) == 0 {
+		return 0, false
+	}
+	n := copy(samples[:min(len(samples), s.chunk)], s.samples)
+	s.samples = s.samples[n:]
+	return n, true
+}
+
+func (s *sliceStreamer) Err() error {
+	return s.err
+}
+
+// Read everything from 'streamer', 'bufSize' samples at a time, and
+// call 'between' before each call to Stream()
+func drain(streamer beep.Streamer, bufSize int, between func(pos int)) [][2]float64 {
+	var out [][2]float64
+	buf := make([][2]float64, bufSize)
+	for {
+		if between != nil {
+			between(len(out))
+		}
+		n, ok := streamer.Stream(buf)
+		if !ok {
+			return out
+		}
+		out = append(out, buf[:n]...)
+	}
+}
+
+func Test_StreamerMatchesProcess(t *testing.T) {
+	in := testInput()
+	expected := process(t, newChip(t, DefaultOptions()), in)
+
+	for _, chunk := range []int{1, 77, 512, 5000} {
+		for _, bufSize := range []int{1, 100, 4096} {
+			fx := NewFV1Streamer(newChip(t, DefaultOptions()), &sliceStreamer{samples: in, chunk: chunk})
+			out := drain(fx, bufSize, nil)
+			if fx.Err() != nil {
+				t.Fatal(fx.Err())
+			}
+			if !equal(expected, out) {
+				t.Errorf("Chunk %d, buffer %d: Expected the same result as Process()", chunk, bufSize)
+			}
+		}
+	}
+}
+
+func Test_StreamerTrail(t *testing.T) {
+	in := testInput()
+	padded := append(append([][2]float64{}, in...), make([][2]float64, 1234)...)
+	expected := process(t, newChip(t, DefaultOptions()), padded)
+
+	fx := NewFV1Streamer(newChip(t, DefaultOptions()), &sliceStreamer{samples: in, chunk: 300})
+	fx.SetTrail(1234)
+	if out := drain(fx, 512, nil); !equal(expected, out) {
+		t.Errorf("Expected %d samples with a trail of silence, got %d", len(expected), len(out))
+	}
+}
+
+func Test_StreamerSetPot(t *testing.T) {
+	in := testInput()
+	const changeAt = 1024
+
+	chip := newChip(t, DefaultOptions())
+	expected := process(t, chip, in[:changeAt])
+	chip.SetPot(0, 0.9)
+	chip.SetPot(1, 0.1)
+	expected = append(expected, process(t, chip, in[changeAt:])...)
+
+	fx := NewFV1Streamer(newChip(t, DefaultOptions()), &sliceStreamer{samples: in, chunk: len(in)})
+	out := drain(fx, 256, func(pos int) {
+		if pos == changeAt {
+			fx.SetPot(0, 0.9)
+			fx.SetPot(1, 0.1)
+		}
+	})
+	if !equal(expected, out) {
+		t.Errorf("Expected the pots to change between two Stream() calls")
+	}
+
+	if fx.SetPot(3, 0.5) == nil || fx.SetPot(2, -0.1) == nil {
+		t.Errorf("Expected invalid pots to fail")
+	}
+}
+
+func Test_StreamerComposition(t *testing.T) {
+	in := testInput()
+	expected := process(t, newChip(t, DefaultOptions()), in[:1500])
+
+	// Limited by beep.Take(), followed by silence in a beep.Seq()
+	fx := NewFV1Streamer(newChip(t, DefaultOptions()), &sliceStreamer{samples: in, chunk: 100})
+	out := drain(beep.Seq(beep.Take(1500, fx), beep.Silence(500)), 512, nil)
+	if len(out) != 2000 || !equal(expected, out[:1500]) {
+		t.Errorf("Expected the processed input followed by silence, got %d samples", len(out))
+	}
+
+	// Mixed with itself at half volume
+	a := NewFV1Streamer(newChip(t, DefaultOptions()), &sliceStreamer{samples: in, chunk: 100})
+	b := NewFV1Streamer(newChip(t, DefaultOptions()), &sliceStreamer{samples: in, chunk: 333})
+	mixer := beep.Mix(&gainStreamer{a, 0.5}, &gainStreamer{b, 0.5})
+	full := process(t, newChip(t, DefaultOptions()), in)
+	mixed := drain(beep.Take(len(in), mixer), 512, nil)
+	for i := range full {
+		if mixed[i] != [2]float64{full[i][0]*0.5 + full[i][0]*0.5, full[i][1]*0.5 + full[i][1]*0.5} {
+			t.Fatalf("Sample %d: Unexpected mix %v of %v", i, mixed[i], full[i])
+		}
+	}
+}
+
+type gainStreamer struct {
+	beep.Streamer
+	gain float64
+}
+
+func (g *gainStreamer) Stream(samples [][2]float64) (int, bool) {
+	n, ok := g.Streamer.Stream(samples)
+	for i := range samples[:n] {
+		samples[i][0] *= g.gain
+		samples[i][1] *= g.gain
+	}
+	return n, ok
+}
+
+func Test_StreamerErrors(t *testing.T) {
+	failure := errors.New("Broken input")
+	fx := NewFV1Streamer(newChip(t, DefaultOptions()), &sliceStreamer{err: failure})
+	fx.SetTrail(100)
+	if n, ok := fx.Stream(make([][2]float64, 10)); n != 0 || ok {
+		t.Errorf("Expected a failing input to stop the stream")
+	}
+	if fx.Err() != failure {
+		t.Errorf("Expected the input's error, got %v", fx.Err())
+	}
+
+	chip, _ := New(DefaultOptions())
+	fx = NewFV1Streamer(chip, &sliceStreamer{samples: testInput(), chunk: 100})
+	if _, ok := fx.Stream(make([][2]float64, 10)); ok || fx.Err() == nil {
+		t.Errorf("Expected streaming without a program to fail")
+	}
+}
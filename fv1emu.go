@@ -7,23 +7,35 @@ import (
 	"log"
 	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/pprof"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/faiface/beep"
 	"github.com/fatih/color"
 	ui "github.com/gizak/termui/v3"
 
+	"github.com/handegar/fv1emu/asm"
 	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/clock"
+	"github.com/handegar/fv1emu/codec"
 	"github.com/handegar/fv1emu/debugger"
 	"github.com/handegar/fv1emu/disasm"
 	"github.com/handegar/fv1emu/dsp"
+	"github.com/handegar/fv1emu/pcm"
 	"github.com/handegar/fv1emu/reader"
+	"github.com/handegar/fv1emu/resample"
 	"github.com/handegar/fv1emu/settings"
 	"github.com/handegar/fv1emu/writer"
 )
 
+// Samples processed between the updates of the DSP rate when the
+// clock follows a script. About 1ms at 32768 Hz.
+const clockBlockSize = 32
+
 type ChannelStatistics struct {
 	Max             float64
 	Min             float64
@@ -41,13 +53,17 @@ type WavStatistics struct {
 
 func parseCommandLineParameters() bool {
 	flag.StringVar(&settings.InFilename, "bin",
-		settings.InFilename, "FV-1 binary file")
+		settings.InFilename, "FV-1 binary file (or SpinASM source file)")
 	flag.StringVar(&settings.InFilename, "hex",
 		settings.InFilename, "SpinCAD/Intel HEX file (alias for \"-bin\")")
 	flag.StringVar(&settings.InputWav, "in",
-		settings.InputWav, "Input wav-file")
+		settings.InputWav, "Input audio file (WAV, FLAC or AIFF)")
 	flag.StringVar(&settings.OutputWav, "out",
-		settings.OutputWav, "Output wav-file")
+		settings.OutputWav, "Output audio file (WAV, FLAC or AIFF by extension)")
+	flag.StringVar(&settings.OutputFormat, "out-format",
+		settings.OutputFormat, "Output sample format: s16, s24, s32 or f32 (default is the input format)")
+	flag.BoolVar(&settings.Dither, "dither",
+		settings.Dither, "Add TPDF dither to integer output formats")
 	flag.BoolVar(&settings.Stream, "stream",
 		settings.Stream, "Stream output to sound device")
 
@@ -70,7 +86,13 @@ func parseCommandLineParameters() bool {
 		"Potensiometer 2 value (0 .. 1.0)")
 
 	flag.Float64Var(&settings.ClockFrequency, "clock", settings.ClockFrequency,
-		"Chrystal frequency")
+		"Chrystal frequency, the DSP rate with -accurate-clock")
+
+	flag.BoolVar(&settings.AccurateClock, "accurate-clock", settings.AccurateClock,
+		"Run the DSP at the clock frequency (32768 Hz unless -clock is given) and resample the audio to and from it")
+
+	flag.StringVar(&settings.ClockScript, "clock-script", settings.ClockScript,
+		"Script with a clock curve over time (implies -accurate-clock)")
 
 	flag.Float64Var(&settings.TrailSeconds, "trail", settings.TrailSeconds,
 		"Additional trail length (seconds)")
@@ -81,6 +103,20 @@ func parseCommandLineParameters() bool {
 	flag.Float64Var(&settings.PostGain, "postgain", settings.PostGain,
 		"Gain for output audio")
 
+	flag.BoolVar(&settings.FloatDelayRAM, "float-delay-ram", settings.FloatDelayRAM,
+		"Store the delay memory in the 14-bit floating point format of the FV-1 instead of 24 bits")
+
+	flag.BoolVar(&settings.Codec, "codec", settings.Codec,
+		"Model the ADC and DAC of the FV-1 (implied by the -codec-* options)")
+	flag.Float64Var(&settings.CodecBandLimit, "codec-band-limit", settings.CodecBandLimit,
+		"Cutoff of the input band-limit low-pass before the ADC (Hz, 0 is off)")
+	flag.IntVar(&settings.CodecADCBits, "codec-adc-bits", settings.CodecADCBits,
+		"ADC resolution (bits, 0 is off)")
+	flag.Float64Var(&settings.CodecReconstruction, "codec-reconstruction", settings.CodecReconstruction,
+		"Cutoff of the DAC reconstruction low-pass (Hz, 0 is off)")
+	flag.Float64Var(&settings.CodecDCBlock, "codec-dc-block", settings.CodecDCBlock,
+		"Cutoff of the DAC DC-blocking high-pass (Hz, 0 is off)")
+
 	flag.BoolVar(&settings.Debugger, "debug",
 		settings.Debugger,
 		"Enable step-debugger user-interface")
@@ -108,6 +144,9 @@ func parseCommandLineParameters() bool {
 	flag.BoolVar(&settings.PrintCode, "print-code",
 		settings.PrintCode, "Print program code")
 
+	flag.BoolVar(&settings.InferMemoryLayout, "infer-memory",
+		settings.InferMemoryLayout, "Guess the delay memory layout of BIN/HEX programs and name the addresses")
+
 	flag.BoolVar(&settings.PrintDebug, "print-debug",
 		settings.PrintDebug, "Print additional info when debugging")
 
@@ -117,6 +156,10 @@ func parseCommandLineParameters() bool {
 	flag.BoolVar(&settings.ShowCodeIntsAsHex, "show-code-ints-as-hex",
 		settings.ShowCodeIntsAsHex, "Display integers in the code as HEX instead of BINARY")
 
+	flag.StringVar(&settings.OutputProgram, "write-program",
+		settings.OutputProgram,
+		"Write the program(s) to a BIN or HEX file, or the program to a SpinASM file (by extension), and exit")
+
 	flag.BoolVar(&settings.MuteLeftOutput, "mute-left-output",
 		settings.MuteLeftOutput, "Don't write the left channel to disk")
 
@@ -130,7 +173,7 @@ func parseCommandLineParameters() bool {
 	}
 
 	if settings.InFilename == "" {
-		fmt.Println("  No bin/hex file specified. Use the '-bin/-hex' parameter.")
+		fmt.Println("  No bin/hex/spn file specified. Use the '-bin/-hex' parameter.")
 		return false
 	}
 
@@ -144,6 +187,31 @@ func parseCommandLineParameters() bool {
 		return false
 	}
 
+	if settings.OutputFormat != "" {
+		if _, err := pcm.ParseFormat(settings.OutputFormat); err != nil {
+			fmt.Printf("  %s\n", err)
+			return false
+		}
+	}
+
+	clockGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		clockGiven = clockGiven || f.Name == "clock"
+		if strings.HasPrefix(f.Name, "codec-") {
+			settings.Codec = true
+		}
+	})
+	if settings.ClockScript != "" {
+		settings.AccurateClock = true
+	}
+	if settings.AccurateClock && !clockGiven {
+		settings.ClockFrequency = settings.ChipClockFrequency
+	}
+	if settings.ClockFrequency <= 0 {
+		fmt.Println("  The clock frequency must be positive.")
+		return false
+	}
+
 	if settings.ProgramNumber < 0 || settings.ProgramNumber > 7 {
 		fmt.Println("  Program number must be between 0 and 7.")
 		return false
@@ -223,26 +291,102 @@ func printWavStatistics(statistics *WavStatistics) {
 	}
 }
 
+// Read a BIN, HEX or SpinASM file. The symbol table is only available
+// for SpinASM files.
+func loadProgramFile(filename string) ([]uint32, *base.SymbolTable, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".bin":
+		buf, err := reader.ReadBin(filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Reading BIN file failed: %s", err)
+		}
+		return buf, nil, nil
+	case ".hex":
+		buf, err := reader.ReadHex(filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Reading HEX file failed: %s", err)
+		}
+		return buf, nil, nil
+	case ".spn":
+		prog, err := asm.AssembleFile(filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Assembling SPN file failed:\n%s", err)
+		}
+		for _, w := range prog.Warnings {
+			fmt.Println(w)
+		}
+		return prog.Words, prog.Symbols, nil
+	}
+	return nil, nil, fmt.Errorf("Unknown program file type '%s', expected .bin, .hex or .spn", filename)
+}
+
+// Write the selected program ('-prog') as SpinASM source
+func saveAsSpinASM(filename string, buf []uint32, symbols *base.SymbolTable) error {
+	start := settings.ProgramNumber * settings.InstructionsPerSample
+	if len(buf) <= start {
+		return fmt.Errorf("Number of program(s) in BIN/HEX is only %d",
+			len(buf)/settings.InstructionsPerSample)
+	}
+
+	if symbols == nil && settings.InferMemoryLayout {
+		var ops []base.Op
+		for _, w := range buf[start:min(len(buf), start+settings.InstructionsPerSample)] {
+			ops = append(ops, dsp.DecodeOp(w))
+		}
+		_, symbols, _ = disasm.InferMemoryLayout(ops)
+	}
+
+	source, err := disasm.ToSpinASM(buf[start:], symbols)
+	if err != nil {
+		color.Red("* WARNING: %s", err)
+	}
+
+	fmt.Printf("* Writing SpinASM source to '%s'\n", filename)
+	return os.WriteFile(filename, []byte(source), 0644)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "pipe" {
+		if !runPipeCommand(os.Args[2:]) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Printf("* FV-1 emulator v%s\n", settings.Version)
+	if len(os.Args) > 1 && os.Args[1] == "bank" {
+		if !runBankCommand(os.Args[2:]) {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "transpile" {
+		if !runTranspileCommand(os.Args[2:]) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if !parseCommandLineParameters() {
 		return
 	}
 
-	var buf []uint32
-	var err error
-	if strings.HasSuffix(settings.InFilename, ".bin") {
-		buf, err = reader.ReadBin(settings.InFilename)
-		if err != nil {
-			fmt.Printf("Reading BIN file failed: %s\n", err)
-			return
+	buf, symbols, err := loadProgramFile(settings.InFilename)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return
+	}
+
+	if settings.OutputProgram != "" {
+		if strings.EqualFold(filepath.Ext(settings.OutputProgram), ".spn") {
+			err = saveAsSpinASM(settings.OutputProgram, buf, symbols)
+		} else {
+			err = writer.SaveProgram(settings.OutputProgram, buf)
 		}
-	} else if strings.HasSuffix(settings.InFilename, ".hex") {
-		buf, err = reader.ReadHex(settings.InFilename)
 		if err != nil {
-			fmt.Printf("Reading HEX file failed: %s\n", err)
-			return
+			fmt.Printf("Writing program failed: %s\n", err)
 		}
+		return
 	}
 
 	if len(buf) <= settings.ProgramNumber*settings.InstructionsPerSample {
@@ -251,7 +395,8 @@ func main() {
 		return
 	}
 
-	var opCodes = dsp.DecodeOpCodes(buf[settings.ProgramNumber*settings.InstructionsPerSample:])
+	var opCodes = dsp.DecodeOpCodes(buf[settings.ProgramNumber*settings.InstructionsPerSample:],
+		dsp.ConfigFromSettings())
 	_ = opCodes
 
 	if len(opCodes) == 0 {
@@ -259,13 +404,26 @@ func main() {
 		return
 	}
 
+	var delayLines []disasm.DelayLine
+	numRMPA := 0
+	if symbols == nil && settings.InferMemoryLayout {
+		delayLines, symbols, numRMPA = disasm.InferMemoryLayout(opCodes)
+	}
+
 	if settings.PrintCode {
-		disasm.PrintCodeListing(opCodes)
+		disasm.PrintCodeListing(opCodes, symbols)
+		disasm.PrintMemoryLayout(delayLines, numRMPA)
 	}
 
 	printPotensiometersInUse(opCodes)
 	printDACsAndADCsInUse(opCodes)
 
+	prog, err := dsp.Compile(opCodes)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return
+	}
+
 	var regCSVWriter *csv.Writer = nil
 	if settings.WriteRegisterToCSV >= 0 && !settings.Debugger {
 		filename := fmt.Sprintf("./reg-%d.csv", settings.WriteRegisterToCSV)
@@ -285,16 +443,76 @@ func main() {
 			settings.WriteRegisterToCSV)})
 	}
 
-	f, stream, wavFormat, err := reader.ReadWAV(settings.InputWav)
-	defer f.Close()
+	stream, err := reader.ReadAudio(settings.InputWav)
+	if err != nil {
+		fmt.Printf("Reading '%s' failed: %s\n", settings.InputWav, err)
+		return
+	}
+	defer stream.Close()
+	wavFormat := stream.Format()
 
 	isStereo := wavFormat.NumChannels == 2
 	settings.SampleRate = float64(wavFormat.SampleRate)
 
-	fmt.Printf("* Reading '%s': %d channels, %dHz, %dbit\n",
-		settings.InputWav, wavFormat.NumChannels, wavFormat.SampleRate, wavFormat.Precision)
+	sampleType := ""
+	if stream.IsFloat() {
+		sampleType = " float"
+	}
+	fmt.Printf("* Reading '%s': %d channels, %dHz, %dbit%s\n",
+		settings.InputWav, wavFormat.NumChannels, wavFormat.SampleRate, stream.BitsPerSample(), sampleType)
 	fmt.Printf("* Chrystal frequency: %.2f Hz\n", settings.ClockFrequency)
 
+	// The DSP runs at the input rate, or at the clock frequency with
+	// the audio resampled at the ADC and DAC
+	var input beep.Streamer = stream
+	var inResampler, outResampler *resample.Resampler
+	var clockCurve *clock.Curve
+	dspSampleRate := settings.SampleRate
+	if settings.AccurateClock {
+		dspSampleRate = settings.ClockFrequency
+		if settings.ClockScript != "" {
+			clockCurve, err = clock.ReadScript(settings.ClockScript, settings.ClockFrequency)
+			if err != nil {
+				fmt.Printf("Error reading clock script '%s': %s\n", settings.ClockScript, err)
+				return
+			}
+			dspSampleRate = clockCurve.At(0)
+			low, high := clockCurve.Range()
+			fmt.Printf("* Clock follows '%s': %.2f Hz .. %.2f Hz\n", settings.ClockScript, low, high)
+		}
+		resampler := resample.NewStreamer(stream, settings.SampleRate, dspSampleRate)
+		input = resampler
+		inResampler = resampler.Resampler()
+		outResampler = resample.NewResampler(dspSampleRate, settings.SampleRate)
+		fmt.Printf("* Resampling %d Hz -> %.2f Hz -> %d Hz\n",
+			wavFormat.SampleRate, dspSampleRate, wavFormat.SampleRate)
+	}
+
+	var codecModel *codec.Codec
+	if settings.Codec {
+		codecModel, err = codec.New(codec.Config{
+			BandLimit:      settings.CodecBandLimit,
+			ADCBits:        settings.CodecADCBits,
+			Reconstruction: settings.CodecReconstruction,
+			DCBlock:        settings.CodecDCBlock,
+		}, dspSampleRate)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		fmt.Printf("* Codec: %.0f Hz input band-limit, %d bit ADC, %.0f Hz reconstruction, %.0f Hz DC-blocking\n",
+			settings.CodecBandLimit, settings.CodecADCBits, settings.CodecReconstruction, settings.CodecDCBlock)
+	}
+
+	// The DSP rate follows the clock curve, updated for every
+	// 'clockBlockSize' samples. 'clockTime' is the time of the audio
+	// processed so far (seconds).
+	clockTime := 0.0
+	blockSize := 1024
+	if clockCurve != nil {
+		blockSize = clockBlockSize
+	}
+
 	var statistics WavStatistics
 	statistics.Left.Silent = true
 	statistics.Right.Silent = true
@@ -311,8 +529,9 @@ func main() {
 			log.Fatalf("failed to initialize termui: %v", err)
 		}
 		debugger.Reset()
+		debugger.SetSymbolTable(symbols)
 		if settings.SkipToSample > 0 {
-			dsp.SkipNumSamples(settings.SkipToSample)
+			state.SkipNumSamples(settings.SkipToSample)
 		}
 	}
 
@@ -337,23 +556,103 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	var outSamples [][2]float64
-	for {
-		var samples [][2]float64 = make([][2]float64, 1024)
-		_, err := stream.Stream(samples)
-		if !err {
+	// The result is written to disk while processing, so long renders
+	// don't have to fit in memory
+	var wavWriter writer.AudioWriter
+	if !settings.Debugger && !settings.Stream {
+		wavWriter, err = createOutputWAV(settings.OutputWav, wavFormat, stream.IsFloat())
+		if err != nil {
+			fmt.Printf("Error creating output file: %s\n", err)
+			return
+		}
+		defer wavWriter.Close()
+	}
+
+	// Stop on CTRL-C and keep what has been processed so far. The
+	// debugger handles the keyboard itself.
+	interrupt := make(chan os.Signal, 1)
+	if !settings.Debugger {
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	}
+	interrupted := false
+	numOutSamples := 0
+	checkForInterrupt := func() bool {
+		select {
+		case <-interrupt:
+			color.Yellow("* Interrupted. Stopping after %d samples.", numOutSamples)
+			interrupted = true
+		default:
+		}
+		return interrupted
+	}
+
+	// Processed samples at the DSP rate and the samples to write at
+	// the rate of the file
+	outSamples := make([][2]float64, 0, 1024)
+	fileSamples := make([][2]float64, 0, 1024)
+	resampleBuf := make([][2]float64, 256)
+	convertOutSamples := func() {
+		if outResampler == nil {
+			fileSamples = append(fileSamples, outSamples...)
+		} else {
+			outResampler.Write(outSamples)
+			for n := outResampler.Read(resampleBuf); n > 0; n = outResampler.Read(resampleBuf) {
+				fileSamples = append(fileSamples, resampleBuf[:n]...)
+			}
+		}
+		outSamples = outSamples[:0]
+	}
+	writeOutSamples := func() bool {
+		convertOutSamples()
+		numOutSamples += len(fileSamples)
+		if wavWriter != nil {
+			err := wavWriter.Write(fileSamples)
+			if err == nil {
+				err = wavWriter.Flush()
+			}
+			if err != nil {
+				fmt.Printf("Error writing samples: %s\n", err)
+				return false
+			}
+		}
+		fileSamples = fileSamples[:0]
+		return true
+	}
+	isOutBufferFull := func() bool {
+		return len(outSamples)+len(fileSamples) >= cap(outSamples)
+	}
+	updateClock := func() {
+		if clockCurve == nil {
+			return
+		}
+		// The DAC changes its rate after the samples processed so far
+		convertOutSamples()
+		dspSampleRate = clockCurve.At(clockTime)
+		inResampler.SetRates(settings.SampleRate, dspSampleRate)
+		outResampler.ChangeRates(dspSampleRate, settings.SampleRate)
+		if codecModel != nil {
+			codecModel.SetSampleRate(dspSampleRate)
+		}
+	}
+
+	for !checkForInterrupt() {
+		updateClock()
+		var samples [][2]float64 = make([][2]float64, blockSize)
+		n, ok := input.Stream(samples)
+		if !ok {
 			break
 		}
+		clockTime += float64(n) / dspSampleRate
 
 		letsContinue := true
-		for _, sample := range samples {
+		for _, sample := range samples[:n] {
 			var left float64 = sample[0] * settings.PreGain
 			var right float64 = sample[1] * settings.PreGain
 			if !isStereo {
 				right = sample[0]
 			}
 
-			outLeft, outRight, cont := processSample(left, right, state, opCodes, sampleNum)
+			outLeft, outRight, cont := processSample(left, right, state, prog, codecModel, sampleNum)
 
 			outLeft = outLeft * settings.PostGain
 			outRight = outRight * settings.PostGain
@@ -381,10 +680,22 @@ func main() {
 			}
 		}
 
+		if isOutBufferFull() || !letsContinue {
+			if !writeOutSamples() {
+				return
+			}
+		}
 		if !letsContinue {
 			break
 		}
 	}
+	if !writeOutSamples() {
+		return
+	}
+	if err := input.Err(); err != nil {
+		color.Red("* WARNING: Reading '%s' failed: %s. Keeping what has been processed.",
+			settings.InputWav, err)
+	}
 
 	/*
 		if settings.Profiler {
@@ -400,15 +711,23 @@ func main() {
 		color.Yellow("* No more samples to process.")
 	} else {
 		// Do trail-samples?
-		numSamples := len(outSamples)
+		numSamples := sampleNum
 
-		if settings.TrailSeconds > 0.0 &&
-			(settings.StopAtSample > 0 && sampleNum < settings.StopAtSample) {
-			numTrailSamples := int(settings.TrailSeconds * settings.SampleRate)
+		if settings.TrailSeconds > 0.0 && !interrupted &&
+			!(settings.StopAtSample > 0 && sampleNum >= settings.StopAtSample) {
+			numTrailSamples := int(settings.TrailSeconds * dspSampleRate)
+			if clockCurve != nil {
+				numTrailSamples = int(clockCurve.Cycles(clockTime, clockTime+settings.TrailSeconds))
+			}
 			fmt.Printf("* Adding a %.2f second(s) trail (%d samples)\n",
 				settings.TrailSeconds, numTrailSamples)
 			for i := 0; i < numTrailSamples; i++ {
-				outLeft, outRight, ok := processSample(0.0, 0.0, state, opCodes, numSamples+i)
+				if i%clockBlockSize == 0 {
+					updateClock()
+				}
+				clockTime += 1.0 / dspSampleRate
+
+				outLeft, outRight, ok := processSample(0.0, 0.0, state, prog, codecModel, numSamples+i)
 				updateWavStatistics(numSamples+i, 0.0, 0.0, &statistics)
 
 				if regCSVWriter != nil {
@@ -422,12 +741,31 @@ func main() {
 				}
 
 				outSamples = append(outSamples, [2]float64{outLeft, outRight})
+				if isOutBufferFull() {
+					if !writeOutSamples() {
+						return
+					}
+					if checkForInterrupt() {
+						break
+					}
+				}
+			}
+			if !writeOutSamples() {
+				return
+			}
+		}
+
+		// The last samples held back by the resampler
+		if outResampler != nil {
+			outResampler.End()
+			if !writeOutSamples() {
+				return
 			}
 		}
 		duration := time.Since(start).Seconds()
 		fmt.Printf("   -> ..took %fs to process %d samples (%.2f%% of realtime)\n",
-			duration, len(outSamples),
-			(float64(duration) / (float64(len(outSamples)) / settings.SampleRate) * 100.0))
+			duration, numOutSamples,
+			(float64(duration) / (float64(numOutSamples) / settings.SampleRate) * 100.0))
 	}
 
 	statistics.Left.Mean = statistics.Left.Mean / float64(statistics.NumSamples)
@@ -435,6 +773,11 @@ func main() {
 
 	printWavStatistics(&statistics)
 
+	if state.DebugFlags.InvalidRegister != 0 {
+		fmt.Printf("WARNING: The program uses register %d (0x%x) which is not in use\n",
+			state.DebugFlags.InvalidRegister, state.DebugFlags.InvalidRegister)
+	}
+
 	if settings.PrintDebug {
 		fmt.Printf("DEBUG: Sin0-range used: <%f, %f>\n",
 			state.DebugFlags.Sin0Min, state.DebugFlags.Sin0Max)
@@ -467,13 +810,48 @@ func main() {
 			})))
 			<-done
 		*/
-	} else {
-		if !settings.Debugger {
-			writer.SaveAsWAV(settings.OutputWav, wavFormat, outSamples)
+	} else if wavWriter != nil {
+		if err := wavWriter.Close(); err != nil {
+			fmt.Printf("Error writing samples: %s\n", err)
+			return
 		}
+		fmt.Printf("* Wrote %d samples to '%s'\n", wavWriter.NumSamples(), settings.OutputWav)
 	}
 }
 
+// Create the output WAV, FLAC or AIFF file (by extension) with the
+// '-out-format' sample format, or the input format if not given
+func createOutputWAV(filename string, wavFormat beep.Format, inputIsFloat bool) (writer.AudioWriter, error) {
+	var sampleFormat *pcm.Format
+	if settings.OutputFormat != "" {
+		f, err := pcm.ParseFormat(settings.OutputFormat)
+		if err != nil {
+			return nil, err
+		}
+		sampleFormat = &f
+	} else if inputIsFloat {
+		f := pcm.F32LE
+		sampleFormat = &f
+	} else if settings.Dither && wavFormat.Precision > 1 {
+		f := writer.SampleFormatOf(wavFormat) // Dither needs a sample format
+		sampleFormat = &f
+	}
+
+	if sampleFormat != nil {
+		fmt.Printf("* Writing to '%s' as %s\n", filename, *sampleFormat)
+	} else {
+		fmt.Printf("* Writing to '%s'\n", filename)
+	}
+	w, err := writer.CreateAudio(filename, wavFormat, sampleFormat)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Dither && sampleFormat != nil && *sampleFormat != pcm.F32LE {
+		w.SetDither(pcm.NewDither(1))
+	}
+	return w, nil
+}
+
 func DebugPreFn(opCodes []base.Op, state *dsp.State, sampleNum int) int {
 	debugger.Init()
 	debugger.RegisterState(state)
@@ -508,19 +886,19 @@ func DebugPostFn(opCodes []base.Op, state *dsp.State, sampleNum int) int {
 			return dsp.Fatal
 		}
 	case "next sample":
-		dsp.SkipNumSamples(1)
+		state.SkipNumSamples(1)
 		break
 	case "next 100 samples":
-		dsp.SkipNumSamples(100)
+		state.SkipNumSamples(100)
 		break
 	case "next 1000 samples":
-		dsp.SkipNumSamples(1000)
+		state.SkipNumSamples(1000)
 		break
 	case "next 10000 samples":
-		dsp.SkipNumSamples(10000)
+		state.SkipNumSamples(10000)
 		break
 	case "next 100000 samples":
-		dsp.SkipNumSamples(100000)
+		state.SkipNumSamples(100000)
 		break
 	}
 	return dsp.Ok
@@ -532,19 +910,29 @@ func NoDebugFn(opCodes []base.Op, state *dsp.State, sampleNum int) int {
 }
 
 // Returns an Int-pair (16bits signed)
-func processSample(inRight float64, inLeft float64, state *dsp.State, opCodes []base.Op, sampleNum int) (float64, float64, bool) {
-	state.GetRegister(base.ADCL).SetFloat64(inLeft)
-	state.GetRegister(base.ADCR).SetFloat64(inRight)
+// The codec model is skipped when 'codecModel' is nil
+func processSample(inRight float64, inLeft float64, state *dsp.State, prog *dsp.Program,
+	codecModel *codec.Codec, sampleNum int) (float64, float64, bool) {
+	if codecModel != nil {
+		in := codecModel.ADC([2]float64{inLeft, inRight})
+		inLeft, inRight = in[0], in[1]
+	}
+	state.GetRegister(base.ADCL).SetClampedFloat64(inLeft)
+	state.GetRegister(base.ADCR).SetClampedFloat64(inRight)
 
 	cont := true
-	if settings.Debugger && dsp.GetSkipNumSamples() == 0 {
-		cont = dsp.ProcessSample(opCodes, state, sampleNum, DebugPreFn, DebugPostFn)
+	if settings.Debugger && state.GetSkipNumSamples() == 0 {
+		cont = dsp.ProcessSample(prog, state, sampleNum, DebugPreFn, DebugPostFn)
 	} else {
-		cont = dsp.ProcessSample(opCodes, state, sampleNum, NoDebugFn, NoDebugFn)
+		cont = dsp.ProcessSample(prog, state, sampleNum, NoDebugFn, NoDebugFn)
 	}
 
 	outLeft := state.GetRegister(base.DACL).ToFloat64()
 	outRight := state.GetRegister(base.DACR).ToFloat64()
+	if codecModel != nil {
+		out := codecModel.DAC([2]float64{outLeft, outRight})
+		outLeft, outRight = out[0], out[1]
+	}
 	return outLeft, outRight, cont
 }
 
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/handegar/fv1emu/asm"
+	"github.com/handegar/fv1emu/codec"
+	"github.com/handegar/fv1emu/dsp"
+	"github.com/handegar/fv1emu/resample"
+)
+
+// A full-scale square wave overshoots 1.0 after the resampler and the
+// codec filters, which must not stop the program
+func Test_ProcessSampleOvershoot(t *testing.T) {
+	program, err := asm.Assemble("ldax adcl\nwrax dacl, 0\nldax adcr\nwrax dacr, 0\n")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	config := dsp.ConfigFromSettings()
+	prog, err := dsp.Compile(dsp.DecodeOpCodes(program.Words, config))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	square := make([][2]float64, 4410)
+	for i := range square {
+		v := 1.0
+		if (i/50)%2 == 1 {
+			v = -1.0
+		}
+		square[i] = [2]float64{v, -v}
+	}
+	r := resample.NewResampler(44100, 32768)
+	r.Write(square)
+	r.End()
+	in := make([][2]float64, 4096)
+	n := r.Read(in)
+	in = in[:n]
+
+	overshoot := false
+	for _, s := range in {
+		if s[0] < -1.0 || s[0] > 1.0 {
+			overshoot = true
+		}
+	}
+	if !overshoot {
+		t.Fatalf("Expected the resampled square wave to overshoot 1.0")
+	}
+
+	codecConfig := codec.DefaultConfig()
+	codecConfig.ADCBits = 0
+	codecModel, err := codec.New(codecConfig, 32768)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, c := range []*codec.Codec{nil, codecModel} {
+		state := dsp.NewStateWithConfig(config)
+		for i, s := range in {
+			left, right, cont := processSample(s[1], s[0], state, prog, c, i)
+			if !cont {
+				t.Fatalf("The program stopped at sample %d", i)
+			}
+			// The DAC filters of the codec may overshoot too
+			if c == nil && (left < -1.0 || left > 1.0 || right < -1.0 || right > 1.0) {
+				t.Fatalf("Expected the output within [-1, 1], got %f, %f at sample %d", left, right, i)
+			}
+		}
+	}
+}
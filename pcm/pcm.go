@@ -0,0 +1,239 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+/**
+  Raw, headerless PCM as used by sox, ffmpeg, arecord and aplay.
+  Samples are interleaved little endian frames with one or two
+  channels. A mono input is fed to both channels of the emulator and
+  only the left channel is written to a mono output.
+*/
+
+type Format int
+
+const (
+	S16LE Format = iota
+	S24LE
+	S32LE
+	F32LE
+)
+
+var formatNames = []string{"s16le", "s24le", "s32le", "f32le"}
+
+// Accepts the names with or without the "le" suffix, ie. "s24le" or
+// "s24"
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if strings.EqualFold(name, n) || strings.EqualFold(name, strings.TrimSuffix(n, "le")) {
+			return Format(f), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown PCM format '%s', expected %s", name, strings.Join(formatNames, ", "))
+}
+
+func (f Format) String() string {
+	return formatNames[f]
+}
+
+// Bytes per sample
+func (f Format) Width() int {
+	switch f {
+	case S16LE:
+		return 2
+	case S24LE:
+		return 3
+	}
+	return 4
+}
+
+// Decode one sample from 'p' to [-1.0 .. 1.0>
+func (f Format) Decode(p []byte) float64 {
+	switch f {
+	case S16LE:
+		return float64(int16(binary.LittleEndian.Uint16(p))) / (1 << 15)
+	case S24LE:
+		v := int32(uint32(p[0])<<8|uint32(p[1])<<16|uint32(p[2])<<24) >> 8
+		return float64(v) / (1 << 23)
+	case S32LE:
+		return float64(int32(binary.LittleEndian.Uint32(p))) / (1 << 31)
+	}
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(p)))
+}
+
+// Encode one sample into 'p'. Integer formats are rounded and clipped,
+// F32LE is not clipped.
+func (f Format) Encode(p []byte, v float64) {
+	switch f {
+	case S16LE:
+		binary.LittleEndian.PutUint16(p, uint16(quantize(v, 16)))
+	case S24LE:
+		i := quantize(v, 24)
+		p[0], p[1], p[2] = byte(i), byte(i>>8), byte(i>>16)
+	case S32LE:
+		binary.LittleEndian.PutUint32(p, uint32(quantize(v, 32)))
+	default:
+		binary.LittleEndian.PutUint32(p, math.Float32bits(float32(v)))
+	}
+}
+
+// The rounded and clipped integer value of 'v' for the integer
+// formats, ie. [-32768 .. 32767] for S16LE
+func (f Format) Quantize(v float64) int32 {
+	return quantize(v, f.Bits())
+}
+
+// Bits per sample
+func (f Format) Bits() int {
+	return f.Width() * 8
+}
+
+// The value of the least significant bit. Zero for F32LE.
+func (f Format) LSB() float64 {
+	if f == F32LE {
+		return 0
+	}
+	return 1.0 / float64(int64(1)<<(f.Bits()-1))
+}
+
+func quantize(v float64, bits int) int32 {
+	scale := float64(int64(1) << (bits - 1))
+	v = math.Round(v * scale)
+	return int32(math.Max(-scale, math.Min(scale-1, v)))
+}
+
+func checkChannels(channels int) error {
+	if channels != 1 && channels != 2 {
+		return fmt.Errorf("Unsupported number of channels %d, expected 1 or 2", channels)
+	}
+	return nil
+}
+
+// TPDF dither, ie. noise with a triangular distribution of +/-1 LSB
+// added before quantizing. Makes the quantization error independent of
+// the signal so quiet reverb tails fade into noise instead of
+// distorting. The noise is the same for each run with the same seed.
+type Dither struct {
+	rng *rand.Rand
+}
+
+func NewDither(seed int64) *Dither {
+	return &Dither{rng: rand.New(rand.NewSource(seed))}
+}
+
+// Add dither to 'v' before encoding it as 'format'. Does nothing for
+// F32LE.
+func (d *Dither) Apply(format Format, v float64) float64 {
+	if format == F32LE {
+		return v
+	}
+	return v + (d.rng.Float64()-d.rng.Float64())*format.LSB()
+}
+
+// Reads frames from an io.Reader. Implements beep.Streamer and can be
+// used with endless inputs like a pipe from arecord. An incomplete
+// frame at the end of the input is dropped.
+type Reader struct {
+	r        io.Reader
+	format   Format
+	channels int
+	buf      []byte
+	err      error
+	drained  bool
+}
+
+func NewReader(r io.Reader, format Format, channels int) (*Reader, error) {
+	if err := checkChannels(channels); err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, format: format, channels: channels}, nil
+}
+
+func (r *Reader) Stream(samples [][2]float64) (int, bool) {
+	if r.drained {
+		return 0, false
+	}
+
+	width := r.format.Width()
+	frameSize := width * r.channels
+	if cap(r.buf) < len(samples)*frameSize {
+		r.buf = make([]byte, len(samples)*frameSize)
+	}
+	buf := r.buf[:len(samples)*frameSize]
+
+	read, err := io.ReadFull(r.r, buf)
+	if err != nil {
+		r.drained = true
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			r.err = err
+			return 0, false
+		}
+	}
+
+	n := read / frameSize
+	for i := range samples[:n] {
+		frame := buf[i*frameSize:]
+		samples[i][0] = r.format.Decode(frame)
+		samples[i][1] = samples[i][0]
+		if r.channels == 2 {
+			samples[i][1] = r.format.Decode(frame[width:])
+		}
+	}
+	return n, n > 0
+}
+
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Writes frames to an io.Writer
+type Writer struct {
+	w        io.Writer
+	format   Format
+	channels int
+	buf      []byte
+	dither   *Dither
+}
+
+func NewWriter(w io.Writer, format Format, channels int) (*Writer, error) {
+	if err := checkChannels(channels); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, format: format, channels: channels}, nil
+}
+
+// Dither the samples before encoding them. Nil turns dithering off.
+func (w *Writer) SetDither(dither *Dither) {
+	w.dither = dither
+}
+
+// Write all samples with a single Write() to the underlying writer
+func (w *Writer) Write(samples [][2]float64) error {
+	width := w.format.Width()
+	frameSize := width * w.channels
+	if cap(w.buf) < len(samples)*frameSize {
+		w.buf = make([]byte, len(samples)*frameSize)
+	}
+	buf := w.buf[:len(samples)*frameSize]
+
+	for i, sample := range samples {
+		if w.dither != nil {
+			sample[0] = w.dither.Apply(w.format, sample[0])
+			sample[1] = w.dither.Apply(w.format, sample[1])
+		}
+		frame := buf[i*frameSize:]
+		w.format.Encode(frame, sample[0])
+		if w.channels == 2 {
+			w.format.Encode(frame[width:], sample[1])
+		}
+	}
+	_, err := w.w.Write(buf)
+	return err
+}
@@ -0,0 +1,143 @@
+package pcm
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func Test_ParseFormat(t *testing.T) {
+	for _, name := range []string{"s16le", "S24LE", "s32le", "f32le"} {
+		f, err := ParseFormat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.EqualFold([]byte(f.String()), []byte(name)) {
+			t.Errorf("Expected %s, got %s", name, f)
+		}
+	}
+	if _, err := ParseFormat("u8"); err == nil {
+		t.Errorf("Expected an unknown format to fail")
+	}
+}
+
+func Test_EncodeDecode(t *testing.T) {
+	type testCase struct {
+		format   Format
+		value    float64
+		encoded  []byte
+		expected float64
+	}
+	cases := []testCase{
+		{S16LE, 0.5, []byte{0x00, 0x40}, 0.5},
+		{S16LE, -1.0, []byte{0x00, 0x80}, -1.0},
+		{S16LE, 1.0, []byte{0xFF, 0x7F}, 32767.0 / 32768.0},
+		{S16LE, -3.0, []byte{0x00, 0x80}, -1.0},
+		{S16LE, 1.5 / 32768.0, []byte{0x02, 0x00}, 2.0 / 32768.0},
+		{S24LE, -0.25, []byte{0x00, 0x00, 0xE0}, -0.25},
+		{S24LE, 1.0 / (1 << 23), []byte{0x01, 0x00, 0x00}, 1.0 / (1 << 23)},
+		{S24LE, 2.0, []byte{0xFF, 0xFF, 0x7F}, 8388607.0 / 8388608.0},
+		{S32LE, -0.5, []byte{0x00, 0x00, 0x00, 0xC0}, -0.5},
+		{S32LE, 1.0, []byte{0xFF, 0xFF, 0xFF, 0x7F}, 2147483647.0 / 2147483648.0},
+		{F32LE, 0.75, []byte{0x00, 0x00, 0x40, 0x3F}, 0.75},
+		{F32LE, -2.0, []byte{0x00, 0x00, 0x00, 0xC0}, -2.0}, // Not clipped
+	}
+
+	for _, c := range cases {
+		p := make([]byte, c.format.Width())
+		c.format.Encode(p, c.value)
+		if !bytes.Equal(p, c.encoded) {
+			t.Errorf("%s %f: Expected % X, got % X", c.format, c.value, c.encoded, p)
+		}
+		if v := c.format.Decode(p); v != c.expected {
+			t.Errorf("%s % X: Expected %f, got %f", c.format, p, c.expected, v)
+		}
+	}
+}
+
+func Test_ReaderWriter(t *testing.T) {
+	samples := [][2]float64{{0.5, -0.5}, {0.25, 0.125}, {-1.0, 0.0}}
+
+	for _, channels := range []int{1, 2} {
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, S16LE, channels)
+		if err := w.Write(samples); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != len(samples)*2*channels {
+			t.Fatalf("Expected %d bytes, got %d", len(samples)*2*channels, buf.Len())
+		}
+
+		// With an incomplete frame at the end
+		buf.WriteByte(0x12)
+		r, _ := NewReader(&buf, S16LE, channels)
+		out := make([][2]float64, 2)
+		var got [][2]float64
+		for {
+			n, ok := r.Stream(out)
+			if !ok {
+				break
+			}
+			got = append(got, out[:n]...)
+		}
+		if r.Err() != nil {
+			t.Fatal(r.Err())
+		}
+		if len(got) != len(samples) {
+			t.Fatalf("%d channel(s): Expected %d samples, got %d", channels, len(samples), len(got))
+		}
+		for i := range samples {
+			expected := samples[i]
+			if channels == 1 {
+				expected[1] = expected[0]
+			}
+			if got[i] != expected {
+				t.Errorf("%d channel(s): Sample %d: Expected %v, got %v", channels, i, expected, got[i])
+			}
+		}
+	}
+
+	if _, err := NewReader(nil, S16LE, 3); err == nil {
+		t.Errorf("Expected 3 channels to fail")
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("Read failed")
+}
+
+func Test_ReaderError(t *testing.T) {
+	r, _ := NewReader(failingReader{}, F32LE, 2)
+	if n, ok := r.Stream(make([][2]float64, 10)); n != 0 || ok || r.Err() == nil {
+		t.Errorf("Expected the error to be returned by Err()")
+	}
+}
+
+func Test_Dither(t *testing.T) {
+	if f, err := ParseFormat("s24"); err != nil || f != S24LE {
+		t.Errorf("Expected 's24' to be parsed as s24le")
+	}
+
+	d := NewDither(1)
+	sum := 0.0
+	for i := 0; i < 10000; i++ {
+		v := d.Apply(S16LE, 0.5)
+		if diff := v - 0.5; diff <= -S16LE.LSB() || diff >= S16LE.LSB() {
+			t.Fatalf("Expected the dither to be within +/-1 LSB, got %g", diff/S16LE.LSB())
+		}
+		sum += v - 0.5
+	}
+	if mean := sum / 10000; math.Abs(mean) > 0.05*S16LE.LSB() {
+		t.Errorf("Expected the dither to have zero mean, got %g LSB", mean/S16LE.LSB())
+	}
+
+	if NewDither(7).Apply(S24LE, 0.25) != NewDither(7).Apply(S24LE, 0.25) {
+		t.Errorf("Expected the same seed to give the same dither")
+	}
+	if d.Apply(F32LE, 0.25) != 0.25 {
+		t.Errorf("Expected no dither for f32le")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/faiface/beep"
+	"github.com/fatih/color"
+
+	"github.com/handegar/fv1emu/codec"
+	"github.com/handegar/fv1emu/fv1"
+	"github.com/handegar/fv1emu/pcm"
+	"github.com/handegar/fv1emu/resample"
+	"github.com/handegar/fv1emu/settings"
+)
+
+const pipeUsage = `Usage:
+  fv1emu pipe [OPTIONS] PROGRAM
+      Read raw PCM from stdin, run it through PROGRAM (BIN, HEX or SPN)
+      and write raw PCM to stdout until the input ends. Messages are
+      written to stderr. Example:
+        arecord -f S16_LE -c 2 -r 48000 -t raw | \
+          fv1emu pipe -format s16le -rate 48000 reverb.spn | \
+          aplay -f S16_LE -c 2 -r 48000 -t raw`
+
+// Frames per block. Each block is written as soon as it has been
+// processed, so this is also the added latency.
+const pipeBlockSize = 256
+
+// The "pipe" sub-command. Stdout carries the audio, so everything
+// else is printed to stderr. Returns FALSE on errors.
+func runPipeCommand(args []string) bool {
+	audioOut := os.Stdout
+	os.Stdout = os.Stderr
+	color.Output = os.Stderr
+
+	fmt.Printf("* FV-1 emulator v%s\n", settings.Version)
+	if err := pipeAudio(args, audioOut); err != nil {
+		fmt.Printf("%s\n", err)
+		return false
+	}
+	return true
+}
+
+func pipeAudio(args []string, audioOut *os.File) error {
+	opts := fv1.DefaultOptions()
+	flags := flag.NewFlagSet("pipe", flag.ContinueOnError)
+	formatName := flags.String("format", "s16le", "Sample format: s16le, s24le, s32le or f32le")
+	rate := flags.Int("rate", 44100, "Sample rate (Hz)")
+	channels := flags.Int("channels", 2, "Number of channels (1 or 2)")
+	dither := flags.Bool("dither", false, "Add TPDF dither to integer formats")
+	prog := flags.Int("prog", 0, "Which program to use for multiprogram BIN/HEX files")
+	flags.Float64Var(&opts.Pot0, "p0", opts.Pot0, "Potensiometer 0 value (0 .. 1.0)")
+	flags.Float64Var(&opts.Pot1, "p1", opts.Pot1, "Potensiometer 1 value (0 .. 1.0)")
+	flags.Float64Var(&opts.Pot2, "p2", opts.Pot2, "Potensiometer 2 value (0 .. 1.0)")
+	flags.Float64Var(&opts.ClockFrequency, "clock", 0, "Chrystal frequency (defaults to the sample rate)")
+	accurateClock := flags.Bool("accurate-clock", false,
+		"Run the DSP at the clock frequency (32768 Hz unless -clock is given) and resample the audio to and from it")
+	flags.BoolVar(&opts.FloatDelayRAM, "float-delay-ram", opts.FloatDelayRAM,
+		"Store the delay memory in the 14-bit floating point format of the FV-1 instead of 24 bits")
+	useCodec := flags.Bool("codec", false, "Model the ADC and DAC of the FV-1 with the default settings")
+	trailSeconds := flags.Float64("trail", 0, "Additional trail length after the input ends (seconds)")
+	flags.BoolVar(&opts.Disable24BitsClamping, "disable-24bits-clamping", opts.Disable24BitsClamping,
+		"Disable clamping of register values to 24-bits but use the entire 32-bits range.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("%s", pipeUsage)
+	}
+
+	format, err := pcm.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	if *rate <= 0 {
+		return fmt.Errorf("Invalid sample rate %d", *rate)
+	}
+	if opts.ClockFrequency == 0 {
+		opts.ClockFrequency = float64(*rate)
+		if *accurateClock {
+			opts.ClockFrequency = settings.ChipClockFrequency
+		}
+	}
+	if opts.ClockFrequency < 0 {
+		return fmt.Errorf("Invalid clock frequency %f", opts.ClockFrequency)
+	}
+
+	if *useCodec {
+		config := codec.DefaultConfig()
+		opts.Codec = &config
+	}
+
+	words, _, err := loadProgramFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	chip, err := fv1.New(opts)
+	if err != nil {
+		return err
+	}
+	if err = chip.Load(words, *prog); err != nil {
+		return err
+	}
+
+	input, err := pcm.NewReader(os.Stdin, format, *channels)
+	if err != nil {
+		return err
+	}
+	output, err := pcm.NewWriter(audioOut, format, *channels)
+	if err != nil {
+		return err
+	}
+	if *dither {
+		output.SetDither(pcm.NewDither(1))
+	}
+
+	fmt.Printf("* Processing %s, %d channel(s), %dHz. Clock is %.2f Hz.\n",
+		format, *channels, *rate, opts.ClockFrequency)
+
+	// The chip runs at the sample rate, or at the clock frequency
+	// with the audio resampled at the ADC and DAC
+	var dspInput beep.Streamer = input
+	dspRate := float64(*rate)
+	if *accurateClock {
+		dspRate = opts.ClockFrequency
+		dspInput = resample.NewStreamer(input, float64(*rate), dspRate)
+	}
+	fx := fv1.NewFV1Streamer(chip, dspInput)
+	fx.SetTrail(int(*trailSeconds * dspRate))
+
+	var out beep.Streamer = fx
+	if *accurateClock {
+		out = resample.NewStreamer(fx, dspRate, float64(*rate))
+	}
+
+	samples := make([][2]float64, pipeBlockSize)
+	numSamples := 0
+	for {
+		n, ok := out.Stream(samples)
+		if !ok {
+			break
+		}
+		if err = output.Write(samples[:n]); err != nil {
+			return fmt.Errorf("Writing to stdout failed: %s", err)
+		}
+		numSamples += n
+	}
+	if err = out.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("* Processed %d samples\n", numSamples)
+	return nil
+}
@@ -0,0 +1,276 @@
+package reader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/faiface/beep"
+
+	"github.com/handegar/fv1emu/pcm"
+)
+
+/**
+  An AIFF and AIFF-C decoder. Handles big endian PCM with 8 to 32 bits
+  per sample, and the AIFF-C compression types 'NONE', 'sowt' (little
+  endian PCM), 'fl32' and 'fl64' (big endian IEEE float). Unknown
+  chunks are skipped. Implements beep.Streamer like WAVDecoder.
+*/
+
+type AIFFDecoder struct {
+	r      io.Reader
+	closer io.Closer // Only set when opened by ReadAIFF()
+
+	numChannels   int
+	numFrames     int
+	sampleRate    int
+	bitsPerSample int
+	littleEndian  bool // 'sowt'
+	float         bool // 'fl32' and 'fl64'
+
+	remaining int64 // Bytes left of the sample data
+	buf       []byte
+	err       error
+}
+
+// Open and decode the header of an AIFF file. Close() closes the file.
+func ReadAIFF(filename string) (*AIFFDecoder, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := NewAIFFDecoder(bufio.NewReaderSize(file, 64*1024))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	d.closer = file
+	return d, nil
+}
+
+// Read the header up to the start of the sample data
+func NewAIFFDecoder(r io.Reader) (*AIFFDecoder, error) {
+	var form [12]byte
+	if _, err := io.ReadFull(r, form[:]); err != nil {
+		return nil, fmt.Errorf("Not an AIFF file, %s", err)
+	}
+	formType := string(form[8:12])
+	if string(form[0:4]) != "FORM" || (formType != "AIFF" && formType != "AIFC") {
+		return nil, errors.New("Not an AIFF file, missing the FORM/AIFF header")
+	}
+
+	d := &AIFFDecoder{r: r}
+	hasComm := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			if !hasComm {
+				return nil, errors.New("No 'COMM' chunk in the AIFF file")
+			}
+			return nil, errors.New("No 'SSND' chunk in the AIFF file")
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.BigEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "COMM":
+			data := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, fmt.Errorf("Truncated 'COMM' chunk, %s", err)
+			}
+			if err := d.parseComm(data[:size], formType == "AIFC"); err != nil {
+				return nil, err
+			}
+			hasComm = true
+		case "SSND":
+			if !hasComm {
+				return nil, errors.New("The 'SSND' chunk comes before the 'COMM' chunk")
+			}
+			var ssnd [8]byte
+			if _, err := io.ReadFull(r, ssnd[:]); err != nil {
+				return nil, fmt.Errorf("Truncated 'SSND' chunk, %s", err)
+			}
+			offset := int64(binary.BigEndian.Uint32(ssnd[0:4]))
+			if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+				return nil, fmt.Errorf("Truncated 'SSND' chunk, %s", err)
+			}
+			// The size of the sample data is given by the frame count
+			// in 'COMM', the chunk may have padding after it
+			d.remaining = int64(d.numFrames * d.blockAlign())
+			return d, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, fmt.Errorf("Truncated '%s' chunk, %s", id, err)
+			}
+		}
+	}
+}
+
+func (d *AIFFDecoder) parseComm(data []byte, isAIFC bool) error {
+	if len(data) < 18 || (isAIFC && len(data) < 22) {
+		return fmt.Errorf("The 'COMM' chunk is too short (%d bytes)", len(data))
+	}
+	d.numChannels = int(binary.BigEndian.Uint16(data[0:]))
+	d.numFrames = int(binary.BigEndian.Uint32(data[2:]))
+	d.bitsPerSample = int(binary.BigEndian.Uint16(data[6:]))
+	d.sampleRate = int(math.Round(decodeExtended(data[8:18])))
+
+	if isAIFC {
+		switch compression := string(data[18:22]); compression {
+		case "NONE":
+		case "sowt":
+			d.littleEndian = true
+		case "fl32", "FL32":
+			d.float = true
+			d.bitsPerSample = 32
+		case "fl64", "FL64":
+			d.float = true
+			d.bitsPerSample = 64
+		default:
+			return fmt.Errorf("Unsupported AIFF-C compression '%s'", compression)
+		}
+	}
+
+	if d.numChannels < 1 {
+		return fmt.Errorf("Invalid number of channels %d", d.numChannels)
+	}
+	if d.sampleRate <= 0 {
+		return fmt.Errorf("Invalid sample rate %d", d.sampleRate)
+	}
+	if !d.float && (d.bitsPerSample < 8 || d.bitsPerSample > 32) {
+		return fmt.Errorf("Unsupported PCM sample size of %d bits", d.bitsPerSample)
+	}
+	return nil
+}
+
+// Decode an 80-bit IEEE 754 extended precision number, which is how
+// AIFF stores the sample rate
+func decodeExtended(p []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(p[0:2]) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(p[2:10])
+	v := math.Ldexp(float64(mantissa), exponent-16383-63)
+	if p[0]&0x80 != 0 {
+		return -v
+	}
+	return v
+}
+
+// Bytes per sample. Samples like 20 bits are stored left-justified.
+func (d *AIFFDecoder) width() int {
+	return (d.bitsPerSample + 7) / 8
+}
+
+func (d *AIFFDecoder) blockAlign() int {
+	return d.width() * d.numChannels
+}
+
+// Precision is the bytes per sample, but at most 4 as beep does not
+// handle 64-bit samples
+func (d *AIFFDecoder) Format() beep.Format {
+	return beep.Format{
+		SampleRate:  beep.SampleRate(d.sampleRate),
+		NumChannels: d.numChannels,
+		Precision:   min(d.width(), 4),
+	}
+}
+
+func (d *AIFFDecoder) BitsPerSample() int {
+	return d.bitsPerSample
+}
+
+func (d *AIFFDecoder) IsFloat() bool {
+	return d.float
+}
+
+// The number of samples
+func (d *AIFFDecoder) Len() int {
+	return d.numFrames
+}
+
+// Decode one sample to [-1.0 .. 1.0>. Float samples are not clipped.
+func (d *AIFFDecoder) decode(p []byte) float64 {
+	if d.float {
+		if d.bitsPerSample == 64 {
+			return math.Float64frombits(binary.BigEndian.Uint64(p))
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(p)))
+	}
+
+	if d.littleEndian {
+		switch d.width() {
+		case 1:
+			return float64(int8(p[0])) / (1 << 7)
+		case 2:
+			return pcm.S16LE.Decode(p)
+		case 3:
+			return pcm.S24LE.Decode(p)
+		}
+		return pcm.S32LE.Decode(p)
+	}
+
+	switch d.width() {
+	case 1:
+		return float64(int8(p[0])) / (1 << 7) // 8 bit AIFF files are signed
+	case 2:
+		return float64(int16(binary.BigEndian.Uint16(p))) / (1 << 15)
+	case 3:
+		v := int32(uint32(p[0])<<24|uint32(p[1])<<16|uint32(p[2])<<8) >> 8
+		return float64(v) / (1 << 23)
+	}
+	return float64(int32(binary.BigEndian.Uint32(p))) / (1 << 31)
+}
+
+// A truncated file ends the stream without an error, like WAVDecoder
+func (d *AIFFDecoder) Stream(samples [][2]float64) (int, bool) {
+	if d.err != nil || d.remaining == 0 {
+		return 0, false
+	}
+
+	blockAlign := d.blockAlign()
+	size := min(int64(len(samples)*blockAlign), d.remaining)
+	if cap(d.buf) < int(size) {
+		d.buf = make([]byte, size)
+	}
+	buf := d.buf[:size]
+
+	read, err := io.ReadFull(d.r, buf)
+	d.remaining -= int64(read)
+	if err != nil {
+		d.remaining = 0
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			d.err = err
+			return 0, false
+		}
+	}
+
+	width := d.width()
+	n := read / blockAlign
+	for i := range samples[:n] {
+		frame := buf[i*blockAlign:]
+		samples[i][0] = d.decode(frame)
+		samples[i][1] = samples[i][0]
+		if d.numChannels > 1 {
+			samples[i][1] = d.decode(frame[width:])
+		}
+	}
+	return n, n > 0
+}
+
+func (d *AIFFDecoder) Err() error {
+	return d.err
+}
+
+// Close the file if it was opened by ReadAIFF()
+func (d *AIFFDecoder) Close() error {
+	if d.closer == nil {
+		return nil
+	}
+	err := d.closer.Close()
+	d.closer = nil
+	return err
+}
@@ -0,0 +1,91 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/faiface/beep"
+)
+
+// The decoders for the audio input files
+type Decoder interface {
+	beep.Streamer
+	Format() beep.Format
+	BitsPerSample() int
+	IsFloat() bool
+	Len() int // -1 if unknown
+	Close() error
+}
+
+// Open a WAV, FLAC or AIFF file. The type is detected from the start
+// of the file, or from the filename extension if the start is not
+// recognized.
+func ReadAudio(filename string) (Decoder, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReaderSize(file, 64*1024)
+	magic, _ := r.Peek(12)
+
+	var d Decoder
+	switch {
+	case bytes.HasPrefix(magic, []byte("RIFF")) && bytes.HasSuffix(magic, []byte("WAVE")):
+		d, err = newWAVDecoder(r, file)
+	case bytes.HasPrefix(magic, []byte("fLaC")):
+		d, err = newFLACDecoder(r, file)
+	case bytes.HasPrefix(magic, []byte("FORM")) &&
+		(bytes.HasSuffix(magic, []byte("AIFF")) || bytes.HasSuffix(magic, []byte("AIFC"))):
+		d, err = newAIFFDecoder(r, file)
+	default:
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".wav", ".wave":
+			d, err = newWAVDecoder(r, file)
+		case ".flac":
+			d, err = newFLACDecoder(r, file)
+		case ".aif", ".aiff", ".aifc":
+			d, err = newAIFFDecoder(r, file)
+		default:
+			err = fmt.Errorf("Unknown audio file type '%s', expected WAV, FLAC or AIFF", filename)
+		}
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func newWAVDecoder(r io.Reader, closer io.Closer) (Decoder, error) {
+	d, err := NewWAVDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	d.closer = closer
+	return d, nil
+}
+
+func newFLACDecoder(r io.Reader, closer io.Closer) (Decoder, error) {
+	d, err := NewFLACDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	d.closer = closer
+	return d, nil
+}
+
+func newAIFFDecoder(r io.Reader, closer io.Closer) (Decoder, error) {
+	d, err := NewAIFFDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	d.closer = closer
+	return d, nil
+}
@@ -0,0 +1,128 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func aiffChunk(id string, data []byte) []byte {
+	c := append([]byte(id), binary.BigEndian.AppendUint32(nil, uint32(len(data)))...)
+	c = append(c, data...)
+	if len(data)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+func aiffFile(formType string, comm []byte, chunks ...[]byte) []byte {
+	body := []byte(formType)
+	body = append(body, aiffChunk("COMM", comm)...)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return append(append([]byte("FORM"), binary.BigEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+// 44100Hz as an 80-bit extended
+var rate44100 = []byte{0x40, 0x0E, 0xAC, 0x44, 0, 0, 0, 0, 0, 0}
+
+func commChunk(channels int, frames int, bits int, compression string) []byte {
+	c := binary.BigEndian.AppendUint16(nil, uint16(channels))
+	c = binary.BigEndian.AppendUint32(c, uint32(frames))
+	c = binary.BigEndian.AppendUint16(c, uint16(bits))
+	c = append(c, rate44100...)
+	if compression != "" {
+		c = append(c, compression...)
+		c = append(c, 0, 0) // Empty name, padded
+	}
+	return c
+}
+
+func Test_AIFFDecoder(t *testing.T) {
+	type testCase struct {
+		name     string
+		data     []byte
+		expected [][2]float64
+	}
+	ssnd := func(data ...byte) []byte {
+		return aiffChunk("SSND", append(make([]byte, 8), data...))
+	}
+	cases := []testCase{
+		{"8 bit mono", aiffFile("AIFF", commChunk(1, 3, 8, ""),
+			aiffChunk("MARK", []byte{0, 0, 1}), ssnd(0x40, 0xC0, 0x00)),
+			[][2]float64{{0.5, 0.5}, {-0.5, -0.5}, {0, 0}}},
+		{"16 bit", aiffFile("AIFF", commChunk(2, 1, 16, ""), ssnd(0x40, 0x00, 0x80, 0x00)),
+			[][2]float64{{0.5, -1.0}}},
+		{"24 bit", aiffFile("AIFF", commChunk(2, 1, 24, ""), ssnd(0xE0, 0x00, 0x00, 0x00, 0x00, 0x01)),
+			[][2]float64{{-0.25, 1.0 / (1 << 23)}}},
+		{"sowt", aiffFile("AIFC", commChunk(2, 1, 16, "sowt"), ssnd(0x00, 0x40, 0x00, 0x80)),
+			[][2]float64{{0.5, -1.0}}},
+		{"fl32", aiffFile("AIFC", commChunk(2, 1, 32, "fl32"), ssnd(0x3F, 0x40, 0x00, 0x00, 0xC0, 0x00, 0x00, 0x00)),
+			[][2]float64{{0.75, -2.0}}},
+	}
+
+	for _, c := range cases {
+		d, err := NewAIFFDecoder(bytes.NewReader(c.data))
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if d.Format().SampleRate != 44100 || d.Len() != len(c.expected) {
+			t.Errorf("%s: Expected %d samples at 44100Hz, got %d samples at %dHz",
+				c.name, len(c.expected), d.Len(), d.Format().SampleRate)
+		}
+		samples := make([][2]float64, 10)
+		n, _ := d.Stream(samples)
+		if n != len(c.expected) {
+			t.Fatalf("%s: Expected %d samples, got %d", c.name, len(c.expected), n)
+		}
+		for i := range c.expected {
+			if samples[i] != c.expected[i] {
+				t.Errorf("%s: Sample %d: Expected %v, got %v", c.name, i, c.expected[i], samples[i])
+			}
+		}
+	}
+
+	if _, err := NewAIFFDecoder(bytes.NewReader(aiffFile("AIFC", commChunk(2, 1, 16, "ulaw")))); err == nil {
+		t.Errorf("Expected an unsupported compression to fail")
+	}
+}
+
+// The type is detected from the content before the extension
+func Test_ReadAudio(t *testing.T) {
+	dir := t.TempDir()
+	wavData := riff(chunk("fmt ", fmtChunk(wavFormatPCM, 2, 16, false)), chunk("data", []byte{0x00, 0x40, 0x00, 0xC0}))
+	aiffData := aiffFile("AIFF", commChunk(2, 1, 16, ""), aiffChunk("SSND", []byte{0, 0, 0, 0, 0, 0, 0, 0, 0x40, 0x00, 0xC0, 0x00}))
+
+	files := map[string][]byte{
+		"wav-named.aiff": wavData,
+		"aiff-named.wav": aiffData,
+		"broken.flac":    []byte("not a FLAC file"),
+		"unknown.xyz":    []byte("not audio"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"wav-named.aiff", "aiff-named.wav"} {
+		d, err := ReadAudio(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		samples := make([][2]float64, 2)
+		if n, _ := d.Stream(samples); n != 1 || samples[0] != [2]float64{0.5, -0.5} {
+			t.Errorf("%s: Expected one sample {0.5, -0.5}, got %v", name, samples[:n])
+		}
+		d.Close()
+	}
+
+	for _, name := range []string{"broken.flac", "unknown.xyz", "missing.wav"} {
+		if _, err := ReadAudio(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s: Expected an error", name)
+		}
+	}
+}
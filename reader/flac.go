@@ -0,0 +1,139 @@
+package reader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/faiface/beep"
+	"github.com/mewkiz/flac"
+)
+
+/**
+  A FLAC decoder for 4 to 32 bits per sample. Uses the same FLAC
+  parser as beep's flac package, but handles any sample size and
+  number of channels and does not report the end of the file as an
+  error. Implements beep.Streamer like WAVDecoder.
+*/
+
+type FLACDecoder struct {
+	stream *flac.Stream
+	closer io.Closer // Only set when opened by ReadFLAC()
+
+	decoded [][2]float64 // The samples of the current frame
+	buf     [][2]float64 // The part of 'decoded' not streamed yet
+	err     error
+	eof     bool
+}
+
+// Open and decode the header of a FLAC file. Close() closes the file.
+func ReadFLAC(filename string) (*FLACDecoder, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := NewFLACDecoder(bufio.NewReaderSize(file, 64*1024))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	d.closer = file
+	return d, nil
+}
+
+// Read the metadata blocks up to the first audio frame
+func NewFLACDecoder(r io.Reader) (*FLACDecoder, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("Not a FLAC file, %s", err)
+	}
+	if stream.Info.NChannels < 1 {
+		return nil, fmt.Errorf("Invalid number of channels %d", stream.Info.NChannels)
+	}
+	return &FLACDecoder{stream: stream}, nil
+}
+
+// Precision is the bytes per sample, ie. 3 for 20-bit samples
+func (d *FLACDecoder) Format() beep.Format {
+	return beep.Format{
+		SampleRate:  beep.SampleRate(d.stream.Info.SampleRate),
+		NumChannels: int(d.stream.Info.NChannels),
+		Precision:   (d.BitsPerSample() + 7) / 8,
+	}
+}
+
+func (d *FLACDecoder) BitsPerSample() int {
+	return int(d.stream.Info.BitsPerSample)
+}
+
+func (d *FLACDecoder) IsFloat() bool {
+	return false
+}
+
+// The number of samples, or -1 if the size is not in the header
+func (d *FLACDecoder) Len() int {
+	if d.stream.Info.NSamples == 0 {
+		return -1
+	}
+	return int(d.stream.Info.NSamples)
+}
+
+// Decode the next frame into the buffer
+func (d *FLACDecoder) refill() {
+	frame, err := d.stream.ParseNext()
+	if err != nil {
+		d.eof = true
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			d.err = err
+		}
+		return
+	}
+
+	scale := 1.0 / float64(int64(1)<<(d.stream.Info.BitsPerSample-1))
+	left := frame.Subframes[0].Samples
+	right := left
+	if len(frame.Subframes) > 1 {
+		right = frame.Subframes[1].Samples
+	}
+
+	n := len(left)
+	if cap(d.decoded) < n {
+		d.decoded = make([][2]float64, n)
+	}
+	d.buf = d.decoded[:n]
+	for i := range d.buf {
+		d.buf[i] = [2]float64{float64(left[i]) * scale, float64(right[i]) * scale}
+	}
+}
+
+// A truncated file ends the stream without an error, like WAVDecoder
+func (d *FLACDecoder) Stream(samples [][2]float64) (int, bool) {
+	n := 0
+	for n < len(samples) && !d.eof {
+		if len(d.buf) == 0 {
+			d.refill()
+			continue
+		}
+		copied := copy(samples[n:], d.buf)
+		d.buf = d.buf[copied:]
+		n += copied
+	}
+	return n, n > 0
+}
+
+func (d *FLACDecoder) Err() error {
+	return d.err
+}
+
+// Close the file if it was opened by ReadFLAC()
+func (d *FLACDecoder) Close() error {
+	if d.closer == nil {
+		return nil
+	}
+	err := d.closer.Close()
+	d.closer = nil
+	return err
+}
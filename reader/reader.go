@@ -5,11 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"os"
-
-	"github.com/faiface/beep"
-	"github.com/faiface/beep/wav"
 )
 
 func ReadBin(filename string) ([]uint32, error) {
@@ -26,11 +22,12 @@ func ReadBin(filename string) ([]uint32, error) {
 	}
 
 	var size int64 = stats.Size()
+	if size%4 != 0 {
+		return nil, fmt.Errorf("The size of a BIN file must be a multiple of 4 bytes, got %d", size)
+	}
 	bytes := make([]byte, size)
 
-	buf := bufio.NewReader(file)
-	_, err = buf.Read(bytes)
-
+	_, err = io.ReadFull(file, bytes)
 	if err != nil {
 		return nil, err
 	}
@@ -61,6 +58,10 @@ func ReadHex(filename string) ([]uint32, error) {
 			break
 		}
 
+		if len(line) < 1+2+4+2+2 || line[0] != ':' {
+			continue // Not a record
+		}
+
 		recordTypeStr := line[1+2+4 : 1+2+4+2]
 		recordType := 0
 		_, err = fmt.Sscanf(string(recordTypeStr), "%X", &recordType)
@@ -86,24 +87,10 @@ func ReadHex(filename string) ([]uint32, error) {
 
 	// Collect all 32bits chunks
 	var ints []uint32
-	for i := 0; i < (len(bytes) - 4); i += 4 {
+	for i := 0; i+4 <= len(bytes); i += 4 {
 		chunk := []byte{bytes[i], bytes[i+1], bytes[i+2], bytes[i+3]}
 		ints = append(ints, binary.BigEndian.Uint32(chunk))
 	}
 
 	return ints, err
 }
-
-func ReadWAV(filename string) (*os.File, beep.Streamer, beep.Format, error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	stream, wavFormat, err := wav.Decode(f)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return f, stream, wavFormat, err
-}
@@ -0,0 +1,282 @@
+package reader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/faiface/beep"
+
+	"github.com/handegar/fv1emu/pcm"
+)
+
+/**
+  A WAV decoder which handles what DAWs and recorders write, not only
+  the plain 16-bit PCM files:
+
+  - PCM with 8, 16, 24 or 32 bits per sample (8 bit is unsigned)
+  - IEEE float with 32 or 64 bits per sample
+  - WAVE_FORMAT_EXTENSIBLE headers with a PCM or float sub-format
+  - Unknown chunks (LIST, bext, cue, ...) before and after 'fmt '
+  - Odd sized chunks, which are followed by a pad byte
+
+  Mono is fed to both channels and only the two first channels of a
+  multichannel file are used. Implements beep.Streamer.
+*/
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+type WAVDecoder struct {
+	r      io.Reader
+	closer io.Closer // Only set when opened by ReadWAV()
+
+	formatTag     int // PCM or float, also for extensible headers
+	numChannels   int
+	sampleRate    int
+	bitsPerSample int
+	blockAlign    int
+
+	dataSize  int64 // -1 if unknown, ie. for a streamed file
+	remaining int64 // Bytes left of the data chunk
+	buf       []byte
+	err       error
+}
+
+// Open and decode the header of a WAV file. Close() closes the file.
+func ReadWAV(filename string) (*WAVDecoder, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := NewWAVDecoder(bufio.NewReaderSize(file, 64*1024))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	d.closer = file
+	return d, nil
+}
+
+// Read the header up to the start of the sample data
+func NewWAVDecoder(r io.Reader) (*WAVDecoder, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, fmt.Errorf("Not a WAV file, %s", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errors.New("Not a WAV file, missing the RIFF/WAVE header")
+	}
+
+	// The RIFF size and the offset of the next chunk count from "WAVE"
+	riffSize := int64(binary.LittleEndian.Uint32(riff[4:8]))
+	offset := int64(4)
+
+	d := &WAVDecoder{r: r}
+	hasFmt := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			if !hasFmt {
+				return nil, errors.New("No 'fmt ' chunk in the WAV file")
+			}
+			return nil, errors.New("No 'data' chunk in the WAV file")
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		offset += 8
+
+		switch id {
+		case "fmt ":
+			data := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, fmt.Errorf("Truncated 'fmt ' chunk, %s", err)
+			}
+			if err := d.parseFmt(data[:size]); err != nil {
+				return nil, err
+			}
+			hasFmt = true
+		case "data":
+			if !hasFmt {
+				return nil, errors.New("The 'data' chunk comes before the 'fmt ' chunk")
+			}
+			// Files written while recording may have an empty or
+			// maximum size until they are closed. An empty data chunk
+			// is only unknown when the RIFF size has no chunks after it.
+			d.dataSize = size
+			if size == 0xFFFFFFFF || (size == 0 && riffSize <= offset) {
+				d.dataSize = -1
+			}
+			d.remaining = d.dataSize
+			return d, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, fmt.Errorf("Truncated '%s' chunk, %s", id, err)
+			}
+		}
+		offset += size + size%2
+	}
+}
+
+func (d *WAVDecoder) parseFmt(data []byte) error {
+	if len(data) < 16 {
+		return fmt.Errorf("The 'fmt ' chunk is too short (%d bytes)", len(data))
+	}
+	d.formatTag = int(binary.LittleEndian.Uint16(data[0:]))
+	d.numChannels = int(binary.LittleEndian.Uint16(data[2:]))
+	d.sampleRate = int(binary.LittleEndian.Uint32(data[4:]))
+	d.blockAlign = int(binary.LittleEndian.Uint16(data[12:]))
+	d.bitsPerSample = int(binary.LittleEndian.Uint16(data[14:]))
+
+	// The first two bytes of the sub-format GUID is the format tag
+	if d.formatTag == wavFormatExtensible {
+		if len(data) < 40 {
+			return fmt.Errorf("The extensible 'fmt ' chunk is too short (%d bytes)", len(data))
+		}
+		d.formatTag = int(binary.LittleEndian.Uint16(data[24:]))
+	}
+
+	if d.numChannels < 1 {
+		return fmt.Errorf("Invalid number of channels %d", d.numChannels)
+	}
+	if d.sampleRate <= 0 {
+		return fmt.Errorf("Invalid sample rate %d", d.sampleRate)
+	}
+
+	switch d.formatTag {
+	case wavFormatPCM:
+		if d.bitsPerSample < 8 || d.bitsPerSample > 32 {
+			return fmt.Errorf("Unsupported PCM sample size of %d bits", d.bitsPerSample)
+		}
+	case wavFormatFloat:
+		if d.bitsPerSample != 32 && d.bitsPerSample != 64 {
+			return fmt.Errorf("Unsupported float sample size of %d bits", d.bitsPerSample)
+		}
+	default:
+		return fmt.Errorf("Unsupported WAV format 0x%04X, only PCM and IEEE float is supported", d.formatTag)
+	}
+
+	// Samples like 20 bits are stored left-justified in 3 bytes
+	if d.blockAlign != d.width()*d.numChannels {
+		return fmt.Errorf("Invalid block align %d for %d channels with %d bits",
+			d.blockAlign, d.numChannels, d.bitsPerSample)
+	}
+	return nil
+}
+
+// Bytes per sample
+func (d *WAVDecoder) width() int {
+	return (d.bitsPerSample + 7) / 8
+}
+
+// Precision is the bytes per sample, but at most 4 as beep does not
+// handle 64-bit samples
+func (d *WAVDecoder) Format() beep.Format {
+	return beep.Format{
+		SampleRate:  beep.SampleRate(d.sampleRate),
+		NumChannels: d.numChannels,
+		Precision:   min(d.width(), 4),
+	}
+}
+
+func (d *WAVDecoder) BitsPerSample() int {
+	return d.bitsPerSample
+}
+
+func (d *WAVDecoder) IsFloat() bool {
+	return d.formatTag == wavFormatFloat
+}
+
+// The number of samples, or -1 if the size is not in the header
+func (d *WAVDecoder) Len() int {
+	if d.dataSize < 0 {
+		return -1
+	}
+	return int(d.dataSize / int64(d.blockAlign))
+}
+
+// Decode one sample to [-1.0 .. 1.0>. Float samples are not clipped.
+func (d *WAVDecoder) decode(p []byte) float64 {
+	if d.formatTag == wavFormatFloat {
+		if d.bitsPerSample == 64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(p))
+		}
+		return pcm.F32LE.Decode(p)
+	}
+
+	switch d.width() {
+	case 1:
+		return (float64(p[0]) - 128) / 128 // 8 bit WAV files are unsigned
+	case 2:
+		return pcm.S16LE.Decode(p)
+	case 3:
+		return pcm.S24LE.Decode(p)
+	}
+	return pcm.S32LE.Decode(p)
+}
+
+// A truncated data chunk ends the stream without an error, so files
+// from interrupted recordings can be used
+func (d *WAVDecoder) Stream(samples [][2]float64) (int, bool) {
+	if d.err != nil || d.remaining == 0 {
+		return 0, false
+	}
+
+	size := int64(len(samples) * d.blockAlign)
+	if d.remaining > 0 {
+		size = min(size, d.remaining-d.remaining%int64(d.blockAlign))
+		if size == 0 { // Less than a sample left
+			d.remaining = 0
+			return 0, false
+		}
+	}
+	if cap(d.buf) < int(size) {
+		d.buf = make([]byte, size)
+	}
+	buf := d.buf[:size]
+
+	read, err := io.ReadFull(d.r, buf)
+	if err != nil {
+		d.remaining = 0
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			d.err = err
+			return 0, false
+		}
+	} else if d.remaining > 0 {
+		d.remaining -= int64(read)
+	}
+
+	width := d.width()
+	n := read / d.blockAlign
+	for i := range samples[:n] {
+		frame := buf[i*d.blockAlign:]
+		samples[i][0] = d.decode(frame)
+		samples[i][1] = samples[i][0]
+		if d.numChannels > 1 {
+			samples[i][1] = d.decode(frame[width:])
+		}
+	}
+	return n, n > 0
+}
+
+func (d *WAVDecoder) Err() error {
+	return d.err
+}
+
+// Close the file if it was opened by ReadWAV()
+func (d *WAVDecoder) Close() error {
+	if d.closer == nil {
+		return nil
+	}
+	err := d.closer.Close()
+	d.closer = nil
+	return err
+}
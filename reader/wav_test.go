@@ -0,0 +1,223 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A 'fmt ' chunk body. Extensible headers get the format tag as the
+// sub-format.
+func fmtChunk(formatTag int, channels int, bits int, extensible bool) []byte {
+	width := (bits + 7) / 8
+	tag := formatTag
+	if extensible {
+		tag = wavFormatExtensible
+	}
+	c := binary.LittleEndian.AppendUint16(nil, uint16(tag))
+	c = binary.LittleEndian.AppendUint16(c, uint16(channels))
+	c = binary.LittleEndian.AppendUint32(c, 48000)
+	c = binary.LittleEndian.AppendUint32(c, uint32(48000*width*channels))
+	c = binary.LittleEndian.AppendUint16(c, uint16(width*channels))
+	c = binary.LittleEndian.AppendUint16(c, uint16(bits))
+	if extensible {
+		c = binary.LittleEndian.AppendUint16(c, 22)
+		c = binary.LittleEndian.AppendUint16(c, uint16(bits)) // Valid bits
+		c = binary.LittleEndian.AppendUint32(c, 0x3)          // Channel mask
+		c = binary.LittleEndian.AppendUint16(c, uint16(formatTag))
+		c = append(c, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71)
+	}
+	return c
+}
+
+func chunk(id string, data []byte) []byte {
+	c := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	c = append(c, data...)
+	if len(data)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+func riff(chunks ...[]byte) []byte {
+	body := []byte("WAVE")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func decodeAll(t *testing.T, data []byte) (*WAVDecoder, [][2]float64) {
+	d, err := NewWAVDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][2]float64
+	samples := make([][2]float64, 2)
+	for {
+		n, ok := d.Stream(samples)
+		if !ok {
+			break
+		}
+		got = append(got, samples[:n]...)
+	}
+	if d.Err() != nil {
+		t.Fatal(d.Err())
+	}
+	return d, got
+}
+
+func Test_WAVDecoderFormats(t *testing.T) {
+	type testCase struct {
+		name       string
+		formatTag  int
+		bits       int
+		extensible bool
+		data       []byte // Two stereo samples
+		expected   [][2]float64
+	}
+	f32 := func(v float32) []byte { return binary.LittleEndian.AppendUint32(nil, math.Float32bits(v)) }
+	f64 := func(v float64) []byte { return binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)) }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	cases := []testCase{
+		{"8 bit", wavFormatPCM, 8, false,
+			[]byte{0x80, 0xC0, 0x00, 0x40},
+			[][2]float64{{0, 0.5}, {-1.0, -0.5}}},
+		{"16 bit", wavFormatPCM, 16, false,
+			[]byte{0x00, 0x40, 0x00, 0x80, 0xFF, 0x7F, 0x00, 0x00},
+			[][2]float64{{0.5, -1.0}, {32767.0 / 32768.0, 0}}},
+		{"24 bit", wavFormatPCM, 24, false,
+			[]byte{0x00, 0x00, 0xE0, 0x01, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x80},
+			[][2]float64{{-0.25, 1.0 / (1 << 23)}, {0.5, -1.0}}},
+		{"24 bit extensible", wavFormatPCM, 24, true,
+			[]byte{0x00, 0x00, 0xE0, 0x01, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x80},
+			[][2]float64{{-0.25, 1.0 / (1 << 23)}, {0.5, -1.0}}},
+		{"32 bit", wavFormatPCM, 32, false,
+			[]byte{0, 0, 0, 0xC0, 0, 0, 0, 0x40, 0, 0, 0, 0, 0, 0, 0, 0x80},
+			[][2]float64{{-0.5, 0.5}, {0, -1.0}}},
+		{"32 bit float", wavFormatFloat, 32, false,
+			cat(f32(0.75), f32(-2.0), f32(0.125), f32(0)),
+			[][2]float64{{0.75, -2.0}, {0.125, 0}}},
+		{"32 bit float extensible", wavFormatFloat, 32, true,
+			cat(f32(0.75), f32(-2.0), f32(0.125), f32(0)),
+			[][2]float64{{0.75, -2.0}, {0.125, 0}}},
+		{"64 bit float", wavFormatFloat, 64, false,
+			cat(f64(0.1), f64(1.5), f64(-0.3), f64(0)),
+			[][2]float64{{0.1, 1.5}, {-0.3, 0}}},
+	}
+
+	for _, c := range cases {
+		data := riff(chunk("fmt ", fmtChunk(c.formatTag, 2, c.bits, c.extensible)), chunk("data", c.data))
+		d, got := decodeAll(t, data)
+		if d.IsFloat() != (c.formatTag == wavFormatFloat) || d.BitsPerSample() != c.bits {
+			t.Errorf("%s: Expected float=%v with %d bits, got float=%v with %d bits",
+				c.name, c.formatTag == wavFormatFloat, c.bits, d.IsFloat(), d.BitsPerSample())
+		}
+		if f := d.Format(); f.SampleRate != 48000 || f.NumChannels != 2 || d.Len() != 2 {
+			t.Errorf("%s: Expected 2 samples at 48000Hz, got %d samples, %v", c.name, d.Len(), f)
+		}
+		if len(got) != len(c.expected) {
+			t.Fatalf("%s: Expected %d samples, got %d", c.name, len(c.expected), len(got))
+		}
+		for i := range got {
+			if got[i] != c.expected[i] {
+				t.Errorf("%s: Sample %d: Expected %v, got %v", c.name, i, c.expected[i], got[i])
+			}
+		}
+	}
+}
+
+// Unknown and odd sized chunks must be skipped, mono goes to both
+// channels and an odd sized data chunk is padded
+func Test_WAVDecoderChunks(t *testing.T) {
+	data := riff(
+		chunk("LIST", []byte("INFOodd")),
+		chunk("fmt ", fmtChunk(wavFormatPCM, 1, 8, false)),
+		chunk("bext", make([]byte, 13)),
+		chunk("data", []byte{0xC0, 0x40, 0x80}),
+		chunk("cue ", make([]byte, 4)))
+
+	_, got := decodeAll(t, data)
+	expected := [][2]float64{{0.5, 0.5}, {-0.5, -0.5}, {0, 0}}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d samples, got %d", len(expected), len(got))
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("Sample %d: Expected %v, got %v", i, expected[i], got[i])
+		}
+	}
+}
+
+// A file from an interrupted recording, with no data size in the
+// header and a truncated last sample
+func Test_WAVDecoderTruncated(t *testing.T) {
+	data := riff(chunk("fmt ", fmtChunk(wavFormatPCM, 2, 16, false)))
+	data = append(data, "data"...)
+	data = append(data, 0, 0, 0, 0)
+	data = append(data, 0x00, 0x40, 0x00, 0x40, 0x00, 0xC0)
+
+	d, got := decodeAll(t, data)
+	if d.Len() != -1 || len(got) != 1 || got[0] != [2]float64{0.5, 0.5} {
+		t.Errorf("Expected an unknown length and one sample, got length %d and %v", d.Len(), got)
+	}
+}
+
+// An empty data chunk followed by other chunks is empty, not unknown
+func Test_WAVDecoderEmpty(t *testing.T) {
+	data := riff(
+		chunk("fmt ", fmtChunk(wavFormatPCM, 2, 16, false)),
+		chunk("data", nil),
+		chunk("LIST", []byte("INFOISFT\x08\x00\x00\x00fv1emu\x00\x00")))
+
+	d, got := decodeAll(t, data)
+	if d.Len() != 0 || len(got) != 0 {
+		t.Errorf("Expected no samples, got length %d and %v", d.Len(), got)
+	}
+}
+
+func Test_WAVDecoderErrors(t *testing.T) {
+	cases := map[string][]byte{
+		"not a WAV file": []byte("RIFF\x04\x00\x00\x00AVI "),
+		"empty":          nil,
+		"no fmt":         riff(chunk("data", []byte{0, 0})),
+		"no data":        riff(chunk("fmt ", fmtChunk(wavFormatPCM, 2, 16, false))),
+		"ADPCM":          riff(chunk("fmt ", fmtChunk(0x0002, 2, 4, false)), chunk("data", []byte{0, 0})),
+		"48 bit float":   riff(chunk("fmt ", fmtChunk(wavFormatFloat, 2, 48, false)), chunk("data", []byte{0, 0})),
+		"short fmt":      riff(chunk("fmt ", []byte{1, 0, 2, 0}), chunk("data", []byte{0, 0})),
+		"truncated":      riff(chunk("fmt ", fmtChunk(wavFormatPCM, 2, 16, false)))[:30],
+	}
+	for name, data := range cases {
+		if _, err := NewWAVDecoder(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: Expected an error", name)
+		}
+	}
+
+	if _, err := ReadWAV(filepath.Join(t.TempDir(), "missing.wav")); err == nil {
+		t.Errorf("Expected a missing file to fail")
+	}
+}
+
+func Test_ReadWAV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "in.wav")
+	data := riff(chunk("fmt ", fmtChunk(wavFormatPCM, 2, 16, false)), chunk("data", []byte{0x00, 0x40, 0x00, 0xC0}))
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ReadWAV(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make([][2]float64, 10)
+	if n, ok := d.Stream(samples); n != 1 || !ok || samples[0] != [2]float64{0.5, -0.5} {
+		t.Errorf("Expected one sample {0.5, -0.5}, got %d samples %v", n, samples[:n])
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
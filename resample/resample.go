@@ -0,0 +1,273 @@
+package resample
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/faiface/beep"
+)
+
+/**
+  A band-limited (windowed sinc) sample rate converter for the ADC
+  and DAC boundaries, ie. between the rate of the audio files and the
+  sample rate given by the FV-1 clock. Unlike beep.Resample() the
+  kernel is a low-pass filter at the lower of the two Nyquist
+  frequencies, so content the chip can't represent is removed instead
+  of aliased.
+
+  The Resampler is push based: samples are written with Write() and
+  the converted samples read with Read() when enough input has been
+  written. Streamer wraps a Resampler around a beep.Streamer.
+
+  	r := resample.NewResampler(48000, 32768)
+  	r.Write(in)
+  	n := r.Read(out)
+  	...
+  	r.End()
+  	n = r.Read(out)
+
+  The rates can be changed while running, also gradually like a clock
+  being modulated. SetRates() changes them from the next output
+  sample and ChangeRates() from the next input sample.
+*/
+
+const (
+	// Zero crossings of the sinc on each side of the center at a
+	// ratio of 1:1. Gives a transition band of about 2.5% of the
+	// sample rate.
+	halfWidth = 32
+
+	// Steps per zero crossing in the kernel table
+	tableSteps = 512
+
+	// Kaiser window parameter. Gives about 80 dB of stopband
+	// attenuation.
+	kaiserBeta = 8.0
+
+	// The cutoff relative to the lower Nyquist frequency, so the
+	// transition band ends at Nyquist
+	rolloff = 0.95
+)
+
+// The right half of the windowed sinc, 'tableSteps' values per zero
+// crossing. One extra value for the interpolation at the end.
+var kernel = makeKernel()
+
+func makeKernel() []float64 {
+	k := make([]float64, halfWidth*tableSteps+2)
+	for i := range k {
+		u := float64(i) / tableSteps
+		if u > halfWidth {
+			break
+		}
+		k[i] = sinc(u) * kaiser(u/halfWidth)
+	}
+	return k
+}
+
+func sinc(u float64) float64 {
+	if u == 0 {
+		return 1.0
+	}
+	return math.Sin(math.Pi*u) / (math.Pi * u)
+}
+
+// Kaiser window for r in [-1 .. 1]
+func kaiser(r float64) float64 {
+	return bessel0(kaiserBeta*math.Sqrt(1-r*r)) / bessel0(kaiserBeta)
+}
+
+// Modified Bessel function of the first kind, order 0
+func bessel0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 50; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < sum*1e-16 {
+			break
+		}
+	}
+	return sum
+}
+
+// The kernel at 'u' zero crossings from the center
+func kernelAt(u float64) float64 {
+	u = math.Abs(u) * tableSteps
+	i := int(u)
+	if i >= halfWidth*tableSteps {
+		return 0
+	}
+	frac := u - float64(i)
+	return kernel[i] + (kernel[i+1]-kernel[i])*frac
+}
+
+type Resampler struct {
+	ratio  float64 // Input samples per output sample
+	cutoff float64 // Relative to the input Nyquist frequency
+	taps   int     // Input samples used on each side of an output sample
+
+	buf      [][2]float64 // Input samples from 'bufStart'
+	bufStart int64        // The input sample number of buf[0]
+	written  int64        // The number of input samples written
+	pos      float64      // The input position of the next output sample
+	ended    bool
+
+	changes []rateChange // Queued by ChangeRates()
+}
+
+type rateChange struct {
+	from     int64 // The input sample number where it starts
+	fromRate float64
+	toRate   float64
+}
+
+func NewResampler(fromRate float64, toRate float64) *Resampler {
+	r := new(Resampler)
+	r.SetRates(fromRate, toRate)
+	return r
+}
+
+// Change the conversion from the next output sample. Panics if a rate
+// is not positive.
+func (r *Resampler) SetRates(fromRate float64, toRate float64) {
+	if fromRate <= 0 || toRate <= 0 {
+		panic(fmt.Sprintf("Invalid sample rates %f -> %f", fromRate, toRate))
+	}
+	r.ratio = fromRate / toRate
+	r.cutoff = math.Min(1.0, toRate/fromRate) * rolloff
+	r.taps = int(math.Ceil(halfWidth / r.cutoff))
+}
+
+// Change the conversion from the next input sample written, ie. for
+// the output made from the input written after this call. Used when
+// the rate follows the input, like the DAC of a modulated clock where
+// the output lags the input written by the length of the filter.
+func (r *Resampler) ChangeRates(fromRate float64, toRate float64) {
+	if fromRate <= 0 || toRate <= 0 {
+		panic(fmt.Sprintf("Invalid sample rates %f -> %f", fromRate, toRate))
+	}
+	r.changes = append(r.changes, rateChange{from: r.written, fromRate: fromRate, toRate: toRate})
+}
+
+// Input samples per output sample
+func (r *Resampler) Ratio() float64 {
+	return r.ratio
+}
+
+// Add input samples. Must not be called after End().
+func (r *Resampler) Write(in [][2]float64) {
+	r.buf = append(r.buf, in...)
+	r.written += int64(len(in))
+}
+
+// No more input. The last output samples are made with silence after
+// the input, and Read() returns as many samples as the input covers.
+func (r *Resampler) End() {
+	r.ended = true
+}
+
+// The input sample 'n', which is silent outside the written input
+func (r *Resampler) input(n int64) [2]float64 {
+	if n < r.bufStart || n >= r.written {
+		return [2]float64{}
+	}
+	return r.buf[n-r.bufStart]
+}
+
+// Move to the next output sample. The part of the step after a rate
+// change queued by ChangeRates() is taken at the new rate.
+func (r *Resampler) advance() {
+	next := r.pos + r.ratio
+	for len(r.changes) > 0 && next >= float64(r.changes[0].from) {
+		from := math.Max(float64(r.changes[0].from), r.pos)
+		oldRatio := r.ratio
+		r.SetRates(r.changes[0].fromRate, r.changes[0].toRate)
+		r.changes = r.changes[1:]
+		next = from + (next-from)*r.ratio/oldRatio
+	}
+	r.pos = next
+}
+
+// Convert as many samples as the input written so far allows, at most
+// len(out). Returns the number of samples written to 'out'.
+func (r *Resampler) Read(out [][2]float64) int {
+	n := 0
+	for n < len(out) {
+		for len(r.changes) > 0 && r.pos >= float64(r.changes[0].from) {
+			r.SetRates(r.changes[0].fromRate, r.changes[0].toRate)
+			r.changes = r.changes[1:]
+		}
+
+		center := int64(math.Floor(r.pos))
+		if r.ended {
+			if r.pos >= float64(r.written) {
+				break
+			}
+		} else if center+int64(r.taps) >= r.written {
+			break // Needs more input
+		}
+
+		var sum [2]float64
+		for i := center - int64(r.taps) + 1; i <= center+int64(r.taps); i++ {
+			h := kernelAt((r.pos - float64(i)) * r.cutoff)
+			x := r.input(i)
+			sum[0] += x[0] * h
+			sum[1] += x[1] * h
+		}
+		out[n] = [2]float64{sum[0] * r.cutoff, sum[1] * r.cutoff}
+		n++
+		r.advance()
+	}
+
+	// Drop the input which is not needed anymore. The slice is only
+	// copied when more than half of it is unused.
+	first := int64(math.Floor(r.pos)) - int64(r.taps) + 1
+	if drop := first - r.bufStart; drop > 0 && drop > int64(len(r.buf))/2 {
+		drop = min(drop, int64(len(r.buf)))
+		r.buf = append(r.buf[:0], r.buf[drop:]...)
+		r.bufStart += drop
+	}
+	return n
+}
+
+// Pulls samples from a beep.Streamer through a Resampler. Implements
+// beep.Streamer.
+type Streamer struct {
+	r     *Resampler
+	input beep.Streamer
+	buf   [][2]float64
+}
+
+func NewStreamer(input beep.Streamer, fromRate float64, toRate float64) *Streamer {
+	return &Streamer{r: NewResampler(fromRate, toRate), input: input, buf: make([][2]float64, 512)}
+}
+
+// The Resampler, for changing the rates
+func (s *Streamer) Resampler() *Resampler {
+	return s.r
+}
+
+func (s *Streamer) Stream(samples [][2]float64) (int, bool) {
+	n := 0
+	for n < len(samples) {
+		read := s.r.Read(samples[n:])
+		n += read
+		if n == len(samples) {
+			break
+		}
+		if s.r.ended {
+			break
+		}
+
+		in, ok := s.input.Stream(s.buf)
+		s.r.Write(s.buf[:in])
+		if !ok {
+			s.r.End()
+		}
+	}
+	return n, n > 0
+}
+
+func (s *Streamer) Err() error {
+	return s.input.Err()
+}
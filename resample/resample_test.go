@@ -0,0 +1,159 @@
+package resample
+
+import (
+	"math"
+	"testing"
+
+	"github.com/faiface/beep"
+)
+
+func sine(freq float64, rate float64, num int) [][2]float64 {
+	samples := make([][2]float64, num)
+	for i := range samples {
+		v := 0.5 * math.Sin(2*math.Pi*freq*float64(i)/rate)
+		samples[i] = [2]float64{v, -v}
+	}
+	return samples
+}
+
+func streamAll(s beep.Streamer) [][2]float64 {
+	var out [][2]float64
+	buf := make([][2]float64, 300)
+	for {
+		n, ok := s.Stream(buf)
+		if !ok {
+			break
+		}
+		out = append(out, buf[:n]...)
+	}
+	return out
+}
+
+// The peak of the left channel, skipping the edges
+func peak(samples [][2]float64) float64 {
+	p := 0.0
+	for _, s := range samples[len(samples)/4 : len(samples)*3/4] {
+		p = math.Max(p, math.Abs(s[0]))
+	}
+	return p
+}
+
+func Test_Resample(t *testing.T) {
+	type testCase struct {
+		from, to float64
+	}
+	for _, c := range []testCase{{48000, 32768}, {32768, 44100}, {44100, 44100}} {
+		in := sine(1000, c.from, int(c.from/2))
+		out := streamAll(NewStreamer(beep.StreamerFunc(func(samples [][2]float64) (int, bool) {
+			n := copy(samples, in)
+			in = in[n:]
+			return n, n > 0
+		}), c.from, c.to))
+
+		expectedLen := int(math.Ceil(c.to / 2))
+		if len(out) != expectedLen {
+			t.Errorf("%.0f -> %.0f: Expected %d samples, got %d", c.from, c.to, expectedLen, len(out))
+		}
+
+		// A 1kHz sine must come out as the same sine at the new rate
+		expected := sine(1000, c.to, len(out))
+		maxErr := 0.0
+		for i := len(out) / 4; i < len(out)*3/4; i++ {
+			maxErr = math.Max(maxErr, math.Abs(out[i][0]-expected[i][0]))
+			maxErr = math.Max(maxErr, math.Abs(out[i][1]-expected[i][1]))
+		}
+		if maxErr > 1e-3 {
+			t.Errorf("%.0f -> %.0f: Expected the 1kHz sine, max error was %g", c.from, c.to, maxErr)
+		}
+	}
+}
+
+// Content above the Nyquist frequency of the new rate must be removed
+// instead of folded back
+func Test_ResampleAntiAliasing(t *testing.T) {
+	r := NewResampler(48000, 32768)
+	r.Write(sine(20000, 48000, 24000))
+	r.End()
+	out := make([][2]float64, 20000)
+	n := r.Read(out)
+	if p := peak(out[:n]); p > 0.5*1e-3 {
+		t.Errorf("Expected a 20kHz tone to be attenuated by at least 60 dB, the peak is %g", p)
+	}
+}
+
+// Output is only made when there is enough input, and the rates can
+// be changed while running
+func Test_ResamplerPush(t *testing.T) {
+	r := NewResampler(32768, 32768)
+	out := make([][2]float64, 100)
+	r.Write(make([][2]float64, 10))
+	if n := r.Read(out); n != 0 {
+		t.Errorf("Expected no output before %d samples of lookahead, got %d", r.taps, n)
+	}
+	r.Write(make([][2]float64, 100))
+	first := 110 - r.taps
+	if n := r.Read(out); n != first {
+		t.Errorf("Expected %d samples, got %d", first, n)
+	}
+
+	r.SetRates(32768, 16384)
+	if r.Ratio() != 2.0 {
+		t.Errorf("Expected a ratio of 2, got %f", r.Ratio())
+	}
+	r.End()
+	// The rest of the input at half the rate
+	if n := r.Read(out); n != (110-first+1)/2 {
+		t.Errorf("Expected %d samples after End(), got %d", (110-first+1)/2, n)
+	}
+	if n := r.Read(out); n != 0 {
+		t.Errorf("Expected no more samples, got %d", n)
+	}
+}
+
+// A clock modulated at both the ADC and the DAC, like the emulator
+// does with a clock script, must give back the input
+func Test_ResampleModulatedClock(t *testing.T) {
+	in := sine(1000, 48000, 24000)
+	src := in
+	adc := NewStreamer(beep.StreamerFunc(func(samples [][2]float64) (int, bool) {
+		n := copy(samples, src)
+		src = src[n:]
+		return n, n > 0
+	}), 48000, 20000)
+	dac := NewResampler(20000, 48000)
+
+	var out [][2]float64
+	block := make([][2]float64, 32)
+	buf := make([][2]float64, 256)
+	clockTime := 0.0
+	for {
+		clock := 20000 + 8000*math.Sin(2*math.Pi*3*clockTime)
+		adc.Resampler().SetRates(48000, clock)
+		dac.ChangeRates(clock, 48000)
+
+		n, ok := adc.Stream(block)
+		if !ok {
+			break
+		}
+		clockTime += float64(n) / clock
+		dac.Write(block[:n])
+		for m := dac.Read(buf); m > 0; m = dac.Read(buf) {
+			out = append(out, buf[:m]...)
+		}
+	}
+	dac.End()
+	for m := dac.Read(buf); m > 0; m = dac.Read(buf) {
+		out = append(out, buf[:m]...)
+	}
+
+	if len(out) < len(in) || len(out) > len(in)+3 {
+		t.Errorf("Expected %d samples, got %d", len(in), len(out))
+	}
+	maxErr := 0.0
+	for i := 1000; i < len(in)-1000; i++ {
+		maxErr = math.Max(maxErr, math.Abs(out[i][0]-in[i][0]))
+	}
+	if maxErr > 1e-3 {
+		t.Errorf("Expected the input back, max error was %g", maxErr)
+	}
+}
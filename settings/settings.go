@@ -6,12 +6,25 @@ var InputWav = "input.wav"
 var OutputWav = "output.wav"
 var InFilename = ""
 
+// Write the loaded program(s) to this BIN/HEX file and exit
+var OutputProgram = ""
+
+// Sample format of the output file: s16, s24, s32 or f32. Empty
+// means the same precision as the input.
+var OutputFormat = ""
+
+// Add TPDF dither when writing integer sample formats
+var Dither = false
+
 // Stream result to speaker?
 var Stream = false
 
 // Do a code printout
 var PrintCode = true
 
+// Guess the delay memory layout (MEM blocks) for BIN/HEX programs
+var InferMemoryLayout = true
+
 // Potensiometer values
 var Pot0Value = 0.5
 var Pot1Value = 0.5
@@ -27,9 +40,22 @@ var Profiler = false
 var SampleRate = 44100.0
 
 // Internal clock speed of the "chip". Usually 32768.0 but we'll match
-// the samplerate as this is more convenient.
+// the samplerate as this is more convenient. Only used when
+// AccurateClock is set, the LFOs step once per processed sample.
 var ClockFrequency = 44100.0
 
+// The crystal frequency of a real FV-1
+const ChipClockFrequency = 32768.0
+
+// Run the DSP at ClockFrequency like the real chip, with the input
+// resampled from SampleRate and the output resampled back. Delay
+// times and LFO speeds then match the hardware for any input rate.
+var AccurateClock = false
+
+// A script with the clock frequency over time, see the 'clock'
+// package. Implies AccurateClock.
+var ClockScript = ""
+
 // Trail samples
 var TrailSeconds = 0.0
 
@@ -53,6 +79,14 @@ var PreGain = 1.0
 // Post processing gain for output audio
 var PostGain = 1.0
 
+// Model the codec around the DSP, see the 'codec' package. A zero
+// value switches that stage off.
+var Codec = false
+var CodecBandLimit = 15000.0      // Hz
+var CodecADCBits = 24             // Bits
+var CodecReconstruction = 15000.0 // Hz
+var CodecDCBlock = 10.0           // Hz
+
 // The simulator uses 32bits fixed floats but the FV-1 uses 24bits
 // floats. We will therefore clamp all values to 24 bits. However one
 // might want to detect when a register or DAC reaches it's limits to
@@ -61,6 +95,11 @@ var PostGain = 1.0
 // be highlighted in the debugger.
 var Disable24BitsClamping = false
 
+// Store the delay memory in the 14-bit floating point format of the
+// FV-1 (S+10+3) instead of the full 24 bits. Gives the noise floor
+// of the real chip.
+var FloatDelayRAM = false
+
 // Write the result value for a register for each sample to a CSV file
 // Default filename will be 'reg-<NUM>.csv'. Ignored if value is < 0.
 var WriteRegisterToCSV = -1
@@ -0,0 +1,468 @@
+package transpile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/settings"
+)
+
+// Same runtime as for Go. Signed overflow is undefined in C so the
+// wrap-around of the emulator's int32 arithmetic is done on unsigned
+// values. '@' is replaced by the prefix for the public names.
+const cRuntime = `
+#include <math.h>
+#include <stdint.h>
+
+#define CLAMP24 %d
+#define ALLOW_ALL_CHO_RDAL_FLAGS %d
+#define FLOAT_RAM %d
+
+#define SINE_AMPLITUDE ((1 << 23) - (1 << 8))
+
+#define CHO_COS   0x1
+#define CHO_REG   0x2
+#define CHO_COMPC 0x4
+#define CHO_COMPA 0x8
+#define CHO_RPTR2 0x10
+#define CHO_NA    0x20
+
+/* The complete state of the FV-1. Registers are S.23 fixed point
+   numbers stored in the lower 24 bits. */
+typedef struct @state {
+	int32_t regs[64];
+	int32_t acc;
+	int32_t pacc;
+	int32_t lr;
+	int run;
+	int ptr;
+	int32_t ram[32768];
+	struct { int32_t sin; int32_t cos; int32_t rate; } sin[2];
+	struct { int32_t value; int32_t freq; int32_t width; } ramp[2]; /* value in 1/16384 words */
+	int32_t lfoReg[6]; /* SIN0, SIN1, RMP0, RMP1, COS0, COS1 as stored by the REG flag */
+} @state;
+
+static inline int32_t fromFloat(double v) {
+	return (int32_t)(v * 8388608.0);
+}
+
+static inline int32_t fromClampedFloat(double v) {
+	if (v >= 1.0) {
+		v = 0.99999;
+	}
+	if (v < -1.0) {
+		v = -1.0;
+	}
+	return fromFloat(v);
+}
+
+static inline int32_t saturate(int64_t v) {
+	if (v > 0x7fffff) {
+		return 0x7fffff;
+	} else if (v < -0x800000) {
+		return -0x800000;
+	}
+	return (int32_t)v;
+}
+
+static inline double toFloat(int32_t v) {
+	return (double)v / 8388608.0;
+}
+
+/* Set POT0, POT1 or POT2 to a value between 0 and 1.0 */
+void @set_pot(@state *s, int pot, double value) {
+	s->regs[%d + pot] = fromClampedFloat(value);
+}
+
+void @init(@state *s) {
+	*s = (@state){0};
+	s->sin[0].cos = SINE_AMPLITUDE;
+	s->sin[1].cos = SINE_AMPLITUDE;
+	s->ramp[0].width = 512;
+	s->ramp[1].width = 512;
+	@set_pot(s, 0, %s);
+	@set_pot(s, 1, %s);
+	@set_pot(s, 2, %s);
+	s->regs[%d] = 512;
+	s->regs[%d] = 512;
+}
+
+static inline int32_t clamp(int32_t v) {
+	if (!CLAMP24) {
+		return v;
+	}
+	if (v > 0x7fffff) {
+		return 0x7fffff;
+	} else if (v < -0x800000) {
+		return -0x800000;
+	}
+	return v;
+}
+
+static inline int32_t fxAdd(int32_t a, int32_t b) {
+	return clamp((int32_t)((uint32_t)a + (uint32_t)b));
+}
+
+static inline int32_t fxSub(int32_t a, int32_t b) {
+	return clamp((int32_t)((uint32_t)a - (uint32_t)b));
+}
+
+static inline int32_t fxMul(int32_t a, int32_t b) {
+	return clamp((int32_t)(((int64_t)a * (int64_t)b) >> 23));
+}
+
+static inline int32_t fxAbs(int32_t a) {
+	if (a < 0) {
+		return (int32_t)(0u - (uint32_t)a);
+	}
+	return a;
+}
+
+static inline int32_t notOp(int32_t a) {
+	return a & ~0x7FFFFFFF;
+}
+
+static inline int32_t readRAM(@state *s, int32_t addr) {
+	int32_t v = s->ram[(addr + s->ptr) & 0x7FFF];
+	return (int32_t)((uint32_t)v << 8) >> 8;
+}
+
+/* The 14-bit floating point format of the delay memory: sign, 3 bit
+   exponent and 10 bit mantissa */
+static inline uint16_t encodeRAMWord(int32_t v) {
+	uint16_t sign = 0;
+	uint32_t m = (uint32_t)v;
+	if (v < 0) {
+		sign = 1 << 13;
+		m = (uint32_t)(-(int64_t)v);
+	}
+	int len = 0;
+	while (len < 32 && (m >> len) != 0) {
+		len++;
+	}
+	int exp = len - 16 > 0 ? len - 16 : 0;
+	int shift = 6 + (exp > 1 ? exp - 1 : 0);
+	uint32_t mantissa = (m + ((1u << shift) >> 1)) >> shift;
+	if (exp > 0) {
+		mantissa -= 1 << 10;
+	}
+	if (mantissa == 1 << 10) {
+		exp++;
+		mantissa = 0;
+	}
+	if (exp > 7) {
+		exp = 7;
+		mantissa = (1 << 10) - 1;
+	}
+	return (uint16_t)(sign | (exp << 10) | mantissa);
+}
+
+static inline int32_t decodeRAMWord(uint16_t w) {
+	int exp = (w >> 10) & 7;
+	int32_t m = w & ((1 << 10) - 1);
+	if (exp > 0) {
+		m = (m | (1 << 10)) << (exp - 1);
+	}
+	m <<= 6;
+	return (w & (1 << 13)) != 0 ? -m : m;
+}
+
+static inline void writeRAM(@state *s, int32_t addr, int32_t v) {
+#if FLOAT_RAM
+	v = decodeRAMWord(encodeRAMWord((int32_t)((uint32_t)v << 8) >> 8));
+#endif
+	s->ram[(addr + s->ptr) & 0x7FFF] = v & 0xFFFFFF;
+}
+
+static inline int32_t logOp(int32_t acc, int32_t c, int32_t d) {
+	acc = fxAbs(acc);
+	if (acc == 0) {
+		acc = 1; /* LOG(0) is the LOG of the smallest value */
+	}
+	double val = (log10(toFloat(acc)) / log10(2.0)) / 16.0;
+	val = val * toFloat(c);
+	val = val + toFloat(d);
+	return fromClampedFloat(val);
+}
+
+static inline int32_t expOp(int32_t acc, int32_t c, int32_t d) {
+	if (acc >= 0) {
+		return fxAdd(fxMul(0x7fffff, c), d);
+	}
+	return fxAdd(fxMul(fromFloat(exp2(toFloat(acc) * 16.0)), c), d);
+}
+
+static inline int32_t maxx(int32_t acc, int32_t reg, int32_t c) {
+	int32_t a = fxAbs(fxMul(reg, c));
+	int32_t b = fxAbs(acc);
+	return a > b ? a : b;
+}
+
+static inline void wrlx(@state *s, int regNo, int32_t c) {
+	int32_t acc = s->acc;
+	s->regs[regNo] = acc;
+	s->acc = fxAdd(fxMul(fxSub(s->pacc, acc), c), s->pacc);
+	s->pacc = acc;
+}
+
+static inline void wrhx(@state *s, int regNo, int32_t c) {
+	int32_t acc = s->acc;
+	s->regs[regNo] = acc;
+	s->acc = fxAdd(fxMul(acc, c), s->pacc);
+	s->pacc = acc;
+}
+
+static inline void setSinRate(@state *s, int lfo, int32_t acc) {
+	if (acc < 0) {
+		acc = (int32_t)(0u - (uint32_t)acc);
+	}
+	int32_t rate = acc >> (24 - 9 - 1);
+	if (rate > 511) {
+		rate = 511;
+	}
+	s->regs[lfo * 2] = rate;
+	s->sin[lfo].rate = rate;
+}
+
+static inline void setRampRate(@state *s, int lfo, int32_t acc) {
+	int32_t freq = (int16_t)(acc >> (24 - 16));
+	s->regs[4 + lfo * 2] = freq;
+	s->ramp[lfo].freq = freq;
+}
+
+static inline void setRampWidth(@state *s, int lfo, int32_t width) {
+	s->regs[5 + lfo * 2] = width;
+	s->ramp[lfo].width = width;
+	s->ramp[lfo].value &= (width << 14) - 1;
+}
+
+static inline void setRampRange(@state *s, int lfo, int32_t acc) {
+	static const int32_t amps[4] = {4096, 2048, 1024, 512};
+	setRampWidth(s, lfo, amps[3 - ((acc >> 21) & 3)]);
+}
+
+static inline void wlds(@state *s, int lfo, int32_t freq, int32_t amp) {
+	s->regs[lfo * 2] = freq;
+	s->regs[lfo * 2 + 1] = amp;
+	s->sin[lfo].rate = freq;
+}
+
+static inline void wldr(@state *s, int lfo, int32_t freq, int32_t amp) {
+	s->regs[4 + lfo * 2] = freq;
+	s->ramp[lfo].freq = freq;
+	setRampWidth(s, lfo, amp);
+}
+
+/* The LFOs take one step per sample */
+static inline void stepLFOs(@state *s) {
+	/* The ramp moves 'freq' / 16384 words and wraps at the end of its
+	   window */
+	for (int i = 0; i < 2; i++) {
+		s->ramp[i].value = (s->ramp[i].value - s->ramp[i].freq) & ((s->ramp[i].width << 14) - 1);
+	}
+
+	/* The sine LFO is two integrators feeding each other */
+	for (int i = 0; i < 2; i++) {
+		s->sin[i].sin = saturate(s->sin[i].sin + (((int64_t)s->sin[i].cos * s->sin[i].rate) >> 17));
+		s->sin[i].cos = saturate(s->sin[i].cos - (((int64_t)s->sin[i].sin * s->sin[i].rate) >> 17));
+	}
+}
+
+static inline int isSinLFO(int typ) {
+	return typ == 0 || typ == 1 || typ == 4 || typ == 5;
+}
+
+static inline double lfoValue(@state *s, int typ, int store) {
+	if (!store) {
+		return toFloat(s->lfoReg[typ]);
+	}
+
+	if (isSinLFO(typ)) {
+		int lfo = typ %% 4;
+		s->lfoReg[lfo] = s->sin[lfo].sin;
+		s->lfoReg[lfo + 4] = s->sin[lfo].cos;
+		return toFloat(s->lfoReg[typ]);
+	}
+
+	s->lfoReg[typ] = s->ramp[typ - 2].value >> 4;
+	return toFloat(s->lfoReg[typ]);
+}
+
+static inline double lfoRange(@state *s, double value, int typ) {
+	switch (typ) {
+	case 0:
+	case 4:
+		return value * (double)s->regs[1] / 32768.0;
+	case 1:
+	case 5:
+		return value * (double)s->regs[3] / 32768.0;
+	}
+	return value;
+}
+
+static inline double scaleLFO(@state *s, double value, int typ) {
+	return lfoRange(s, value, typ) * 8192.0;
+}
+
+static inline double rampRange(@state *s, int typ) {
+	return (double)s->regs[5 + (typ - 2) * 2] / 8192.0;
+}
+
+static inline double lfoPlusHalfCycle(@state *s, double lfo, int typ) {
+	double end = rampRange(s, typ);
+	lfo += end / 2.0;
+	if (lfo >= end) {
+		lfo -= end;
+	}
+	return lfo;
+}
+
+static inline double lfoFlags(@state *s, double lfo, int typ, int flags) {
+	if ((flags & CHO_RPTR2) != 0 && !isSinLFO(typ)) {
+		lfo = lfoPlusHalfCycle(s, lfo, typ);
+	}
+	if ((flags & CHO_COMPA) != 0) {
+		lfo = isSinLFO(typ) ? -lfo : rampRange(s, typ) - lfo;
+	}
+	return lfo;
+}
+
+static inline double xfade(@state *s, double lfo, int typ) {
+	double pos = lfo / rampRange(s, typ);
+	double triangle = 1.0 - fabs(2.0 * pos - 1.0);
+	return fmax(0.0, fmin(1.0, 2.0 * triangle - 0.5));
+}
+
+static inline void choRDA(@state *s, int32_t addr, int typ, int flags) {
+	if ((flags & CHO_COS) != 0) {
+		typ += 4;
+	}
+
+	double lfo = lfoFlags(s, lfoValue(s, typ, (flags & CHO_REG) != 0), typ, flags);
+
+	// The integer part of the offset moves the read and the fraction
+	// is the coefficient. NA reads the address itself with the
+	// crossfade as the coefficient.
+	double whole = 0.0;
+	double coeff;
+	if ((flags & CHO_NA) != 0) {
+		coeff = xfade(s, lfo, typ);
+	} else {
+		double offset = scaleLFO(s, lfo, typ);
+		whole = floor(offset);
+		coeff = offset - whole;
+	}
+	if ((flags & CHO_COMPC) != 0) {
+		coeff = 1.0 - coeff;
+	}
+	s->lr = readRAM(s, addr + (int32_t)whole);
+	s->acc = fxAdd(s->acc, fxMul(s->lr, fromFloat(coeff)));
+}
+
+static inline void choSOF(@state *s, int32_t d, int typ, int flags) {
+	if ((flags & CHO_COS) != 0) {
+		typ += 4;
+	}
+
+	double lfo = lfoRange(s, lfoValue(s, typ, (flags & CHO_REG) != 0), typ);
+	lfo = lfoFlags(s, lfo, typ, flags);
+
+	int32_t scale;
+	if ((flags & CHO_NA) != 0) {
+		double x = xfade(s, lfo, typ);
+		if ((flags & CHO_COMPC) != 0) {
+			x = 1.0 - x;
+		}
+		scale = fromFloat(x);
+	} else {
+		if ((flags & CHO_COMPC) != 0) {
+			lfo = 1.0 - lfo;
+		}
+		scale = fromFloat(lfo);
+	}
+	s->acc = fxAdd(fxMul(s->acc, scale), d);
+}
+
+static inline void choRDAL(@state *s, int typ, int flags) {
+	if (ALLOW_ALL_CHO_RDAL_FLAGS && (flags & CHO_COS) != 0) {
+		typ += 4;
+	}
+
+	double lfo = lfoRange(s, lfoValue(s, typ, (flags & CHO_REG) != 0), typ);
+	if (ALLOW_ALL_CHO_RDAL_FLAGS) {
+		lfo = lfoFlags(s, lfo, typ, flags);
+	}
+
+	if (ALLOW_ALL_CHO_RDAL_FLAGS && (flags & CHO_NA) != 0) {
+		double x = xfade(s, lfo, typ);
+		if ((flags & CHO_COMPC) != 0) {
+			x = 1.0 - x;
+		}
+		lfo = x;
+	} else if ((flags & CHO_COMPC) != 0) {
+		lfo = fmin(1.0, 1.0 - lfo);
+	}
+	s->acc = fromFloat(lfo);
+}
+`
+
+func cFloat(v float64) string {
+	str := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(str, ".e") {
+		str += ".0"
+	}
+	return str
+}
+
+// Returns the program as a C source file. Public names are prefixed
+// with 'prefix' and an underscore. 'source' is the name of the
+// program file, it is only used in the header.
+//
+// NOTE: The fixed point arithmetic is identical to the emulator, but
+// the LFOs, LOG and EXP uses the C library's sin(), cos(), log10() and
+// exp2() which may differ in the last bit from Go's.
+func ToC(ops []base.Op, prefix string, source string) ([]byte, error) {
+	if err := checkOps(ops); err != nil {
+		return nil, err
+	}
+
+	bool2int := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	var sb strings.Builder
+	sb.WriteString(header(source))
+	sb.WriteString(fmt.Sprintf(cRuntime,
+		bool2int(!settings.Disable24BitsClamping), bool2int(settings.AllowAllChoRdalFlags),
+		bool2int(settings.FloatDelayRAM), base.POT0,
+		cFloat(settings.Pot0Value), cFloat(settings.Pot1Value), cFloat(settings.Pot2Value),
+		base.RAMP0_RANGE, base.RAMP1_RANGE))
+
+	sb.WriteString(`
+/* Process one stereo sample. Input and output are in the range
+   [-1.0 .. 1.0>. */
+void @process(@state *s, double inLeft, double inRight, double *outLeft, double *outRight) {
+`)
+	sb.WriteString(fmt.Sprintf("\ts->regs[%d] = fromFloat(inLeft);\n\ts->regs[%d] = fromFloat(inRight);\n",
+		base.ADCL, base.ADCR))
+	sb.WriteString(programBody(ops, "s->"))
+	sb.WriteString(fmt.Sprintf(`
+	s->run = 1;
+	s->ptr -= 1;
+	if (s->ptr <= -32768) {
+		s->ptr = 0;
+	}
+	stepLFOs(s);
+
+	*outLeft = toFloat(s->regs[%d]);
+	*outRight = toFloat(s->regs[%d]);
+}
+`, base.DACL, base.DACR))
+
+	return []byte(strings.ReplaceAll(sb.String(), "@", prefix+"_")), nil
+}
@@ -0,0 +1,472 @@
+package transpile
+
+import (
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/settings"
+)
+
+// The runtime is a copy of the emulator's semantics (see the dsp
+// package) written for plain int32 registers. Keep the two in sync.
+const goRuntime = `
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	clamp24            = %t
+	allowAllCHORDALFlags = %t
+	floatRAM             = %t
+)
+
+const (
+	choCOS   = 0x1
+	choREG   = 0x2
+	choCOMPC = 0x4
+	choCOMPA = 0x8
+	choRPTR2 = 0x10
+	choNA    = 0x20
+)
+
+// The LFOs take one step per sample. The sine LFO is two integrators
+// feeding each other.
+type sineLFO struct {
+	sin  int32
+	cos  int32
+	rate int32
+}
+
+const sineAmplitude = (1 << 23) - (1 << 8)
+
+type rampLFO struct {
+	value int32 // In 1/16384 words
+	freq  int32
+	width int32
+}
+
+// The complete state of the FV-1. Registers are S.23 fixed point
+// numbers stored in the lower 24 bits.
+type State struct {
+	regs   [64]int32
+	acc    int32
+	pacc   int32
+	lr     int32
+	run    bool
+	ptr    int
+	ram    [32768]int32
+	sin    [2]sineLFO
+	ramp   [2]rampLFO
+	lfoReg [6]int32 // SIN0, SIN1, RMP0, RMP1, COS0, COS1 as stored by the REG flag
+}
+
+func NewState() *State {
+	s := new(State)
+	s.sin[0].cos = sineAmplitude
+	s.sin[1].cos = sineAmplitude
+	s.ramp[0].width = 512
+	s.ramp[1].width = 512
+	s.SetPot(0, %s)
+	s.SetPot(1, %s)
+	s.SetPot(2, %s)
+	s.regs[%d] = 512
+	s.regs[%d] = 512
+	return s
+}
+
+// Set POT0, POT1 or POT2 to a value between 0 and 1.0
+func (s *State) SetPot(pot int, value float64) {
+	if value >= 1.0 {
+		value = 0.99999
+	}
+	if value < -1.0 {
+		value = -1.0
+	}
+	s.regs[%d+pot] = fromFloat(value)
+}
+
+func clamp(v int32) int32 {
+	if !clamp24 {
+		return v
+	}
+	if v > 0x7fffff {
+		return 0x7fffff
+	} else if v < -0x800000 {
+		return -0x800000
+	}
+	return v
+}
+
+func fxAdd(a int32, b int32) int32 {
+	return clamp(a + b)
+}
+
+func fxSub(a int32, b int32) int32 {
+	return clamp(a - b)
+}
+
+func fxMul(a int32, b int32) int32 {
+	return clamp(int32((int64(a) * int64(b)) >> 23))
+}
+
+func fxAbs(a int32) int32 {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func notOp(a int32) int32 {
+	return a &^ 0x7FFFFFFF
+}
+
+func fromFloat(v float64) int32 {
+	return int32(v * math.Pow(2, 23))
+}
+
+func fromClampedFloat(v float64) int32 {
+	if v >= 1.0 {
+		v = 0.99999
+	}
+	if v < -1.0 {
+		v = -1.0
+	}
+	return fromFloat(v)
+}
+
+func toFloat(v int32) float64 {
+	return float64(v) / (1 << 23)
+}
+
+func readRAM(s *State, addr int32) int32 {
+	v := s.ram[(int(addr)+s.ptr)&0x7FFF]
+	return (v << 8) >> 8
+}
+
+func writeRAM(s *State, addr int32, v int32) {
+	if floatRAM {
+		v = decodeRAMWord(encodeRAMWord((v << 8) >> 8))
+	}
+	s.ram[(int(addr)+s.ptr)&0x7FFF] = v & 0xFFFFFF
+}
+
+// The 14-bit floating point format of the delay memory: sign, 3 bit
+// exponent and 10 bit mantissa
+func encodeRAMWord(v int32) uint16 {
+	var sign uint16
+	m := uint32(v)
+	if v < 0 {
+		sign = 1 << 13
+		m = uint32(-int64(v))
+	}
+	exp := max(0, bits.Len32(m)-16)
+	shift := 6 + max(0, exp-1)
+	mantissa := (m + (1 << shift >> 1)) >> shift
+	if exp > 0 {
+		mantissa -= 1 << 10
+	}
+	if mantissa == 1<<10 {
+		exp++
+		mantissa = 0
+	}
+	if exp > 7 {
+		exp = 7
+		mantissa = 1<<10 - 1
+	}
+	return sign | uint16(exp)<<10 | uint16(mantissa)
+}
+
+func decodeRAMWord(w uint16) int32 {
+	exp := int(w>>10) & 7
+	m := int32(w & (1<<10 - 1))
+	if exp > 0 {
+		m = (m | 1<<10) << (exp - 1)
+	}
+	m <<= 6
+	if w&(1<<13) != 0 {
+		return -m
+	}
+	return m
+}
+
+func logOp(acc int32, c int32, d int32) int32 {
+	acc = max(fxAbs(acc), 1) // LOG(0) is the LOG of the smallest value
+	val := (math.Log10(toFloat(acc)) / math.Log10(2.0)) / 16.0
+	val = val * toFloat(c)
+	val = val + toFloat(d)
+	return fromClampedFloat(val)
+}
+
+func expOp(acc int32, c int32, d int32) int32 {
+	if acc >= 0 {
+		return fxAdd(fxMul(0x7fffff, c), d)
+	}
+	return fxAdd(fxMul(fromFloat(math.Exp2(toFloat(acc)*16.0)), c), d)
+}
+
+func maxx(acc int32, reg int32, c int32) int32 {
+	a := fxAbs(fxMul(reg, c))
+	b := fxAbs(acc)
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func wrlx(s *State, regNo int, c int32) {
+	acc := s.acc
+	s.regs[regNo] = acc
+	s.acc = fxAdd(fxMul(fxSub(s.pacc, acc), c), s.pacc)
+	s.pacc = acc
+}
+
+func wrhx(s *State, regNo int, c int32) {
+	acc := s.acc
+	s.regs[regNo] = acc
+	s.acc = fxAdd(fxMul(acc, c), s.pacc)
+	s.pacc = acc
+}
+
+func setSinRate(s *State, lfo int, acc int32) {
+	if acc < 0 {
+		acc = -acc
+	}
+	rate := min(acc>>(24-9-1), 511)
+	s.regs[lfo*2] = rate
+	s.sin[lfo].rate = rate
+}
+
+func setRampRate(s *State, lfo int, acc int32) {
+	freq := int32(int16(acc >> (24 - 16)))
+	s.regs[4+lfo*2] = freq
+	s.ramp[lfo].freq = freq
+}
+
+func setRampRange(s *State, lfo int, acc int32) {
+	amps := [4]int32{4096, 2048, 1024, 512}
+	setRampWidth(s, lfo, amps[3-(acc>>21)&3])
+}
+
+func setRampWidth(s *State, lfo int, width int32) {
+	s.regs[5+lfo*2] = width
+	s.ramp[lfo].width = width
+	s.ramp[lfo].value &= width<<14 - 1
+}
+
+func wlds(s *State, lfo int, freq int32, amp int32) {
+	s.regs[lfo*2] = freq
+	s.regs[lfo*2+1] = amp
+	s.sin[lfo].rate = freq
+}
+
+func wldr(s *State, lfo int, freq int32, amp int32) {
+	s.regs[4+lfo*2] = freq
+	s.ramp[lfo].freq = freq
+	setRampWidth(s, lfo, amp)
+}
+
+func stepLFOs(s *State) {
+	for i := range s.ramp {
+		r := &s.ramp[i]
+		r.value = (r.value - r.freq) & (r.width<<14 - 1)
+	}
+
+	for i := range s.sin {
+		l := &s.sin[i]
+		l.sin = saturate(int64(l.sin) + (int64(l.cos)*int64(l.rate))>>17)
+		l.cos = saturate(int64(l.cos) - (int64(l.sin)*int64(l.rate))>>17)
+	}
+}
+
+func saturate(v int64) int32 {
+	return int32(max(-(1 << 23), min((1<<23)-1, v)))
+}
+
+func isSinLFO(typ int) bool {
+	return typ == 0 || typ == 1 || typ == 4 || typ == 5
+}
+
+func lfoValue(s *State, typ int, store bool) float64 {
+	if !store {
+		return toFloat(s.lfoReg[typ])
+	}
+
+	if isSinLFO(typ) {
+		lfo := typ %% 4
+		s.lfoReg[lfo] = s.sin[lfo].sin
+		s.lfoReg[lfo+4] = s.sin[lfo].cos
+		return toFloat(s.lfoReg[typ])
+	}
+
+	s.lfoReg[typ] = s.ramp[typ-2].value >> 4
+	return toFloat(s.lfoReg[typ])
+}
+
+func lfoPlusHalfCycle(s *State, lfo float64, typ int) float64 {
+	end := rampRange(s, typ)
+	lfo += end / 2.0
+	if lfo >= end {
+		lfo -= end
+	}
+	return lfo
+}
+
+func lfoFlags(s *State, lfo float64, typ int, flags int) float64 {
+	if flags&choRPTR2 != 0 && !isSinLFO(typ) {
+		lfo = lfoPlusHalfCycle(s, lfo, typ)
+	}
+	if flags&choCOMPA != 0 {
+		if isSinLFO(typ) {
+			lfo = -lfo
+		} else {
+			lfo = rampRange(s, typ) - lfo
+		}
+	}
+	return lfo
+}
+
+func lfoRange(s *State, value float64, typ int) float64 {
+	switch typ {
+	case 0, 4:
+		return value * float64(s.regs[1]) / 32768.0
+	case 1, 5:
+		return value * float64(s.regs[3]) / 32768.0
+	}
+	return value
+}
+
+func scaleLFO(s *State, value float64, typ int) float64 {
+	return lfoRange(s, value, typ) * 8192.0
+}
+
+func rampRange(s *State, typ int) float64 {
+	return float64(s.regs[5+(typ-2)*2]) / 8192.0
+}
+
+func xfade(s *State, lfo float64, typ int) float64 {
+	pos := lfo / rampRange(s, typ)
+	triangle := 1.0 - math.Abs(2.0*pos-1.0)
+	return math.Max(0.0, math.Min(1.0, 2.0*triangle-0.5))
+}
+
+func choRDA(s *State, addr int32, typ int, flags int) {
+	if flags&choCOS != 0 {
+		typ += 4
+	}
+
+	lfo := lfoFlags(s, lfoValue(s, typ, flags&choREG != 0), typ, flags)
+
+	// The integer part of the offset moves the read and the fraction
+	// is the coefficient. NA reads the address itself with the
+	// crossfade as the coefficient.
+	var whole, coeff float64
+	if flags&choNA != 0 {
+		coeff = xfade(s, lfo, typ)
+	} else {
+		offset := scaleLFO(s, lfo, typ)
+		whole = math.Floor(offset)
+		coeff = offset - whole
+	}
+	if flags&choCOMPC != 0 {
+		coeff = 1.0 - coeff
+	}
+	s.lr = readRAM(s, addr+int32(whole))
+	s.acc = fxAdd(s.acc, fxMul(s.lr, fromFloat(coeff)))
+}
+
+func choSOF(s *State, d int32, typ int, flags int) {
+	if flags&choCOS != 0 {
+		typ += 4
+	}
+
+	lfo := lfoRange(s, lfoValue(s, typ, flags&choREG != 0), typ)
+	lfo = lfoFlags(s, lfo, typ, flags)
+
+	var scale int32
+	if flags&choNA != 0 {
+		x := xfade(s, lfo, typ)
+		if flags&choCOMPC != 0 {
+			x = 1.0 - x
+		}
+		scale = fromFloat(x)
+	} else {
+		if flags&choCOMPC != 0 {
+			lfo = 1.0 - lfo
+		}
+		scale = fromFloat(lfo)
+	}
+	s.acc = fxAdd(fxMul(s.acc, scale), d)
+}
+
+func choRDAL(s *State, typ int, flags int) {
+	if allowAllCHORDALFlags && flags&choCOS != 0 {
+		typ += 4
+	}
+
+	lfo := lfoRange(s, lfoValue(s, typ, flags&choREG != 0), typ)
+	if allowAllCHORDALFlags {
+		lfo = lfoFlags(s, lfo, typ, flags)
+	}
+
+	if allowAllCHORDALFlags && flags&choNA != 0 {
+		x := xfade(s, lfo, typ)
+		if flags&choCOMPC != 0 {
+			x = 1.0 - x
+		}
+		lfo = x
+	} else if flags&choCOMPC != 0 {
+		lfo = min(1.0, 1.0-lfo)
+	}
+	s.acc = fromFloat(lfo)
+}
+`
+
+func goFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Returns the program as a Go source file in package 'pkg'. 'source'
+// is the name of the program file, it is only used in the header.
+func ToGo(ops []base.Op, pkg string, source string) ([]byte, error) {
+	if err := checkOps(ops); err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(header(source))
+	sb.WriteString(fmt.Sprintf("\npackage %s\n", pkg))
+	sb.WriteString(fmt.Sprintf(goRuntime,
+		!settings.Disable24BitsClamping, settings.AllowAllChoRdalFlags, settings.FloatDelayRAM,
+		goFloat(settings.Pot0Value), goFloat(settings.Pot1Value), goFloat(settings.Pot2Value),
+		base.RAMP0_RANGE, base.RAMP1_RANGE, base.POT0))
+
+	sb.WriteString(`
+// Process one stereo sample. Input and output are in the range
+// [-1.0 .. 1.0>.
+func (s *State) Process(inLeft float64, inRight float64) (float64, float64) {
+`)
+	sb.WriteString(fmt.Sprintf("\ts.regs[%d] = fromFloat(inLeft)\n\ts.regs[%d] = fromFloat(inRight)\n",
+		base.ADCL, base.ADCR))
+	sb.WriteString(programBody(ops, "s."))
+	sb.WriteString(fmt.Sprintf(`
+	s.run = true
+	s.ptr -= 1
+	if s.ptr <= -32768 {
+		s.ptr = 0
+	}
+	stepLFOs(s)
+
+	return toFloat(s.regs[%d]), toFloat(s.regs[%d])
+}
+`, base.DACL, base.DACR))
+
+	code, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return []byte(sb.String()), fmt.Errorf("Generated Go code does not compile: %s", err)
+	}
+	return code, nil
+}
@@ -0,0 +1,238 @@
+package transpile
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/disasm"
+	"github.com/handegar/fv1emu/dsp"
+	"github.com/handegar/fv1emu/settings"
+)
+
+/**
+  Turns a decoded program into a standalone Go or C function which
+  processes one stereo sample exactly like 'dsp.ProcessSample()':
+  same fixed-point arithmetic and clamping, same delay RAM addressing,
+  same LFOs and the same SKP semantics. The generated code has no
+  dependencies besides the standard math library.
+
+  Each instruction is written as one statement block using a small set
+  of helper functions from the runtime which is part of the generated
+  file. The statements are valid in both languages; only the state
+  access ("s." or "s->") differs. SKP becomes a GOTO to a label in
+  front of the target instruction.
+
+  The settings which changes how the emulator behaves (24-bit clamping,
+  CHO RDAL flags, delay RAM format and pot values) are taken from the
+  current settings.
+*/
+
+// Fixed point constants are converted exactly like the emulator does
+func fixed(raw int32, intBits int, fracBits int) int32 {
+	return dsp.NewRegisterWithIntsAndFracs(raw, intBits, fracBits).Value
+}
+
+func isSinLFO(typ int) bool {
+	return typ == base.LFO_SIN0 || typ == base.LFO_SIN1
+}
+
+// Reject instructions the emulator would stop at (or assert on)
+func checkOps(ops []base.Op) error {
+	if len(ops) == 0 {
+		return errors.New("The program has no instructions")
+	}
+
+	for ip, op := range ops {
+		if _, found := dsp.OpcodeNumber(op.Name); !found || op.Name == "CHO <?>" {
+			return fmt.Errorf("Instruction %d (0x%08X) is not a valid instruction", ip, uint32(op.RawValue))
+		}
+		if !strings.HasPrefix(op.Name, "CHO ") {
+			continue
+		}
+
+		typ := int(op.Args[1].RawValue)
+		flags := int(op.Args[3].RawValue)
+		if op.Name == "CHO RDAL" && !settings.AllowAllChoRdalFlags {
+			continue // Only REG and COMPC are used
+		}
+		if flags&base.CHO_COS != 0 && !isSinLFO(typ) {
+			return fmt.Errorf("Instruction %d: Cannot use the COS flag with RAMP LFOs", ip)
+		}
+		if flags&(base.CHO_RPTR2|base.CHO_NA) != 0 && isSinLFO(typ) {
+			return fmt.Errorf("Instruction %d: Cannot use RPTR2 or NA with SIN LFOs", ip)
+		}
+	}
+	return nil
+}
+
+// Returns the target instruction of all SKP instructions. Targets
+// beyond the program are set to len(ops), ie. the end.
+func skipTargets(ops []base.Op) map[int]bool {
+	targets := make(map[int]bool)
+	for ip, op := range ops {
+		if op.Name == "SKP" {
+			targets[min(ip+1+int(op.Args[1].RawValue), len(ops))] = true
+		}
+	}
+	return targets
+}
+
+func label(target int, numOps int) string {
+	if target >= numOps {
+		return "end"
+	}
+	return fmt.Sprintf("L%d", target)
+}
+
+// The SKP condition, or "" if it always jumps
+func skipCondition(flags int) string {
+	var conds []string
+	if flags&base.SKP_RUN != 0 {
+		conds = append(conds, "S.run")
+	}
+	if flags&base.SKP_ZRC != 0 {
+		conds = append(conds, "(S.acc < 0) != (S.pacc < 0)")
+	}
+	if flags&base.SKP_ZRO != 0 {
+		conds = append(conds, "S.acc == 0")
+	}
+	if flags&base.SKP_GEZ != 0 {
+		conds = append(conds, "S.acc >= 0")
+	}
+	if flags&base.SKP_NEG != 0 {
+		conds = append(conds, "S.acc < 0")
+	}
+	return strings.Join(conds, " || ")
+}
+
+// The special cases for WRAX to the LFO registers
+func wraxRegister(regNo int) string {
+	switch regNo {
+	case base.SIN0_RATE, base.SIN1_RATE:
+		return fmt.Sprintf("setSinRate(s, %d, S.acc);", regNo/2)
+	case base.SIN0_RANGE, base.SIN1_RANGE:
+		return fmt.Sprintf("S.regs[%d] = S.acc >> 8;", regNo)
+	case base.RAMP0_RATE, base.RAMP1_RATE:
+		return fmt.Sprintf("setRampRate(s, %d, S.acc);", (regNo-base.RAMP0_RATE)/2)
+	case base.RAMP0_RANGE, base.RAMP1_RANGE:
+		return fmt.Sprintf("setRampRange(s, %d, S.acc);", (regNo-base.RAMP0_RANGE)/2)
+	}
+	return fmt.Sprintf("S.regs[%d] = S.acc;", regNo)
+}
+
+// The statements for one instruction, with "S." for the state access
+func instruction(op base.Op, ip int, numOps int) string {
+	reg := int(op.Args[0].RawValue)
+	c14 := func() int32 { return fixed(op.Args[2].RawValue, 1, 14) }
+
+	switch op.Name {
+	case "LOG", "EXP":
+		return fmt.Sprintf("S.pacc = S.acc; S.acc = %sOp(S.acc, %d, %d);", strings.ToLower(op.Name),
+			fixed(op.Args[1].RawValue, 1, 14), fixed(op.Args[0].RawValue, 0, 10))
+	case "SOF":
+		return fmt.Sprintf("S.pacc = S.acc; S.acc = fxAdd(fxMul(S.acc, %d), %d);",
+			fixed(op.Args[1].RawValue, 1, 14), fixed(op.Args[0].RawValue, 0, 10))
+	case "AND":
+		return fmt.Sprintf("S.pacc = S.acc; S.acc = S.acc & %d;", dsp.Extend24to32(op.Args[1].RawValue))
+	case "OR":
+		return fmt.Sprintf("S.pacc = S.acc; S.acc = S.acc | %d;", dsp.Extend24to32(op.Args[1].RawValue))
+	case "XOR":
+		return fmt.Sprintf("S.pacc = S.acc; S.acc = S.acc ^ %d;", dsp.Extend24to32(op.Args[1].RawValue))
+	case "CLR":
+		return "S.pacc = S.acc; S.acc = 0;"
+	case "NOT":
+		return "S.pacc = S.acc; S.acc = notOp(S.acc);"
+	case "SKP":
+		target := label(ip+1+int(op.Args[1].RawValue), numOps)
+		if cond := skipCondition(int(op.Args[2].RawValue)); cond != "" {
+			return fmt.Sprintf("if (%s) {\n\tgoto %s;\n}", cond, target)
+		}
+		return fmt.Sprintf("goto %s;", target)
+	case "NOP":
+		return ""
+	case "RDA":
+		return fmt.Sprintf("S.lr = readRAM(s, %d); S.pacc = S.acc; S.acc = fxAdd(S.acc, fxMul(S.lr, %d));",
+			op.Args[0].RawValue, fixed(op.Args[1].RawValue, 1, 9))
+	case "RMPA":
+		return fmt.Sprintf("S.lr = readRAM(s, S.regs[%d] >> 8); S.pacc = S.acc; S.acc = fxAdd(S.acc, fxMul(S.lr, %d));",
+			base.ADDR_PTR, fixed(op.Args[1].RawValue, 1, 9))
+	case "WRA":
+		return fmt.Sprintf("S.pacc = S.acc; writeRAM(s, %d, S.acc); S.acc = fxMul(S.acc, %d);",
+			op.Args[0].RawValue, fixed(op.Args[1].RawValue, 1, 9))
+	case "WRAP":
+		return fmt.Sprintf("S.pacc = S.acc; writeRAM(s, %d, S.acc); S.acc = fxAdd(fxMul(S.acc, %d), S.lr);",
+			op.Args[0].RawValue, fixed(op.Args[1].RawValue, 1, 9))
+	case "RDAX":
+		return fmt.Sprintf("S.pacc = S.acc; S.acc = fxAdd(S.acc, fxMul(S.regs[%d], %d));", reg, c14())
+	case "RDFX":
+		return fmt.Sprintf("S.pacc = S.acc; S.acc = fxAdd(fxMul(fxSub(S.acc, S.regs[%d]), %d), S.regs[%d]);",
+			reg, c14(), reg)
+	case "WRAX":
+		return fmt.Sprintf("S.pacc = S.acc; %s S.acc = fxMul(S.acc, %d);", wraxRegister(reg), c14())
+	case "MAXX":
+		return fmt.Sprintf("S.pacc = S.acc; S.acc = maxx(S.acc, S.regs[%d], %d);", reg, c14())
+	case "ABSA":
+		return "S.pacc = S.acc; S.acc = fxAbs(S.acc);"
+	case "MULX":
+		return fmt.Sprintf("S.pacc = S.acc; S.acc = fxMul(S.acc, S.regs[%d]);", reg)
+	case "LDAX":
+		return fmt.Sprintf("S.acc = S.regs[%d];", reg)
+	case "WRLX":
+		return fmt.Sprintf("wrlx(s, %d, %d);", reg, c14())
+	case "WRHX":
+		return fmt.Sprintf("wrhx(s, %d, %d);", reg, c14())
+	case "WLDS":
+		return fmt.Sprintf("wlds(s, %d, %d, %d);",
+			op.Args[2].RawValue, op.Args[1].RawValue, op.Args[0].RawValue)
+	case "WLDR":
+		freq := int32(int16(op.Args[2].RawValue))
+		if freq < -(1 << 14) { // Capped like the emulator does
+			freq = -(1 << 14) + 1
+		}
+		return fmt.Sprintf("wldr(s, %d, %d, %d);",
+			op.Args[3].RawValue, freq, base.RampAmpValues[op.Args[0].RawValue])
+	case "JAM":
+		return fmt.Sprintf("S.ramp[%d].value = 0;", op.Args[1].RawValue)
+	case "CHO RDA":
+		return fmt.Sprintf("choRDA(s, %d, %d, %d);",
+			op.Args[0].RawValue, op.Args[1].RawValue, op.Args[3].RawValue)
+	case "CHO SOF":
+		return fmt.Sprintf("choSOF(s, %d, %d, %d);",
+			fixed(op.Args[0].RawValue, 0, 15), op.Args[1].RawValue, op.Args[3].RawValue)
+	case "CHO RDAL":
+		return fmt.Sprintf("choRDAL(s, %d, %d);", op.Args[1].RawValue, op.Args[3].RawValue)
+	}
+
+	panic(fmt.Sprintf("Unhandled instruction '%s'", op.Name))
+}
+
+// The body of the process function. 'state' replaces "S." in the
+// statements.
+func programBody(ops []base.Op, state string) string {
+	targets := skipTargets(ops)
+
+	var sb strings.Builder
+	for ip, op := range ops {
+		if targets[ip] {
+			sb.WriteString(fmt.Sprintf("L%d:\n", ip))
+		}
+		sb.WriteString(fmt.Sprintf("\t// %s\n", strings.Replace(disasm.SpinASMInstruction(op, ip, len(ops), nil), "\t", " ", 1)))
+
+		if code := instruction(op, ip, len(ops)); code != "" {
+			for _, stmt := range strings.SplitAfter(code, "; ") {
+				stmt = strings.ReplaceAll(strings.TrimSpace(stmt), "\n", "\n\t")
+				sb.WriteString("\t" + strings.ReplaceAll(stmt, "S.", state) + "\n")
+			}
+		}
+	}
+	if targets[len(ops)] {
+		sb.WriteString("end:\n")
+	}
+	return sb.String()
+}
+
+func header(source string) string {
+	return fmt.Sprintf("// Code generated by fv1emu v%s from '%s'. DO NOT EDIT.\n", settings.Version, source)
+}
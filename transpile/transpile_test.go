@@ -0,0 +1,311 @@
+package transpile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/handegar/fv1emu/asm"
+	"github.com/handegar/fv1emu/base"
+	"github.com/handegar/fv1emu/dsp"
+	"github.com/handegar/fv1emu/settings"
+)
+
+const numTestSamples = 5000
+
+// Exercises what the calibration programs don't: SKP, LOG/EXP, the
+// LFO registers, RMPA, ramp LFOs with all flags.
+const testProgram = `
+	skp	run, start
+	wldr	rmp0, -2000, 1024
+	wldr	rmp1, 3000, 4096
+	wlds	sin1, 200, 3000
+start:
+	rdax	adcl, 0.5
+	rdax	adcr, 0.5
+	wra	100, 0.5
+	skp	gez, pos
+	log	0.5, -0.25
+	exp	1.0, 0
+	skp	0, mixed
+pos:	log	-0.5, 0.1
+	exp	0.8, 0.05
+mixed:	wrax	reg0, 1.0
+	rdax	pot0, 1.0
+	wrax	rmp1_rate, 0
+	rdax	pot2, 0.5
+	wrax	rmp0_range, 0
+	rdax	pot0, 0.01
+	wrax	sin1_rate, 0
+	rdax	pot1, 0.2
+	wrax	sin1_range, 0
+	rdax	pot1, 0.1
+	wrax	addr_ptr, 0
+	rmpa	0.5
+	rdfx	reg1, 0.01
+	wrlx	reg1, -0.5
+	wrhx	reg2, 0.25
+	maxx	reg0, 0.8
+	absa
+	mulx	reg1
+	and	$7FFF00
+	or	$000F00
+	xor	$0F0F0F
+	not
+	sof	-0.3, 0.1
+	wrax	reg3, 0
+	cho	rda, rmp0, reg|compc, 2000
+	cho	rda, rmp0, rptr2, 2001
+	cho	rda, rmp1, reg|na|compa, 3000
+	cho	sof, rmp1, na|compc, 0.25
+	cho	rda, sin1, cos|reg|compc, 1000
+	cho	sof, sin1, compa, 0.1
+	wra	1000, 0
+	cho	rdal, rmp0, reg|rptr2|compa
+	wrap	2000, 0.5
+	wrax	reg4, 0
+	cho	rdal, sin1, cos|reg
+	skp	zrc|neg, end
+	jam	rmp1
+end:	rdax	reg0, 0.5
+	rdax	reg4, 0.5
+	wrax	dacl, 1.0
+	rdax	reg3, 1.0
+	wrax	dacr, 0
+`
+
+func testInput() [][2]float64 {
+	var samples [][2]float64
+	for i := 0; i < numTestSamples; i++ {
+		t := float64(i) / 44100.0
+		samples = append(samples, [2]float64{
+			0.8 * math.Sin(2*math.Pi*220*t),
+			0.6*math.Sin(2*math.Pi*3*t) - 0.3*math.Cos(2*math.Pi*1250*t),
+		})
+	}
+	return samples
+}
+
+// The DAC registers for each sample, as raw S.23 values
+func runInterpreter(ops []base.Op, input [][2]float64) []string {
+	noDebug := func(opCodes []base.Op, state *dsp.State, sampleNum int) int { return dsp.Ok }
+
+	prog, err := dsp.Compile(ops)
+	if err != nil {
+		panic(err)
+	}
+	state := dsp.NewState()
+	var out []string
+	for n, sample := range input {
+		state.GetRegister(base.ADCL).SetFloat64(sample[0])
+		state.GetRegister(base.ADCR).SetFloat64(sample[1])
+		dsp.ProcessSample(prog, state, n, noDebug, noDebug)
+		out = append(out, fmt.Sprintf("%d %d",
+			state.GetRegister(base.DACL).Value, state.GetRegister(base.DACR).Value))
+	}
+	return out
+}
+
+type testCase struct {
+	name     string
+	ops      []base.Op
+	floatRAM bool // Sets settings.FloatDelayRAM
+}
+
+func testCases(t *testing.T) []testCase {
+	files, _ := filepath.Glob("../programs/calibrate/*.spn")
+	if len(files) == 0 {
+		t.Fatalf("No calibration programs found")
+	}
+
+	var cases []testCase
+	for _, f := range files {
+		prog, err := asm.AssembleFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cases = append(cases, testCase{filepath.Base(f), dsp.DecodeOpCodes(prog.Words, dsp.ConfigFromSettings()), false})
+	}
+
+	prog, err := asm.Assemble(testProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := dsp.DecodeOpCodes(prog.Words, dsp.ConfigFromSettings())
+	return append(cases, testCase{"test program", ops, false},
+		testCase{"test program (float delay RAM)", ops, true})
+}
+
+func writeInput(t *testing.T, filename string, input [][2]float64) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, input)
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func compareOutput(t *testing.T, name string, expected []string, output string) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != len(expected) {
+		t.Errorf("%s: Expected %d samples, got %d", name, len(expected), len(lines))
+		return
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("%s: Sample %d differs. Expected DACL/DACR %s, got %s", name, i, expected[i], lines[i])
+			return
+		}
+	}
+}
+
+const goDriver = `package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	%s
+)
+
+func main() {
+	data, _ := os.ReadFile("input.raw")
+	input := make([][2]float64, len(data)/16)
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, input)
+	%s
+}
+`
+
+const goDriverProgram = `
+	fmt.Println("== %s")
+	s%d := %s.NewState()
+	for _, sample := range input {
+		l, r := s%d.Process(sample[0], sample[1])
+		fmt.Println(int32(l*(1<<23)), int32(r*(1<<23)))
+	}
+`
+
+// Compile all programs into one Go binary and compare the output with
+// the interpreter, sample by sample.
+func Test_TranspileGo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Builds a Go program")
+	}
+
+	dir := t.TempDir()
+	input := testInput()
+	writeInput(t, filepath.Join(dir, "input.raw"), input)
+
+	defer func() { settings.FloatDelayRAM = false }()
+	cases := testCases(t)
+	var imports, calls []string
+	for i, c := range cases {
+		pkg := fmt.Sprintf("p%d", i)
+		settings.FloatDelayRAM = c.floatRAM
+		code, err := ToGo(c.ops, pkg, c.name)
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", c.name, err, code)
+		}
+
+		os.Mkdir(filepath.Join(dir, pkg), 0755)
+		if err = os.WriteFile(filepath.Join(dir, pkg, "prog.go"), code, 0644); err != nil {
+			t.Fatal(err)
+		}
+		imports = append(imports, strconv.Quote("fv1test/"+pkg))
+		calls = append(calls, fmt.Sprintf(goDriverProgram, c.name, i, pkg, i))
+	}
+
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module fv1test\n\ngo 1.21\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "main.go"),
+		[]byte(fmt.Sprintf(goDriver, strings.Join(imports, "\n\t"), strings.Join(calls, ""))), 0644)
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOTOOLCHAIN=local", "GOWORK=off", "GOFLAGS=")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Running the generated code failed: %s\n%s", err, output)
+	}
+
+	results := strings.Split(string(output), "== ")[1:]
+	if len(results) != len(cases) {
+		t.Fatalf("Unexpected output:\n%s", output)
+	}
+	for i, c := range cases {
+		settings.FloatDelayRAM = c.floatRAM
+		expected := runInterpreter(c.ops, input)
+		compareOutput(t, c.name, expected, strings.SplitN(results[i], "\n", 2)[1])
+	}
+}
+
+const cDriver = `#include <stdio.h>
+#include "prog.c"
+
+int main(void) {
+	static p_state s;
+	double in[2], l, r;
+	FILE *f = fopen("input.raw", "rb");
+
+	p_init(&s);
+	while (fread(in, sizeof(double), 2, f) == 2) {
+		p_process(&s, in[0], in[1], &l, &r);
+		printf("%d %d\n", (int)(l * 8388608.0), (int)(r * 8388608.0));
+	}
+	return 0;
+}
+`
+
+// The C code must compile without warnings and give the same result
+// as long as the C library's sin()/cos() agree with Go's
+func Test_TranspileC(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil || testing.Short() {
+		t.Skip("No C compiler")
+	}
+
+	dir := t.TempDir()
+	input := testInput()
+	writeInput(t, filepath.Join(dir, "input.raw"), input)
+	os.WriteFile(filepath.Join(dir, "driver.c"), []byte(cDriver), 0644)
+
+	defer func() { settings.FloatDelayRAM = false }()
+	for _, c := range testCases(t) {
+		settings.FloatDelayRAM = c.floatRAM
+		code, err := ToC(c.ops, "p", c.name)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		os.WriteFile(filepath.Join(dir, "prog.c"), code, 0644)
+
+		build := exec.Command(cc, "-std=c99", "-Wall", "-Werror", "-O2", "-o", "prog", "driver.c", "-lm")
+		build.Dir = dir
+		if output, err := build.CombinedOutput(); err != nil {
+			t.Fatalf("%s: Compiling the generated code failed: %s\n%s\n%s", c.name, err, output, code)
+		}
+
+		run := exec.Command("./prog")
+		run.Dir = dir
+		output, err := run.Output()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		compareOutput(t, c.name, runInterpreter(c.ops, input), string(output))
+	}
+}
+
+func Test_TranspileErrors(t *testing.T) {
+	prog, _ := asm.Assemble("cho rda, rmp0, cos, 100\n")
+	if _, err := ToGo(dsp.DecodeOpCodes(prog.Words, dsp.ConfigFromSettings()), "p", "x"); err == nil {
+		t.Errorf("Expected COS with a RAMP LFO to fail")
+	}
+	if _, err := ToC(nil, "p", "x"); err == nil {
+		t.Errorf("Expected an empty program to fail")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/handegar/fv1emu/dsp"
+	"github.com/handegar/fv1emu/settings"
+	"github.com/handegar/fv1emu/transpile"
+)
+
+const transpileUsage = `Usage:
+  fv1emu transpile [-package NAME] [-prog N] [-float-delay-ram] PROGRAM OUTPUT
+      Write PROGRAM (BIN, HEX or SPN) as a Go (.go) or C (.c) source file`
+
+// The "transpile" sub-command. Returns FALSE on errors.
+func runTranspileCommand(args []string) bool {
+	if err := transpileProgram(args); err != nil {
+		fmt.Printf("%s\n", err)
+		return false
+	}
+	return true
+}
+
+func transpileProgram(args []string) error {
+	flags := flag.NewFlagSet("transpile", flag.ContinueOnError)
+	pkg := flags.String("package", "fv1prog", "Package name (Go) or prefix for all public names (C)")
+	flags.IntVar(&settings.ProgramNumber, "prog", settings.ProgramNumber,
+		"Which program to use for multiprogram BIN/HEX files")
+	flags.BoolVar(&settings.FloatDelayRAM, "float-delay-ram", settings.FloatDelayRAM,
+		"Store the delay memory in the 14-bit floating point format of the FV-1 instead of 24 bits")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) != 2 {
+		return fmt.Errorf("%s", transpileUsage)
+	}
+
+	buf, _, err := loadProgramFile(args[0])
+	if err != nil {
+		return err
+	}
+	start := settings.ProgramNumber * settings.InstructionsPerSample
+	if len(buf) <= start {
+		return fmt.Errorf("Number of program(s) in BIN/HEX is only %d",
+			len(buf)/settings.InstructionsPerSample)
+	}
+	ops := dsp.DecodeOpCodes(buf[start:], dsp.ConfigFromSettings())
+
+	var code []byte
+	switch strings.ToLower(filepath.Ext(args[1])) {
+	case ".go":
+		code, err = transpile.ToGo(ops, *pkg, filepath.Base(args[0]))
+	case ".c":
+		code, err = transpile.ToC(ops, *pkg, filepath.Base(args[0]))
+	default:
+		return fmt.Errorf("Unknown output file type '%s', expected .go or .c", args[1])
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("* Writing %d instructions to '%s'\n", len(ops), args[1])
+	return os.WriteFile(args[1], code, 0644)
+}
@@ -0,0 +1,211 @@
+package writer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/faiface/beep"
+
+	"github.com/handegar/fv1emu/pcm"
+)
+
+/**
+  Writes an AIFF file while the samples are being processed, like
+  WAVWriter. Integer formats are written as big endian AIFF, F32LE as
+  AIFF-C with the 'fl32' compression type and the values unclipped.
+*/
+
+type AIFFWriter struct {
+	w            io.WriteSeeker
+	file         *os.File // Only set when created by CreateAIFF()
+	bw           *bufio.Writer
+	format       beep.Format
+	sampleFormat pcm.Format
+	dither       *pcm.Dither
+	buf          []byte
+	dataSize     int64
+	closed       bool
+}
+
+func CreateAIFF(filename string, format beep.Format, sampleFormat pcm.Format) (*AIFFWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewAIFFWriter(file, format, sampleFormat)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.file = file
+	return w, nil
+}
+
+// Writes the header to 'w' at once. 'w' is not closed by Close(). The
+// precision of 'format' is ignored.
+func NewAIFFWriter(w io.WriteSeeker, format beep.Format, sampleFormat pcm.Format) (*AIFFWriter, error) {
+	if format.NumChannels <= 0 {
+		return nil, fmt.Errorf("Invalid number of channels %d", format.NumChannels)
+	}
+	format.Precision = sampleFormat.Width()
+
+	aw := &AIFFWriter{w: w, bw: bufio.NewWriterSize(w, 64*1024), format: format, sampleFormat: sampleFormat}
+	if _, err := aw.bw.Write(aw.header()); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// Dither the samples before encoding them. Nil turns dithering off.
+func (w *AIFFWriter) SetDither(dither *pcm.Dither) {
+	w.dither = dither
+}
+
+// Encode an 80-bit IEEE 754 extended precision number, which is how
+// AIFF stores the sample rate
+func encodeExtended(v float64) []byte {
+	p := make([]byte, 10)
+	if v == 0 {
+		return p
+	}
+	frac, exp := math.Frexp(math.Abs(v))
+	exponent := uint16(exp + 16382)
+	if v < 0 {
+		exponent |= 0x8000
+	}
+	binary.BigEndian.PutUint16(p[0:], exponent)
+	binary.BigEndian.PutUint64(p[2:], uint64(math.Ldexp(frac, 64)))
+	return p
+}
+
+// The FORM, COMM and SSND chunk headers for the data written so far.
+// AIFF-C also needs a FVER chunk.
+func (w *AIFFWriter) header() []byte {
+	f := w.format
+	isFloat := w.sampleFormat == pcm.F32LE
+
+	comm := binary.BigEndian.AppendUint16(nil, uint16(f.NumChannels))
+	comm = binary.BigEndian.AppendUint32(comm, uint32(w.NumSamples()))
+	comm = binary.BigEndian.AppendUint16(comm, uint16(w.sampleFormat.Bits()))
+	comm = append(comm, encodeExtended(float64(f.SampleRate))...)
+	if isFloat {
+		name := "32-bit floating point"
+		comm = append(comm, "fl32"...)
+		comm = append(comm, byte(len(name)))
+		comm = append(comm, name...)
+		if len(name)%2 == 0 { // The pascal string is padded to an even size
+			comm = append(comm, 0)
+		}
+	}
+
+	var body []byte
+	if isFloat {
+		body = append(body, "FVER"...)
+		body = binary.BigEndian.AppendUint32(body, 4)
+		body = binary.BigEndian.AppendUint32(body, 0xA2805140) // AIFF-C version 1
+	}
+	body = append(body, "COMM"...)
+	body = binary.BigEndian.AppendUint32(body, uint32(len(comm)))
+	body = append(body, comm...)
+	body = append(body, "SSND"...)
+	body = binary.BigEndian.AppendUint32(body, uint32(8+w.dataSize))
+	body = binary.BigEndian.AppendUint32(body, 0) // Offset
+	body = binary.BigEndian.AppendUint32(body, 0) // Block size
+
+	h := []byte("FORM")
+	h = binary.BigEndian.AppendUint32(h, uint32(4+int64(len(body))+w.dataSize+w.dataSize%2))
+	if isFloat {
+		h = append(h, "AIFC"...)
+	} else {
+		h = append(h, "AIFF"...)
+	}
+	return append(h, body...)
+}
+
+// Encode one sample as big endian
+func (w *AIFFWriter) encode(p []byte, v float64) {
+	if w.dither != nil {
+		v = w.dither.Apply(w.sampleFormat, v)
+	}
+	w.sampleFormat.Encode(p, v)
+	for i, j := 0, w.sampleFormat.Width()-1; i < j; i, j = i+1, j-1 {
+		p[i], p[j] = p[j], p[i]
+	}
+}
+
+// Mono is the average of both channels and extra channels are
+// silent, like WAVWriter
+func (w *AIFFWriter) Write(samples [][2]float64) error {
+	width := w.sampleFormat.Width()
+	frameSize := w.format.Width()
+	if cap(w.buf) < len(samples)*frameSize {
+		w.buf = make([]byte, len(samples)*frameSize)
+	}
+	buf := w.buf[:len(samples)*frameSize]
+
+	for i, sample := range samples {
+		frame := buf[i*frameSize:]
+		if w.format.NumChannels == 1 {
+			sample[0] = (sample[0] + sample[1]) / 2
+		}
+		for c := 0; c < w.format.NumChannels; c++ {
+			v := 0.0
+			if c < 2 {
+				v = sample[c]
+			}
+			w.encode(frame[c*width:], v)
+		}
+	}
+
+	n, err := w.bw.Write(buf)
+	w.dataSize += int64(n)
+	return err
+}
+
+// The number of samples written
+func (w *AIFFWriter) NumSamples() int {
+	return int(w.dataSize / int64(w.format.Width()))
+}
+
+// Write the buffered samples and update the sizes in the header
+func (w *AIFFWriter) Flush() error {
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(w.header()); err != nil {
+		return err
+	}
+	_, err := w.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// Flush, add the pad byte required after an odd sized SSND chunk and
+// close the file if it was opened by CreateAIFF(). Calling Close()
+// again does nothing.
+func (w *AIFFWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	var err error
+	if w.dataSize%2 == 1 {
+		_, err = w.bw.Write([]byte{0})
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if w.file != nil {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
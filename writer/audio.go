@@ -0,0 +1,58 @@
+package writer
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/faiface/beep"
+
+	"github.com/handegar/fv1emu/pcm"
+)
+
+// The writers for the audio output files
+type AudioWriter interface {
+	Write(samples [][2]float64) error
+	Flush() error
+	Close() error
+	NumSamples() int
+	SetDither(dither *pcm.Dither)
+}
+
+// Create a WAV, FLAC or AIFF file depending on the filename
+// extension. Anything but .flac, .aif and .aiff is written as WAV. A
+// nil 'sampleFormat' means the precision of 'format', where 8 bit is
+// written as 16 bit to FLAC and AIFF files and 32 bit as 24 bit to
+// FLAC files.
+func CreateAudio(filename string, format beep.Format, sampleFormat *pcm.Format) (AudioWriter, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if sampleFormat == nil && (ext == ".flac" || ext == ".aif" || ext == ".aiff") {
+		f := SampleFormatOf(format)
+		if ext == ".flac" && f == pcm.S32LE {
+			f = pcm.S24LE
+		}
+		sampleFormat = &f
+	}
+
+	switch ext {
+	case ".flac":
+		return CreateFLAC(filename, format, *sampleFormat)
+	case ".aif", ".aiff":
+		return CreateAIFF(filename, format, *sampleFormat)
+	}
+	if sampleFormat == nil {
+		return CreateWAV(filename, format)
+	}
+	return CreateWAVAs(filename, format, *sampleFormat)
+}
+
+// The integer sample format with the precision of 'format', but at
+// least 16 bit
+func SampleFormatOf(format beep.Format) pcm.Format {
+	switch format.Precision {
+	case 3:
+		return pcm.S24LE
+	case 4:
+		return pcm.S32LE
+	}
+	return pcm.S16LE
+}
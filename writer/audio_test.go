@@ -0,0 +1,149 @@
+package writer
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/faiface/beep"
+
+	"github.com/handegar/fv1emu/pcm"
+	"github.com/handegar/fv1emu/reader"
+)
+
+// Write with CreateAudio() and read back with reader.ReadAudio()
+func roundTrip(t *testing.T, filename string, format beep.Format, sampleFormat *pcm.Format, samples [][2]float64) (reader.Decoder, [][2]float64) {
+	w, err := CreateAudio(filename, format, sampleFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Several writes and flushes like when processing
+	for i := 0; i < len(samples); i += 1000 {
+		if err = w.Write(samples[i:min(i+1000, len(samples))]); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.NumSamples() != len(samples) {
+		t.Errorf("%s: Expected %d samples, got %d", filename, len(samples), w.NumSamples())
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := reader.ReadAudio(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	var got [][2]float64
+	buf := make([][2]float64, 333)
+	for {
+		n, ok := d.Stream(buf)
+		if !ok {
+			break
+		}
+		got = append(got, buf[:n]...)
+	}
+	if d.Err() != nil {
+		t.Fatal(d.Err())
+	}
+	return d, got
+}
+
+func Test_AudioRoundTrip(t *testing.T) {
+	type testCase struct {
+		filename     string
+		channels     int
+		sampleFormat pcm.Format
+		isFloat      bool
+	}
+	cases := []testCase{
+		{"s16.flac", 2, pcm.S16LE, false},
+		{"s24.flac", 1, pcm.S24LE, false},
+		{"s16.aiff", 2, pcm.S16LE, false},
+		{"s24.aif", 1, pcm.S24LE, false},
+		{"s32.aiff", 2, pcm.S32LE, false},
+		{"f32.aiff", 2, pcm.F32LE, true},
+		{"f32.wav", 2, pcm.F32LE, true},
+	}
+	samples := testSamples(10000)
+	samples[1] = [2]float64{1.5, -2.0} // Only kept by the float formats
+
+	for _, c := range cases {
+		filename := filepath.Join(t.TempDir(), c.filename)
+		format := beep.Format{SampleRate: 48000, NumChannels: c.channels, Precision: 2}
+		d, got := roundTrip(t, filename, format, &c.sampleFormat, samples)
+
+		if d.Format().SampleRate != 48000 || d.Format().NumChannels != c.channels ||
+			d.BitsPerSample() != c.sampleFormat.Bits() || d.IsFloat() != c.isFloat {
+			t.Errorf("%s: Expected %d channels at 48000Hz with %d bits, got %v with %d bits",
+				c.filename, c.channels, c.sampleFormat.Bits(), d.Format(), d.BitsPerSample())
+		}
+		// Smaller than the samples, so not written verbatim
+		if filepath.Ext(c.filename) == ".flac" {
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw := len(samples) * c.channels * c.sampleFormat.Bits() / 8
+			if info.Size() >= int64(raw) {
+				t.Errorf("%s: Expected the samples to be compressed, got %d bytes for %d bytes of samples", c.filename, info.Size(), raw)
+			}
+		}
+		if len(got) != len(samples) || d.Len() != len(samples) {
+			t.Fatalf("%s: Expected %d samples, got %d (length %d)", c.filename, len(samples), len(got), d.Len())
+		}
+
+		for i, sample := range samples {
+			expected := sample
+			if c.channels == 1 {
+				expected[0] = (sample[0] + sample[1]) / 2
+				expected[1] = expected[0]
+			}
+			var p [4]byte
+			for ch := range expected {
+				c.sampleFormat.Encode(p[:], expected[ch])
+				expected[ch] = c.sampleFormat.Decode(p[:])
+			}
+			if got[i] != expected {
+				t.Fatalf("%s: Sample %d: Expected %v, got %v", c.filename, i, expected, got[i])
+			}
+		}
+	}
+}
+
+// Unknown extensions are WAV and FLAC only has 16 and 24 bit
+func Test_CreateAudioFormats(t *testing.T) {
+	dir := t.TempDir()
+	format := beep.Format{SampleRate: 44100, NumChannels: 2, Precision: 4}
+
+	// A FLAC stream shorter than the minimum block size must still
+	// declare a valid block size
+	d, got := roundTrip(t, filepath.Join(dir, "short.flac"), format, nil, testSamples(10))
+	if d.BitsPerSample() != 24 || len(got) != 10 {
+		t.Errorf("Expected 10 samples with 24 bits, got %d samples with %d bits", len(got), d.BitsPerSample())
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "short.flac"))
+	if minSize := binary.BigEndian.Uint16(data[8:]); minSize != flacBlockSize {
+		t.Errorf("Expected a minimum block size of %d, got %d", flacBlockSize, minSize)
+	}
+
+	w, err := CreateAudio(filepath.Join(dir, "out.raw"), format, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.(*WAVWriter); !ok {
+		t.Errorf("Expected a WAV writer for an unknown extension, got %T", w)
+	}
+	w.Close()
+
+	f32 := pcm.F32LE
+	if _, err := CreateAudio(filepath.Join(dir, "out.flac"), format, &f32); err == nil {
+		t.Errorf("Expected float FLAC output to fail")
+	}
+}
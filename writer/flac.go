@@ -0,0 +1,241 @@
+package writer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/faiface/beep"
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+
+	"github.com/handegar/fv1emu/pcm"
+)
+
+// Samples per FLAC frame, the same as the reference encoder uses
+const flacBlockSize = 4096
+
+/**
+  Writes a FLAC file while the samples are being processed, like
+  WAVWriter. Samples are encoded one block at a time, so Flush() only
+  writes whole blocks and the rest is written by Close(). The number
+  of samples and the MD5 sum in the header are updated by Close().
+  FLAC has no float format, so only S16LE and S24LE are supported.
+*/
+
+type FLACWriter struct {
+	file         *os.File
+	out          *seekableWriter
+	enc          *flac.Encoder
+	format       beep.Format
+	sampleFormat pcm.Format
+	dither       *pcm.Dither
+	block        *frame.Frame
+	numSamples   int
+	closed       bool
+}
+
+// A bufio.Writer which can seek, so the encoder can update the header
+// without doing one write per frame field
+type seekableWriter struct {
+	*bufio.Writer
+	file *os.File
+}
+
+func (s *seekableWriter) Seek(offset int64, whence int) (int64, error) {
+	if err := s.Flush(); err != nil {
+		return 0, err
+	}
+	return s.file.Seek(offset, whence)
+}
+
+func CreateFLAC(filename string, format beep.Format, sampleFormat pcm.Format) (*FLACWriter, error) {
+	if format.NumChannels < 1 || format.NumChannels > 8 {
+		return nil, fmt.Errorf("Unsupported number of channels %d, FLAC supports 1 to 8", format.NumChannels)
+	}
+	if sampleFormat != pcm.S16LE && sampleFormat != pcm.S24LE {
+		return nil, fmt.Errorf("Unsupported FLAC sample format %s, expected s16le or s24le", sampleFormat)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &seekableWriter{Writer: bufio.NewWriterSize(file, 64*1024), file: file}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  flacBlockSize,
+		BlockSizeMax:  flacBlockSize,
+		SampleRate:    uint32(format.SampleRate),
+		NChannels:     uint8(format.NumChannels),
+		BitsPerSample: uint8(sampleFormat.Bits()),
+	}
+	enc, err := flac.NewEncoder(out, info)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &FLACWriter{file: file, out: out, enc: enc, format: format, sampleFormat: sampleFormat}
+	w.block = &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			SampleRate:        info.SampleRate,
+			Channels:          frame.Channels(format.NumChannels - 1), // Independent channels
+			BitsPerSample:     info.BitsPerSample,
+		},
+	}
+	for c := 0; c < format.NumChannels; c++ {
+		w.block.Subframes = append(w.block.Subframes, &frame.Subframe{
+			SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+			Samples:   make([]int32, 0, flacBlockSize),
+		})
+	}
+	return w, nil
+}
+
+// Dither the samples before encoding them. Nil turns dithering off.
+func (w *FLACWriter) SetDither(dither *pcm.Dither) {
+	w.dither = dither
+}
+
+func (w *FLACWriter) quantize(v float64) int32 {
+	if w.dither != nil {
+		v = w.dither.Apply(w.sampleFormat, v)
+	}
+	return w.sampleFormat.Quantize(v)
+}
+
+// Mono is the average of both channels and extra channels are
+// silent, like WAVWriter
+func (w *FLACWriter) Write(samples [][2]float64) error {
+	for _, sample := range samples {
+		if w.format.NumChannels == 1 {
+			sample[0] = (sample[0] + sample[1]) / 2
+		}
+		for c, subframe := range w.block.Subframes {
+			v := int32(0)
+			if c < 2 {
+				v = w.quantize(sample[c])
+			}
+			subframe.Samples = append(subframe.Samples, v)
+		}
+		w.numSamples++
+
+		if len(w.block.Subframes[0].Samples) == flacBlockSize {
+			if err := w.writeBlock(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Encode the collected samples as one frame
+func (w *FLACWriter) writeBlock() error {
+	n := len(w.block.Subframes[0].Samples)
+	if n == 0 {
+		return nil
+	}
+	w.block.BlockSize = uint16(n)
+	for _, subframe := range w.block.Subframes {
+		subframe.NSamples = n
+		choosePrediction(subframe, int(w.block.BitsPerSample))
+	}
+	err := w.enc.WriteFrame(w.block)
+	for _, subframe := range w.block.Subframes {
+		subframe.Samples = subframe.Samples[:0]
+	}
+	return err
+}
+
+// Pick the fixed predictor of order 0 to 4 which needs the fewest
+// bits, or verbatim if that is smaller. The encoder computes the
+// residuals itself from the order and writes them as one Rice partition.
+func choosePrediction(subframe *frame.Subframe, bps int) {
+	samples := subframe.Samples
+	subframe.Pred = frame.PredVerbatim
+	subframe.Order = 0
+	subframe.RiceSubframe = nil
+	bestBits := len(samples) * bps
+
+	for order := 0; order <= 4 && order < len(samples); order++ {
+		coeffs := frame.FixedCoeffs[order]
+		var residuals []uint32
+		for i := order; i < len(samples); i++ {
+			r := samples[i]
+			for j, c := range coeffs {
+				r -= c * samples[i-j-1]
+			}
+			residuals = append(residuals, uint32(r<<1)^uint32(r>>31)) // Zigzag, like the Rice coding
+		}
+
+		// The Rice parameters 15 and 31 are escape codes
+		for k := uint(0); k < 31; k++ {
+			if k == 15 {
+				continue
+			}
+			bits := order * bps
+			for _, r := range residuals {
+				bits += int(r>>k) + 1 + int(k)
+			}
+			if bits < bestBits {
+				bestBits = bits
+				subframe.Pred = frame.PredFixed
+				subframe.Order = order
+				subframe.ResidualCodingMethod = frame.ResidualCodingMethodRice1
+				if k > 14 {
+					subframe.ResidualCodingMethod = frame.ResidualCodingMethodRice2
+				}
+				subframe.RiceSubframe = &frame.RiceSubframe{
+					Partitions: []frame.RicePartition{{Param: k}},
+				}
+			}
+		}
+	}
+}
+
+// The number of samples written
+func (w *FLACWriter) NumSamples() int {
+	return w.numSamples
+}
+
+// Write the encoded blocks to the file
+func (w *FLACWriter) Flush() error {
+	return w.out.Flush()
+}
+
+// Encode the last block, update the header and close the file.
+// Calling Close() again does nothing.
+func (w *FLACWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.writeBlock()
+	if err == nil {
+		err = w.enc.Close()
+	}
+	// The encoder sets the minimum block size to the size of the
+	// last block, which is not counted by the FLAC format
+	if err == nil {
+		var sizes [4]byte
+		binary.BigEndian.PutUint16(sizes[0:], flacBlockSize)
+		binary.BigEndian.PutUint16(sizes[2:], flacBlockSize)
+		_, err = w.out.Seek(8, io.SeekStart) // After "fLaC" and the block header
+		if err == nil {
+			_, err = w.out.Write(sizes[:])
+		}
+	}
+	if err == nil {
+		err = w.out.Flush()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
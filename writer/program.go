@@ -0,0 +1,105 @@
+package writer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/handegar/fv1emu/settings"
+)
+
+const NOP_WORD = 0x00000011 // "SKP 0, 0", what SpinASM fills programs with
+
+// Pad the instruction words up to a whole number of programs
+func padProgram(words []uint32) []uint32 {
+	padded := append([]uint32{}, words...)
+	for len(padded) == 0 || len(padded)%settings.InstructionsPerSample != 0 {
+		padded = append(padded, NOP_WORD)
+	}
+	return padded
+}
+
+// Write instruction words as big-endian 32-bit words, padded to 128
+// instructions per program. Same format as 'reader.ReadBin()' reads.
+func EncodeBin(w io.Writer, words []uint32) error {
+	words = padProgram(words)
+	buf := make([]byte, 4*len(words))
+	for i, word := range words {
+		binary.BigEndian.PutUint32(buf[i*4:], word)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+/*
+*
+
+	Write instruction words as Intel HEX, one instruction (4 bytes) per
+	data record like SpinCAD and the Spin programmer does, followed by
+	an EOF record. Each program is padded to 128 instructions. Same
+	format as 'reader.ReadHex()' reads.
+*/
+func EncodeHex(w io.Writer, words []uint32) error {
+	words = padProgram(words)
+	out := bufio.NewWriter(w)
+
+	var record = func(address int, recordType byte, data []byte) {
+		bytes := []byte{byte(len(data)), byte(address >> 8), byte(address), recordType}
+		bytes = append(bytes, data...)
+
+		checksum := byte(0)
+		for _, b := range bytes {
+			checksum += b
+		}
+		bytes = append(bytes, -checksum)
+
+		fmt.Fprintf(out, ":%X\n", bytes)
+	}
+
+	upperAddress := 0
+	for i, word := range words {
+		address := i * 4
+		if address>>16 != upperAddress { // Extended linear address record
+			upperAddress = address >> 16
+			record(0, 0x04, []byte{byte(upperAddress >> 8), byte(upperAddress)})
+		}
+
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, word)
+		record(address&0xFFFF, 0x00, data)
+	}
+	record(0, 0x01, nil)
+
+	return out.Flush()
+}
+
+// Write instruction words to a BIN or HEX file depending on the
+// filename extension
+func SaveProgram(filename string, words []uint32) error {
+	var encode func(w io.Writer, words []uint32) error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".hex":
+		encode = EncodeHex
+	case ".bin":
+		encode = EncodeBin
+	default:
+		return fmt.Errorf("Unknown program file type '%s', expected .bin or .hex", filename)
+	}
+
+	fmt.Printf("* Writing program to '%s' (%d instructions)\n", filename, len(words))
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = encode(file, words)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
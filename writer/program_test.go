@@ -0,0 +1,114 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/handegar/fv1emu/reader"
+	"github.com/handegar/fv1emu/settings"
+)
+
+func testProgram(numWords int) []uint32 {
+	var words []uint32
+	for i := 0; i < numWords; i++ {
+		words = append(words, uint32(i)*0x01010101^0x80000000|0x04)
+	}
+	return words
+}
+
+func Test_ProgramRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, ext := range []string{".bin", ".hex"} {
+		for _, numWords := range []int{0, 3, settings.InstructionsPerSample, 8 * settings.InstructionsPerSample} {
+			words := testProgram(numWords)
+			filename := filepath.Join(dir, "prog"+ext)
+			if err := SaveProgram(filename, words); err != nil {
+				t.Fatal(err)
+			}
+
+			var read []uint32
+			var err error
+			if ext == ".bin" {
+				read, err = reader.ReadBin(filename)
+			} else {
+				read, err = reader.ReadHex(filename)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := padProgram(words)
+			if len(expected)%settings.InstructionsPerSample != 0 || len(expected) < numWords {
+				t.Fatalf("Bad padding: %d words padded to %d", numWords, len(expected))
+			}
+			if !reflect.DeepEqual(read, expected) {
+				t.Errorf("%s (%d words): Read back %d words which differs from the %d written",
+					ext, numWords, len(read), len(expected))
+			}
+
+			// Writing what was read must give the same file
+			original, _ := os.ReadFile(filename)
+			var buf bytes.Buffer
+			if ext == ".bin" {
+				err = EncodeBin(&buf, read)
+			} else {
+				err = EncodeHex(&buf, read)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(original, buf.Bytes()) {
+				t.Errorf("%s (%d words): Rewritten file differs from the original", ext, numWords)
+			}
+		}
+	}
+}
+
+func Test_HexRecords(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeHex(&buf, []uint32{0x80400011, 0x40000284}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != settings.InstructionsPerSample+1 {
+		t.Fatalf("Expected %d records, got %d", settings.InstructionsPerSample+1, len(lines))
+	}
+
+	expected := []string{":04000000804000112B", ":040004004000028432"}
+	for i, e := range expected {
+		if lines[i] != e {
+			t.Errorf("Record %d: Expected %s, got %s", i, e, lines[i])
+		}
+	}
+	if lines[len(lines)-1] != ":00000001FF" {
+		t.Errorf("Expected an EOF record, got %s", lines[len(lines)-1])
+	}
+
+	// All bytes in a record (checksum included) sums up to zero
+	for _, line := range lines {
+		data, err := hex.DecodeString(line[1:])
+		if err != nil {
+			t.Fatalf("Invalid record '%s': %s", line, err)
+		}
+		sum := byte(0)
+		for _, b := range data {
+			sum += b
+		}
+		if sum != 0 {
+			t.Errorf("Bad checksum in '%s'", line)
+		}
+	}
+}
+
+func Test_SaveProgramUnknownType(t *testing.T) {
+	if err := SaveProgram(filepath.Join(t.TempDir(), "prog.txt"), nil); err == nil {
+		t.Errorf("Expected an unknown file type to fail")
+	}
+}
@@ -0,0 +1,251 @@
+package writer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/faiface/beep"
+
+	"github.com/handegar/fv1emu/pcm"
+)
+
+const wavHeaderSize = 44
+
+// IEEE float data needs the extended fmt chunk and a fact chunk
+const wavFloatHeaderSize = wavHeaderSize + 2 + 12
+
+const (
+	wavFormatPCM   = 1
+	wavFormatFloat = 3
+)
+
+/**
+  Writes a WAV file while the samples are being processed instead of
+  keeping everything in memory. The header is written with empty
+  sizes first and patched by Flush() and Close(), so the file is
+  valid up to the last Flush() even if the program is stopped.
+
+  	w, err := writer.CreateWAV("out.wav", format)
+  	...
+  	err = w.Write(samples)
+  	...
+  	err = w.Close()
+
+  Use CreateWAVAs() to write a sample format other than the precision
+  of the beep.Format, ie. 24-bit or 32-bit float output for a 16-bit
+  input.
+*/
+
+type WAVWriter struct {
+	w      io.WriteSeeker
+	file   *os.File // Only set when created by CreateWAV()
+	bw     *bufio.Writer
+	format beep.Format
+	buf    []byte
+
+	// Encode with pcm.Format instead of the beep.Format precision
+	// when set
+	sampleFormat *pcm.Format
+	dither       *pcm.Dither
+
+	dataSize int64
+	closed   bool
+}
+
+func CreateWAV(filename string, format beep.Format) (*WAVWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWAVWriter(file, format)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.file = file
+	return w, nil
+}
+
+// Like CreateWAV() but the samples are written as 'sampleFormat'. The
+// precision of 'format' is ignored.
+func CreateWAVAs(filename string, format beep.Format, sampleFormat pcm.Format) (*WAVWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWAVWriterAs(file, format, sampleFormat)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.file = file
+	return w, nil
+}
+
+// Writes the header to 'w' at once. 'w' is not closed by Close().
+func NewWAVWriter(w io.WriteSeeker, format beep.Format) (*WAVWriter, error) {
+	if format.Precision < 1 || format.Precision > 4 {
+		return nil, fmt.Errorf("Unsupported precision %d bytes, 1 to 4 is supported", format.Precision)
+	}
+	return newWAVWriter(w, format, nil)
+}
+
+// Like NewWAVWriter() but the samples are written as
+// 'sampleFormat'. Integer formats are clipped, F32LE is written as an
+// IEEE float WAV file with the values unclipped.
+func NewWAVWriterAs(w io.WriteSeeker, format beep.Format, sampleFormat pcm.Format) (*WAVWriter, error) {
+	format.Precision = sampleFormat.Width()
+	return newWAVWriter(w, format, &sampleFormat)
+}
+
+func newWAVWriter(w io.WriteSeeker, format beep.Format, sampleFormat *pcm.Format) (*WAVWriter, error) {
+	if format.NumChannels <= 0 {
+		return nil, fmt.Errorf("Invalid number of channels %d", format.NumChannels)
+	}
+
+	ww := &WAVWriter{w: w, bw: bufio.NewWriterSize(w, 64*1024), format: format, sampleFormat: sampleFormat}
+	if _, err := ww.bw.Write(ww.header()); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// Dither the samples before encoding them. Only used with the sample
+// formats of CreateWAVAs() and NewWAVWriterAs(). Nil turns dithering
+// off.
+func (w *WAVWriter) SetDither(dither *pcm.Dither) {
+	w.dither = dither
+}
+
+func (w *WAVWriter) isFloat() bool {
+	return w.sampleFormat != nil && *w.sampleFormat == pcm.F32LE
+}
+
+func (w *WAVWriter) headerSize() int64 {
+	if w.isFloat() {
+		return wavFloatHeaderSize
+	}
+	return wavHeaderSize
+}
+
+// A PCM or IEEE float header for the data written so far
+func (w *WAVWriter) header() []byte {
+	f := w.format
+	h := make([]byte, 0, w.headerSize())
+	h = append(h, "RIFF"...)
+	h = binary.LittleEndian.AppendUint32(h, uint32(w.headerSize()-8+w.dataSize+w.dataSize%2))
+	h = append(h, "WAVEfmt "...)
+	if w.isFloat() {
+		h = binary.LittleEndian.AppendUint32(h, 18)
+		h = binary.LittleEndian.AppendUint16(h, wavFormatFloat)
+	} else {
+		h = binary.LittleEndian.AppendUint32(h, 16)
+		h = binary.LittleEndian.AppendUint16(h, wavFormatPCM)
+	}
+	h = binary.LittleEndian.AppendUint16(h, uint16(f.NumChannels))
+	h = binary.LittleEndian.AppendUint32(h, uint32(f.SampleRate))
+	h = binary.LittleEndian.AppendUint32(h, uint32(int(f.SampleRate)*f.Width()))
+	h = binary.LittleEndian.AppendUint16(h, uint16(f.Width()))
+	h = binary.LittleEndian.AppendUint16(h, uint16(f.Precision*8))
+	if w.isFloat() {
+		h = binary.LittleEndian.AppendUint16(h, 0) // No extension
+		h = append(h, "fact"...)
+		h = binary.LittleEndian.AppendUint32(h, 4)
+		h = binary.LittleEndian.AppendUint32(h, uint32(w.NumSamples()))
+	}
+	h = append(h, "data"...)
+	return binary.LittleEndian.AppendUint32(h, uint32(w.dataSize))
+}
+
+func (w *WAVWriter) Write(samples [][2]float64) error {
+	width := w.format.Width()
+	if cap(w.buf) < len(samples)*width {
+		w.buf = make([]byte, len(samples)*width)
+	}
+	buf := w.buf[:len(samples)*width]
+
+	p := buf
+	for _, sample := range samples {
+		if w.sampleFormat != nil {
+			p = p[w.encode(p, sample):]
+		} else if w.format.Precision == 1 {
+			// 8 bit WAV files are unsigned
+			p = p[w.format.EncodeUnsigned(p, sample):]
+		} else {
+			p = p[w.format.EncodeSigned(p, sample):]
+		}
+	}
+
+	n, err := w.bw.Write(buf)
+	w.dataSize += int64(n)
+	return err
+}
+
+// Encode one frame with the sample format. Mono is the average of
+// both channels and extra channels are silent, like beep.Format does.
+func (w *WAVWriter) encode(p []byte, sample [2]float64) int {
+	format := *w.sampleFormat
+	width := format.Width()
+	if w.format.NumChannels == 1 {
+		sample[0] = (sample[0] + sample[1]) / 2
+	}
+	for c := 0; c < w.format.NumChannels; c++ {
+		v := 0.0
+		if c < 2 {
+			v = sample[c]
+		}
+		if w.dither != nil {
+			v = w.dither.Apply(format, v)
+		}
+		format.Encode(p[c*width:], v)
+	}
+	return w.format.Width()
+}
+
+// The number of samples written
+func (w *WAVWriter) NumSamples() int {
+	return int(w.dataSize / int64(w.format.Width()))
+}
+
+// Write the buffered samples, add the pad byte required after an odd
+// sized data chunk and update the sizes in the header. The pad byte is
+// written before the header which counts it, and the next samples
+// overwrite it.
+func (w *WAVWriter) Flush() error {
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	if w.dataSize%2 == 1 {
+		if _, err := w.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(w.header()); err != nil {
+		return err
+	}
+	_, err := w.w.Seek(w.headerSize()+w.dataSize, io.SeekStart)
+	return err
+}
+
+// Flush and close the file if it was opened by CreateWAV(). Calling
+// Close() again does nothing.
+func (w *WAVWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.Flush()
+	if w.file != nil {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
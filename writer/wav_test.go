@@ -0,0 +1,224 @@
+package writer
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/wav"
+
+	"github.com/handegar/fv1emu/pcm"
+)
+
+func testSamples(num int) [][2]float64 {
+	samples := make([][2]float64, num)
+	for i := range samples {
+		samples[i] = [2]float64{float64(i%100)/100.0 - 0.5, 0.25}
+	}
+	return samples
+}
+
+// The RIFF and data chunk sizes in the header
+func readSizes(t *testing.T, filename string) (int, int) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(binary.LittleEndian.Uint32(data[4:])), int(binary.LittleEndian.Uint32(data[40:]))
+}
+
+func Test_WAVWriter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "out.wav")
+	format := beep.Format{SampleRate: 48000, NumChannels: 2, Precision: 3}
+	samples := testSamples(3000)
+
+	w, err := CreateWAV(filename, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file must be valid after each Flush()
+	for i := 0; i < len(samples); i += 1000 {
+		if err = w.Write(samples[i : i+1000]); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		riffSize, dataSize := readSizes(t, filename)
+		if dataSize != (i+1000)*6 || riffSize != dataSize+36 {
+			t.Errorf("Expected a data size of %d, got %d (RIFF size %d)", (i+1000)*6, dataSize, riffSize)
+		}
+	}
+	if w.NumSamples() != len(samples) {
+		t.Errorf("Expected %d samples, got %d", len(samples), w.NumSamples())
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Errorf("Expected a second Close() to do nothing, got %s", err)
+	}
+
+	f, _ := os.Open(filename)
+	defer f.Close()
+	stream, readFormat, err := wav.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readFormat != format || stream.Len() != len(samples) {
+		t.Errorf("Expected %v with %d samples, got %v with %d samples",
+			format, len(samples), readFormat, stream.Len())
+	}
+}
+
+// Mono 8 bit data with an odd number of samples must be padded
+func Test_WAVWriterPadding(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "out.wav")
+	format := beep.Format{SampleRate: 8000, NumChannels: 1, Precision: 1}
+
+	if err := SaveAsWAV(filename, format, testSamples(101)); err != nil {
+		t.Fatal(err)
+	}
+	riffSize, dataSize := readSizes(t, filename)
+	info, _ := os.Stat(filename)
+	if dataSize != 101 || riffSize != 36+102 || info.Size() != 44+102 {
+		t.Errorf("Expected 101 bytes of data padded to 102, got data size %d, RIFF size %d and file size %d",
+			dataSize, riffSize, info.Size())
+	}
+
+	// The pad byte must be in the file before the header counts it,
+	// and be overwritten by the next samples
+	w, err := CreateWAV(filename, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, num := range []int{101, 2, 1} {
+		if err = w.Write(testSamples(num)); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		riffSize, dataSize = readSizes(t, filename)
+		info, _ = os.Stat(filename)
+		padded := int64(dataSize + dataSize%2)
+		if dataSize != w.NumSamples() || info.Size() != 44+padded || int64(riffSize) != info.Size()-8 {
+			t.Errorf("%d samples: Got data size %d, RIFF size %d and file size %d",
+				w.NumSamples(), dataSize, riffSize, info.Size())
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.Open(filename)
+	defer f.Close()
+	stream, _, err := wav.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Len() != 104 {
+		t.Errorf("Expected 104 samples, got %d", stream.Len())
+	}
+
+	if _, err := CreateWAV(filename, beep.Format{SampleRate: 8000, NumChannels: 2, Precision: 5}); err == nil {
+		t.Errorf("Expected an unsupported precision to fail")
+	}
+}
+
+func Test_WAVWriterSampleFormats(t *testing.T) {
+	type testCase struct {
+		sampleFormat pcm.Format
+		formatTag    int
+		headerSize   int
+	}
+	cases := []testCase{
+		{pcm.S16LE, 1, 44},
+		{pcm.S24LE, 1, 44},
+		{pcm.S32LE, 1, 44},
+		{pcm.F32LE, 3, 58},
+	}
+	samples := [][2]float64{{0.5, -0.25}, {1.5, -2.0}, {0.125, 0.0}}
+
+	for _, c := range cases {
+		filename := filepath.Join(t.TempDir(), "out.wav")
+		format := beep.Format{SampleRate: 44100, NumChannels: 2, Precision: 2}
+		w, err := CreateWAVAs(filename, format, c.sampleFormat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Write(samples); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, _ := os.ReadFile(filename)
+		width := c.sampleFormat.Width()
+		if len(data) != c.headerSize+len(samples)*2*width {
+			t.Fatalf("%s: Expected %d bytes, got %d", c.sampleFormat, c.headerSize+len(samples)*2*width, len(data))
+		}
+		tag := int(binary.LittleEndian.Uint16(data[20:]))
+		bits := int(binary.LittleEndian.Uint16(data[34:]))
+		if tag != c.formatTag || bits != width*8 {
+			t.Errorf("%s: Expected format tag %d with %d bits, got %d with %d bits",
+				c.sampleFormat, c.formatTag, width*8, tag, bits)
+		}
+		if string(data[c.headerSize-8:c.headerSize-4]) != "data" {
+			t.Errorf("%s: Expected the data chunk at %d", c.sampleFormat, c.headerSize-8)
+		}
+
+		// Only the float format keeps values outside [-1.0 .. 1.0>
+		p := data[c.headerSize:]
+		for i, sample := range samples {
+			for ch := 0; ch < 2; ch++ {
+				expected := sample[ch]
+				if c.sampleFormat != pcm.F32LE {
+					expected = math.Max(-1.0, math.Min(1.0-c.sampleFormat.LSB(), expected))
+				}
+				if v := c.sampleFormat.Decode(p[(i*2+ch)*width:]); v != expected {
+					t.Errorf("%s: Sample %d/%d: Expected %f, got %f", c.sampleFormat, i, ch, expected, v)
+				}
+			}
+		}
+	}
+}
+
+// Dithered output must stay within one LSB of the undithered value
+func Test_WAVWriterDither(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "out.wav")
+	format := beep.Format{SampleRate: 44100, NumChannels: 1}
+	w, err := CreateWAVAs(filename, format, pcm.S16LE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetDither(pcm.NewDither(1))
+
+	samples := make([][2]float64, 1000)
+	for i := range samples {
+		samples[i] = [2]float64{0.1, 0.1}
+	}
+	w.Write(samples)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(filename)
+	numDiffering := 0
+	for i := range samples {
+		v := pcm.S16LE.Decode(data[44+i*2:])
+		if math.Abs(v-0.1) > 1.5*pcm.S16LE.LSB() {
+			t.Fatalf("Sample %d: Expected %f +/- 1 LSB, got %f", i, 0.1, v)
+		}
+		if v != pcm.S16LE.Decode(data[44:]) {
+			numDiffering++
+		}
+	}
+	if numDiffering == 0 {
+		t.Errorf("Expected the dither to change some of the samples")
+	}
+}
@@ -2,12 +2,9 @@ package writer
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/faiface/beep"
-	"github.com/faiface/beep/wav"
 
-	"github.com/handegar/fv1emu/settings"
 	"github.com/handegar/fv1emu/utils"
 )
 
@@ -41,19 +38,21 @@ func (ws *WriteStreamer) Err() error {
 	return nil
 }
 
+// Write all samples at once. See WAVWriter for writing while
+// processing.
 func SaveAsWAV(filename string, wavFormat beep.Format, samples [][2]float64) error {
 	fmt.Printf("* Writing to '%s' (%d samples, %d channels)\n",
-		settings.OutputWav, len(samples), len(samples[0]))
-	outWAVFile, err := os.Create(settings.OutputWav)
+		filename, len(samples), wavFormat.NumChannels)
+	w, err := CreateWAV(filename, wavFormat)
 	if err != nil {
 		fmt.Printf("Error creating output file: %s\n", err)
 		return err
 	}
 
-	var outStream *WriteStreamer = new(WriteStreamer)
-	outStream.Data = samples
-
-	err = wav.Encode(outWAVFile, outStream, wavFormat)
+	err = w.Write(samples)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		fmt.Printf("Error writing samples: %s\n", err)
 		return err